@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/internal/homeassistant"
+	"github.com/090809/homeassistant-domru/pkg/auth"
+	"github.com/090809/homeassistant-domru/pkg/authorizedhttp"
+	"github.com/090809/homeassistant-domru/pkg/tokenmanagement"
+)
+
+// selftestMQTTTimeout bounds the MQTT connect of --selftest, the broker may be unreachable.
+const selftestMQTTTimeout = 10 * time.Second
+
+// errSelftestSkipped marks checks that don't apply, i.e. MQTT outside of Home Assistant.
+var errSelftestSkipped = errors.New("skipped")
+
+// selftestCheck is one step of --selftest, run returns a short detail on success.
+type selftestCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+// runOpenDoor opens the door of the access control once and returns the process exit code.
+// The result is printed to stdout, logs keep going to stderr, so scripts can rely on the output.
+func runOpenDoor(domruAPI *domru.APIWrapper, placeID, accessControlID int, precheck bool) int {
+	open := domruAPI.OpenDoor
+	if precheck {
+		open = domruAPI.OpenDoorChecked
+	}
+
+	err := open(placeID, accessControlID)
+	switch {
+	case err == nil:
+		fmt.Printf("door %d of place %d opened\n", accessControlID, placeID)
+		return 0
+	case errors.As(err, &authorizedhttp.TokenRefreshError{}):
+		fmt.Fprintf(os.Stderr, "failed to open door: login expired, log in again: %v\n", err)
+	default:
+		fmt.Fprintf(os.Stderr, "failed to open door: %v\n", err)
+	}
+	return 1
+}
+
+// newSelftestChecks returns the --selftest steps. None of them opens a door or publishes to MQTT.
+func newSelftestChecks(credentialsStore auth.CredentialsStore, authProvider *tokenmanagement.ValidTokenProvider, domruAPI *domru.APIWrapper, mqttIntegration *homeassistant.MqttIntegration) []selftestCheck {
+	return []selftestCheck{
+		{name: "credentials", run: func() (string, error) {
+			credentials, err := credentialsStore.LoadCredentials()
+			if err != nil {
+				return "", err
+			}
+			if credentials.RefreshToken == "" {
+				return "", errors.New("no refresh token, log in via the web interface first")
+			}
+			return fmt.Sprintf("operator %d", credentials.OperatorID), nil
+		}},
+		{name: "token refresh", run: func() (string, error) {
+			return "", authProvider.RefreshToken()
+		}},
+		{name: "places", run: func() (string, error) {
+			places, err := domruAPI.RequestPlaces()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d found", len(places.Data)), nil
+		}},
+		{name: "mqtt", run: func() (string, error) {
+			err := mqttIntegration.CheckConnection(selftestMQTTTimeout)
+			if errors.Is(err, homeassistant.ErrMQTTUnavailable) {
+				return "", fmt.Errorf("%w: %v", errSelftestSkipped, err)
+			}
+			return "connected", err
+		}},
+		{name: "home assistant host", run: func() (string, error) {
+			host, err := homeassistant.GetHomeAssistantNetworkAddressWithPort()
+			if err != nil {
+				return "", err
+			}
+			if host == "" {
+				return "", fmt.Errorf("%w: not running in Home Assistant", errSelftestSkipped)
+			}
+			return host, nil
+		}},
+	}
+}
+
+// runSelftest runs every check, even after a failure, prints a line per check and a summary,
+// and returns the process exit code.
+func runSelftest(out io.Writer, checks []selftestCheck) int {
+	var passed, failed, skipped int
+	for _, check := range checks {
+		detail, err := check.run()
+		switch {
+		case errors.Is(err, errSelftestSkipped):
+			skipped++
+			fmt.Fprintf(out, "SKIP %s: %v\n", check.name, err)
+		case err != nil:
+			failed++
+			fmt.Fprintf(out, "FAIL %s: %v\n", check.name, err)
+		case detail != "":
+			passed++
+			fmt.Fprintf(out, "PASS %s: %s\n", check.name, detail)
+		default:
+			passed++
+			fmt.Fprintf(out, "PASS %s\n", check.name)
+		}
+	}
+
+	fmt.Fprintf(out, "selftest: %d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runPrintDiscovery writes the discovery of the places to out and returns the process exit code,
+// which is non-zero when the places can't be requested.
+func runPrintDiscovery(out io.Writer, mqttIntegration *homeassistant.MqttIntegration) int {
+	if err := mqttIntegration.PrintDiscovery(out); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to request places: %v\n", err)
+		return 1
+	}
+	return 0
+}
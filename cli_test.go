@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSelftest(t *testing.T) {
+	var out bytes.Buffer
+	code := runSelftest(&out, []selftestCheck{
+		{name: "places", run: func() (string, error) { return "2 found", nil }},
+		{name: "token refresh", run: func() (string, error) { return "", errors.New("401") }},
+		{name: "mqtt", run: func() (string, error) { return "", fmt.Errorf("%w: no broker", errSelftestSkipped) }},
+	})
+
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "PASS places: 2 found\n"+
+		"FAIL token refresh: 401\n"+
+		"SKIP mqtt: skipped: no broker\n"+
+		"selftest: 1 passed, 1 failed, 1 skipped\n", out.String())
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+
+	"github.com/090809/homeassistant-domru/internal/domru/constants"
+	"github.com/090809/homeassistant-domru/internal/homeassistant"
+	"github.com/090809/homeassistant-domru/pkg/auth"
+)
+
+// instanceSuffix restricts mqtt-instance-suffix to a single part of the entity IDs.
+var instanceSuffix = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// configError lists every configuration problem at once, so all of them can be fixed in one go.
+type configError struct {
+	Problems []string
+}
+
+func (e *configError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *configError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// validateConfig checks the flags, environment and options.json values. Fatal problems are
+// returned as a *configError, problems the addon can work around are only logged.
+func validateConfig(logger *slog.Logger) error {
+	problems := &configError{}
+
+	if port, err := cast.ToIntE(viper.Get(flagPort)); err != nil || port < 1 || port > 65535 {
+		problems.addf("%s must be a number between 1 and 65535, got %q", flagPort, viper.GetString(flagPort))
+	}
+
+	switch strings.ToLower(viper.GetString(flagLogLevel)) {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		problems.addf("%s must be one of trace, debug, info, warn, error, got %q", flagLogLevel, viper.GetString(flagLogLevel))
+	}
+
+	if _, err := parseBaseURL(viper.GetString(flagBaseURL)); err != nil {
+		problems.addf("%s: %v", flagBaseURL, err)
+	}
+
+	if externalURL := viper.GetString(flagExternalURL); externalURL != "" {
+		if _, err := parseBaseURL(externalURL); err != nil {
+			problems.addf("%s: %v", flagExternalURL, err)
+		}
+	}
+
+	operatorID, err := cast.ToIntE(viper.Get(flagOperatorID))
+	if err != nil || operatorID < 0 {
+		problems.addf("%s must be a positive number, got %q", flagOperatorID, viper.GetString(flagOperatorID))
+	}
+	refreshToken := viper.GetString(flagRefreshToken)
+	switch {
+	case refreshToken != "" && operatorID == 0:
+		problems.addf("%s requires %s, the token can't be refreshed without it", flagRefreshToken, flagOperatorID)
+	case refreshToken == "" && operatorID > 0:
+		logger.Warn(fmt.Sprintf("%s is ignored without %s", flagOperatorID, flagRefreshToken))
+	}
+
+	switch backend := viper.GetString(flagCredentialsStore); backend {
+	case credentialsBackendFile, credentialsBackendEnv:
+	case credentialsBackendRedis:
+		if viper.GetString(flagRedisAddr) == "" {
+			problems.addf("%s is required for the %s credentials backend", flagRedisAddr, credentialsBackendRedis)
+		}
+	default:
+		problems.addf("%s must be one of %s, %s, %s, got %q", flagCredentialsStore, credentialsBackendFile, credentialsBackendEnv, credentialsBackendRedis, backend)
+	}
+
+	if viper.GetString(flagCredentialsKey) != "" || viper.GetString(flagCredentialsKeyFile) != "" {
+		switch {
+		case viper.GetString(flagCredentialsKey) != "" && viper.GetString(flagCredentialsKeyFile) != "":
+			problems.addf("%s and %s are mutually exclusive", flagCredentialsKey, flagCredentialsKeyFile)
+		case viper.GetString(flagCredentialsStore) != credentialsBackendFile:
+			problems.addf("%s only encrypts the %s credentials backend", flagCredentialsKey, credentialsBackendFile)
+		}
+	}
+
+	for _, flag := range []string{flagMqttQoS, flagMqttDiscoveryQoS, flagMqttStateQoS, flagMqttAvailabilityQoS} {
+		if qos, err := cast.ToIntE(viper.Get(flag)); err != nil || qos < 0 || qos > 2 {
+			problems.addf("%s must be 0, 1 or 2, got %q", flag, viper.GetString(flag))
+		}
+	}
+	if !viper.GetBool(flagMqttDiscoveryRetain) {
+		logger.Warn(fmt.Sprintf("%s is off, MQTT entities will disappear after every Home Assistant restart", flagMqttDiscoveryRetain))
+	}
+	if viper.GetString(flagMqttClientID) == "" {
+		problems.addf("%s must not be empty", flagMqttClientID)
+	}
+	if port, err := cast.ToIntE(viper.Get(flagMqttPort)); err != nil || port < 0 || port > 65535 {
+		problems.addf("%s must be a number between 1 and 65535, got %q", flagMqttPort, viper.GetString(flagMqttPort))
+	}
+	if raw := viper.GetString(flagMqttURL); raw != "" {
+		if _, err := homeassistant.ParseBrokerURL(raw); err != nil {
+			problems.addf("%s: %v", flagMqttURL, err)
+		}
+	}
+	if (viper.GetString(flagMqttCertFile) == "") != (viper.GetString(flagMqttKeyFile) == "") {
+		problems.addf("%s and %s must be set together", flagMqttCertFile, flagMqttKeyFile)
+	}
+	if !viper.GetBool(flagMqttTLS) {
+		for _, flag := range []string{flagMqttCAFile, flagMqttCertFile, flagMqttKeyFile} {
+			if viper.GetString(flag) != "" {
+				logger.Warn(fmt.Sprintf("%s is ignored, %s is off", flag, flagMqttTLS))
+			}
+		}
+	}
+	if prefix := viper.GetString(flagMqttTopicPrefix); strings.ContainsAny(prefix, "/+# ") {
+		problems.addf("%s must be a single topic level without wildcards, got %q", flagMqttTopicPrefix, prefix)
+	}
+	if suffix := viper.GetString(flagMqttInstanceSuffix); suffix != "" && !instanceSuffix.MatchString(suffix) {
+		problems.addf("%s must be 1 to 32 lowercase latin letters, digits or underscores, got %q", flagMqttInstanceSuffix, suffix)
+	}
+	if prefix := viper.GetString(flagMqttDiscoveryPrefix); strings.ContainsAny(prefix, "+# ") ||
+		strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		problems.addf("%s must be a topic without wildcards and surrounding slashes, got %q", flagMqttDiscoveryPrefix, prefix)
+	}
+
+	if attempts, err := cast.ToIntE(viper.Get(flagMqttPublishAttempts)); err != nil || attempts < 1 {
+		problems.addf("%s must be at least 1, got %q", flagMqttPublishAttempts, viper.GetString(flagMqttPublishAttempts))
+	}
+
+	if _, err := homeassistant.ParseDoorEntityType(viper.GetString(flagMqttEntityType)); err != nil {
+		problems.addf("%s: %v", flagMqttEntityType, err)
+	}
+
+	if _, err := homeassistant.ParseLockCommandModes(viper.GetStringSlice(flagMqttLockCommand)); err != nil {
+		problems.addf("%s: %v", flagMqttLockCommand, err)
+	}
+
+	if _, err := time.LoadLocation(viper.GetString(flagTimezone)); err != nil {
+		problems.addf("%s must be a timezone like Europe/Moscow, got %q", flagTimezone, viper.GetString(flagTimezone))
+	}
+
+	if _, err := homeassistant.ParseDoorCameraIDs(viper.GetStringSlice(flagMqttDoorCameraIDs)); err != nil {
+		problems.addf("%s: %v", flagMqttDoorCameraIDs, err)
+	}
+
+	if _, err := homeassistant.ParseLocale(viper.GetString(flagMqttLocale)); err != nil {
+		problems.addf("%s: %v", flagMqttLocale, err)
+	}
+
+	if _, err := homeassistant.ParseDoorNames(viper.GetStringSlice(flagMqttDoorNames)); err != nil {
+		problems.addf("%s: %v", flagMqttDoorNames, err)
+	}
+
+	if _, err := cast.ToDurationE(viper.Get(flagStreamFlush)); err != nil {
+		problems.addf("%s must be a duration like 100ms, got %q", flagStreamFlush, viper.GetString(flagStreamFlush))
+	}
+
+	if viper.GetBool(flagOpenDoor) && viper.GetBool(flagSelftest) {
+		problems.addf("%s and %s can't be used together", flagOpenDoor, flagSelftest)
+	}
+	for _, flag := range []string{flagOpenDoor, flagSelftest} {
+		if viper.GetBool(flagPrintDiscovery) && viper.GetBool(flag) {
+			problems.addf("%s and %s can't be used together", flag, flagPrintDiscovery)
+		}
+	}
+	if viper.GetBool(flagOpenDoor) {
+		for _, flag := range []string{flagPlaceID, flagAccessControlID} {
+			if id, err := cast.ToIntE(viper.Get(flag)); err != nil || id <= 0 {
+				problems.addf("%s must be set to a positive ID with --%s", flag, flagOpenDoor)
+			}
+		}
+	}
+
+	for _, flag := range []string{flagSnapshotTemplates, flagStreamTemplates} {
+		if _, err := constants.ParseURLTemplates(viper.GetStringSlice(flag)); err != nil {
+			problems.addf("%s: %v", flag, err)
+		}
+	}
+
+	for _, flag := range []string{flagBalanceInterval, flagRediscovery, flagEventsInterval, flagMotionOffDelay, flagShutdownDrain, flagHTTPIdleTimeout, flagMqttPublishTimeout, flagMqttCameraInterval, flagMqttRelockDelay, flagMqttOpenDebounce, flagMqttStopGrace, flagMqttDiagnostics, flagMqttReconnectMax, flagTokenRefreshSkew} {
+		if duration, err := cast.ToDurationE(viper.Get(flag)); err != nil || duration < 0 {
+			problems.addf("%s must be a non-negative duration like 30s or 1h, got %q", flag, viper.GetString(flag))
+		}
+	}
+
+	for _, flag := range []string{flagEventsMaxClients, flagLogProxySample, flagHTTPMaxIdle, flagHTTPMaxIdlePerHost, flagRequestLogSize, flagStreamMaxPerCamera, flagMqttPlaceFailures, flagMqttReconnects} {
+		if value, err := cast.ToIntE(viper.Get(flag)); err != nil || value < 0 {
+			problems.addf("%s must be a non-negative number, got %q", flag, viper.GetString(flag))
+		}
+	}
+
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Config is the typed addon configuration. Its keys are the flag names, which are the same as the
+// options.json keys and, upper-cased with the DOMRU_ prefix, the environment variables.
+type Config struct {
+	Port             int           `mapstructure:"port"`
+	BaseURL          string        `mapstructure:"base-url"`
+	ExternalURL      string        `mapstructure:"external-url"`
+	LogLevel         string        `mapstructure:"log-level"`
+	LogProxySample   int           `mapstructure:"log-proxy-sample"`
+	LogUnsafe        bool          `mapstructure:"log-unsafe"`
+	AccessLog        bool          `mapstructure:"access-log"`
+	ShutdownDrain    time.Duration `mapstructure:"shutdown-drain-timeout"`
+	DoorPrecheck     bool          `mapstructure:"door-precheck"`
+	EventsInterval   time.Duration `mapstructure:"events-interval"`
+	EventsMaxClients int           `mapstructure:"events-max-clients"`
+	EventsCursorFile string        `mapstructure:"events-cursor-file"`
+	Timezone         string        `mapstructure:"timezone"`
+	Selftest         bool          `mapstructure:"selftest"`
+	PrintDiscovery   bool          `mapstructure:"print-discovery"`
+	RequestLogSize   int           `mapstructure:"request-log-size"`
+
+	Credentials CredentialsConfig `mapstructure:",squash"`
+	OpenDoor    OpenDoorConfig    `mapstructure:",squash"`
+	HTTP        HTTPConfig        `mapstructure:",squash"`
+	URLs        URLConfig         `mapstructure:",squash"`
+	MQTT        MQTTConfig        `mapstructure:",squash"`
+}
+
+// CredentialsConfig selects where the credentials are stored and optionally overrides them.
+type CredentialsConfig struct {
+	Backend       string        `mapstructure:"credentials-backend"`
+	File          string        `mapstructure:"credentials"`
+	Watch         bool          `mapstructure:"watch-credentials"`
+	RefreshSkew   time.Duration `mapstructure:"token-refresh-skew"`
+	RefreshToken  string        `mapstructure:"refresh-token"`
+	OperatorID    int           `mapstructure:"operator-id"`
+	ExtraFiles    []string      `mapstructure:"extra-credentials"`
+	RedisAddr     string        `mapstructure:"redis-addr"`
+	RedisPassword string        `mapstructure:"redis-password"`
+	RedisDB       int           `mapstructure:"redis-db"`
+	RedisKey      string        `mapstructure:"redis-key"`
+	Key           string        `mapstructure:"credentials-key"`
+	KeyFile       string        `mapstructure:"credentials-key-file"`
+}
+
+// OpenDoorConfig is the one-shot door opening mode of the command line.
+type OpenDoorConfig struct {
+	Enabled         bool `mapstructure:"open-door"`
+	PlaceID         int  `mapstructure:"place-id"`
+	AccessControlID int  `mapstructure:"access-control-id"`
+}
+
+// HTTPConfig tunes the connections to Dom.ru.
+type HTTPConfig struct {
+	MaxIdleConns        int           `mapstructure:"http-max-idle-conns"`
+	MaxIdleConnsPerHost int           `mapstructure:"http-max-idle-conns-per-host"`
+	IdleTimeout         time.Duration `mapstructure:"http-idle-timeout"`
+	HTTP2               bool          `mapstructure:"http2"`
+	StreamFlushInterval time.Duration `mapstructure:"stream-flush-interval"`
+	StreamProxy         bool          `mapstructure:"stream-proxy"`
+	StreamMaxPerCamera  int           `mapstructure:"stream-max-per-camera"`
+}
+
+// URLConfig holds the snapshot and stream URL templates by camera model.
+type URLConfig struct {
+	SnapshotTemplates   []string `mapstructure:"snapshot-url-templates"`
+	StreamTemplates     []string `mapstructure:"stream-url-templates"`
+	SnapshotPlaceholder bool     `mapstructure:"snapshot-placeholder"`
+}
+
+// MQTTConfig configures the Home Assistant MQTT integration.
+type MQTTConfig struct {
+	URL                   string        `mapstructure:"mqtt-url"`
+	Host                  string        `mapstructure:"mqtt-host"`
+	Port                  int           `mapstructure:"mqtt-port"`
+	Username              string        `mapstructure:"mqtt-user"`
+	Password              string        `mapstructure:"mqtt-password"`
+	TLS                   bool          `mapstructure:"mqtt-tls"`
+	CAFile                string        `mapstructure:"mqtt-ca-file"`
+	CertFile              string        `mapstructure:"mqtt-cert-file"`
+	KeyFile               string        `mapstructure:"mqtt-key-file"`
+	TLSInsecure           bool          `mapstructure:"mqtt-tls-insecure"`
+	ClientID              string        `mapstructure:"mqtt-client-id"`
+	V5                    bool          `mapstructure:"mqtt-v5"`
+	DryRun                bool          `mapstructure:"mqtt-dry-run"`
+	TopicPrefix           string        `mapstructure:"mqtt-topic-prefix"`
+	InstanceSuffix        string        `mapstructure:"mqtt-instance-suffix"`
+	DiscoveryPrefix       string        `mapstructure:"mqtt-discovery-prefix"`
+	RegistryFile          string        `mapstructure:"mqtt-registry-file"`
+	SettingsFile          string        `mapstructure:"mqtt-settings-file"`
+	OpenCountsFile        string        `mapstructure:"mqtt-open-counts-file"`
+	AvailabilityJSON      bool          `mapstructure:"mqtt-availability-json"`
+	Optimistic            bool          `mapstructure:"mqtt-optimistic"`
+	RelockDelay           time.Duration `mapstructure:"mqtt-relock-delay"`
+	OpenDebounce          time.Duration `mapstructure:"mqtt-open-debounce"`
+	StopGracePeriod       time.Duration `mapstructure:"mqtt-stop-grace-period"`
+	DiagnosticsInterval   time.Duration `mapstructure:"mqtt-diagnostics-interval"`
+	PlaceFailureThreshold int           `mapstructure:"mqtt-place-failure-threshold"`
+	MaxReconnectInterval  time.Duration `mapstructure:"mqtt-max-reconnect-interval"`
+	ReconnectAttempts     int           `mapstructure:"mqtt-reconnect-attempts"`
+	DoorCameras           bool          `mapstructure:"mqtt-door-cameras"`
+	CallButtons           bool          `mapstructure:"mqtt-call-buttons"`
+	CameraInterval        time.Duration `mapstructure:"mqtt-camera-interval"`
+	EntityType            string        `mapstructure:"mqtt-entity-type"`
+	BirthTopic            string        `mapstructure:"mqtt-birth-topic"`
+	BalanceInterval       time.Duration `mapstructure:"mqtt-balance-interval"`
+	RediscoveryInterval   time.Duration `mapstructure:"mqtt-rediscovery-interval"`
+	MotionOffDelay        time.Duration `mapstructure:"mqtt-motion-off-delay"`
+	LockCommand           []string      `mapstructure:"mqtt-lock-command"`
+	DoorCameraIDs         []string      `mapstructure:"mqtt-door-camera-ids"`
+	Locale                string        `mapstructure:"mqtt-locale"`
+	DoorNames             []string      `mapstructure:"mqtt-door-names"`
+	PublishAttempts       int           `mapstructure:"mqtt-publish-attempts"`
+	PublishTimeout        time.Duration `mapstructure:"mqtt-publish-timeout"`
+	Include               []string      `mapstructure:"mqtt-include"`
+	Exclude               []string      `mapstructure:"mqtt-exclude"`
+	DiscoveryQoS          int           `mapstructure:"mqtt-discovery-qos"`
+	DiscoveryRetain       bool          `mapstructure:"mqtt-discovery-retain"`
+	StateQoS              int           `mapstructure:"mqtt-state-qos"`
+	StateRetain           bool          `mapstructure:"mqtt-state-retain"`
+	AvailabilityQoS       int           `mapstructure:"mqtt-availability-qos"`
+	AvailabilityRetain    bool          `mapstructure:"mqtt-availability-retain"`
+}
+
+// loadConfig validates the flags, environment and options.json values and decodes them into a Config.
+// Viper resolves every key the same way as its getters do, so the precedence stays flag > env > options.json > default.
+func loadConfig(logger *slog.Logger) (Config, error) {
+	applyPublishDefaults()
+	applyBirthTopicDefault()
+	if err := validateConfig(logger); err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return Config{}, fmt.Errorf("decode configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// publishDefaults maps the QoS and retain flags of every publish category to the global flag they default to.
+var publishDefaults = map[string]string{
+	flagMqttDiscoveryQoS:       flagMqttQoS,
+	flagMqttStateQoS:           flagMqttQoS,
+	flagMqttAvailabilityQoS:    flagMqttQoS,
+	flagMqttDiscoveryRetain:    flagMqttRetain,
+	flagMqttStateRetain:        flagMqttRetain,
+	flagMqttAvailabilityRetain: flagMqttRetain,
+}
+
+// applyPublishDefaults sets the publish options of the categories not configured themselves to the global ones,
+// i.e. mqtt-retain: false turns retain off for every category.
+func applyPublishDefaults() {
+	for flag, global := range publishDefaults {
+		if !viper.IsSet(flag) && viper.IsSet(global) {
+			viper.Set(flag, viper.Get(global))
+		}
+	}
+}
+
+// applyBirthTopicDefault moves the birth topic under the discovery prefix unless it is configured itself,
+// Home Assistant announces its start under the discovery prefix.
+func applyBirthTopicDefault() {
+	if !viper.IsSet(flagMqttBirthTopic) && viper.GetString(flagMqttDiscoveryPrefix) != "" {
+		viper.Set(flagMqttBirthTopic, homeassistant.Topics{DiscoveryPrefix: viper.GetString(flagMqttDiscoveryPrefix)}.Birth())
+	}
+}
+
+// location returns the configured timezone, validateConfig already rejected unknown ones.
+// Empty is the system timezone, which the supervisor sets to the Home Assistant one via TZ.
+func (c Config) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	location, _ := time.LoadLocation(c.Timezone)
+	return location
+}
+
+// URLTemplates returns the snapshot and stream URL templates, validateConfig already rejected invalid ones.
+func (c URLConfig) URLTemplates() constants.URLTemplates {
+	snapshot, _ := constants.ParseURLTemplates(c.SnapshotTemplates)
+	stream, _ := constants.ParseURLTemplates(c.StreamTemplates)
+	return constants.URLTemplates{Snapshot: snapshot, Stream: stream}
+}
+
+// key returns the key encrypting the credentials files, nil when they are plaintext.
+// Like the TLS files the key file is only read here, so a missing file stops the addon at start.
+func (c CredentialsConfig) key() ([]byte, error) {
+	secret := c.Key
+	if c.KeyFile != "" {
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read credentials key: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("credentials key file %s is empty", c.KeyFile)
+		}
+	}
+	if secret == "" {
+		return nil, nil
+	}
+	return auth.NewCredentialsKey(secret), nil
+}
+
+func (c MQTTConfig) lockCommandModes() map[string]homeassistant.LockCommandMode {
+	modes, _ := homeassistant.ParseLockCommandModes(c.LockCommand)
+	return modes
+}
+
+func (c MQTTConfig) doorCameraIDs() map[int]int {
+	cameras, _ := homeassistant.ParseDoorCameraIDs(c.DoorCameraIDs)
+	return cameras
+}
+
+// tlsConfig returns the TLS config of the broker connection, nil when TLS is off.
+// Unlike the other options the files are only read here, so a missing file stops the addon at start.
+func (c MQTTConfig) tlsConfig() (*tls.Config, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+	return homeassistant.TLSSettings{
+		CAFile:             c.CAFile,
+		CertFile:           c.CertFile,
+		KeyFile:            c.KeyFile,
+		InsecureSkipVerify: c.TLSInsecure,
+	}.Config()
+}
+
+func publishOptions(qos int, retain bool) homeassistant.PublishOptions {
+	return homeassistant.PublishOptions{QoS: byte(qos), Retain: retain}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	defaults := map[string]interface{}{
+		flagPort:                8080,
+		flagLogLevel:            "info",
+		flagBaseURL:             "https://myhome.proptech.ru",
+		flagCredentialsStore:    credentialsBackendFile,
+		flagMqttDiscoveryQoS:    1,
+		flagMqttStateQoS:        1,
+		flagMqttAvailabilityQoS: 1,
+		flagMqttDiscoveryRetain: true,
+		flagMqttClientID:        "domru_proxy",
+		flagEventsInterval:      "15s",
+		flagLogProxySample:      1,
+		flagMqttPublishAttempts: 3,
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("Valid config", func(t *testing.T) {
+		viper.Reset()
+		for key, value := range defaults {
+			viper.Set(key, value)
+		}
+		assert.NoError(t, validateConfig(logger))
+	})
+
+	t.Run("Every problem is reported", func(t *testing.T) {
+		viper.Reset()
+		for key, value := range defaults {
+			viper.Set(key, value)
+		}
+		viper.Set(flagPort, 70000)
+		viper.Set(flagLogLevel, "verbose")
+		viper.Set(flagRefreshToken, "token")
+		viper.Set(flagMqttStateQoS, 3)
+		viper.Set(flagEventsInterval, "soon")
+		viper.Set(flagCredentialsKey, "secret")
+		viper.Set(flagCredentialsKeyFile, "/data/credentials.key")
+		viper.Set(flagMqttInstanceSuffix, "Home-2")
+
+		err := validateConfig(logger)
+		var configErr *configError
+		if assert.True(t, errors.As(err, &configErr)) {
+			assert.Len(t, configErr.Problems, 7)
+		}
+	})
+	viper.Reset()
+}
+
+func TestLoadConfig(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	viper.SetConfigType("json")
+	require.NoError(t, viper.ReadConfig(strings.NewReader(`{
+		"port": 8081,
+		"log-level": "info",
+		"base-url": "https://myhome.proptech.ru",
+		"credentials-backend": "file",
+		"mqtt-client-id": "domru_proxy",
+		"mqtt-qos": 0,
+		"mqtt-retain": false,
+		"mqtt-state-qos": 2,
+		"mqtt-discovery-retain": true,
+		"mqtt-publish-attempts": 3,
+		"mqtt-discovery-prefix": "ha-discovery",
+		"mqtt-include": ["1", "Main*"],
+		"events-interval": "1m"
+	}`)))
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.SetEnvPrefix("domru")
+	viper.AutomaticEnv()
+	t.Setenv("DOMRU_PORT", "9090")
+
+	cfg, err := loadConfig(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, "domru_proxy", cfg.MQTT.ClientID)
+	assert.Equal(t, []string{"1", "Main*"}, cfg.MQTT.Include)
+	assert.Equal(t, time.Minute, cfg.EventsInterval)
+	assert.Equal(t, credentialsBackendFile, cfg.Credentials.Backend)
+	assert.Equal(t, 0, cfg.MQTT.DiscoveryQoS)
+	assert.Equal(t, 2, cfg.MQTT.StateQoS)
+	assert.True(t, cfg.MQTT.DiscoveryRetain)
+	assert.False(t, cfg.MQTT.StateRetain)
+	// Home Assistant announces its start under the discovery prefix
+	assert.Equal(t, "ha-discovery/status", cfg.MQTT.BirthTopic)
+}
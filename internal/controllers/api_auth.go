@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	domruModels "github.com/090809/homeassistant-domru/internal/domru/models"
+	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
+	"github.com/090809/homeassistant-domru/pkg/tokenmanagement"
+)
+
+// SessionReporter provides the health of the Dom.ru session.
+type SessionReporter interface {
+	Status() tokenmanagement.Status
+}
+
+// authStatusResponse describes the credentials without revealing them: the tokens are never included.
+type authStatusResponse struct {
+	Authenticated     bool       `json:"authenticated"`
+	OperatorID        int        `json:"operator_id,omitempty"`
+	Phone             string     `json:"phone,omitempty"`
+	AccessToken       bool       `json:"access_token"`
+	AccessTokenExpiry *time.Time `json:"access_token_expiry,omitempty"`
+	RefreshToken      string     `json:"refresh_token,omitempty"`
+	LastRefresh       *time.Time `json:"last_refresh,omitempty"`
+}
+
+// phoneFetchBackoff is how long a failed phone request isn't repeated, polling the auth status during
+// an auth problem must not keep requesting Dom.ru.
+const phoneFetchBackoff = time.Minute
+
+// subscriberPhone caches the phone of the primary account, so the auth status is answered without calling Dom.ru.
+// It's set at login and by the pages requesting the subscriber profile anyway, and cleared on login, logout
+// and external changes of the credentials.
+type subscriberPhone struct {
+	mu       sync.Mutex
+	phone    string
+	known    bool
+	fetching bool
+	// failedAt is when the last fetch failed, zero after a success.
+	failedAt time.Time
+	// generation changes on clear, so a fetch started for the previous login doesn't set its phone.
+	generation int
+	// now is time.Now, tests replace it.
+	now func() time.Time
+}
+
+func (p *subscriberPhone) get() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phone, p.known
+}
+
+func (p *subscriberPhone) set(phone string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phone, p.known = phone, true
+}
+
+func (p *subscriberPhone) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phone, p.known = "", false
+	p.failedAt = time.Time{}
+	p.generation++
+}
+
+func (p *subscriberPhone) clock() time.Time {
+	if p.now == nil {
+		return time.Now()
+	}
+	return p.now()
+}
+
+// startFetch reports whether the caller should fetch the unknown phone and the generation to finish it with,
+// only one fetch runs at a time and a failed one is repeated after phoneFetchBackoff.
+func (p *subscriberPhone) startFetch() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.known || p.fetching {
+		return 0, false
+	}
+	if !p.failedAt.IsZero() && p.clock().Sub(p.failedAt) < phoneFetchBackoff {
+		return 0, false
+	}
+	p.fetching = true
+	return p.generation, true
+}
+
+// fetchDone finishes a fetch, the phone or the failure is kept unless the login changed meanwhile.
+func (p *subscriberPhone) fetchDone(generation int, phone string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetching = false
+	if generation != p.generation {
+		return
+	}
+	if !ok {
+		p.failedAt = p.clock()
+		return
+	}
+	p.phone, p.known, p.failedAt = phone, true, time.Time{}
+}
+
+// CredentialsChanged drops what the handlers know about the previous credentials, it should be called
+// when the credentials were changed outside the addon, i.e. the credentials file was replaced.
+func (h *Handler) CredentialsChanged() {
+	h.phone.clear()
+}
+
+// profilePhone returns the first phone of a subscriber profile, empty when it has none.
+func profilePhone(profile domruModels.SubscriberProfilesResponse) string {
+	if len(profile.SubscriberPhones) == 0 {
+		return ""
+	}
+	return profile.SubscriberPhones[0].Number
+}
+
+// fetchSubscriberPhone requests the unknown phone in the background, the auth status doesn't wait for Dom.ru.
+func (h *Handler) fetchSubscriberPhone() {
+	generation, ok := h.phone.startFetch()
+	if !ok {
+		return
+	}
+	go func() {
+		profile, err := h.domruAPI.GetSubscriberProfile()
+		if err != nil {
+			h.Logger.With("err", err.Error()).Warn("failed to get subscriber profile for auth status")
+		}
+		h.phone.fetchDone(generation, profilePhone(profile), err == nil)
+	}()
+}
+
+// AuthStatusHandler describes the stored credentials as JSON. Missing credentials are reported
+// as not authenticated instead of an error, so the endpoint can be polled, i.e. by automations.
+// It's answered from local state, only an unknown phone is requested from Dom.ru once, in the background.
+func (h *Handler) AuthStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var response authStatusResponse
+
+	credentials, err := h.credentialsStore.LoadCredentials()
+	if err != nil {
+		h.Logger.With("err", err.Error()).DebugContext(r.Context(), "no credentials for auth status")
+	}
+	if err == nil && credentials.RefreshToken != "" {
+		response.Authenticated = true
+		response.OperatorID = credentials.OperatorID
+		response.AccessToken = credentials.AccessToken != ""
+		response.RefreshToken = sanitizing_utils.KeepFirstNCharacters(credentials.RefreshToken, 7)
+		if expiresAt, expiryErr := tokenmanagement.TokenExpiry(credentials.AccessToken); expiryErr == nil {
+			expiresAt = expiresAt.In(h.location())
+			response.AccessTokenExpiry = &expiresAt
+		}
+
+		if phone, known := h.phone.get(); !known {
+			h.fetchSubscriberPhone()
+		} else if phone != "" {
+			response.Phone = sanitizing_utils.KeepLastNCharacters(phone, 4)
+		}
+	}
+
+	if h.Session != nil {
+		if lastRefresh := h.Session.Status().LastRefresh; !lastRefresh.IsZero() {
+			lastRefresh = lastRefresh.In(h.location())
+			response.LastRefresh = &lastRefresh
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to encode auth status")
+	}
+}
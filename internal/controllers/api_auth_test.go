@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"embed"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/pkg/auth"
+)
+
+func TestAuthStatusHandler(t *testing.T) {
+	var profileRequests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profileRequests.Add(1)
+		_, _ = w.Write([]byte(`{"subscriberPhones":[{"number":"79991112233"}]}`))
+	}))
+	defer upstream.Close()
+
+	store := auth.NewMemoryCredentialsStore()
+	h := NewHandlers(embed.FS{}, store, domru.NewDomruAPI(upstream.Client(), upstream.URL))
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Without credentials the status is still answered
+	recorder := httptest.NewRecorder()
+	h.AuthStatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/auth/status", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"authenticated":false,"access_token":false}`, recorder.Body.String())
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1700000000}`))
+	accessToken := "header." + payload + ".signature"
+	require.NoError(t, store.SaveCredentials(auth.Credentials{AccessToken: accessToken, RefreshToken: "refresh-token-secret", OperatorID: 2}))
+
+	// The phone is requested once in the background, the polls are answered without Dom.ru
+	recorder = httptest.NewRecorder()
+	h.AuthStatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/auth/status", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	require.Eventually(t, func() bool { _, known := h.phone.get(); return known }, time.Second, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		recorder = httptest.NewRecorder()
+		h.AuthStatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/auth/status", nil))
+	}
+	assert.Equal(t, int32(1), profileRequests.Load())
+	body := recorder.Body.String()
+	assert.Contains(t, body, `"authenticated":true`)
+	assert.Contains(t, body, `"operator_id":2`)
+	assert.Contains(t, body, `"phone":"*******2233"`)
+	assert.Contains(t, body, `"refresh_token":"refresh*************"`)
+	assert.Contains(t, body, `"access_token_expiry"`)
+	assert.NotContains(t, body, accessToken)
+	assert.NotContains(t, body, "refresh-token-secret")
+}
+
+func TestAuthStatusPhoneFetch(t *testing.T) {
+	var profileRequests atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profileRequests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"subscriberPhones":[{"number":"79991112233"}]}`))
+	}))
+	defer upstream.Close()
+
+	store := auth.NewMemoryCredentialsStore()
+	require.NoError(t, store.SaveCredentials(auth.Credentials{RefreshToken: "refresh", OperatorID: 2}))
+	h := NewHandlers(embed.FS{}, store, domru.NewDomruAPI(upstream.Client(), upstream.URL))
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	h.phone.now = func() time.Time { return time.Unix(0, now.Load()) }
+	poll := func() {
+		h.AuthStatusHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/auth/status", nil))
+	}
+	failed := func() bool {
+		h.phone.mu.Lock()
+		defer h.phone.mu.Unlock()
+		return !h.phone.fetching && !h.phone.failedAt.IsZero()
+	}
+
+	// A failed request isn't repeated by every poll
+	poll()
+	require.Eventually(t, failed, time.Second, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		poll()
+	}
+	assert.Equal(t, int32(1), profileRequests.Load())
+
+	// It's repeated after the backoff
+	failing.Store(false)
+	now.Add(int64(phoneFetchBackoff))
+	poll()
+	require.Eventually(t, func() bool { _, known := h.phone.get(); return known }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), profileRequests.Load())
+
+	// The phone of replaced credentials is requested again
+	h.CredentialsChanged()
+	_, known := h.phone.get()
+	assert.False(t, known)
+	poll()
+	require.Eventually(t, func() bool { _, known := h.phone.get(); return known }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(3), profileRequests.Load())
+}
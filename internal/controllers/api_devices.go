@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cast"
+
+	"github.com/090809/homeassistant-domru/internal/domru/constants"
+	"github.com/090809/homeassistant-domru/internal/homeassistant"
+)
+
+type devicesResponse struct {
+	Places  []devicePlace  `json:"places"`
+	Cameras []deviceCamera `json:"cameras"`
+	Errors  []string       `json:"errors,omitempty"`
+}
+
+type devicePlace struct {
+	ID             int                   `json:"id"`
+	Address        string                `json:"address"`
+	AccessControls []deviceAccessControl `json:"access_controls"`
+}
+
+type deviceAccessControl struct {
+	ID          int                      `json:"id"`
+	Name        string                   `json:"name"`
+	Type        string                   `json:"type"`
+	SnapshotURL string                   `json:"snapshot_url"`
+	OpenDoorURL string                   `json:"open_door_url"`
+	MQTT        homeassistant.DoorTopics `json:"mqtt"`
+}
+
+type deviceCamera struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	StreamURL string `json:"stream_url"`
+}
+
+// DevicesHandler lists places, access controls and cameras with their URLs and MQTT topics as JSON.
+func (h *Handler) DevicesHandler(w http.ResponseWriter, r *http.Request) {
+	baseURL := h.determineBaseURL(r)
+	response := devicesResponse{Places: []devicePlace{}, Cameras: []deviceCamera{}}
+
+	// Cameras go first, the snapshot URL of an access control follows the model of its camera
+	cameras, err := h.domruAPI.WithContext(r.Context()).RequestCameras()
+	if err != nil {
+		h.Logger.With("err", err.Error()).WarnContext(r.Context(), "failed to get cameras for devices list")
+		response.Errors = append(response.Errors, "failed to get cameras")
+	}
+	cameraModels := make(map[int]string, len(cameras.Data))
+	for _, camera := range cameras.Data {
+		cameraModels[camera.ID] = camera.Model
+		response.Cameras = append(response.Cameras, deviceCamera{
+			ID:        camera.ID,
+			Name:      camera.Name,
+			StreamURL: sanitizeURL(h.URLTemplates.StreamURL(camera.Model, baseURL, camera.ID)),
+		})
+	}
+
+	places, err := h.domruAPI.WithContext(r.Context()).RequestPlaces()
+	if err != nil {
+		h.Logger.With("err", err.Error()).WarnContext(r.Context(), "failed to get places for devices list")
+		response.Errors = append(response.Errors, "failed to get places")
+	}
+	for _, data := range places.Data {
+		place := devicePlace{
+			ID:             data.Place.ID,
+			Address:        data.Place.Address.VisibleAddress,
+			AccessControls: []deviceAccessControl{},
+		}
+		for _, ac := range data.Place.AccessControls {
+			place.AccessControls = append(place.AccessControls, deviceAccessControl{
+				ID:          ac.ID,
+				Name:        ac.Name,
+				Type:        ac.Type,
+				SnapshotURL: sanitizeURL(h.URLTemplates.SnapshotURL(cameraModels[cast.ToInt(ac.ExternalCameraId)], baseURL, data.Place.ID, ac.ID)),
+				OpenDoorURL: sanitizeURL(constants.GetOpenDoorUrl(baseURL, data.Place.ID, ac.ID)),
+				MQTT:        h.MQTTTopics.DoorLockTopics(ac.ID, data.Place.ID),
+			})
+		}
+		response.Places = append(response.Places, place)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to encode devices list")
+	}
+}
+
+// sanitizeURL drops the query string and user info, which may carry tokens.
+func sanitizeURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String()
+}
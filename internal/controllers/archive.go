@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	domruModels "github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+type archiveResponse struct {
+	CameraID int                          `json:"camera_id"`
+	From     time.Time                    `json:"from"`
+	To       time.Time                    `json:"to"`
+	Segments []domruModels.ArchiveSegment `json:"segments"`
+}
+
+// ArchiveHandler lists camera archive segments for ?from=...&to=... (RFC 3339).
+// When to is omitted, the window ends now.
+func (h *Handler) ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	cameraID, err := strconv.Atoi(r.PathValue("cameraId"))
+	if err != nil {
+		http.Error(w, "invalid camera id", http.StatusBadRequest)
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "from must be an RFC 3339 timestamp", http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	if rawTo := r.URL.Query().Get("to"); rawTo != "" {
+		if to, err = time.Parse(time.RFC3339, rawTo); err != nil {
+			http.Error(w, "to must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	segments, err := h.domruAPI.WithContext(r.Context()).RequestArchive(cameraID, from, to)
+	switch {
+	case errors.Is(err, domru.ErrInvalidArchiveRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, domru.ErrArchiveUnavailable):
+		http.Error(w, "archive is not available for this camera", http.StatusForbidden)
+		return
+	case err != nil:
+		h.Logger.With("err", err.Error()).With("cameraID", cameraID).WarnContext(r.Context(), "failed to get archive")
+		http.Error(w, "failed to get archive", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	response := archiveResponse{CameraID: cameraID, From: from, To: to, Segments: segments}
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to encode archive")
+	}
+}
@@ -2,23 +2,74 @@ package controllers
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/090809/homeassistant-domru/internal/domru"
 	"github.com/090809/homeassistant-domru/internal/domru/constants"
-	"github.com/090809/homeassistant-domru/internal/domru/models"
+	domruModels "github.com/090809/homeassistant-domru/internal/domru/models"
 	"github.com/090809/homeassistant-domru/internal/homeassistant"
+	"github.com/090809/homeassistant-domru/internal/models"
 	"github.com/090809/homeassistant-domru/pkg/auth"
+	"github.com/090809/homeassistant-domru/pkg/authorizedhttp"
 )
 
 type Handler struct {
 	Logger           *slog.Logger
 	domruAPI         *domru.APIWrapper
 	credentialsStore auth.CredentialsStore
-	accountInfo      *models.Account
+	accountInfo      *domruModels.Account
+	smsAttempts      atomic.Int32
+	phone            subscriberPhone
+
+	// Events is the source of live events for the events stream, nil disables the stream.
+	Events EventSubscriber
+	// MaxEventStreams limits concurrent event streams, zero means unlimited.
+	MaxEventStreams int
+	eventStreams    atomic.Int32
+	// closingEvents is closed by CloseEventStreams, the server shutdown doesn't end streaming handlers.
+	closingEvents   chan struct{}
+	closeEventsOnce sync.Once
+
+	// Discovery reports the MQTT discovery state on the status page, nil means MQTT is disabled.
+	Discovery DiscoveryReporter
+	// DiscoveryCleanup removes the MQTT entities on logout, nil means MQTT is disabled.
+	DiscoveryCleanup DiscoveryCleaner
+	// Session reports the last token refresh in the auth status, nil leaves it out.
+	Session SessionReporter
+	// Rediscovery publishes the devices of a new login right away, nil means MQTT is disabled.
+	Rediscovery DeviceRediscoverer
+	// DoorOpens is told about every door open attempt, nil means MQTT is disabled.
+	DoorOpens DoorOpenRecorder
+	// MQTTTopics names the MQTT topics listed in the devices API.
+	MQTTTopics homeassistant.Topics
+	// URLTemplates builds the snapshot and stream URLs by camera model.
+	URLTemplates constants.URLTemplates
+
+	// Location is the timezone event and status times are shown in, nil means the system timezone.
+	Location *time.Location
+
+	// StreamProxy proxies camera streams instead of redirecting the client to Dom.ru, StreamClient fetches them.
+	StreamProxy  bool
+	StreamClient *http.Client
+	// MaxStreamsPerCamera limits concurrent proxied streams of a camera, zero means unlimited.
+	MaxStreamsPerCamera int
+	streams             streamLimiter
+
+	// RequestLog lists the recent proxied requests, nil disables the list.
+	RequestLog RequestLister
+
+	// Accounts are the additional accounts of the credentials file, their doors are listed on the home page.
+	Accounts []Account
+
+	// SnapshotPlaceholder serves a "no image" picture instead of an error when a snapshot can't be retrieved.
+	SnapshotPlaceholder bool
 
 	TemplateFs embed.FS
 }
@@ -29,11 +80,65 @@ func NewHandlers(templateFs embed.FS, credentialsStore auth.CredentialsStore, do
 		Logger:           slog.Default(),
 		credentialsStore: credentialsStore,
 		domruAPI:         domruAPI,
+		closingEvents:    make(chan struct{}),
 	}
 
 	return h
 }
 
+// Account is an additional Dom.ru account of the credentials file.
+type Account struct {
+	Name string
+	API  *domru.APIWrapper
+}
+
+// accountAPI returns the API of the named account, the primary one for an empty name,
+// or nil if there is no such account.
+func (h *Handler) accountAPI(name string) *domru.APIWrapper {
+	if name == "" {
+		return h.domruAPI
+	}
+	for _, account := range h.Accounts {
+		if account.Name == name {
+			return account.API
+		}
+	}
+	return nil
+}
+
+// saveCredentials saves the credentials of a login, an empty account name replaces the primary account.
+func (h *Handler) saveCredentials(account string, credentials auth.Credentials) error {
+	if account != "" {
+		store, ok := h.credentialsStore.(auth.AccountStore)
+		if !ok {
+			return errors.New("the credentials backend doesn't support accounts")
+		}
+		return store.AddAccount(account, credentials)
+	}
+
+	if err := h.credentialsStore.SaveCredentials(credentials); err != nil {
+		return err
+	}
+	h.phone.clear()
+	if h.Rediscovery != nil {
+		go h.Rediscovery.Rediscover()
+	}
+	return nil
+}
+
+// needsLogin reports whether err means the user has to log in again: the session expired
+// or the credentials file is corrupt.
+func needsLogin(err error) bool {
+	return errors.As(err, &authorizedhttp.TokenRefreshError{}) || errors.As(err, &auth.CorruptCredentialsError{})
+}
+
+func (h *Handler) location() *time.Location {
+	if h.Location == nil {
+		return time.Local
+	}
+	return h.Location
+}
+
 func (h *Handler) renderTemplate(w http.ResponseWriter, templateName string, data interface{}) error {
 	w.Header().Set("Content-Type", "text/html")
 
@@ -43,7 +148,7 @@ func (h *Handler) renderTemplate(w http.ResponseWriter, templateName string, dat
 		return fmt.Errorf("readfile %s: %w", templateFile, err)
 	}
 
-	t, err := template.New(templateName).Funcs(getTemplateFunctions()).Parse(string(tmpl))
+	t, err := template.New(templateName).Funcs(getTemplateFunctions(h.URLTemplates)).Parse(string(tmpl))
 	if err != nil {
 		return fmt.Errorf("parse %s error: %w", templateFile, err)
 	}
@@ -56,11 +161,35 @@ func (h *Handler) renderTemplate(w http.ResponseWriter, templateName string, dat
 	return nil
 }
 
-func getTemplateFunctions() template.FuncMap {
+// renderError logs err and shows the user a friendly error page with userMessage instead of the raw error text.
+func (h *Handler) renderError(w http.ResponseWriter, r *http.Request, status int, userMessage string, err error) {
+	logger := h.Logger.With("status", status)
+	if err != nil {
+		logger = logger.With("err", err.Error())
+	}
+	logger.ErrorContext(r.Context(), userMessage)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(status)
+	if renderErr := h.renderTemplate(w, "error", models.ErrorPageData{Status: status, Message: userMessage}); renderErr != nil {
+		h.Logger.With("err", renderErr.Error()).ErrorContext(r.Context(), "failed to render error page")
+	}
+}
+
+// RenderInternalError shows the generic error page with a 500 status, e.g. after a recovered panic.
+func (h *Handler) RenderInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	h.renderError(w, r, http.StatusInternalServerError, "Внутренняя ошибка сервера. Попробуйте позже", err)
+}
+
+func getTemplateFunctions(urlTemplates constants.URLTemplates) template.FuncMap {
 	return template.FuncMap{
-		"getSnapshotUrl":     constants.GetSnapshotUrl,
-		"getCameraStreamUrl": constants.GetCameraStreamUrl,
-		"getOpenDoorUrl":     constants.GetOpenDoorUrl,
+		"getSnapshotUrl": func(baseUrl string, placeId, accessControlId int, model string) string {
+			return urlTemplates.SnapshotURL(model, baseUrl, placeId, accessControlId)
+		},
+		"getCameraStreamUrl": func(baseUrl string, cameraId int, model string) string {
+			return urlTemplates.StreamURL(model, baseUrl, cameraId)
+		},
+		"getOpenDoorUrl": constants.GetOpenDoorUrl,
 		"ha_host": func() string {
 			host, err := homeassistant.GetHomeAssistantNetworkAddressWithPort()
 			if err != nil {
@@ -71,22 +200,32 @@ func getTemplateFunctions() template.FuncMap {
 	}
 }
 func (h *Handler) determineBaseURL(r *http.Request) string {
-	var scheme string
-	host := r.Host
+	haHost, haNetworkErr := homeassistant.GetHomeAssistantNetworkAddress()
+	if haNetworkErr != nil {
+		haHost = ""
+	}
+
+	baseURL, missingIngressPath := computeBaseURL(r.URL.Scheme, r.Host, haHost, r.Header.Get("X-Ingress-Path"))
+	if missingIngressPath {
+		h.Logger.With("ha_host", haHost).WarnContext(r.Context(), "X-Ingress-Path header is empty, when using Home Assistant host")
+	}
+
+	h.Logger.With("base_url", baseURL).InfoContext(r.Context(), "determining base URL")
 
-	if scheme = r.URL.Scheme; scheme == "" {
+	return baseURL
+}
+
+// computeBaseURL builds the base URL for links rendered in templates.
+// haHost is the address reported by the supervisor and replaces the request host when set.
+// missingIngressPath reports that the supervisor host is used without an X-Ingress-Path,
+// which usually means the page was opened bypassing the ingress.
+func computeBaseURL(scheme, host, haHost, ingressPath string) (baseURL string, missingIngressPath bool) {
+	if scheme == "" {
 		scheme = "http"
 	}
-	haHost, haNetworkErr := homeassistant.GetHomeAssistantNetworkAddress()
-	if haNetworkErr == nil && haHost != "" {
+	if haHost != "" {
 		host = haHost
 	}
-	ingressPath := r.Header.Get("X-Ingress-Path")
-	if ingressPath == "" && haHost != "" {
-		h.Logger.With("ha_host", haHost).Warn("X-Ingress-Path header is empty, when using Home Assistant host")
-	}
-
-	h.Logger.With("base_url", fmt.Sprintf("%s://%s%s", scheme, host, ingressPath)).Info("determining base URL")
 
-	return fmt.Sprintf("%s://%s%s", scheme, host, ingressPath)
+	return fmt.Sprintf("%s://%s%s", scheme, host, ingressPath), ingressPath == "" && haHost != ""
 }
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeBaseURL(t *testing.T) {
+	tests := []struct {
+		name               string
+		scheme             string
+		host               string
+		haHost             string
+		ingressPath        string
+		wantBaseURL        string
+		wantMissingIngress bool
+	}{
+		{
+			name:        "Local development without supervisor",
+			host:        "localhost:8080",
+			wantBaseURL: "http://localhost:8080",
+		},
+		{
+			name:        "Supervisor with ingress path",
+			host:        "homeassistant.local:8123",
+			haHost:      "172.30.32.1",
+			ingressPath: "/api/hassio_ingress/abcdef",
+			wantBaseURL: "http://172.30.32.1/api/hassio_ingress/abcdef",
+		},
+		{
+			name:               "Supervisor without ingress path",
+			host:               "homeassistant.local:8123",
+			haHost:             "172.30.32.1",
+			wantBaseURL:        "http://172.30.32.1",
+			wantMissingIngress: true,
+		},
+		{
+			name:        "Https scheme",
+			scheme:      "https",
+			host:        "domru.example.com",
+			ingressPath: "/api/hassio_ingress/abcdef",
+			wantBaseURL: "https://domru.example.com/api/hassio_ingress/abcdef",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL, missingIngress := computeBaseURL(tt.scheme, tt.host, tt.haHost, tt.ingressPath)
+			assert.Equal(t, tt.wantBaseURL, baseURL)
+			assert.Equal(t, tt.wantMissingIngress, missingIngress)
+		})
+	}
+}
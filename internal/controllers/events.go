@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+const eventsHeartbeatInterval = 30 * time.Second
+
+type EventSubscriber interface {
+	Subscribe() (<-chan models.Event, func())
+}
+
+type eventMessage struct {
+	ID        models.EventID   `json:"id"`
+	Kind      models.EventKind `json:"kind"`
+	Type      string           `json:"type"`
+	PlaceID   int              `json:"placeId"`
+	Timestamp string           `json:"timestamp"`
+	Time      string           `json:"time,omitempty"`
+	Message   string           `json:"message"`
+}
+
+// CloseEventStreams ends the open event streams and rejects new ones, so the server shutdown doesn't wait for them.
+func (h *Handler) CloseEventStreams() {
+	h.closeEventsOnce.Do(func() { close(h.closingEvents) })
+}
+
+// EventsHandler streams live Dom.ru events to the browser using Server-Sent Events.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.Events == nil {
+		http.Error(w, "events are disabled", http.StatusNotFound)
+		return
+	}
+	select {
+	case <-h.closingEvents:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	if streams := h.eventStreams.Add(1); h.MaxEventStreams > 0 && int(streams) > h.MaxEventStreams {
+		h.eventStreams.Add(-1)
+		http.Error(w, "too many event streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.eventStreams.Add(-1)
+
+	controller := http.NewResponseController(w)
+	// The stream is long-lived, so the server write timeout must not apply to it
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		h.Logger.With("err", err.Error()).DebugContext(r.Context(), "unable to reset write deadline for event stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		h.Logger.With("err", err.Error()).WarnContext(r.Context(), "event stream is not supported by the response writer")
+		return
+	}
+
+	events, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	h.Logger.DebugContext(r.Context(), "event stream opened")
+	for {
+		select {
+		case <-r.Context().Done():
+			h.Logger.DebugContext(r.Context(), "event stream closed by client")
+			return
+		case <-h.closingEvents:
+			h.Logger.DebugContext(r.Context(), "event stream closed on shutdown")
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(h.newEventMessage(event))
+			if err != nil {
+				h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to marshal event")
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind(), data); err != nil {
+				return
+			}
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// newEventMessage converts the event for the stream. The timestamp is converted to the configured timezone,
+// timestamps that can't be parsed are passed as is.
+func (h *Handler) newEventMessage(event models.Event) eventMessage {
+	message := eventMessage{
+		ID:        event.ID,
+		Kind:      event.Kind(),
+		Type:      event.EventTypeName,
+		PlaceID:   event.PlaceID,
+		Timestamp: event.Timestamp,
+		Message:   event.Message,
+	}
+	if at, err := event.Time(); err == nil {
+		local := at.In(h.location())
+		message.Timestamp = local.Format(time.RFC3339)
+		message.Time = local.Format("15:04")
+	} else {
+		h.Logger.With("err", err.Error()).Debug("event timestamp is passed as is")
+	}
+	return message
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"bufio"
+	"embed"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// fakeEvents broadcasts the events sent on the channel to a single subscriber.
+type fakeEvents struct {
+	events chan models.Event
+}
+
+func (f *fakeEvents) Subscribe() (<-chan models.Event, func()) {
+	return f.events, func() {}
+}
+
+func TestEventsHandlerCloseEventStreams(t *testing.T) {
+	h := NewHandlers(embed.FS{}, nil, nil)
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	h.Location = time.UTC
+	source := &fakeEvents{events: make(chan models.Event, 1)}
+	h.Events = source
+	server := httptest.NewServer(http.HandlerFunc(h.EventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	source.events <- models.Event{ID: "1", EventTypeName: "accessControlCallMissed", Timestamp: "2024-05-01T10:00:00"}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: call\n", line)
+
+	// The stream ends with the shutdown, server.Close would wait for it otherwise
+	h.CloseEventStreams()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, resp.Body)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("event stream is still open after CloseEventStreams")
+	}
+
+	w := httptest.NewRecorder()
+	h.EventsHandler(w, httptest.NewRequest(http.MethodGet, "/api/events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "new streams are rejected once closing")
+}
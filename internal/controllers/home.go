@@ -1,17 +1,16 @@
 package controllers
 
 import (
-	errors2 "errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/090809/homeassistant-domru/internal/models"
-	"github.com/090809/homeassistant-domru/pkg/authorizedhttp"
 )
 
 func (h *Handler) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	data, err := h.prepareHomePageData(r)
-	if errors2.As(err, &authorizedhttp.TokenRefreshError{}) {
+	if needsLogin(err) {
 		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
 		return
 	}
@@ -26,9 +25,9 @@ func (h *Handler) prepareHomePageData(r *http.Request) (models.HomePageData, err
 	var errors []string
 	data := models.HomePageData{}
 
-	cameras, camerasErr := h.domruAPI.RequestCameras()
+	cameras, camerasErr := h.domruAPI.WithContext(r.Context()).RequestCameras()
 	if camerasErr != nil {
-		if errors2.As(camerasErr, &authorizedhttp.TokenRefreshError{}) {
+		if needsLogin(camerasErr) {
 			return data, camerasErr
 		}
 		errors = append(errors, camerasErr.Error())
@@ -36,17 +35,27 @@ func (h *Handler) prepareHomePageData(r *http.Request) (models.HomePageData, err
 		data.Cameras = cameras
 	}
 
-	places, placesErr := h.domruAPI.RequestPlaces()
+	places, placesErr := h.domruAPI.WithContext(r.Context()).RequestPlaces()
 	if placesErr != nil {
 		errors = append(errors, placesErr.Error())
 	} else {
 		data.Places = places
 	}
 
-	subscriberProfiles, subscriberProfilesErr := h.domruAPI.GetSubscriberProfile()
+	for _, account := range h.Accounts {
+		accountPlaces, err := account.API.WithContext(r.Context()).RequestPlaces()
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %s", account.Name, err.Error()))
+			continue
+		}
+		data.Accounts = append(data.Accounts, models.AccountPlaces{Name: account.Name, Places: accountPlaces})
+	}
+
+	subscriberProfiles, subscriberProfilesErr := h.domruAPI.WithContext(r.Context()).GetSubscriberProfile()
 	if subscriberProfilesErr != nil {
 		errors = append(errors, subscriberProfilesErr.Error())
 	} else {
+		h.phone.set(profilePhone(subscriberProfiles))
 		if len(subscriberProfiles.SubscriberPhones) > 0 {
 			data.Phone = subscriberProfiles.SubscriberPhones[0].Number
 		}
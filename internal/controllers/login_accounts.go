@@ -1,26 +1,23 @@
 package controllers
 
 import (
-	"fmt"
 	"net/http"
 
 	domruModels "github.com/090809/homeassistant-domru/internal/domru/models"
-	"github.com/090809/homeassistant-domru/internal/models"
-	"github.com/090809/homeassistant-domru/pkg/auth"
 )
 
 func (h *Handler) SelectAccountHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, fmt.Sprintf("ParseForm() err: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadRequest, "Некорректные данные формы", err)
 		return
 	}
 
 	phoneNumber := r.FormValue("phone")
 	accountID := r.FormValue("accountId")
 
-	accounts, err := h.domruAPI.RequestAccounts(phoneNumber)
+	accounts, err := h.domruAPI.WithContext(r.Context()).RequestAccounts(phoneNumber)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get user accounts: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadGateway, "Не удалось получить список договоров. Попробуйте позже", err)
 		return
 	}
 
@@ -35,24 +32,14 @@ func (h *Handler) SelectAccountHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	authenticator := auth.NewPhoneNumberAuthenticator(phoneNumber)
-	requestErr := authenticator.RequestSmsCode(selectedAccount)
+	requestErr := h.domruAPI.LoginWithPhoneNumber(phoneNumber, selectedAccount)
 	if requestErr != nil {
-		http.Error(w, fmt.Sprintf("Failed to request confirmation code: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadGateway, "Не удалось отправить код подтверждения. Попробуйте позже", requestErr)
 		return
 	}
 
 	h.accountInfo = &selectedAccount
+	h.smsAttempts.Store(0)
 
-	loginError := ""
-	data := models.SMSPageData{
-		Phone:      phoneNumber,
-		BaseURL:    h.determineBaseURL(r),
-		LoginError: loginError,
-	}
-
-	if err = h.renderTemplate(w, "sms", data); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to render confirmation page: %v", err), http.StatusInternalServerError)
-		return
-	}
+	h.renderSmsPage(w, r, phoneNumber, r.FormValue("account"), "")
 }
@@ -1,41 +1,62 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 
 	"github.com/090809/homeassistant-domru/internal/models"
+	"github.com/090809/homeassistant-domru/pkg/auth"
 )
 
+// invalidAccountNameMessage explains the names accepted for additional accounts.
+const invalidAccountNameMessage = "Название договора может содержать только строчные латинские буквы, цифры и _"
+
 func (h *Handler) LoginPageHandler(w http.ResponseWriter, r *http.Request) {
-	data := models.LoginPageData{Phone: "TODO: maybe store phone number"}
+	data := models.LoginPageData{Phone: "TODO: maybe store phone number", Account: r.URL.Query().Get("account"), InMemory: h.credentialsInMemory()}
+	if _, err := h.credentialsStore.LoadCredentials(); errors.As(err, &auth.CorruptCredentialsError{}) {
+		data.LoginError = "Не удалось прочитать сохранённые данные для входа, войдите заново: " + err.Error()
+	}
 	data.BaseURL = h.determineBaseURL(r)
 
 	err := h.renderTemplate(w, "login", data)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to render login page: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось отобразить страницу входа", err)
 	}
 }
 
+// credentialsInMemory reports whether the credentials don't survive a restart.
+func (h *Handler) credentialsInMemory() bool {
+	_, inMemory := h.credentialsStore.(*auth.MemoryCredentialsStore)
+	return inMemory
+}
+
 func (h *Handler) LoginPhoneInputHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, fmt.Sprintf("ParseForm() err: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadRequest, "Некорректные данные формы", err)
 		return
 	}
 
+	account := r.FormValue("account")
+	if account != "" {
+		if err := auth.ValidateAccountName(account); err != nil {
+			h.renderLoginPage(w, r, invalidAccountNameMessage)
+			return
+		}
+	}
+
 	phone := r.FormValue("phone")
-	accounts, err := h.domruAPI.RequestAccounts(phone)
+	accounts, err := h.domruAPI.WithContext(r.Context()).RequestAccounts(phone)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get user accounts: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadGateway, "Не удалось получить список договоров. Попробуйте позже", err)
 		return
 	}
 
-	data := models.AccountsPageData{Accounts: accounts, Phone: phone}
+	data := models.AccountsPageData{Accounts: accounts, Phone: phone, Account: account}
 	data.BaseURL = h.determineBaseURL(r)
 	data.LoginError = ""
 
 	err = h.renderTemplate(w, "accounts", data)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to render accounts page: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось отобразить список договоров", err)
 	}
 }
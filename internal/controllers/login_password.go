@@ -12,16 +12,23 @@ import (
 
 func (h *Handler) LoginWithPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, fmt.Sprintf("ParseForm() err: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadRequest, "Некорректные данные формы", err)
 		return
 	}
 
 	accountID := r.FormValue("account_id")
 	password := r.FormValue("password")
+	account := r.FormValue("account")
+	if account != "" {
+		if err := auth.ValidateAccountName(account); err != nil {
+			h.renderLoginPage(w, r, invalidAccountNameMessage)
+			return
+		}
+	}
 
 	authResponse, err := h.domruAPI.LoginWithPassword(accountID, password)
 	if err != nil {
-		h.Logger.With("err", err.Error()).Warn("failed to login with password")
+		h.Logger.With("err", err.Error()).WarnContext(r.Context(), "failed to login with password")
 
 		var errorMessage string
 		var upstreamErr *helpers.UpstreamError
@@ -32,18 +39,16 @@ func (h *Handler) LoginWithPasswordHandler(w http.ResponseWriter, r *http.Reques
 			errorMessage = fmt.Sprintf("Internal error: %s", err.Error())
 		}
 
-		data := models.LoginPageData{LoginError: errorMessage, Phone: ""}
+		data := models.LoginPageData{LoginError: errorMessage, Phone: "", InMemory: h.credentialsInMemory()}
 		data.BaseURL = h.determineBaseURL(r)
 		if err = h.renderTemplate(w, "login", data); err != nil {
-			h.Logger.With("err", err.Error()).Error("failed to render login page")
-			http.Error(w, fmt.Sprintf("failed to render login page: %v", err), http.StatusInternalServerError)
+			h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to render login page")
 		}
 		return
 	}
 
-	if err = h.credentialsStore.SaveCredentials(auth.NewCredentialsFromAuthResponse(authResponse)); err != nil {
-		h.Logger.With("err", err.Error()).Error("failed to save credentials")
-		http.Error(w, fmt.Sprintf("failed to save credentials: %v", err), http.StatusInternalServerError)
+	if err = h.saveCredentials(account, auth.NewCredentialsFromAuthResponse(authResponse)); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось сохранить данные для входа", err)
 		return
 	}
 
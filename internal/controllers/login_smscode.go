@@ -3,33 +3,98 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/090809/homeassistant-domru/internal/models"
 	"github.com/090809/homeassistant-domru/pkg/auth"
 )
 
+const (
+	// smsCodeLength is the number of digits in Dom.ru confirmation codes.
+	smsCodeLength = 4
+	// maxSmsAttempts is how many wrong codes are accepted before the login starts over.
+	maxSmsAttempts = 3
+)
+
+var errInvalidSmsCode = fmt.Errorf("код должен состоять из %d цифр", smsCodeLength)
+
 func (h *Handler) SubmitSmsCodeHandler(w http.ResponseWriter, r *http.Request) {
 	phoneNumber := r.FormValue("phone")
-	smsCode := r.FormValue("smsCode")
+	// The account comes with the form, so overlapping logins can't save into each other's account
+	account := r.FormValue("account")
+	if account != "" {
+		if err := auth.ValidateAccountName(account); err != nil {
+			h.renderLoginPage(w, r, invalidAccountNameMessage)
+			return
+		}
+	}
 
 	if h.accountInfo == nil {
-		h.Logger.Error("Account info is missing")
-		http.Error(w, "Account info is missing", http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusBadRequest, "Сессия входа истекла, начните вход заново", nil)
+		return
+	}
+
+	// Malformed codes are rejected here, so they don't waste a Dom.ru attempt
+	smsCode, err := normalizeSmsCode(r.FormValue("smsCode"))
+	if err != nil {
+		h.renderSmsPage(w, r, phoneNumber, account, err.Error())
 		return
 	}
 
 	authResponse, err := h.domruAPI.SubmitSmsCode(phoneNumber, smsCode, *h.accountInfo)
 	if err != nil {
-		h.Logger.With("err", err.Error()).Error("Failed to authenticate")
-		http.Error(w, fmt.Sprintf("Failed to authenticate: %v", err), http.StatusInternalServerError)
+		attempts := h.smsAttempts.Add(1)
+		h.Logger.With("err", err.Error()).With("attempt", attempts).WarnContext(r.Context(), "failed to submit sms code")
+		if attempts >= maxSmsAttempts {
+			h.accountInfo = nil
+			h.renderLoginPage(w, r, "Слишком много неверных кодов. Начните вход заново")
+			return
+		}
+		h.renderSmsPage(w, r, phoneNumber, account, fmt.Sprintf("Неверный код. Осталось попыток: %d", maxSmsAttempts-attempts))
 		return
 	}
 
-	err = h.credentialsStore.SaveCredentials(auth.NewCredentialsFromAuthResponse(authResponse))
+	err = h.saveCredentials(account, auth.NewCredentialsFromAuthResponse(authResponse))
 	if err != nil {
-		h.Logger.With("err", err.Error()).Error("Failed to save credentials")
-		http.Error(w, fmt.Sprintf("Failed to save credentials: %v", err), http.StatusInternalServerError)
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось сохранить данные для входа", err)
 		return
 	}
+	if account == "" {
+		h.phone.set(phoneNumber)
+	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// normalizeSmsCode strips whitespace the user may have typed or pasted and checks the code format.
+func normalizeSmsCode(code string) (string, error) {
+	code = strings.Join(strings.Fields(code), "")
+	if len(code) != smsCodeLength {
+		return "", errInvalidSmsCode
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return "", errInvalidSmsCode
+		}
+	}
+	return code, nil
+}
+
+func (h *Handler) renderSmsPage(w http.ResponseWriter, r *http.Request, phoneNumber, account, loginError string) {
+	data := models.SMSPageData{
+		Phone:      phoneNumber,
+		BaseURL:    h.determineBaseURL(r),
+		LoginError: loginError,
+		Account:    account,
+	}
+	if err := h.renderTemplate(w, "sms", data); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось отобразить страницу подтверждения", err)
+	}
+}
+
+func (h *Handler) renderLoginPage(w http.ResponseWriter, r *http.Request, loginError string) {
+	data := models.LoginPageData{LoginError: loginError, BaseURL: h.determineBaseURL(r), InMemory: h.credentialsInMemory()}
+	if err := h.renderTemplate(w, "login", data); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось отобразить страницу входа", err)
+	}
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"embed"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	domruModels "github.com/090809/homeassistant-domru/internal/domru/models"
+	"github.com/090809/homeassistant-domru/pkg/auth"
+)
+
+func TestNormalizeSmsCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{"Valid code", "1234", "1234", false},
+		{"Spaces are stripped", " 12 34 ", "1234", false},
+		{"Too short", "123", "", true},
+		{"Too long", "12345", "", true},
+		{"Letters", "12a4", "", true},
+		{"Empty", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeSmsCode(tt.code)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSubmitSmsCodeAccount(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"accessToken": "access", "refreshToken": "refresh", "operatorId": 2}`)
+	}))
+	defer upstream.Close()
+
+	store := auth.NewFileCredentialsStore(filepath.Join(t.TempDir(), "credentials.json"))
+	h := NewHandlers(embed.FS{}, store, domru.NewDomruAPI(upstream.Client(), upstream.URL))
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	accountID := "123"
+	h.accountInfo = &domruModels.Account{AccountID: &accountID}
+
+	// The account is taken from the submitted form, not from the login that started last
+	form := url.Values{"phone": {"79001234567"}, "smsCode": {"1234"}, "account": {"dacha"}}
+	request := httptest.NewRequest(http.MethodPost, "/sms", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	h.SubmitSmsCodeHandler(recorder, request)
+	assert.Equal(t, http.StatusSeeOther, recorder.Code)
+
+	accounts, err := store.Accounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	assert.Equal(t, "dacha", accounts[0].Name)
+	assert.Equal(t, "refresh", accounts[0].RefreshToken)
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"net/http"
+)
+
+// DiscoveryCleaner removes the MQTT entities of the account from Home Assistant.
+type DiscoveryCleaner interface {
+	CleanupDiscovery()
+}
+
+// DeviceRediscoverer publishes the devices of the account, i.e. after logging in again.
+type DeviceRediscoverer interface {
+	Rediscover()
+}
+
+// LogoutHandler forgets the credentials and removes the MQTT entities of the account,
+// so they don't linger as unavailable after logging in as another account.
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.credentialsStore.ClearCredentials(); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось выйти из аккаунта. Попробуйте позже", err)
+		return
+	}
+	h.accountInfo = nil
+	h.phone.clear()
+
+	if h.DiscoveryCleanup != nil {
+		h.DiscoveryCleanup.CleanupDiscovery()
+	}
+	h.Logger.InfoContext(r.Context(), "logged out")
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
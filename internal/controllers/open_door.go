@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/internal/homeassistant"
+)
+
+const openDoorAction = "accessControlOpen"
+
+// openDoorPath matches the Dom.ru actions endpoint of an access control.
+var openDoorPath = regexp.MustCompile(`^/rest/v1/places/(\d+)/accesscontrols/(\d+)/actions$`)
+
+// DoorOpenRecorder is told about the door opens, i.e. to update the last open sensor of the door.
+type DoorOpenRecorder interface {
+	RecordDoorOpen(open homeassistant.DoorOpen)
+}
+
+// doorOpenSource tells the web UI, which runs behind the Home Assistant ingress, from other API clients.
+func doorOpenSource(r *http.Request) homeassistant.DoorOpenSource {
+	if r.Header.Get("X-Ingress-Path") != "" {
+		return homeassistant.DoorOpenWeb
+	}
+	return homeassistant.DoorOpenAPI
+}
+
+// ObserveDoorOpen reports the door opens proxied to Dom.ru as is to DoorOpens, it's meant for the ObserveResponse
+// hook of the proxy. Opens handled by OpenDoorHandler are reported by the handler.
+func (h *Handler) ObserveDoorOpen(r *http.Request, resp *http.Response) {
+	if h.DoorOpens == nil || r.Method != http.MethodPost {
+		return
+	}
+	match := openDoorPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		return
+	}
+	placeID, _ := strconv.Atoi(match[1])
+	accessControlID, _ := strconv.Atoi(match[2])
+
+	open := homeassistant.DoorOpen{Source: doorOpenSource(r), PlaceID: placeID, AccessControlID: accessControlID}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		open.Err = fmt.Errorf("dom.ru answered %s", resp.Status)
+	}
+	h.DoorOpens.RecordDoorOpen(open)
+}
+
+type openDoorRequest struct {
+	Name string `json:"name"`
+}
+
+type openDoorResponse struct {
+	Data struct {
+		Status bool `json:"status"`
+	} `json:"data"`
+	Error string `json:"error,omitempty"`
+}
+
+// OpenDoorHandler opens a door like the Dom.ru actions endpoint does, but checks first
+// that the door is online and may be opened, answering 409 Conflict otherwise.
+// The account path value selects an additional account, the door of the primary one is opened without it.
+func (h *Handler) OpenDoorHandler(w http.ResponseWriter, r *http.Request) {
+	account := r.PathValue("account")
+	api := h.accountAPI(account)
+	if api == nil {
+		http.Error(w, "unknown account", http.StatusNotFound)
+		return
+	}
+	placeID, err := strconv.Atoi(r.PathValue("placeId"))
+	if err != nil {
+		http.Error(w, "invalid place id", http.StatusBadRequest)
+		return
+	}
+	accessControlID, err := strconv.Atoi(r.PathValue("accessControlId"))
+	if err != nil {
+		http.Error(w, "invalid access control id", http.StatusBadRequest)
+		return
+	}
+
+	var request openDoorRequest
+	if err = json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name != openDoorAction {
+		http.Error(w, "only the "+openDoorAction+" action is supported", http.StatusBadRequest)
+		return
+	}
+
+	var response openDoorResponse
+	status := http.StatusOK
+	err = api.WithContext(r.Context()).OpenDoorChecked(placeID, accessControlID)
+	switch {
+	case errors.Is(err, domru.ErrDoorUnavailable):
+		status = http.StatusConflict
+		response.Error = "door is offline or opening is not allowed"
+	case needsLogin(err):
+		status = http.StatusUnauthorized
+		response.Error = "session expired, log in again"
+	case err != nil:
+		status = http.StatusBadGateway
+		response.Error = "failed to open door"
+	default:
+		response.Data.Status = true
+	}
+	if err != nil {
+		h.Logger.With("err", err.Error()).With("account", account).With("placeID", placeID).With("accessControlID", accessControlID).WarnContext(r.Context(), "failed to open door")
+	}
+	if h.DoorOpens != nil {
+		h.DoorOpens.RecordDoorOpen(homeassistant.DoorOpen{Source: doorOpenSource(r), Account: account, PlaceID: placeID, AccessControlID: accessControlID, Err: err})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to encode open door response")
+	}
+}
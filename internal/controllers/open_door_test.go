@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"embed"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/internal/homeassistant"
+)
+
+type recordedOpens []homeassistant.DoorOpen
+
+func (r *recordedOpens) RecordDoorOpen(open homeassistant.DoorOpen) {
+	*r = append(*r, open)
+}
+
+func TestObserveDoorOpen(t *testing.T) {
+	var opens recordedOpens
+	h := &Handler{DoorOpens: &opens}
+
+	web := httptest.NewRequest(http.MethodPost, "/rest/v1/places/345/accesscontrols/12/actions", nil)
+	web.Header.Set("X-Ingress-Path", "/api/hassio_ingress/abcdef")
+	h.ObserveDoorOpen(web, &http.Response{StatusCode: http.StatusOK, Status: "200 OK"})
+
+	api := httptest.NewRequest(http.MethodPost, "/rest/v1/places/345/accesscontrols/12/actions", nil)
+	h.ObserveDoorOpen(api, &http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"})
+
+	// Other proxied requests are not door opens
+	h.ObserveDoorOpen(httptest.NewRequest(http.MethodGet, "/rest/v1/places/345/accesscontrols/12/actions", nil), &http.Response{StatusCode: http.StatusOK})
+	h.ObserveDoorOpen(httptest.NewRequest(http.MethodPost, "/rest/v1/places/345/accesscontrols", nil), &http.Response{StatusCode: http.StatusOK})
+
+	require.Len(t, opens, 2)
+	assert.Equal(t, homeassistant.DoorOpen{Source: homeassistant.DoorOpenWeb, PlaceID: 345, AccessControlID: 12}, opens[0])
+	assert.Equal(t, homeassistant.DoorOpenAPI, opens[1].Source)
+	assert.Error(t, opens[1].Err)
+}
+
+func TestOpenDoorHandlerAccount(t *testing.T) {
+	var opened []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			// The pre-check is not available, the door is opened without it
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		opened = append(opened, r.URL.Path)
+		_, _ = w.Write([]byte(`{"data":{"status":true}}`))
+	}))
+	defer upstream.Close()
+
+	var opens recordedOpens
+	h := NewHandlers(embed.FS{}, nil, nil)
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	h.DoorOpens = &opens
+	h.Accounts = []Account{{Name: "dacha", API: domru.NewDomruAPI(upstream.Client(), upstream.URL)}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /accounts/{account}/places/{placeId}/accesscontrols/{accessControlId}/actions", h.OpenDoorHandler)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/accounts/dacha/places/345/accesscontrols/12/actions", strings.NewReader(`{"name":"accessControlOpen"}`)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{"/rest/v1/places/345/accesscontrols/12/actions"}, opened)
+	require.Len(t, opens, 1)
+	assert.Equal(t, "dacha", opens[0].Account)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/accounts/apartment/places/345/accesscontrols/12/actions", strings.NewReader(`{"name":"accessControlOpen"}`)))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Len(t, opened, 1)
+}
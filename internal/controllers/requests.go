@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/090809/homeassistant-domru/pkg/requestlog"
+)
+
+const (
+	defaultRequestsLimit = 50
+	maxRequestsLimit     = 500
+)
+
+// RequestLister pages through the recent proxied requests, e.g. a *requestlog.Log.
+type RequestLister interface {
+	Page(filter requestlog.Filter, offset, limit int) ([]requestlog.Entry, int)
+}
+
+type requestsResponse struct {
+	Total    int                `json:"total"`
+	Offset   int                `json:"offset"`
+	Limit    int                `json:"limit"`
+	Requests []requestlog.Entry `json:"requests"`
+}
+
+// RequestsHandler lists the recent proxied requests as JSON, newest first. They may be filtered by
+// the method, status and path prefix query parameters and paged with offset and limit.
+func (h *Handler) RequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.RequestLog == nil {
+		http.Error(w, "request log is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := requestlog.Filter{Method: query.Get("method"), PathPrefix: query.Get("path")}
+	var err error
+	if filter.Status, err = intQueryParam(query.Get("status"), 0); err != nil {
+		http.Error(w, "status must be a number", http.StatusBadRequest)
+		return
+	}
+	offset, err := intQueryParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+	limit, err := intQueryParam(query.Get("limit"), defaultRequestsLimit)
+	if err != nil || limit < 1 || limit > maxRequestsLimit {
+		http.Error(w, "limit must be a number between 1 and "+strconv.Itoa(maxRequestsLimit), http.StatusBadRequest)
+		return
+	}
+
+	entries, total := h.RequestLog.Page(filter, offset, limit)
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(requestsResponse{Total: total, Offset: offset, Limit: limit, Requests: entries}); err != nil {
+		h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to encode request log")
+	}
+}
+
+func intQueryParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// snapshotCacheControl keeps snapshots fresh while still letting a dashboard reuse a frame for a few seconds.
+const snapshotCacheControl = "private, max-age=10"
+
+// snapshotPlaceholderFile is shown instead of a snapshot that couldn't be retrieved.
+const snapshotPlaceholderFile = "templates/snapshot_placeholder.jpg"
+
+// SnapshotHandler serves an access control snapshot through the authorized client,
+// so a rotated token is refreshed and the request retried instead of returning a broken image.
+func (h *Handler) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	placeID := r.PathValue("placeId")
+	accessControlID := r.PathValue("accessControlId")
+	if _, err := strconv.Atoi(placeID); err != nil {
+		http.Error(w, "invalid place id", http.StatusBadRequest)
+		return
+	}
+	if _, err := strconv.Atoi(accessControlID); err != nil {
+		http.Error(w, "invalid access control id", http.StatusBadRequest)
+		return
+	}
+
+	snapshot, err := h.domruAPI.WithContext(r.Context()).GetSnapshot(placeID, accessControlID)
+	if err != nil {
+		h.Logger.With("err", err.Error()).With("placeID", placeID).With("accessControlID", accessControlID).WarnContext(r.Context(), "failed to get snapshot")
+		// Failures must not be cached, otherwise Home Assistant keeps showing a broken picture
+		w.Header().Set("Cache-Control", "no-store")
+
+		if h.SnapshotPlaceholder {
+			h.writeSnapshotPlaceholder(w, r)
+			return
+		}
+
+		status := http.StatusBadGateway
+		if needsLogin(err) {
+			status = http.StatusUnauthorized
+		}
+		http.Error(w, "failed to get snapshot", status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", snapshotCacheControl)
+	w.Header().Set("Content-Length", strconv.Itoa(len(snapshot)))
+	if _, err = w.Write(snapshot); err != nil {
+		h.Logger.With("err", err.Error()).DebugContext(r.Context(), "failed to write snapshot")
+	}
+}
+
+// writeSnapshotPlaceholder serves the embedded "no image" picture. It is sent with 200,
+// since Home Assistant discards the body of an error response and shows a broken image again.
+func (h *Handler) writeSnapshotPlaceholder(w http.ResponseWriter, r *http.Request) {
+	placeholder, err := h.TemplateFs.ReadFile(snapshotPlaceholderFile)
+	if err != nil {
+		h.Logger.With("err", err.Error()).ErrorContext(r.Context(), "failed to read snapshot placeholder")
+		http.Error(w, "failed to get snapshot", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(placeholder)))
+	w.Header().Set("X-Snapshot-Placeholder", "1")
+	if _, err = w.Write(placeholder); err != nil {
+		h.Logger.With("err", err.Error()).DebugContext(r.Context(), "failed to write snapshot placeholder")
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
+	"github.com/090809/homeassistant-domru/internal/homeassistant"
+	"github.com/090809/homeassistant-domru/internal/models"
+	"github.com/090809/homeassistant-domru/pkg/tokenmanagement"
+)
+
+// DiscoveryReporter provides the summary of the latest MQTT discovery.
+type DiscoveryReporter interface {
+	DiscoverySummary() homeassistant.DiscoverySummary
+}
+
+// StatusHandler shows who is logged in, until when the token is valid and how many doors are discovered.
+func (h *Handler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	data := models.StatusPageData{BaseURL: h.determineBaseURL(r)}
+
+	credentials, err := h.credentialsStore.LoadCredentials()
+	if err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось прочитать данные для входа", err)
+		return
+	}
+	data.OperatorID = credentials.OperatorID
+
+	if expiresAt, expiryErr := tokenmanagement.TokenExpiry(credentials.AccessToken); expiryErr != nil {
+		h.Logger.With("err", expiryErr.Error()).DebugContext(r.Context(), "unable to read token expiry")
+	} else {
+		data.TokenExpiresAt = expiresAt.In(h.location())
+		data.TokenExpired = time.Now().After(expiresAt)
+	}
+
+	profile, err := h.domruAPI.WithContext(r.Context()).GetSubscriberProfile()
+	if err != nil {
+		h.Logger.With("err", err.Error()).WarnContext(r.Context(), "failed to get subscriber profile for status page")
+		data.Errors = append(data.Errors, "Не удалось получить профиль абонента")
+	} else {
+		h.phone.set(profilePhone(profile))
+		if len(profile.SubscriberPhones) > 0 {
+			data.Phone = sanitizing_utils.MaskPhone(profile.SubscriberPhones[0].Number)
+		}
+	}
+
+	if h.Discovery != nil {
+		summary := h.Discovery.DiscoverySummary()
+		data.MqttEnabled = true
+		data.DoorsDiscovered = summary.Published
+		data.DiscoveryFailed = summary.Failed
+		data.LastDiscovery = summary.At.In(h.location())
+	}
+
+	if err = h.renderTemplate(w, "status", data); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Не удалось отобразить страницу состояния", err)
+	}
+}
@@ -1,23 +1,126 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// streamHeaders are the upstream response headers passed to the client of a proxied stream.
+var streamHeaders = []string{"Content-Type", "Content-Length", "Cache-Control"}
+
 func (h *Handler) StreamController(w http.ResponseWriter, r *http.Request) {
-	h.Logger.Debug("StreamController: %s %s", r.Method, r.URL.Path)
+	h.Logger.DebugContext(r.Context(), "StreamController: %s %s", r.Method, r.URL.Path)
 	cameraID := r.PathValue("cameraId")
 	if cameraID == "" {
 		http.Error(w, "cameraId is required", http.StatusBadRequest)
 		return
 	}
 
-	streamURL, err := h.domruAPI.GetStreamURL(cameraID, r.URL.Query())
+	streamURL, err := h.domruAPI.WithContext(r.Context()).GetStreamURL(cameraID, r.URL.Query())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to get stream url: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, streamURL, http.StatusFound)
+	if !h.StreamProxy {
+		http.Redirect(w, r, streamURL, http.StatusFound)
+		return
+	}
+
+	if !h.streams.acquire(cameraID, h.MaxStreamsPerCamera) {
+		http.Error(w, "too many streams of this camera", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.streams.release(cameraID)
+
+	h.proxyStream(w, r, streamURL)
+}
+
+// proxyStream copies the upstream stream to the client. The upstream request is bound to the client
+// request context, so a client disconnect cancels it and closes the upstream connection right away.
+func (h *Handler) proxyStream(w http.ResponseWriter, r *http.Request, streamURL string) {
+	upstreamReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, streamURL, nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid stream url: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	client := h.StreamClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(upstreamReq)
+	if err != nil {
+		h.Logger.With("err", err.Error()).WarnContext(r.Context(), "failed to open camera stream")
+		http.Error(w, "failed to open camera stream", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range streamHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	controller := http.NewResponseController(w)
+	// The stream is long-lived, so the server write timeout must not apply to it
+	if err = controller.SetWriteDeadline(time.Time{}); err != nil {
+		h.Logger.With("err", err.Error()).DebugContext(r.Context(), "unable to reset write deadline for camera stream")
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err = w.Write(buf[:n]); err != nil {
+				h.Logger.DebugContext(r.Context(), "camera stream closed by client")
+				return
+			}
+			_ = controller.Flush()
+		}
+		switch {
+		case readErr == nil:
+		case errors.Is(readErr, io.EOF):
+			return
+		case r.Context().Err() != nil:
+			h.Logger.DebugContext(r.Context(), "camera stream closed by client")
+			return
+		default:
+			h.Logger.With("err", readErr.Error()).WarnContext(r.Context(), "camera stream interrupted")
+			return
+		}
+	}
+}
+
+// streamLimiter counts the proxied streams of every camera.
+type streamLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// acquire reserves a stream of the camera unless it already has max of them, max <= 0 means unlimited.
+func (l *streamLimiter) acquire(cameraID string, max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max > 0 && l.active[cameraID] >= max {
+		return false
+	}
+	if l.active == nil {
+		l.active = make(map[string]int)
+	}
+	l.active[cameraID]++
+	return true
+}
+
+func (l *streamLimiter) release(cameraID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[cameraID]--; l.active[cameraID] <= 0 {
+		delete(l.active, cameraID)
+	}
 }
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyStreamClientDisconnect(t *testing.T) {
+	streaming := make(chan struct{})
+	upstreamClosed := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamClosed)
+		w.Header().Set("Content-Type", "video/mp2t")
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			if _, err := w.Write([]byte("chunk")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			if i == 0 {
+				close(streaming)
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	h := &Handler{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), StreamClient: upstream.Client()}
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/stream/1", nil).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.proxyStream(httptest.NewRecorder(), req, upstream.URL)
+	}()
+
+	<-streaming
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "proxyStream did not return after the client disconnected")
+	}
+	select {
+	case <-upstreamClosed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "upstream request was not closed")
+	}
+}
+
+func TestStreamLimiter(t *testing.T) {
+	var limiter streamLimiter
+	assert.True(t, limiter.acquire("1", 1))
+	assert.False(t, limiter.acquire("1", 1))
+	assert.True(t, limiter.acquire("2", 1))
+
+	limiter.release("1")
+	assert.True(t, limiter.acquire("1", 1))
+	assert.True(t, limiter.acquire("1", 0), "zero is unlimited")
+}
@@ -1,10 +1,14 @@
 package domru
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/090809/homeassistant-domru/internal/domru/constants"
 	"github.com/090809/homeassistant-domru/internal/domru/helpers"
@@ -18,27 +22,53 @@ type APIWrapper struct {
 	Logger     *slog.Logger
 	baseURL    string
 	authClient myhttp.HTTPClient
+	ctx        context.Context
 }
 
-func NewDomruAPI(authClient myhttp.HTTPClient) *APIWrapper {
-	return &APIWrapper{authClient: authClient, baseURL: constants.BaseUrl, Logger: slog.Default()}
+func NewDomruAPI(authClient myhttp.HTTPClient, baseURL string) *APIWrapper {
+	if baseURL == "" {
+		baseURL = constants.BaseUrl
+	}
+	return &APIWrapper{authClient: authClient, baseURL: baseURL, Logger: slog.Default(), ctx: context.Background()}
+}
+
+// WithContext returns a copy of the wrapper sending its requests with ctx,
+// so they are cancelled with it and logged with its request ID.
+func (w *APIWrapper) WithContext(ctx context.Context) *APIWrapper {
+	wrapper := *w
+	wrapper.ctx = ctx
+	return &wrapper
+}
+
+// newRequest creates an authorized upstream request bound to the wrapper context.
+func (w *APIWrapper) newRequest(rawURL string, options ...func(*helpers.UpstreamRequest)) *helpers.UpstreamRequest {
+	options = append([]func(*helpers.UpstreamRequest){helpers.WithClient(w.authClient), helpers.WithContext(w.ctx), helpers.WithLogger(w.Logger)}, options...)
+	return helpers.NewUpstreamRequest(rawURL, options...)
+}
+
+// BaseURL returns the Dom.ru API base URL the wrapper sends requests to.
+func (w *APIWrapper) BaseURL() string {
+	return w.baseURL
 }
 
 func (w *APIWrapper) LoginWithPassword(accountID, password string) (models.AuthenticationResponse, error) {
 	authenticator := auth.NewPasswordAuthenticator(accountID, password)
 	authenticator.Logger = w.Logger
+	authenticator.BaseURL = w.baseURL
 
 	return authenticator.Authenticate()
 }
 
 func (w *APIWrapper) LoginWithPhoneNumber(phoneNumber string, account models.Account) error {
 	authenticator := auth.NewPhoneNumberAuthenticator(phoneNumber)
+	authenticator.BaseURL = w.baseURL
 
 	return authenticator.RequestSmsCode(account)
 }
 
 func (w *APIWrapper) SubmitSmsCode(phoneNumber, code string, account models.Account) (models.AuthenticationResponse, error) {
 	authenticator := auth.NewPhoneNumberAuthenticator(phoneNumber)
+	authenticator.BaseURL = w.baseURL
 
 	return authenticator.SubmitSmsCode(code, account)
 }
@@ -47,7 +77,7 @@ func (w *APIWrapper) RequestCameras() (models.CamerasResponse, error) {
 	var cameras models.CamerasResponse
 
 	camerasURL := fmt.Sprintf("%s/rest/v1/forpost/cameras", w.baseURL)
-	err := helpers.NewUpstreamRequest(camerasURL, helpers.WithClient(w.authClient)).Send(http.MethodGet, &cameras)
+	err := w.newRequest(camerasURL).Send(http.MethodGet, &cameras)
 	if err != nil {
 		return models.CamerasResponse{}, fmt.Errorf("request cameras: %w", err)
 	}
@@ -58,7 +88,7 @@ func (w *APIWrapper) RequestPlaces() (models.PlacesResponse, error) {
 	var places models.PlacesResponse
 
 	placesURL := fmt.Sprintf("%s/rest/v1/subscriberplaces", w.baseURL)
-	err := helpers.NewUpstreamRequest(placesURL, helpers.WithClient(w.authClient)).Send(http.MethodGet, &places)
+	err := w.newRequest(placesURL).Send(http.MethodGet, &places)
 	if err != nil {
 		return models.PlacesResponse{}, fmt.Errorf("request places: %w", err)
 	}
@@ -69,18 +99,48 @@ func (w *APIWrapper) RequestFinances() (models.FinancesResponse, error) {
 	var finances models.FinancesResponse
 
 	financesURL := fmt.Sprintf("%s/rest/v1/subscribers/profiles/finances", w.baseURL)
-	err := helpers.NewUpstreamRequest(financesURL, helpers.WithClient(w.authClient)).Send(http.MethodGet, &finances)
+	err := w.newRequest(financesURL).Send(http.MethodGet, &finances)
 	if err != nil {
 		return models.FinancesResponse{}, fmt.Errorf("request finances: %w", err)
 	}
 	return finances, nil
 }
 
+// ErrBalanceUnavailable is returned by RequestBalance for accounts without billing information.
+var ErrBalanceUnavailable = errors.New("balance is not available for this account")
+
+// RequestBalance returns the account finances, or ErrBalanceUnavailable when the account has no balance.
+func (w *APIWrapper) RequestBalance() (models.FinancesResponse, error) {
+	finances, err := w.RequestFinances()
+	if err != nil {
+		var upstreamErr *helpers.UpstreamError
+		if errors.As(err, &upstreamErr) && (upstreamErr.StatusCode == http.StatusNotFound || upstreamErr.StatusCode == http.StatusForbidden) {
+			return models.FinancesResponse{}, ErrBalanceUnavailable
+		}
+		return models.FinancesResponse{}, err
+	}
+	if finances.Balance == nil {
+		return models.FinancesResponse{}, ErrBalanceUnavailable
+	}
+	return finances, nil
+}
+
+func (w *APIWrapper) RequestEvents(placeID int) (models.EventsResponse, error) {
+	var events models.EventsResponse
+
+	eventsURL := constants.GetEventsUrl(w.baseURL, strconv.Itoa(placeID))
+	err := w.newRequest(eventsURL).Send(http.MethodGet, &events)
+	if err != nil {
+		return models.EventsResponse{}, fmt.Errorf("request events: %w", err)
+	}
+	return events, nil
+}
+
 func (w *APIWrapper) RequestAccounts(phone string) ([]models.Account, error) {
 	var accounts []models.Account
 
 	loginURL := fmt.Sprintf("%s/auth/v2/login/%s", w.baseURL, phone)
-	err := helpers.NewUpstreamRequest(loginURL).Send(http.MethodGet, &accounts)
+	err := helpers.NewUpstreamRequest(loginURL, helpers.WithContext(w.ctx), helpers.WithLogger(w.Logger)).Send(http.MethodGet, &accounts)
 	if err != nil {
 		return nil, fmt.Errorf("request accounts: %w", err)
 	}
@@ -89,9 +149,18 @@ func (w *APIWrapper) RequestAccounts(phone string) ([]models.Account, error) {
 
 func (w *APIWrapper) GetSnapshot(placeID, accessControl string) ([]byte, error) {
 	snapshotURL := fmt.Sprintf("%s/rest/v1/places/%s/accesscontrols/%s/videosnapshots", w.baseURL, placeID, accessControl)
-	resp, err := helpers.NewUpstreamRequest(snapshotURL).SendRequest(http.MethodGet)
+	return w.requestSnapshot(snapshotURL)
+}
+
+// GetCameraSnapshot returns the current image of a camera, i.e. one that isn't attached to an access control.
+func (w *APIWrapper) GetCameraSnapshot(cameraID int) ([]byte, error) {
+	return w.requestSnapshot(constants.GetCameraSnapshotUrl(w.baseURL, cameraID))
+}
+
+func (w *APIWrapper) requestSnapshot(snapshotURL string) ([]byte, error) {
+	resp, err := w.newRequest(snapshotURL).SendRequest(http.MethodGet)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("request snapshot: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -116,7 +185,7 @@ func (w *APIWrapper) GetStreamURL(cameraID string, queryParams url.Values) (stri
 	var videoResponse models.VideoResponse
 
 	streamURL := fmt.Sprintf("%s/rest/v1/forpost/cameras/%s/video", w.baseURL, cameraID)
-	err := helpers.NewUpstreamRequest(streamURL, helpers.WithClient(w.authClient), helpers.WithQueryParams(queryParams)).Send(http.MethodGet, &videoResponse)
+	err := w.newRequest(streamURL, helpers.WithQueryParams(queryParams)).Send(http.MethodGet, &videoResponse)
 	if err != nil {
 		return "", fmt.Errorf("request stream streamUrl: %w", err)
 	}
@@ -127,30 +196,143 @@ func (w *APIWrapper) GetStreamURL(cameraID string, queryParams url.Values) (stri
 	return videoResponse.Data.URL, nil
 }
 
+const (
+	// MaxArchiveWindow limits a single archive request, every hour costs an upstream call.
+	MaxArchiveWindow      = 6 * time.Hour
+	archiveSegmentMaxSize = time.Hour
+)
+
+var (
+	// ErrInvalidArchiveRange is returned by RequestArchive when the requested time window is not usable.
+	ErrInvalidArchiveRange = errors.New("invalid archive time range")
+	// ErrArchiveUnavailable is returned by RequestArchive when the camera plan has no archive access.
+	ErrArchiveUnavailable = errors.New("archive is not available for this camera")
+)
+
+// RequestArchive returns playable archive segments covering [from, to), split by hour.
+func (w *APIWrapper) RequestArchive(cameraID int, from, to time.Time) ([]models.ArchiveSegment, error) {
+	now := time.Now()
+	if to.After(now) {
+		to = now
+	}
+	switch {
+	case !from.Before(to):
+		return nil, fmt.Errorf("%w: from must be before to and in the past", ErrInvalidArchiveRange)
+	case to.Sub(from) > MaxArchiveWindow:
+		return nil, fmt.Errorf("%w: window must not exceed %s", ErrInvalidArchiveRange, MaxArchiveWindow)
+	}
+
+	_, tzOffset := from.Zone()
+	videoURL := constants.GetCameraVideoUrl(w.baseURL, cameraID)
+
+	var segments []models.ArchiveSegment
+	for start := from; start.Before(to); start = start.Add(archiveSegmentMaxSize) {
+		end := start.Add(archiveSegmentMaxSize)
+		if end.After(to) {
+			end = to
+		}
+
+		params := url.Values{}
+		params.Set("TS", strconv.FormatInt(start.Unix(), 10))
+		params.Set("TZ", strconv.Itoa(tzOffset))
+
+		var videoResponse models.VideoResponse
+		err := w.newRequest(videoURL, helpers.WithQueryParams(params)).Send(http.MethodGet, &videoResponse)
+		if err != nil {
+			var upstreamErr *helpers.UpstreamError
+			if errors.As(err, &upstreamErr) && (upstreamErr.StatusCode == http.StatusNotFound || upstreamErr.StatusCode == http.StatusForbidden) {
+				return nil, ErrArchiveUnavailable
+			}
+			return nil, fmt.Errorf("request archive: %w", err)
+		}
+		if videoResponse.Data.Error != "" || videoResponse.Data.URL == "" {
+			return nil, fmt.Errorf("%w: %s", ErrArchiveUnavailable, videoResponse.Data.Error)
+		}
+
+		segments = append(segments, models.ArchiveSegment{Start: start, End: end, URL: videoResponse.Data.URL})
+	}
+
+	return segments, nil
+}
+
 func (w *APIWrapper) GetSubscriberProfile() (models.SubscriberProfilesResponse, error) {
 	var profile models.SubscriberProfilesResponse
 
 	profileURL := fmt.Sprintf("%s/rest/v1/subscribers/profiles", w.baseURL)
-	err := helpers.NewUpstreamRequest(profileURL, helpers.WithClient(w.authClient)).Send(http.MethodGet, &profile)
+	err := w.newRequest(profileURL).Send(http.MethodGet, &profile)
 	if err != nil {
 		return models.SubscriberProfilesResponse{}, fmt.Errorf("request subscriber profile: %w", err)
 	}
 	return profile, nil
 }
 
+// RequestSipDevice returns the SIP account intercom calls of the access control ring.
+func (w *APIWrapper) RequestSipDevice(placeID, accessControl int) (models.SipDevice, error) {
+	var sipDevice models.SipDeviceResponse
+
+	sipDeviceURL := fmt.Sprintf("%s/rest/v1/places/%d/accesscontrols/%d/sipdevices", w.baseURL, placeID, accessControl)
+	err := w.newRequest(sipDeviceURL).Send(http.MethodGet, &sipDevice)
+	if err != nil {
+		return models.SipDevice{}, fmt.Errorf("request sip device: %w", err)
+	}
+	return sipDevice.Data, nil
+}
+
 func (w *APIWrapper) OpenDoor(placeID, accessControl int) error {
 	openDoorURL := fmt.Sprintf("%s/rest/v1/places/%d/accesscontrols/%d/actions", w.baseURL, placeID, accessControl)
 
-	_, err := helpers.NewUpstreamRequest(
+	// Send checks the status, so a rejected open isn't reported as a success
+	err := w.newRequest(
 		openDoorURL,
-		helpers.WithClient(w.authClient),
 		helpers.WithBody(map[string]string{
 			"name": "accessControlOpen",
 		}),
-	).SendRequest(http.MethodPost)
+	).Send(http.MethodPost, nil)
 
 	if err != nil {
 		return fmt.Errorf("open door: %w", err)
 	}
 	return nil
 }
+
+var (
+	// ErrAccessControlNotFound is returned by AccessControlStatus when no place has the access control.
+	ErrAccessControlNotFound = errors.New("access control not found")
+	// ErrDoorUnavailable is returned by OpenDoorChecked when the door can't be opened right now.
+	ErrDoorUnavailable = errors.New("door is offline or opening is not allowed")
+)
+
+// AccessControlStatus tells whether the access control of the place can be opened right now.
+func (w *APIWrapper) AccessControlStatus(placeID, accessControl int) (models.AccessControlStatus, error) {
+	places, err := w.RequestPlaces()
+	if err != nil {
+		return models.AccessControlStatus{}, fmt.Errorf("request access control status: %w", err)
+	}
+
+	for _, data := range places.Data {
+		if data.Place.ID != placeID {
+			continue
+		}
+		for _, ac := range data.Place.AccessControls {
+			if ac.ID == accessControl {
+				return models.AccessControlStatus{AllowOpen: ac.AllowOpen, Blocked: data.Blocked}, nil
+			}
+		}
+	}
+	return models.AccessControlStatus{}, ErrAccessControlNotFound
+}
+
+// OpenDoorChecked opens the door only if AccessControlStatus allows it, otherwise ErrDoorUnavailable is returned.
+// When the status can't be requested, the door is opened anyway, like OpenDoor does.
+func (w *APIWrapper) OpenDoorChecked(placeID, accessControl int) error {
+	status, err := w.AccessControlStatus(placeID, accessControl)
+	switch {
+	case err != nil:
+		w.Logger.With("err", err.Error()).With("placeID", placeID).With("accessControlID", accessControl).
+			WarnContext(w.ctx, "door pre-check is not available, opening without it")
+	case !status.CanOpen():
+		return fmt.Errorf("%w: allowOpen=%t, blocked=%t", ErrDoorUnavailable, status.AllowOpen, status.Blocked)
+	}
+
+	return w.OpenDoor(placeID, accessControl)
+}
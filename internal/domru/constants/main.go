@@ -6,7 +6,8 @@ const (
 	BaseUrl            = "https://myhome.proptech.ru"
 	USERAGENT_TEMPLATE = "Google sdkgphone64x8664 | Android 14 | erth | 8.9.2 (8090200)"
 
-	API_HA_NETWORK = "http://supervisor/network/info"
+	API_HA_NETWORK      = "http://supervisor/network/info"
+	API_HA_MQTT_SERVICE = "http://supervisor/services/mqtt"
 
 	API_AUTH = "https://api-auth.domru.ru/v1/person/auth"
 
@@ -18,13 +19,11 @@ const (
 	API_OPEN_DOOR         = "%s/rest/v1/places/%d/accesscontrols/%d/actions"
 	API_FINANCES          = "%s/rest/v1/subscribers/profiles/finances"
 	API_SUBSCRIBER_PLACES = "%s/rest/v1/subscriberplaces"
-	API_VIDEO_SNAPSHOT    = "%s/rest/v1/places/%d/accesscontrols/%d/videosnapshots"
 	API_CAMERA_GET_STREAM = "%s/rest/v1/forpost/cameras/%d/video"
+	API_CAMERA_SNAPSHOT   = "%s/rest/v1/forpost/cameras/%d/snapshots"
 	API_REFRESH_SESSION   = "%s/auth/v2/session/refresh"
 	API_EVENTS            = "%s/rest/v1/places/%s/events?allowExtentedActions=true"
 	API_OPERATORS         = "%s/public/v1/operators"
-
-	CUSTOM_STREAM_URL = "%s/stream/%d"
 )
 
 // GenerateUserAgent создает User-Agent с operatorID, UUID и placeID
@@ -36,16 +35,30 @@ func GenerateUserAgent(operatorID int, uuid string, placeID int64) string {
 	return fmt.Sprintf("Google sdkgphone64x8664 | Android 14 | erth | 8.26.0 (82600010) | | %d | %s | %d", operatorID, uuid, placeID)
 }
 
+func GetRefreshSessionUrl(baseUrl string) string {
+	return fmt.Sprintf(API_REFRESH_SESSION, baseUrl)
+}
+
+// GetSnapshotUrl returns the snapshot URL built with the default template.
 func GetSnapshotUrl(baseUrl string, placeId, accessControlId int) string {
-	return fmt.Sprintf(API_VIDEO_SNAPSHOT, baseUrl, placeId, accessControlId)
+	return URLTemplates{}.SnapshotURL(DefaultCameraModel, baseUrl, placeId, accessControlId)
 }
 
 func GetOpenDoorUrl(baseUrl string, placeId, accessControlId int) string {
 	return fmt.Sprintf(API_OPEN_DOOR, baseUrl, placeId, accessControlId)
 }
 
+// GetCameraStreamUrl returns the stream URL built with the default template.
 func GetCameraStreamUrl(baseUrl string, cameraId int) string {
-	return fmt.Sprintf(CUSTOM_STREAM_URL, baseUrl, cameraId)
+	return URLTemplates{}.StreamURL(DefaultCameraModel, baseUrl, cameraId)
+}
+
+func GetCameraVideoUrl(baseUrl string, cameraId int) string {
+	return fmt.Sprintf(API_CAMERA_GET_STREAM, baseUrl, cameraId)
+}
+
+func GetCameraSnapshotUrl(baseUrl string, cameraId int) string {
+	return fmt.Sprintf(API_CAMERA_SNAPSHOT, baseUrl, cameraId)
 }
 
 func GetEventsUrl(baseUrl, placeId string) string {
@@ -0,0 +1,64 @@
+package constants
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCameraModel is the key of the templates used for cameras without a template of their own.
+const DefaultCameraModel = "default"
+
+const (
+	defaultSnapshotTemplate = "{base}/rest/v1/places/{place}/accesscontrols/{accessControl}/videosnapshots"
+	defaultStreamTemplate   = "{base}/stream/{camera}"
+)
+
+// URLTemplates builds snapshot and stream URLs by camera model. Templates may use the {base}, {place},
+// {accessControl} and {camera} placeholders. Models without a template, and the zero value,
+// fall back to the DefaultCameraModel template and then to the built-in one.
+type URLTemplates struct {
+	Snapshot map[string]string
+	Stream   map[string]string
+}
+
+// SnapshotURL returns the snapshot URL of the access control, whose camera is of the model.
+func (t URLTemplates) SnapshotURL(model, baseUrl string, placeId, accessControlId int) string {
+	return strings.NewReplacer(
+		"{base}", baseUrl,
+		"{place}", strconv.Itoa(placeId),
+		"{accessControl}", strconv.Itoa(accessControlId),
+	).Replace(lookupTemplate(t.Snapshot, model, defaultSnapshotTemplate))
+}
+
+// StreamURL returns the stream URL of the camera of the model.
+func (t URLTemplates) StreamURL(model, baseUrl string, cameraId int) string {
+	return strings.NewReplacer(
+		"{base}", baseUrl,
+		"{camera}", strconv.Itoa(cameraId),
+	).Replace(lookupTemplate(t.Stream, model, defaultStreamTemplate))
+}
+
+func lookupTemplate(templates map[string]string, model, fallback string) string {
+	if template, ok := templates[model]; ok && model != "" {
+		return template
+	}
+	if template, ok := templates[DefaultCameraModel]; ok {
+		return template
+	}
+	return fallback
+}
+
+// ParseURLTemplates parses "model=template" entries, i.e. from a flag, into a templates map.
+func ParseURLTemplates(entries []string) (map[string]string, error) {
+	templates := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		model, template, found := strings.Cut(entry, "=")
+		model, template = strings.TrimSpace(model), strings.TrimSpace(template)
+		if !found || model == "" || template == "" {
+			return nil, fmt.Errorf("invalid URL template %q, expected model=template", entry)
+		}
+		templates[model] = template
+	}
+	return templates, nil
+}
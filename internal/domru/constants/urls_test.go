@@ -0,0 +1,61 @@
+package constants
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLTemplates(t *testing.T) {
+	custom := URLTemplates{
+		Snapshot: map[string]string{"BEWARD": "{base}/rest/v1/places/{place}/accesscontrols/{accessControl}/snapshot"},
+		Stream:   map[string]string{"BEWARD": "{base}/forpost/{camera}", DefaultCameraModel: "{base}/video/{camera}"},
+	}
+
+	tests := []struct {
+		name         string
+		templates    URLTemplates
+		model        string
+		wantSnapshot string
+		wantStream   string
+	}{
+		{
+			name:         "Built-in templates",
+			model:        "",
+			wantSnapshot: "http://ha/rest/v1/places/1/accesscontrols/2/videosnapshots",
+			wantStream:   "http://ha/stream/3",
+		},
+		{
+			name:         "Model template",
+			templates:    custom,
+			model:        "BEWARD",
+			wantSnapshot: "http://ha/rest/v1/places/1/accesscontrols/2/snapshot",
+			wantStream:   "http://ha/forpost/3",
+		},
+		{
+			name:         "Unknown model falls back to the default template",
+			templates:    custom,
+			model:        "other",
+			wantSnapshot: "http://ha/rest/v1/places/1/accesscontrols/2/videosnapshots",
+			wantStream:   "http://ha/video/3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantSnapshot, tt.templates.SnapshotURL(tt.model, "http://ha", 1, 2))
+			assert.Equal(t, tt.wantStream, tt.templates.StreamURL(tt.model, "http://ha", 3))
+		})
+	}
+
+	assert.Equal(t, GetSnapshotUrl("http://ha", 1, 2), URLTemplates{}.SnapshotURL(DefaultCameraModel, "http://ha", 1, 2))
+}
+
+func TestParseURLTemplates(t *testing.T) {
+	templates, err := ParseURLTemplates([]string{"BEWARD = {base}/forpost/{camera}"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"BEWARD": "{base}/forpost/{camera}"}, templates)
+
+	_, err = ParseURLTemplates([]string{"{base}/forpost/{camera}"})
+	assert.Error(t, err)
+}
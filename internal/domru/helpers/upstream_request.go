@@ -2,14 +2,18 @@ package helpers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/090809/homeassistant-domru/internal/domru/http"
 	"github.com/090809/homeassistant-domru/pkg/responder"
@@ -22,6 +26,12 @@ var defaultHeaders = map[string]string{
 	"accept-encoding": "gzip",
 }
 
+const bodySnippetLength = 200
+
+// ErrUpstreamUnavailable is returned when Dom.ru answers with something other than JSON,
+// usually an HTML maintenance page served with a 200 status.
+var ErrUpstreamUnavailable = errors.New("dom.ru is unavailable, received a non-JSON response")
+
 type UpstreamError struct {
 	StatusCode int
 	Body       string
@@ -36,6 +46,7 @@ func NewUpstreamError(statusCode int, body string) *UpstreamError {
 }
 
 type UpstreamRequest struct {
+	ctx     context.Context
 	client  myhttp.HTTPClient
 	url     string
 	body    interface{}
@@ -48,7 +59,7 @@ func NewUpstreamRequest(url string, options ...func(sender *UpstreamRequest)) *U
 	for key, value := range defaultHeaders {
 		headers.Set(key, value)
 	}
-	sender := &UpstreamRequest{url: url, headers: headers, body: nil, client: http.DefaultClient, logger: slog.Default()}
+	sender := &UpstreamRequest{ctx: context.Background(), url: url, headers: headers, body: nil, client: http.DefaultClient, logger: slog.Default()}
 
 	for _, option := range options {
 		option(sender)
@@ -62,6 +73,13 @@ func WithClient(client myhttp.HTTPClient) func(*UpstreamRequest) {
 	}
 }
 
+// WithContext sets the request context. Its request ID is logged with every line about the request.
+func WithContext(ctx context.Context) func(*UpstreamRequest) {
+	return func(u *UpstreamRequest) {
+		u.ctx = ctx
+	}
+}
+
 func WithHeader(key string, value string) func(*UpstreamRequest) {
 	return func(u *UpstreamRequest) {
 		u.headers.Add(key, value)
@@ -104,7 +122,7 @@ func (u *UpstreamRequest) Send(method string, output interface{}) error {
 		if content, err = responder.Read(resp); err != nil {
 			return fmt.Errorf("failed to read response content: %w. Status code: %d", err, resp.StatusCode)
 		}
-		u.logger.With("url", u.url).With("status", resp.StatusCode).With("request_headers", u.headers).With("request_body", u.body).With("response_body", string(content)).Debug("failed to send request")
+		u.logger.With("url", u.url).With("status", resp.StatusCode).With("request_headers", u.headers).With("request_body", u.body).With("response_body", string(content)).DebugContext(u.ctx, "failed to send request")
 		return NewUpstreamError(resp.StatusCode, string(content))
 	}
 
@@ -114,17 +132,46 @@ func (u *UpstreamRequest) Send(method string, output interface{}) error {
 	}
 	content, readErr := responder.Read(resp)
 	if readErr != nil {
-		u.logger.With("url", u.url).With("status", resp.StatusCode).With("request_body", u.body).Debug("failed to read response content")
+		u.logger.With("url", u.url).With("status", resp.StatusCode).With("request_body", u.body).DebugContext(u.ctx, "failed to read response content")
 		return NewUpstreamError(resp.StatusCode, "")
 	}
 
+	contentType := resp.Header.Get("Content-Type")
+	if !looksLikeJSON(contentType, content) {
+		u.logger.With("url", u.url).With("status", resp.StatusCode).With("content_type", contentType).WarnContext(u.ctx, "upstream returned a non-JSON response")
+		return fmt.Errorf("%w: status %d, content type %q, body: %q", ErrUpstreamUnavailable, resp.StatusCode, contentType, bodySnippet(content))
+	}
+
 	if decodeErr := json.NewDecoder(bytes.NewReader(content)).Decode(&output); decodeErr != nil {
-		u.logger.With("url", u.url).With("status", resp.StatusCode).With("request_body", u.body).Debug("failed to send request")
-		return fmt.Errorf("decode response. First 100 characters of body: '%s'. Error: %w", content[:100], decodeErr)
+		u.logger.With("url", u.url).With("status", resp.StatusCode).With("request_body", u.body).DebugContext(u.ctx, "failed to send request")
+		return fmt.Errorf("decode response. Beginning of body: %q. Error: %w", bodySnippet(content), decodeErr)
 	}
 	return nil
 }
 
+// looksLikeJSON rejects HTML content types and bodies that can't be a JSON object or array.
+func looksLikeJSON(contentType string, content []byte) bool {
+	if strings.Contains(strings.ToLower(contentType), "html") {
+		return false
+	}
+	trimmed := bytes.TrimSpace(content)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// bodySnippet returns the beginning of the body with whitespace collapsed, suitable for logs.
+// It is cut on a rune boundary, so Cyrillic error pages don't end in a broken character.
+func bodySnippet(content []byte) string {
+	snippet := strings.Join(strings.Fields(string(content)), " ")
+	if len(snippet) <= bodySnippetLength {
+		return snippet
+	}
+	end := bodySnippetLength
+	for end > 0 && !utf8.RuneStart(snippet[end]) {
+		end--
+	}
+	return snippet[:end] + "..."
+}
+
 func (u *UpstreamRequest) SendRequest(method string) (*http.Response, error) {
 	var requestBody io.Reader
 	if u.body != nil {
@@ -135,7 +182,7 @@ func (u *UpstreamRequest) SendRequest(method string) (*http.Response, error) {
 		requestBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, u.url, requestBody)
+	req, err := http.NewRequestWithContext(u.ctx, method, u.url, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -146,6 +193,6 @@ func (u *UpstreamRequest) SendRequest(method string) (*http.Response, error) {
 	}
 
 	resp, err := u.client.Do(req)
-	u.logger.With("url", req.URL).With("method", req.Method).With("headers", req.Header).Debug("Sent request")
+	u.logger.With("url", req.URL).With("method", req.Method).With("headers", req.Header).DebugContext(u.ctx, "Sent request")
 	return resp, err
 }
@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamRequest_Send_MaintenancePage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html><body>Технические работы</body></html>"))
+	}))
+	defer server.Close()
+
+	var output map[string]interface{}
+	err := NewUpstreamRequest(server.URL).Send(http.MethodGet, &output)
+
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable), "unexpected error: %v", err)
+	assert.Contains(t, err.Error(), "Технические работы")
+}
+
+func TestUpstreamRequest_Send_JSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"id": 1}}`))
+	}))
+	defer server.Close()
+
+	var output struct {
+		Data struct {
+			ID int `json:"id"`
+		} `json:"data"`
+	}
+	err := NewUpstreamRequest(server.URL).Send(http.MethodGet, &output)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, output.Data.ID)
+}
+
+func TestLooksLikeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		content     string
+		want        bool
+	}{
+		{"JSON object", "application/json", `{"a": 1}`, true},
+		{"JSON array with whitespace", "", "\n [1, 2]", true},
+		{"HTML content type", "text/html", `{"a": 1}`, false},
+		{"HTML body", "application/json", "<!DOCTYPE html>", false},
+		{"Empty body", "application/json", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := looksLikeJSON(tt.contentType, []byte(tt.content)); got != tt.want {
+				t.Errorf("looksLikeJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodySnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Short body", "  Service\n unavailable ", "Service unavailable"},
+		{"ASCII cut at the limit", strings.Repeat("a", bodySnippetLength+1), strings.Repeat("a", bodySnippetLength) + "..."},
+		// Every Cyrillic letter takes two bytes, the limit falls into the middle of the 101st
+		{"Cyrillic cut before a split rune", "x" + strings.Repeat("ж", bodySnippetLength), "x" + strings.Repeat("ж", bodySnippetLength/2-1) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bodySnippet([]byte(tt.content))
+			assert.Equal(t, tt.want, got)
+			assert.True(t, utf8.ValidString(got))
+		})
+	}
+}
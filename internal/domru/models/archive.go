@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// ArchiveSegment is a part of a camera recording starting at Start, playable from URL.
+type ArchiveSegment struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	URL   string    `json:"url"`
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"fmt"
 	"log/slog"
+
+	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
 )
 
 type AuthenticationResponse struct {
@@ -43,3 +46,18 @@ func (a AuthenticationResponse) LogValue() slog.Value {
 		slog.String("tokenType", *a.TokenType),
 	)
 }
+
+// LogValue masks the address and the account identifiers.
+func (a Account) LogValue() slog.Value {
+	accountID := ""
+	if a.AccountID != nil {
+		accountID = sanitizing_utils.MaskID(*a.AccountID)
+	}
+	return slog.GroupValue(
+		slog.String("accountId", accountID),
+		slog.String("address", sanitizing_utils.MaskAddress(a.Address)),
+		slog.Int("operatorId", a.OperatorID),
+		slog.Int("placeId", a.PlaceID),
+		slog.String("subscriberId", sanitizing_utils.MaskID(fmt.Sprint(a.SubscriberID))),
+	)
+}
@@ -15,6 +15,8 @@ type Camera struct {
 	TimeZone           int           `json:"TimeZone"`
 	MotionDetectorMode string        `json:"MotionDetectorMode"`
 	ParentID           string        `json:"ParentID"`
+	// Model picks the snapshot and stream URL templates, it's empty when Dom.ru doesn't report it.
+	Model string `json:"Model,omitempty"`
 }
 
 type ParentGroup struct {
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventKindCall   EventKind = "call"
+	EventKindOpen   EventKind = "open"
+	EventKindMotion EventKind = "motion"
+	EventKindOther  EventKind = "other"
+)
+
+// EventID is the event identifier. Dom.ru returns it either as a number or as a string.
+type EventID string
+
+func (id *EventID) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if string(data) == "null" {
+		*id = ""
+		return nil
+	}
+	*id = EventID(data)
+	return nil
+}
+
+type Event struct {
+	ID            EventID         `json:"id"`
+	PlaceID       int             `json:"placeId"`
+	EventTypeName string          `json:"eventTypeName"`
+	Timestamp     string          `json:"timestamp"`
+	Message       string          `json:"message"`
+	Source        Source          `json:"source"`
+	Value         json.RawMessage `json:"value,omitempty"`
+}
+
+// Kind classifies the event by its type name, i.e. "accessControlCallMissed" is a call.
+func (e Event) Kind() EventKind {
+	typeName := strings.ToLower(e.EventTypeName)
+	switch {
+	case strings.Contains(typeName, "call"):
+		return EventKindCall
+	case strings.Contains(typeName, "open"):
+		return EventKindOpen
+	case strings.Contains(typeName, "motion"):
+		return EventKindMotion
+	default:
+		return EventKindOther
+	}
+}
+
+// eventTimeLayouts are the timestamp formats seen in Dom.ru events.
+var eventTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// Time parses the event timestamp. Timestamps without a zone are UTC, Unix timestamps may be in seconds or milliseconds.
+func (e Event) Time() (time.Time, error) {
+	timestamp := strings.TrimSpace(e.Timestamp)
+	if unix, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		if unix > 1e12 {
+			return time.UnixMilli(unix).UTC(), nil
+		}
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	for _, layout := range eventTimeLayouts {
+		if t, err := time.ParseInLocation(layout, timestamp, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported event timestamp %q", e.Timestamp)
+}
+
+type EventsResponse struct {
+	Data []Event `json:"data"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTime(t *testing.T) {
+	want := time.Date(2024, time.March, 31, 11, 32, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		timestamp string
+		wantErr   bool
+	}{
+		{name: "RFC 3339 with offset", timestamp: "2024-03-31T14:32:00+03:00"},
+		{name: "RFC 3339 UTC with fraction", timestamp: "2024-03-31T11:32:00.000Z"},
+		{name: "Without zone", timestamp: "2024-03-31T11:32:00"},
+		{name: "Unix seconds", timestamp: "1711884720"},
+		{name: "Unix milliseconds", timestamp: "1711884720000"},
+		{name: "Garbage", timestamp: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Event{Timestamp: tt.timestamp}.Time()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, want.Equal(got), "got %s", got)
+		})
+	}
+}
@@ -1,5 +1,12 @@
 package models
 
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
+)
+
 type KladrAddress struct {
 	Index          interface{} `json:"index"`
 	Region         interface{} `json:"region"`
@@ -21,6 +28,11 @@ type Address struct {
 	GroupName          string       `json:"groupName"`
 }
 
+// LogValue masks the address, users share their logs in bug reports.
+func (a Address) LogValue() slog.Value {
+	return slog.StringValue(sanitizing_utils.MaskAddress(a.VisibleAddress))
+}
+
 type Location struct {
 	Longitude float64 `json:"longitude"`
 	Latitude  float64 `json:"latitude"`
@@ -47,6 +59,17 @@ type AccessControl struct {
 	Entrances              []interface{} `json:"entrances"`
 }
 
+// LogValue leaves out the account identifiers and masks the names, which may contain the address.
+func (ac AccessControl) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", ac.ID),
+		slog.String("name", sanitizing_utils.MaskAddress(ac.Name)),
+		slog.String("type", ac.Type),
+		slog.Bool("allowOpen", ac.AllowOpen),
+		slog.String("forpostAccountId", sanitizing_utils.MaskID(fmt.Sprint(ac.ForpostAccountId))),
+	)
+}
+
 type Place struct {
 	ID                     int             `json:"id"`
 	Address                Address         `json:"address"`
@@ -78,3 +101,14 @@ type Data struct {
 type PlacesResponse struct {
 	Data []Data `json:"data"`
 }
+
+// AccessControlStatus tells whether an access control can be opened right now.
+type AccessControlStatus struct {
+	AllowOpen bool
+	// Blocked is set when the place subscription is blocked, e.g. for non-payment.
+	Blocked bool
+}
+
+func (s AccessControlStatus) CanOpen() bool {
+	return s.AllowOpen && !s.Blocked
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"log/slog"
+
+	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
+)
+
+// SipDevice is the SIP account the mobile app answers the intercom calls of a place with.
+type SipDevice struct {
+	ID       int    `json:"id"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Realm    string `json:"realm"`
+}
+
+type SipDeviceResponse struct {
+	Data SipDevice `json:"data"`
+}
+
+// LogValue masks the login and leaves out the password, they let anyone answer the intercom.
+func (d SipDevice) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", d.ID),
+		slog.String("login", sanitizing_utils.MaskID(d.Login)),
+		slog.String("password", "[REDACTED]"),
+		slog.String("realm", d.Realm),
+	)
+}
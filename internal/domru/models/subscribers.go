@@ -1,14 +1,14 @@
 package models
 
 type FinancesResponse struct {
-	Balance       float64 `json:"balance"`
-	BlockType     string  `json:"blockType"`
-	AmountSum     float64 `json:"amountSum"`
-	TargetDate    string  `json:"targetDate"`
-	PaymentLink   string  `json:"paymentLink"`
-	DaysToBlock   *int    `json:"daysToBlock"`
-	DaysToWarning *int    `json:"daysToWarning"`
-	Blocked       bool    `json:"blocked"`
+	Balance       *float64 `json:"balance"`
+	BlockType     string   `json:"blockType"`
+	AmountSum     float64  `json:"amountSum"`
+	TargetDate    string   `json:"targetDate"`
+	PaymentLink   string   `json:"paymentLink"`
+	DaysToBlock   *int     `json:"daysToBlock"`
+	DaysToWarning *int     `json:"daysToWarning"`
+	Blocked       bool     `json:"blocked"`
 }
 
 /*
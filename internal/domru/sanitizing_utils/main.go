@@ -1,6 +1,9 @@
 package sanitizing_utils
 
-import "strings"
+import (
+	"strings"
+	"unicode"
+)
 
 func KeepFirstNCharacters(s string, n int) string {
 	if len(s) <= n {
@@ -8,3 +11,51 @@ func KeepFirstNCharacters(s string, n int) string {
 	}
 	return s[:n] + strings.Repeat("*", len(s)-n)
 }
+
+// KeepLastNCharacters masks everything but the last n characters, e.g. the last digits of a phone number.
+func KeepLastNCharacters(s string, n int) string {
+	if len(s) <= n {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-n) + s[len(s)-n:]
+}
+
+// MaskPhone keeps the country code and the last two digits of a phone number, e.g. +7*******12.
+func MaskPhone(phone string) string {
+	const keepFirst, keepLast = 2, 2
+	if len(phone) <= keepFirst+keepLast {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:keepFirst] + strings.Repeat("*", len(phone)-keepFirst-keepLast) + phone[len(phone)-keepLast:]
+}
+
+// MaskAddress keeps the first letter of every word and the punctuation of an address and masks the rest,
+// including all digits, e.g. "ул. Ленина, д. 5" becomes "у*. Л*****, д. *". The address stays recognizable
+// as one without revealing where the user lives.
+func MaskAddress(address string) string {
+	var masked strings.Builder
+	wordStart := true
+	for _, r := range address {
+		switch {
+		case unicode.IsLetter(r) && wordStart:
+			masked.WriteRune(r)
+			wordStart = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			masked.WriteRune('*')
+			wordStart = false
+		default:
+			masked.WriteRune(r)
+			wordStart = unicode.IsSpace(r) || unicode.IsPunct(r)
+		}
+	}
+	return masked.String()
+}
+
+// MaskID keeps the last two characters of an account or subscriber ID, e.g. ******12.
+func MaskID(id string) string {
+	const keepLast = 2
+	if len(id) <= keepLast {
+		return strings.Repeat("*", len(id))
+	}
+	return strings.Repeat("*", len(id)-keepLast) + id[len(id)-keepLast:]
+}
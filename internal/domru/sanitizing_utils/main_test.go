@@ -0,0 +1,29 @@
+package sanitizing_utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"ул. Ленина, д. 5, кв. 12", "у*. Л*****, д. *, к*. **"},
+		{"Moscow", "M*****"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equal(t, tt.want, MaskAddress(tt.address))
+		})
+	}
+}
+
+func TestMaskID(t *testing.T) {
+	assert.Equal(t, "******12", MaskID("12345612"))
+	assert.Equal(t, "**", MaskID("12"))
+}
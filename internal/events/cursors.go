@@ -0,0 +1,137 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// cursorMaxAge is how old a cursor may be to be restored. Events missed during a longer downtime are old
+// news, so the places are primed again instead of replaying them.
+const cursorMaxAge = 10 * time.Minute
+
+// Cursors persists the events seen last per place of every account, so a restart doesn't replay them
+// and calls made while the addon restarted are still delivered. It is shared by the pollers of all accounts.
+type Cursors struct {
+	Logger *slog.Logger
+
+	path string
+
+	mu       sync.Mutex
+	loaded   bool
+	accounts map[string]accountCursor
+}
+
+// accountCursor holds the event IDs seen last per place of an account and when they were saved.
+type accountCursor struct {
+	Saved  time.Time                   `json:"saved"`
+	Places map[string][]models.EventID `json:"places"`
+}
+
+func NewCursors(path string) *Cursors {
+	return &Cursors{
+		Logger:   slog.Default(),
+		path:     path,
+		accounts: make(map[string]accountCursor),
+	}
+}
+
+// restore returns the events seen last per place of the account, places without a cursor younger than maxAge are left out.
+func (c *Cursors) restore(account string, maxAge time.Duration) map[int]map[models.EventID]struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	seen := make(map[int]map[models.EventID]struct{})
+	cursor, ok := c.accounts[account]
+	if !ok || time.Since(cursor.Saved) > maxAge {
+		return seen
+	}
+	for place, ids := range cursor.Places {
+		placeID, err := strconv.Atoi(place)
+		if err != nil {
+			continue
+		}
+		seen[placeID] = make(map[models.EventID]struct{}, len(ids))
+		for _, id := range ids {
+			seen[placeID][id] = struct{}{}
+		}
+	}
+	return seen
+}
+
+// save persists the events seen last per place of the account. Unchanged cursors are only rewritten when
+// half of maxAge passed, so polling doesn't write the file every interval.
+func (c *Cursors) save(account string, seen map[int]map[models.EventID]struct{}, maxAge time.Duration) {
+	places := make(map[string][]models.EventID, len(seen))
+	for placeID, ids := range seen {
+		places[strconv.Itoa(placeID)] = slices.Sorted(maps.Keys(ids))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	previous, ok := c.accounts[account]
+	if ok && time.Since(previous.Saved) < maxAge/2 && maps.EqualFunc(previous.Places, places, slices.Equal) {
+		return
+	}
+	c.accounts[account] = accountCursor{Saved: time.Now(), Places: places}
+
+	data, err := json.Marshal(c.accounts)
+	if err == nil {
+		err = writeFileAtomic(c.path, data)
+	}
+	if err != nil {
+		c.Logger.Warn("Failed to save events cursor", "file", c.path, "error", err)
+	}
+}
+
+// load reads the cursors of a previous run once, it must be called with c.mu held.
+func (c *Cursors) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	accounts := make(map[string]accountCursor)
+	if err == nil {
+		err = json.Unmarshal(data, &accounts)
+	}
+	if err != nil {
+		c.Logger.Warn("Failed to load events cursor, priming the places again", "file", c.path, "error", err)
+		return
+	}
+	c.accounts = accounts
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,214 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+const (
+	placesRefreshInterval = time.Hour
+	subscriberBufferSize  = 16
+)
+
+type Source interface {
+	RequestPlaces() (models.PlacesResponse, error)
+	RequestEvents(placeID int) (models.EventsResponse, error)
+}
+
+// Poller periodically requests events of all places and broadcasts the new ones to subscribers.
+// Events are only polled while there is at least one subscriber.
+type Poller struct {
+	Logger   *slog.Logger
+	Interval time.Duration
+	// Cursors keeps the events seen last across restarts, nil primes the places on every start.
+	Cursors *Cursors
+	// Account names the account of the polled events in Cursors, empty for the primary one.
+	Account string
+
+	source Source
+
+	mu          sync.Mutex
+	subscribers map[chan models.Event]struct{}
+	// seen holds event IDs from the previous poll per place. Nil means the next poll only primes it,
+	// so already existing events are not replayed to new subscribers.
+	seen map[int]map[models.EventID]struct{}
+
+	placeIDs        []int
+	placesRefreshed time.Time
+}
+
+func NewPoller(source Source) *Poller {
+	return &Poller{
+		Logger:      slog.Default(),
+		Interval:    15 * time.Second,
+		source:      source,
+		subscribers: make(map[chan models.Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving new events and a function to cancel the subscription.
+// Slow subscribers miss events instead of blocking the poller.
+func (p *Poller) Subscribe() (<-chan models.Event, func()) {
+	ch := make(chan models.Event, subscriberBufferSize)
+
+	p.mu.Lock()
+	p.subscribers[ch] = struct{}{}
+	p.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.subscribers, ch)
+			if len(p.subscribers) == 0 {
+				p.seen = nil
+			}
+			p.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Run polls events until ctx is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	if p.Interval <= 0 {
+		p.Logger.Info("Events polling is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+func (p *Poller) hasSubscribers() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subscribers) > 0
+}
+
+func (p *Poller) poll() {
+	if !p.hasSubscribers() {
+		return
+	}
+
+	placeIDs, err := p.places()
+	if err != nil {
+		p.Logger.With("err", err.Error()).Warn("failed to get places for events polling")
+		return
+	}
+	p.restoreCursors()
+
+	current := make(map[int]map[models.EventID]struct{}, len(placeIDs))
+	var fresh []models.Event
+	for _, placeID := range placeIDs {
+		response, err := p.source.RequestEvents(placeID)
+		if err != nil {
+			p.Logger.With("err", err.Error()).With("placeID", placeID).Warn("failed to poll events")
+			current[placeID] = p.seenIDs(placeID)
+			continue
+		}
+
+		ids := make(map[models.EventID]struct{}, len(response.Data))
+		for _, event := range response.Data {
+			ids[event.ID] = struct{}{}
+			if event.PlaceID == 0 {
+				event.PlaceID = placeID
+			}
+			if p.isNew(placeID, event.ID) {
+				fresh = append(fresh, event)
+			}
+		}
+		current[placeID] = ids
+	}
+
+	if p.Cursors != nil {
+		p.Cursors.save(p.Account, current, p.cursorMaxAge())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	primed := p.seen != nil
+	p.seen = current
+	if !primed {
+		return
+	}
+	for _, event := range fresh {
+		p.broadcast(event)
+	}
+}
+
+// restoreCursors continues from the events seen last instead of priming, places without a cursor are still primed.
+func (p *Poller) restoreCursors() {
+	if p.Cursors == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seen == nil {
+		p.seen = p.Cursors.restore(p.Account, p.cursorMaxAge())
+	}
+}
+
+// cursorMaxAge is how old a restored cursor may be, at least two intervals so a slow poller keeps its cursor.
+func (p *Poller) cursorMaxAge() time.Duration {
+	return max(cursorMaxAge, 2*p.Interval)
+}
+
+// isNew reports whether the event was not seen before. Events of places polled for the first time are never new.
+func (p *Poller) isNew(placeID int, id models.EventID) bool {
+	seen := p.seenIDs(placeID)
+	if seen == nil {
+		return false
+	}
+	_, ok := seen[id]
+	return !ok
+}
+
+func (p *Poller) seenIDs(placeID int) map[models.EventID]struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seen[placeID]
+}
+
+// broadcast must be called with p.mu held.
+func (p *Poller) broadcast(event models.Event) {
+	for ch := range p.subscribers {
+		select {
+		case ch <- event:
+		default:
+			p.Logger.With("eventID", event.ID).Debug("subscriber is too slow, dropping event")
+		}
+	}
+}
+
+func (p *Poller) places() ([]int, error) {
+	if p.placeIDs != nil && time.Since(p.placesRefreshed) < placesRefreshInterval {
+		return p.placeIDs, nil
+	}
+
+	response, err := p.source.RequestPlaces()
+	if err != nil {
+		return nil, err
+	}
+
+	placeIDs := make([]int, 0, len(response.Data))
+	for _, data := range response.Data {
+		placeIDs = append(placeIDs, data.Place.ID)
+	}
+	p.placeIDs = placeIDs
+	p.placesRefreshed = time.Now()
+	return placeIDs, nil
+}
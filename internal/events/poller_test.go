@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+type fakeSource struct {
+	mu       sync.Mutex
+	events   []models.Event
+	requests int
+}
+
+func (s *fakeSource) RequestPlaces() (models.PlacesResponse, error) {
+	response := models.PlacesResponse{Data: make([]models.Data, 1)}
+	response.Data[0].Place.ID = 345
+	return response, nil
+}
+
+func (s *fakeSource) RequestEvents(int) (models.EventsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	return models.EventsResponse{Data: append([]models.Event(nil), s.events...)}, nil
+}
+
+func (s *fakeSource) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *fakeSource) add(id models.EventID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, models.Event{ID: id, EventTypeName: "accessControlCallMissed"})
+}
+
+func newTestPoller(source Source, cursorFile string) *Poller {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	p := NewPoller(source)
+	p.Logger = logger
+	if cursorFile != "" {
+		p.Cursors = NewCursors(cursorFile)
+		p.Cursors.Logger = logger
+	}
+	return p
+}
+
+// received drains the events broadcast so far.
+func received(events <-chan models.Event) []models.EventID {
+	var ids []models.EventID
+	for {
+		select {
+		case event := <-events:
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestPollerCursorSurvivesRestart(t *testing.T) {
+	cursorFile := filepath.Join(t.TempDir(), "events_cursor.json")
+	source := &fakeSource{}
+	source.add("1")
+
+	first := newTestPoller(source, cursorFile)
+	events, cancel := first.Subscribe()
+	first.poll()
+	assert.Empty(t, received(events), "the first poll only primes the place")
+	source.add("2")
+	first.poll()
+	assert.Equal(t, []models.EventID{"2"}, received(events))
+	cancel()
+
+	// The call made while the addon restarted is delivered, the seen ones are not replayed
+	source.add("3")
+	restarted := newTestPoller(source, cursorFile)
+	events, cancel = restarted.Subscribe()
+	defer cancel()
+	restarted.poll()
+	assert.Equal(t, []models.EventID{"3"}, received(events))
+}
+
+func TestPollerIgnoresStaleCursor(t *testing.T) {
+	cursorFile := filepath.Join(t.TempDir(), "events_cursor.json")
+	source := &fakeSource{}
+	source.add("1")
+
+	first := newTestPoller(source, cursorFile)
+	_, cancel := first.Subscribe()
+	first.poll()
+	cancel()
+
+	_, err := os.Stat(cursorFile)
+	require.NoError(t, err)
+	stale := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	data := []byte(`{"":{"saved":"` + stale + `","places":{"345":["1"]}}}`)
+	require.NoError(t, os.WriteFile(cursorFile, data, 0o600))
+
+	source.add("2")
+	restarted := newTestPoller(source, cursorFile)
+	events, cancel := restarted.Subscribe()
+	defer cancel()
+	restarted.poll()
+	assert.Empty(t, received(events), "calls of a long downtime are not delivered")
+}
+
+func TestPollerCursorsPerAccount(t *testing.T) {
+	cursorFile := filepath.Join(t.TempDir(), "events_cursor.json")
+	cursors := NewCursors(cursorFile)
+	primarySource, otherSource := &fakeSource{}, &fakeSource{}
+	primarySource.add("1")
+	otherSource.add("a")
+
+	primary := newTestPoller(primarySource, "")
+	primary.Cursors = cursors
+	other := newTestPoller(otherSource, "")
+	other.Cursors = cursors
+	other.Account = "op2"
+	for _, p := range []*Poller{primary, other} {
+		_, cancel := p.Subscribe()
+		p.poll()
+		cancel()
+	}
+
+	otherSource.add("b")
+	restarted := newTestPoller(otherSource, cursorFile)
+	restarted.Account = "op2"
+	events, cancel := restarted.Subscribe()
+	defer cancel()
+	restarted.poll()
+	assert.Equal(t, []models.EventID{"b"}, received(events), "the place of another account shares the file, not the cursor")
+}
+
+func TestPollerBroadcastsNewEvents(t *testing.T) {
+	source := &fakeSource{}
+	source.add("1")
+	p := newTestPoller(source, "")
+
+	p.poll()
+	assert.Zero(t, source.requestCount(), "nothing is polled without subscribers")
+
+	first, cancelFirst := p.Subscribe()
+	second, cancelSecond := p.Subscribe()
+	defer cancelSecond()
+	p.poll()
+	assert.Empty(t, received(first), "existing events are not replayed")
+
+	source.add("2")
+	p.poll()
+	p.poll()
+	var event models.Event
+	select {
+	case event = <-second:
+	default:
+		t.Fatal("the second subscriber got no event")
+	}
+	assert.Equal(t, models.EventID("2"), event.ID)
+	assert.Equal(t, 345, event.PlaceID, "the place of the poll is filled in")
+	assert.Equal(t, []models.EventID{"2"}, received(first), "events are delivered once")
+
+	cancelFirst()
+	_, ok := <-first
+	assert.False(t, ok, "cancelling closes the channel")
+}
+
+func TestPollerDropsEventsOfSlowSubscribers(t *testing.T) {
+	source := &fakeSource{}
+	p := newTestPoller(source, "")
+	events, cancel := p.Subscribe()
+	defer cancel()
+	p.poll()
+
+	for i := range subscriberBufferSize + 1 {
+		source.add(models.EventID(strconv.Itoa(i)))
+	}
+	p.poll()
+	assert.Len(t, received(events), subscriberBufferSize)
+}
+
+func TestPollerRun(t *testing.T) {
+	source := &fakeSource{}
+	p := newTestPoller(source, "")
+	p.Interval = 5 * time.Millisecond
+	_, cancelSubscription := p.Subscribe()
+	defer cancelSubscription()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(stopped)
+	}()
+	assert.Eventually(t, func() bool { return source.requestCount() >= 2 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+}
@@ -0,0 +1,39 @@
+package homeassistant
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Publisher publishes the discovery configs and states of the integration.
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+	IsConnected() bool
+}
+
+// Subscriber delivers the messages of the topics the integration listens on, i.e. commands.
+// The handlers of the integration don't use their client argument, other transports may pass nil.
+type Subscriber interface {
+	Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token
+	Unsubscribe(topics ...string) mqtt.Token
+}
+
+// Client is the MQTT transport of the integration. The paho clients implement it, NewClient of
+// MqttIntegration plugs in another one.
+type Client interface {
+	Publisher
+	Subscriber
+	Connect() mqtt.Token
+	Disconnect(quiesce uint)
+}
+
+// newClient creates the client of the integration: NewClient if set, otherwise the paho client of the MQTT
+// protocol version, MQTT 3.1.1 unless MQTTv5 is set.
+func (m *MqttIntegration) newClient(opts *mqtt.ClientOptions) Client {
+	if m.NewClient != nil {
+		return m.NewClient(opts)
+	}
+	if m.MQTTv5 {
+		return newV5Client(opts, m.logger)
+	}
+	return mqtt.NewClient(opts)
+}
@@ -0,0 +1,172 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+)
+
+// newDoorIntegration returns an integration discovering the door 12 of the place 345 from a fake Dom.ru API,
+// which counts the opens of the door.
+func newDoorIntegration(t *testing.T, opens *atomic.Int32) (*MqttIntegration, *fakeClient, *fakeTimers) {
+	return newDoorIntegrationWithOpenStatus(t, opens, http.StatusOK)
+}
+
+// newDoorIntegrationWithOpenStatus is newDoorIntegration with the fake Dom.ru API answering door opens with status.
+func newDoorIntegrationWithOpenStatus(t *testing.T, opens *atomic.Int32, status int) (*MqttIntegration, *fakeClient, *fakeTimers) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/v1/subscriberplaces":
+			_, _ = io.WriteString(w, `{"data": [{"place": {"id": 345, "address": {"visibleAddress": "ул. Ленина, 5"},
+				"accessControls": [{"id": 12, "name": "Entrance", "type": "SIP", "allowOpen": true}]}}]}`)
+		case "/rest/v1/places/345/accesscontrols/12/actions":
+			opens.Add(1)
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, `{"data": {"status": true}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	api := domru.NewDomruAPI(server.Client(), server.URL)
+	api.Logger = logger
+	client := &fakeClient{}
+	timers := &fakeTimers{}
+	m := NewMqttIntegration(api, logger, BrokerSettings{}, "")
+	m.client = client
+	m.relocks = newRelockScheduler(timers.afterFunc)
+	m.discoveryDelay = 0
+	m.DoorCameras = false
+	return m, client, timers
+}
+
+func TestDiscoverAndOpenDoor(t *testing.T) {
+	var opens atomic.Int32
+	m, client, timers := newDoorIntegration(t, &opens)
+	topics := m.Topics.DoorLockTopics(12, 345)
+
+	m.connectHandler(nil)
+	require.Eventually(t, func() bool { return m.DiscoverySummary().Published == 1 }, time.Second, 10*time.Millisecond)
+
+	configs := client.payloads(topics.Discovery)
+	require.Len(t, configs, 1)
+	var lock MqttLock
+	require.NoError(t, json.Unmarshal([]byte(configs[0]), &lock))
+	assert.Equal(t, topics.Command, lock.CommandTopic)
+	assert.Equal(t, "LOCKED", client.payloads(topics.State)[0])
+
+	// The fake delivers the command to commandHandler right away
+	client.Publish(topics.Command, 1, false, "OPEN")
+	assert.Equal(t, int32(1), opens.Load())
+	states := client.payloads(topics.State)
+	assert.Equal(t, "UNLOCKED", states[len(states)-1])
+	if assert.Len(t, timers.funcs, 1) {
+		timers.funcs[0]()
+		states = client.payloads(topics.State)
+		assert.Equal(t, "LOCKED", states[len(states)-1])
+	}
+
+	// Doors that weren't discovered can't be opened
+	client.Publish(m.Topics.DoorLockTopics(13, 345).Command, 1, false, "OPEN")
+	assert.Equal(t, int32(1), opens.Load())
+}
+
+func TestOpenDoorUpstreamError(t *testing.T) {
+	var opens atomic.Int32
+	m, client, timers := newDoorIntegrationWithOpenStatus(t, &opens, http.StatusInternalServerError)
+	topics := m.Topics.DoorLockTopics(12, 345)
+
+	m.connectHandler(nil)
+	require.Eventually(t, func() bool { return m.DiscoverySummary().Published == 1 }, time.Second, 10*time.Millisecond)
+
+	client.Publish(topics.Command, 1, false, "OPEN")
+	assert.Equal(t, int32(1), opens.Load())
+	// A rejected open is not reported as a success
+	states := client.payloads(topics.State)
+	assert.Equal(t, "LOCKED", states[len(states)-1])
+	assert.NotContains(t, states, "UNLOCKED")
+	assert.Empty(t, timers.funcs)
+	attributes := client.payloads(topics.Attributes)
+	if assert.NotEmpty(t, attributes) {
+		assert.Contains(t, attributes[len(attributes)-1], "500")
+	}
+}
+
+func TestCleanupDiscoveryOnLogout(t *testing.T) {
+	var opens atomic.Int32
+	m, client, _ := newDoorIntegration(t, &opens)
+	topics := m.Topics.DoorLockTopics(12, 345)
+
+	m.connectHandler(nil)
+	require.Eventually(t, func() bool { return m.DiscoverySummary().Published == 1 }, time.Second, 10*time.Millisecond)
+
+	m.CleanupDiscovery()
+	configs := client.payloads(topics.Discovery)
+	assert.Empty(t, configs[len(configs)-1])
+	availability := client.payloads(m.Topics.Availability())
+	assert.Equal(t, "offline", availability[len(availability)-1])
+
+	// The next discovery brings the bridge back online
+	m.Rediscover()
+	availability = client.payloads(m.Topics.Availability())
+	assert.Equal(t, "online", availability[len(availability)-1])
+}
+
+func TestRepeatedOpenAfterRelock(t *testing.T) {
+	var opens atomic.Int32
+	m, client, timers := newDoorIntegration(t, &opens)
+	m.RelockDelay = minRelockDelay
+	m.OpenDebounce = defaultOpenDebounce
+	topics := m.Topics.DoorLockTopics(12, 345)
+
+	m.connectHandler(nil)
+	require.Eventually(t, func() bool { return m.DiscoverySummary().Published == 1 }, time.Second, 10*time.Millisecond)
+
+	client.Publish(topics.Command, 1, false, "OPEN")
+	require.Len(t, timers.funcs, 1)
+	assert.Equal(t, time.Second, timers.delays[0])
+
+	// A repeat while the relock is pending is acknowledged as unlocked
+	client.Publish(topics.Command, 1, false, "OPEN")
+	states := client.payloads(topics.State)
+	assert.Equal(t, "UNLOCKED", states[len(states)-1])
+
+	// The relock fired within the debounce window, a repeat must not leave the lock unlocked for good
+	timers.funcs[0]()
+	client.Publish(topics.Command, 1, false, "OPEN")
+	states = client.payloads(topics.State)
+	assert.Equal(t, "LOCKED", states[len(states)-1])
+	assert.Equal(t, int32(1), opens.Load())
+}
+
+func TestCleanupDiscoveryKeepsOtherAccounts(t *testing.T) {
+	var opens atomic.Int32
+	m, client, _ := newDoorIntegration(t, &opens)
+	require.NoError(t, m.AddAccount("dacha", m.domruAPI))
+	primary := m.Topics.DoorLockTopics(12, 345)
+	dacha := m.Topics.AccountDoorLockTopics("dacha", 12, 345)
+
+	m.connectHandler(nil)
+	require.Eventually(t, func() bool { return m.DiscoverySummary().Published == 2 }, time.Second, 10*time.Millisecond)
+
+	// Logging out of the primary account keeps the doors of the other accounts and the bridge online
+	m.CleanupDiscovery()
+	configs := client.payloads(primary.Discovery)
+	assert.Empty(t, configs[len(configs)-1])
+	configs = client.payloads(dacha.Discovery)
+	assert.NotEmpty(t, configs[len(configs)-1])
+	availability := client.payloads(m.Topics.Availability())
+	assert.Equal(t, "online", availability[len(availability)-1])
+}
@@ -0,0 +1,71 @@
+package homeassistant
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+const deviceManufacturer = "Dom.ru"
+
+// device returns the device info of the entities with the addon version and UI address.
+func (m *MqttIntegration) device(identifiers []string, name, model string) MqttDevice {
+	return MqttDevice{
+		Identifiers:      identifiers,
+		Name:             name,
+		Model:            model,
+		Manufacturer:     deviceManufacturer,
+		SwVersion:        m.Version,
+		ConfigurationURL: m.haHost,
+	}
+}
+
+// doorDevice returns the device info of the door, suggesting the area of its place.
+// It must be called with discoveryMu held.
+func (m *MqttIntegration) doorDevice(account string, ac models.AccessControl, placeID int) MqttDevice {
+	device := m.device([]string{m.Topics.AccountDoorLockTopics(account, ac.ID, placeID).DeviceID}, ac.Name, m.text("Doorphone"))
+	device.SuggestedArea = m.placeAreas[placeKey{account: account, placeID: placeID}]
+	return device
+}
+
+var (
+	// postalCode matches Russian postal codes, i.e. "620000".
+	postalCode = regexp.MustCompile(`^\d{6}$`)
+	// settlementPrefixes start the city part of a visible address, i.e. "г. Екатеринбург".
+	settlementPrefixes = []string{"г.", "г ", "город ", "пос.", "п.", "с.", "обл", "область", "респ"}
+	// apartmentPrefixes start the apartment part of a visible address, it's too personal for an area name.
+	apartmentPrefixes = []string{"кв.", "кв ", "квартира"}
+)
+
+// suggestedArea derives a Home Assistant area from the place address: the street and building
+// without the city and the apartment, i.e. "ул. Ленина, 5" for "г. Пермь, ул. Ленина, 5, кв. 12".
+func suggestedArea(address models.Address) string {
+	if kladr := address.KladrAddress; kladr.Street != "" {
+		if kladr.House == "" {
+			return kladr.Street
+		}
+		return kladr.Street + ", " + kladr.House
+	}
+
+	var parts []string
+	for _, part := range strings.Split(address.VisibleAddress, ",") {
+		part = strings.TrimSpace(part)
+		lower := strings.ToLower(part)
+		if part == "" || postalCode.MatchString(part) || hasAnyPrefix(lower, settlementPrefixes) ||
+			hasAnyPrefix(lower, apartmentPrefixes) || strings.EqualFold(part, address.KladrAddress.City) {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestSuggestedArea(t *testing.T) {
+	tests := []struct {
+		name    string
+		address models.Address
+		want    string
+	}{
+		{
+			name:    "Kladr street and house",
+			address: models.Address{KladrAddress: models.KladrAddress{City: "Пермь", Street: "ул. Ленина", House: "5"}},
+			want:    "ул. Ленина, 5",
+		},
+		{
+			name:    "Visible address without city and apartment",
+			address: models.Address{VisibleAddress: "614000, г. Пермь, ул. Ленина, д. 5, кв. 12"},
+			want:    "ул. Ленина, д. 5",
+		},
+		{
+			name:    "City named by kladr",
+			address: models.Address{VisibleAddress: "Пермь, ул. Ленина, 5", KladrAddress: models.KladrAddress{City: "Пермь"}},
+			want:    "ул. Ленина, 5",
+		},
+		{name: "Empty", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, suggestedArea(tt.address))
+		})
+	}
+}
+
+func TestDoorLockDevice(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "https://ha.example.com:8080/")
+	m.client = client
+	m.Version = "1.2.3"
+	m.placeAreas[placeKey{placeID: 345}] = "ул. Ленина, 5"
+
+	require.NoError(t, m.publishDoorLock("", models.AccessControl{ID: 12, Name: "Entrance"}, 345))
+
+	payloads := client.payloads(Topics{}.DoorLockTopics(12, 345).Discovery)
+	require.Len(t, payloads, 1)
+	var lock MqttLock
+	require.NoError(t, json.Unmarshal([]byte(payloads[0]), &lock))
+	assert.Equal(t, MqttDevice{
+		Identifiers:      []string{Topics{}.DoorLockTopics(12, 345).DeviceID},
+		Name:             "Entrance",
+		Model:            "Doorphone",
+		Manufacturer:     "Dom.ru",
+		SwVersion:        "1.2.3",
+		ConfigurationURL: "https://ha.example.com:8080",
+		SuggestedArea:    "ул. Ленина, 5",
+	}, lock.Device)
+	assert.Equal(t, "OPEN", lock.PayloadOpen)
+	assert.Equal(t, "UNLOCK", lock.PayloadUnlock)
+}
@@ -0,0 +1,39 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/090809/homeassistant-domru/internal/domru/constants"
+)
+
+// ParseDoorCameraIDs parses "accessControlID=cameraID" entries, i.e. from a flag, into DoorCameraIDs.
+func ParseDoorCameraIDs(entries []string) (map[int]int, error) {
+	cameras := make(map[int]int, len(entries))
+	for _, entry := range entries {
+		rawAC, rawCamera, found := strings.Cut(entry, "=")
+		acID, acErr := strconv.Atoi(strings.TrimSpace(rawAC))
+		cameraID, cameraErr := strconv.Atoi(strings.TrimSpace(rawCamera))
+		if !found || acErr != nil || cameraErr != nil || acID <= 0 || cameraID <= 0 {
+			return nil, fmt.Errorf("invalid door camera %q, expected accessControlID=cameraID", entry)
+		}
+		cameras[acID] = cameraID
+	}
+	return cameras, nil
+}
+
+// doorCamera returns the camera mapped to the access control in DoorCameraIDs.
+func (m *MqttIntegration) doorCamera(acID int) (int, bool) {
+	cameraID, ok := m.DoorCameraIDs[acID]
+	return cameraID, ok
+}
+
+// doorSnapshotURL returns the URL of the door snapshot shown as the lock picture,
+// the image of the mapped camera if there is one.
+func (m *MqttIntegration) doorSnapshotURL(acID, placeID int) string {
+	if cameraID, ok := m.doorCamera(acID); ok {
+		return constants.GetCameraSnapshotUrl(m.haHost, cameraID)
+	}
+	return m.URLTemplates.SnapshotURL(constants.DefaultCameraModel, m.haHost, placeID, acID)
+}
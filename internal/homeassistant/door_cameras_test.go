@@ -0,0 +1,19 @@
+package homeassistant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoorSnapshotURL(t *testing.T) {
+	cameras, err := ParseDoorCameraIDs([]string{"12=345"})
+	assert.NoError(t, err)
+
+	m := &MqttIntegration{DoorCameraIDs: cameras, haHost: "http://ha:8080"}
+	assert.Equal(t, "http://ha:8080/rest/v1/forpost/cameras/345/snapshots", m.doorSnapshotURL(12, 1))
+	assert.Equal(t, "http://ha:8080/rest/v1/places/1/accesscontrols/13/videosnapshots", m.doorSnapshotURL(13, 1))
+
+	_, err = ParseDoorCameraIDs([]string{"12=front"})
+	assert.Error(t, err)
+}
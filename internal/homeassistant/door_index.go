@@ -0,0 +1,55 @@
+package homeassistant
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errUnknownDoor is returned for commands of entities that aren't published door locks.
+var errUnknownDoor = errors.New("no published door has this entity")
+
+// doorIndex maps the entity IDs of the published door locks to their doors. The command handler reads it
+// without waiting for discoveryMu, a discovery run may take long.
+type doorIndex struct {
+	mu    sync.RWMutex
+	doors map[string]discoveredDoorLock
+}
+
+func (i *doorIndex) lookup(entityID string) (discoveredDoorLock, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	door, ok := i.doors[entityID]
+	return door, ok
+}
+
+func (i *doorIndex) replace(doors map[string]discoveredDoorLock) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.doors = doors
+}
+
+// indexDoors rebuilds the door index from the published door locks. It must be called with discoveryMu held.
+func (m *MqttIntegration) indexDoors() {
+	doors := make(map[string]discoveredDoorLock, len(m.discovered))
+	for _, door := range m.discovered {
+		doors[m.Topics.AccountDoorLockTopics(door.account, door.accessControl.ID, door.placeID).EntityID] = door
+	}
+	m.doors.replace(doors)
+}
+
+// commandDoor returns the published door the "<prefix>/<entity ID>/command" topic belongs to.
+func (m *MqttIntegration) commandDoor(topic string) (discoveredDoorLock, error) {
+	entityID, suffix, err := m.Topics.splitEntityTopic(topic)
+	if err != nil {
+		return discoveredDoorLock{}, err
+	}
+	if suffix != "command" {
+		return discoveredDoorLock{}, fmt.Errorf("not a command topic: %s", topic)
+	}
+	door, ok := m.doors.lookup(entityID)
+	if !ok {
+		return discoveredDoorLock{}, fmt.Errorf("%w: %s", errUnknownDoor, entityID)
+	}
+	return door, nil
+}
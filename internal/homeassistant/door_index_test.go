@@ -0,0 +1,52 @@
+package homeassistant
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestCommandDoor(t *testing.T) {
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	entrance := discoveredDoorLock{accessControl: models.AccessControl{ID: 12}, placeID: 345}
+	gate := discoveredDoorLock{account: "op2", accessControl: models.AccessControl{ID: 13}, placeID: 678}
+	m.discovered[m.Topics.DoorLockTopics(12, 345).Discovery] = entrance
+	m.discovered[m.Topics.AccountDoorLockTopics("op2", 13, 678).Discovery] = gate
+	m.indexDoors()
+
+	tests := []struct {
+		name        string
+		topic       string
+		want        discoveredDoorLock
+		wantErr     bool
+		wantUnknown bool
+	}{
+		{"Primary account", m.Topics.DoorLockTopics(12, 345).Command, entrance, false, false},
+		{"Operator account", m.Topics.AccountDoorLockTopics("op2", 13, 678).Command, gate, false, false},
+		{"Unknown door", m.Topics.DoorLockTopics(14, 345).Command, discoveredDoorLock{}, true, true},
+		{"Unknown entity", "domru/domru-balance/command", discoveredDoorLock{}, true, true},
+		{"Door of another account", m.Topics.AccountDoorLockTopics("op2", 12, 345).Command, discoveredDoorLock{}, true, true},
+		{"State topic", m.Topics.DoorLockTopics(12, 345).State, discoveredDoorLock{}, true, false},
+		{"Extra segment", m.Topics.DoorLockTopics(12, 345).Command + "/set", discoveredDoorLock{}, true, false},
+		{"Other prefix", Topics{Prefix: "home2"}.DoorLockTopics(12, 345).Command, discoveredDoorLock{}, true, false},
+		{"Malformed", "domru", discoveredDoorLock{}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			door, err := m.commandDoor(tt.topic)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, tt.wantUnknown, errors.Is(err, errUnknownDoor))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, door)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package homeassistant
+
+import (
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeClient is an in-memory broker: it records the publishes of the integration and delivers them to
+// the matching subscriptions, so a command published by a test reaches its handler.
+type fakeClient struct {
+	mu            sync.Mutex
+	published     []fakePublish
+	subscriptions []fakeSubscription
+	unsubscribed  []string
+	disconnected  bool
+}
+
+type fakeSubscription struct {
+	filter  string
+	handler mqtt.MessageHandler
+}
+
+type fakePublish struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+func (c *fakeClient) IsConnected() bool   { return true }
+func (c *fakeClient) Connect() mqtt.Token { return doneToken{} }
+
+func (c *fakeClient) Subscribe(topic string, _ byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions = append(c.subscriptions, fakeSubscription{filter: topic, handler: callback})
+	return doneToken{}
+}
+
+func (c *fakeClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unsubscribed = append(c.unsubscribed, topics...)
+	return doneToken{}
+}
+
+func (c *fakeClient) Disconnect(uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnected = true
+}
+
+func (c *fakeClient) Publish(topic string, _ byte, retained bool, payload interface{}) mqtt.Token {
+	var text string
+	switch p := payload.(type) {
+	case string:
+		text = p
+	case []byte:
+		text = string(p)
+	}
+
+	c.mu.Lock()
+	c.published = append(c.published, fakePublish{topic: topic, retained: retained, payload: text})
+	var handlers []mqtt.MessageHandler
+	for _, subscription := range c.subscriptions {
+		if topicMatches(subscription.filter, topic) {
+			handlers = append(handlers, subscription.handler)
+		}
+	}
+	c.mu.Unlock()
+
+	// Handlers publish themselves, i.e. states, so they run without the lock
+	for _, handler := range handlers {
+		handler(nil, fakeMessage{topic: topic, payload: text, retained: retained})
+	}
+	return doneToken{}
+}
+
+// payloads returns the payloads published on the topic in order.
+func (c *fakeClient) payloads(topic string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var payloads []string
+	for _, p := range c.published {
+		if p.topic == topic {
+			payloads = append(payloads, p.payload)
+		}
+	}
+	return payloads
+}
+
+// doneToken is a token of an operation the broker acknowledged.
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Error() error                   { return nil }
+func (doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// fakeMessage is a message delivered to a subscription.
+type fakeMessage struct {
+	mqtt.Message
+
+	topic    string
+	payload  string
+	retained bool
+}
+
+func (m fakeMessage) Topic() string   { return m.topic }
+func (m fakeMessage) Payload() []byte { return []byte(m.payload) }
+func (m fakeMessage) Retained() bool  { return m.retained }
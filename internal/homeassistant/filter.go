@@ -0,0 +1,42 @@
+package homeassistant
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// EntityFilter decides which access controls are exposed to Home Assistant.
+// Patterns match either the numeric ID or the name, names are matched as case-insensitive shell globs.
+// An empty Include list allows everything, Exclude always wins over Include.
+type EntityFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Allows reports whether the entity with the given ID and name passes the filter.
+func (f EntityFilter) Allows(id int, name string) bool {
+	if matchesAny(f.Exclude, id, name) {
+		return false
+	}
+	return len(f.Include) == 0 || matchesAny(f.Include, id, name)
+}
+
+func matchesAny(patterns []string, id int, name string) bool {
+	idString := strconv.Itoa(id)
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if pattern == idString {
+			return true
+		}
+		if matched, err := path.Match(strings.ToLower(pattern), name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package homeassistant
+
+import "testing"
+
+func TestEntityFilter_Allows(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter EntityFilter
+		id     int
+		acName string
+		want   bool
+	}{
+		{"Empty filter allows everything", EntityFilter{}, 1, "Подъезд 1", true},
+		{"Include by ID", EntityFilter{Include: []string{"42"}}, 42, "Подъезд 1", true},
+		{"Not included", EntityFilter{Include: []string{"42"}}, 1, "Подъезд 1", false},
+		{"Include by name glob", EntityFilter{Include: []string{"подъезд*"}}, 1, "Подъезд 1", true},
+		{"Exclude by name glob", EntityFilter{Exclude: []string{"*шлагбаум*"}}, 7, "Въезд шлагбаум", false},
+		{"Exclude wins over include", EntityFilter{Include: []string{"7"}, Exclude: []string{"7"}}, 7, "Калитка", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.id, tt.acName); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// Locale selects the language of the entity names and device models published in discovery.
+type Locale string
+
+const (
+	LocaleEN Locale = "en"
+	LocaleRU Locale = "ru"
+)
+
+// ParseLocale parses the locale, i.e. from a flag. Empty is LocaleEN.
+func ParseLocale(value string) (Locale, error) {
+	switch locale := Locale(value); locale {
+	case "":
+		return LocaleEN, nil
+	case LocaleEN, LocaleRU:
+		return locale, nil
+	default:
+		return "", fmt.Errorf("invalid locale %q, expected en or ru", value)
+	}
+}
+
+// translations are the names of the other locales by their English format, which LocaleEN uses as is.
+var translations = map[Locale]map[string]string{
+	LocaleRU: {
+		"Open %s":             "Открыть %s",
+		"%s snapshot":         "%s снимок",
+		"Refresh %s snapshot": "Обновить снимок %s",
+		"%s last opened":      "%s последнее открытие",
+		"%s opened":           "%s открыта",
+		"%s opens":            "%s открытий",
+		"Publish %s":          "Публиковать %s",
+		"Doorbell":            "Звонок",
+		"Address":             "Адрес",
+		"Answer & open":       "Ответить и открыть",
+		"Reject call":         "Отклонить вызов",
+		"Intercom":            "Домофон",
+		"Intercom calls":      "Вызовы домофона",
+		"Doorphone":           "Домофон",
+		"Camera":              "Камера",
+		"Snapshot":            "Снимок",
+		"Motion":              "Движение",
+		"Balance":             "Баланс",
+		"Account":             "Лицевой счёт",
+		"Dom.ru account":      "Аккаунт Дом.ру",
+		"Dom.ru account %s":   "Аккаунт Дом.ру %s",
+		"Relock delay":        "Задержка закрытия",
+		"Access token expiry": "Истечение токена",
+		"Last token refresh":  "Последнее обновление токена",
+		"Last API error":      "Последняя ошибка API",
+		"Addon":               "Аддон",
+	},
+}
+
+// text returns the name in the locale of the integration, formatted with args.
+func (m *MqttIntegration) text(format string, args ...any) string {
+	if translated, ok := translations[m.Locale][format]; ok {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// ParseDoorNames parses "accessControlID=name" entries, i.e. from a flag, into DoorNames.
+func ParseDoorNames(entries []string) (map[int]string, error) {
+	names := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		rawAC, name, found := strings.Cut(entry, "=")
+		acID, err := strconv.Atoi(strings.TrimSpace(rawAC))
+		name = strings.TrimSpace(name)
+		if !found || err != nil || acID <= 0 || name == "" {
+			return nil, fmt.Errorf("invalid door name %q, expected accessControlID=name", entry)
+		}
+		names[acID] = name
+	}
+	return names, nil
+}
+
+// namedDoor returns the access control with the name configured in DoorNames, if any.
+func (m *MqttIntegration) namedDoor(ac models.AccessControl) models.AccessControl {
+	if name, ok := m.DoorNames[ac.ID]; ok {
+		ac.Name = name
+	}
+	return ac
+}
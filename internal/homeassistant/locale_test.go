@@ -0,0 +1,36 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestText(t *testing.T) {
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	assert.Equal(t, "Open Подъезд 2", m.text("Open %s", "Подъезд 2"))
+
+	m.Locale = LocaleRU
+	assert.Equal(t, "Открыть Подъезд 2", m.text("Open %s", "Подъезд 2"))
+	assert.Equal(t, "Домофон", m.text("Doorphone"))
+
+	for locale, names := range translations {
+		for format, translated := range names {
+			assert.Equal(t, strings.Count(format, "%s"), strings.Count(translated, "%s"), "%s: %s", locale, format)
+		}
+	}
+}
+
+func TestParseDoorNames(t *testing.T) {
+	names, err := ParseDoorNames([]string{"12=Калитка", " 34 = Gate = back "})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{12: "Калитка", 34: "Gate = back"}, names)
+
+	for _, entry := range []string{"12", "x=Gate", "12=", "0=Gate"} {
+		_, err = ParseDoorNames([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
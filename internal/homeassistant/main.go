@@ -3,6 +3,7 @@ package homeassistant
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -25,9 +26,10 @@ type HAConfig struct {
 	} `json:"data"`
 }
 
+// GetHomeAssistantNetworkAddressWithPort returns the address of the addon, empty outside of the supervisor.
 func GetHomeAssistantNetworkAddressWithPort() (string, error) {
 	host, err := GetHomeAssistantNetworkAddress()
-	if err != nil {
+	if err != nil || host == "" {
 		return "", err
 	}
 	return fmt.Sprintf("%s:8080", host), nil
@@ -43,9 +45,7 @@ func GetHomeAssistantNetworkAddress() (string, error) {
 
 	val, ok := os.LookupEnv("SUPERVISOR_TOKEN")
 	if !ok {
-		log.Println("SUPERVISOR_TOKEN not set, addon is likely not running in a Home Assistant production environment. This is okay for local development.")
-		// Fallback for local development or when not in HA environment.
-		// You might want to make "" configurable.
+		// Standalone, i.e. plain Docker or local development, there is no supervisor to ask
 		return "", nil
 	}
 	supervisor_token = val
@@ -92,10 +92,74 @@ func GetHomeAssistantNetworkAddress() (string, error) {
 		return "", fmt.Errorf("supervisor ip Unmarshal %s", err.Error())
 	}
 
-	if haconfig.Result == "ok" && len(haconfig.Data.Interfaces) > 0 {
-		address := strings.Split(haconfig.Data.Interfaces[0].Ipv4.Address[0], "/")
-		return address[0], nil
+	if haconfig.Result == "ok" {
+		// Interfaces without an IPv4 address (e.g. IPv6 only) are skipped
+		for _, iface := range haconfig.Data.Interfaces {
+			if len(iface.Ipv4.Address) == 0 {
+				continue
+			}
+			address := strings.Split(iface.Ipv4.Address[0], "/")
+			return address[0], nil
+		}
 	}
 
 	return "", fmt.Errorf("supervisor ip not found")
 }
+
+// ErrNoSupervisor is returned by supervisor API calls when the addon doesn't run under the supervisor.
+var ErrNoSupervisor = errors.New("SUPERVISOR_TOKEN not set, not running under the Home Assistant supervisor")
+
+// MQTTService is the broker the supervisor provides to addons declaring the mqtt service.
+type MQTTService struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	SSL      bool   `json:"ssl"`
+}
+
+// GetMQTTService returns the broker settings of the supervisor MQTT service, i.e. the Mosquitto addon.
+func GetMQTTService() (MQTTService, error) {
+	token, ok := os.LookupEnv("SUPERVISOR_TOKEN")
+	if !ok {
+		return MQTTService{}, ErrNoSupervisor
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return getMQTTService(ctx, http.DefaultClient, constants.API_HA_MQTT_SERVICE, token)
+}
+
+func getMQTTService(ctx context.Context, client *http.Client, serviceURL, token string) (MQTTService, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, serviceURL, nil)
+	if err != nil {
+		return MQTTService{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return MQTTService{}, fmt.Errorf("supervisor mqtt service request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MQTTService{}, fmt.Errorf("supervisor mqtt service: unexpected status code %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Result  string      `json:"result"`
+		Message string      `json:"message"`
+		Data    MQTTService `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return MQTTService{}, fmt.Errorf("supervisor mqtt service: decode response: %w", err)
+	}
+	if response.Result != "ok" {
+		return MQTTService{}, fmt.Errorf("supervisor mqtt service: %s", response.Message)
+	}
+	if response.Data.Host == "" {
+		return MQTTService{}, errors.New("supervisor mqtt service: no broker host")
+	}
+	return response.Data, nil
+}
@@ -1,10 +1,20 @@
 package homeassistant
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -12,6 +22,18 @@ import (
 	"github.com/090809/homeassistant-domru/internal/domru"
 	"github.com/090809/homeassistant-domru/internal/domru/constants"
 	"github.com/090809/homeassistant-domru/internal/domru/models"
+	"github.com/090809/homeassistant-domru/pkg/auth"
+	"github.com/090809/homeassistant-domru/pkg/logging"
+)
+
+const (
+	defaultPublishAttempts = 3
+	defaultPublishTimeout  = time.Second
+	publishRetryDelay      = 500 * time.Millisecond
+
+	// rediscoveryJitter is the maximum share of RediscoveryInterval added to each wait,
+	// so several instances sharing an account don't query Dom.ru at the same moment.
+	rediscoveryJitter = 0.1
 )
 
 const (
@@ -19,130 +41,887 @@ const (
 	mqttPortEnv     = "MQTT_PORT"
 	mqttUsernameEnv = "MQTT_USER"
 	mqttPasswordEnv = "MQTT_PASSWORD"
+	mqttClientIDEnv = "MQTT_CLIENT_ID"
+)
+
+// DefaultClientID is the MQTT client ID used unless another one is configured.
+const DefaultClientID = "domru_proxy"
+
+// Defaults of the Mosquitto addon, used when neither the broker is configured nor the supervisor MQTT service is available.
+const (
+	supervisorBrokerHost = "addon_core_mosquitto"
+	defaultBrokerPort    = 1883
+	defaultBrokerTLSPort = 8883
+	defaultBrokerUser    = "domru_proxy"
+	defaultBrokerPass    = "domru_proxy"
 )
 
+// BrokerSettings locate the MQTT broker. Without a URL or Host the broker of the supervisor MQTT service is used.
+// Empty fields fall back to the MQTT_HOST, MQTT_PORT, MQTT_USER and MQTT_PASSWORD environment variables
+// and then to the Mosquitto addon defaults.
+type BrokerSettings struct {
+	// URL is the full broker URL, i.e. wss://mqtt.example.com/mqtt. It takes precedence over Host and Port.
+	URL      string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS secures the connection, nil connects over plain TCP.
+	TLS *tls.Config
+}
+
+// PublishOptions are the QoS and retain flags used for a category of publishes.
+type PublishOptions struct {
+	QoS    byte
+	Retain bool
+}
+
 // MqttIntegration handles the connection and communication with Home Assistant via MQTT.
 type MqttIntegration struct {
-	client   mqtt.Client
+	// DiscoveryPublish is used for discovery configs. Home Assistant only sees retained configs after its restart.
+	DiscoveryPublish PublishOptions
+	// StatePublish is used for entity states and attributes.
+	StatePublish PublishOptions
+	// AvailabilityPublish is used for the bridge availability topic and its last will.
+	AvailabilityPublish PublishOptions
+
+	// ClientID is the MQTT client ID. It must be stable, so the broker replaces the previous session
+	// of the addon instead of keeping it, and unique across instances. MQTT_CLIENT_ID overrides it.
+	ClientID string
+	// OpenCountsFile persists the open counts of the doors, so they survive restarts. Empty counts from zero on every start.
+	OpenCountsFile string
+	// DryRun logs the discovery and everything else the integration would publish instead of connecting to the broker.
+	DryRun bool
+	// MQTTv5 connects with MQTT 5 instead of 3.1.1, so failures are reported with the reason codes of the broker.
+	MQTTv5 bool
+	// NewClient creates the MQTT client from the broker options instead of paho, i.e. for another transport.
+	NewClient func(opts *mqtt.ClientOptions) Client
+
+	// BalanceInterval is how often the balance sensor is refreshed. Zero disables the sensor.
+	BalanceInterval time.Duration
+	// Optimistic makes Home Assistant assume the lock state right after a command.
+	// Otherwise the lock shows "unlocking" until the door open is confirmed by Dom.ru.
+	Optimistic bool
+	// MaxReconnectInterval caps the wait between reconnects after the broker connection was lost,
+	// zero keeps the default of the client.
+	MaxReconnectInterval time.Duration
+	// ReconnectAttempts is how often reconnecting is tried before giving up, zero never gives up.
+	ReconnectAttempts int
+	// PlaceFailureThreshold is how many Dom.ru requests of a place have to fail in a row until the entities
+	// of its doors are shown unavailable. Zero never marks places unavailable.
+	PlaceFailureThreshold int
+	// StopGracePeriod is how long Stop waits for the commands being handled before disconnecting.
+	StopGracePeriod time.Duration
+	// OpenDebounce is how long after opening a door repeated OPEN and UNLOCK commands of it are acknowledged
+	// without opening it again. Zero only drops the commands arriving while the door is being opened.
+	OpenDebounce time.Duration
+	// RelockDelay is how long an opened door is reported unlocked before it is reported locked again,
+	// unless the relock delay number entity sets another one.
+	RelockDelay time.Duration
+	// DoorPrecheck makes door opens check that the door is online and may be opened first,
+	// so an open into the void is reported as a failure instead of an optimistic success.
+	DoorPrecheck bool
+	// RediscoveryInterval is how often places are re-queried to publish new and remove vanished
+	// access controls. Zero disables periodic re-discovery, devices are then discovered on connect only.
+	RediscoveryInterval time.Duration
+	// Filter selects the access controls published via discovery.
+	Filter EntityFilter
+
+	// BirthTopic is where Home Assistant announces its start, discovery is then published again.
+	// Empty disables re-publishing on Home Assistant restarts.
+	BirthTopic string
+
+	// DoorEntity selects whether doors are published as locks, buttons or both.
+	DoorEntity DoorEntityType
+
+	// DoorCameras publishes a camera entity with the snapshot of every door,
+	// refreshed on any message to its update topic.
+	DoorCameras bool
+
+	// CallButtons publishes buttons answering and rejecting the intercom calls of every place
+	// with a SIP account, answering a call opens the calling door.
+	CallButtons bool
+
+	// Locale is the language of the entity names and device models.
+	Locale Locale
+	// DoorNames renames access controls by ID in Home Assistant, instead of the names Dom.ru gives them.
+	DoorNames map[int]string
+
+	// Topics names the MQTT topics and entity IDs. Changing its prefix creates new entities,
+	// the door locks of the old prefix are removed by the next discovery when RegistryFile is set.
+	Topics Topics
+	// URLTemplates builds the entity picture URL of the door locks.
+	URLTemplates constants.URLTemplates
+	// DoorCameraIDs maps access control IDs to the camera showing their door, for installations where
+	// it's a separate device. The lock picture and the door camera use its image instead of the access control snapshot.
+	DoorCameraIDs map[int]int
+
+	// PublishAttempts and PublishTimeout are how often discovery configs and initial states are
+	// published and how long each attempt waits for the broker to acknowledge it.
+	PublishAttempts int
+	PublishTimeout  time.Duration
+
+	// LockCommand is how LOCK commands are handled by access control type, DefaultLockCommandType
+	// applies to other types. Types without a mode confirm the lock, doors lock by themselves.
+	LockCommand map[string]LockCommandMode
+
+	// LogUnsafe additionally logs the unmasked places, with addresses and account IDs, at debug level.
+	LogUnsafe bool
+
+	// RegistryFile persists the published door locks, so the ones vanished while the addon was
+	// stopped or published for another account are removed too. Empty keeps them in memory only.
+	RegistryFile string
+
+	// SettingsFile persists the settings changed over MQTT, i.e. the relock delay, so they survive a restart.
+	// Empty keeps them in memory only.
+	SettingsFile string
+
+	// AvailabilityJSON publishes the bridge availability as a JSON document with the version, start time,
+	// operator and the number of published doors instead of plain online/offline.
+	AvailabilityJSON bool
+	// Credentials tells the operator of the primary account in the JSON availability, nil leaves it out.
+	Credentials auth.CredentialsStore
+
+	// CameraInterval is how often the snapshots of the camera entities, one for every camera
+	// of the primary account, are refreshed. Zero disables the camera entities.
+	CameraInterval time.Duration
+
+	// Events feeds the camera motion sensors and the doorbells, nil disables them.
+	Events EventSource
+	// AccountEvents returns the events of an additional account, which feed the doorbells of its doors.
+	// Nil leaves those doors without doorbells, as does a nil Events.
+	AccountEvents func(name string, api *domru.APIWrapper) EventSource
+	// MotionOffDelay is how long a motion sensor stays on after a motion event.
+	MotionOffDelay time.Duration
+	// Version is the addon version shown on the devices.
+	Version string
+	// TokenStatus feeds the diagnostic sensors of the session health, nil disables them.
+	TokenStatus TokenStatusSource
+	// DiagnosticsInterval is how often the diagnostic sensors are refreshed.
+	DiagnosticsInterval time.Duration
+	// Location is the timezone event times are published in, nil means the system timezone.
+	Location *time.Location
+
+	client   Client
 	logger   *slog.Logger
 	domruAPI *domru.APIWrapper
+	accounts []mqttAccount
 	haHost   string
 
-	mqttPort     int
-	mqttUsername string
-	mqttPassword string
+	// mqttService returns the broker of the supervisor, it's used unless a broker host is configured.
+	mqttService      func() (MQTTService, error)
+	brokerConfigured bool
+	mqttURL          string
+	mqttHost         string
+	mqttPort         int
+	mqttUsername     string
+	mqttPassword     string
+	mqttTLS          *tls.Config
+
+	placesMu sync.RWMutex
+	places   *models.PlacesResponse
+
+	// discoveryMu serializes discovery runs, discovered holds the published door locks by discovery topic.
+	discoveryMu sync.Mutex
+	discovered  map[string]discoveredDoorLock
+	// disabledDoors holds the doors disabled over MQTT by discovery topic, only their enabled switch is published.
+	disabledDoors map[string]discoveredDoorLock
+	// doors indexes the discovered door locks by the entity ID of their commands, states holds their last states.
+	doors   doorIndex
+	states  stateRegistry
+	summary DiscoverySummary
+	// placeAreas holds the areas suggested for the doors of a place, derived from its address.
+	placeAreas map[placeKey]string
+	// placeAddresses holds the visible address of every place, for the attributes of its doors.
+	placeAddresses map[placeKey]string
+	// registeredAccount identifies the account the discovered door locks were published for.
+	registeredAccount string
+	// registeredTopics names the topics the discovered door locks were published under.
+	registeredTopics Topics
+
+	// callPlaces holds the places of the published call buttons, calls the incoming calls they act on.
+	callPlaces map[int]bool
+	calls      *callTracker
+	// opened tells the opens of the doors by the addon from the ones by others.
+	opened *openHistory
+	// openCounts counts the successful opens of every door for its open count sensor.
+	openCounts *openCounter
+
+	motionMu      sync.RWMutex
+	motionCameras map[int]bool
+
+	// placeHealth tracks the places whose Dom.ru requests keep failing.
+	placeHealth *placeHealth
+
+	// reconnectAttempts counts the reconnects since the connection was lost,
+	// discovering is set while the discovery started on connect runs.
+	reconnectAttempts atomic.Int32
+	startedAt         time.Time
+	discoveredCount   atomic.Int32
+	discovering       atomic.Bool
+
+	// lockStates holds the last state seen on every state topic, retained ones included.
+	lockStatesMu sync.Mutex
+	lockStates   map[string]string
+
+	// doorAttributes holds the attributes of every door lock by attributes topic.
+	doorAttributesMu sync.Mutex
+	doorAttributes   map[string]doorAttributes
+
+	birth   birthGuard
+	relocks *relockScheduler
+	// discoveryDelay is how long the discovery on connect waits for the connection to settle.
+	discoveryDelay time.Duration
+	opens          *openDebouncer
+	// commands tracks the commands being handled for Stop.
+	commands commandTracker
+	// relockDelayOverride is the relock delay set over MQTT, zero if it wasn't, see relockDelay.
+	relockDelayOverride atomic.Int64
+	// settingsMu guards disabled, the doors disabled over MQTT by doorSettingKey, and the writes of SettingsFile.
+	settingsMu sync.Mutex
+	disabled   map[string]bool
+
+	// camerasMu guards the camera entities by camera ID and the cameras failing to return snapshots.
+	camerasMu   sync.Mutex
+	cameras     map[int]*publishedCamera
+	camerasDown map[int]bool
+
+	snapshots snapshotCache
+
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
-// NewMqttIntegration creates and configures the MQTT integration.
+// NewMqttIntegration creates and configures the MQTT integration connecting to the broker.
+// externalURL is where Home Assistant reaches the addon for entity pictures, empty uses the supervisor address.
 func NewMqttIntegration(
 	domruAPI *domru.APIWrapper,
 	logger *slog.Logger,
+	broker BrokerSettings,
+	externalURL string,
 ) *MqttIntegration {
+	port := broker.Port
+	if rawPort := os.Getenv(mqttPortEnv); port == 0 && rawPort != "" {
+		var err error
+		if port, err = strconv.Atoi(rawPort); err != nil {
+			logger.Warn("Ignoring invalid MQTT port", "env", mqttPortEnv, "value", rawPort)
+			port = 0
+		}
+	}
+	if port == 0 && broker.TLS != nil {
+		port = defaultBrokerTLSPort
+	}
+	if port == 0 {
+		port = defaultBrokerPort
+	}
+
 	return &MqttIntegration{
-		domruAPI: domruAPI,
-		logger:   logger,
+		haHost:                strings.TrimRight(externalURL, "/"),
+		mqttService:           GetMQTTService,
+		brokerConfigured:      broker.Host != "" || broker.URL != "",
+		mqttURL:               broker.URL,
+		mqttHost:              firstNonEmpty(broker.Host, os.Getenv(mqttHostEnv)),
+		mqttPort:              port,
+		mqttTLS:               broker.TLS,
+		mqttUsername:          firstNonEmpty(broker.Username, os.Getenv(mqttUsernameEnv), defaultBrokerUser),
+		mqttPassword:          firstNonEmpty(broker.Password, os.Getenv(mqttPasswordEnv), defaultBrokerPass),
+		DiscoveryPublish:      PublishOptions{QoS: 1, Retain: true},
+		StatePublish:          PublishOptions{QoS: 1, Retain: true},
+		AvailabilityPublish:   PublishOptions{QoS: 1, Retain: true},
+		ClientID:              DefaultClientID,
+		BalanceInterval:       time.Hour,
+		PublishAttempts:       defaultPublishAttempts,
+		PublishTimeout:        defaultPublishTimeout,
+		MotionOffDelay:        30 * time.Second,
+		CameraInterval:        time.Minute,
+		DiagnosticsInterval:   time.Minute,
+		DoorCameras:           true,
+		DoorEntity:            DoorEntityLock,
+		Locale:                LocaleEN,
+		BirthTopic:            DefaultBirthTopic,
+		birth:                 birthGuard{window: birthDebounce},
+		RelockDelay:           defaultRelockDelay,
+		OpenDebounce:          defaultOpenDebounce,
+		StopGracePeriod:       defaultStopGracePeriod,
+		opens:                 newOpenDebouncer(time.Now),
+		PlaceFailureThreshold: defaultPlaceFailureThreshold,
+		MaxReconnectInterval:  2 * time.Minute,
+		placeHealth:           newPlaceHealth(),
+		lockStates:            make(map[string]string),
+		doorAttributes:        make(map[string]doorAttributes),
+		placeAreas:            make(map[placeKey]string),
+		placeAddresses:        make(map[placeKey]string),
+		callPlaces:            make(map[int]bool),
+		calls:                 newCallTracker(time.Now),
+		opened:                newOpenHistory(),
+		openCounts:            &openCounter{counts: make(map[string]int64)},
+		relocks:               newRelockScheduler(timeAfterFunc),
+		discoveryDelay:        2 * time.Second,
+		domruAPI:              domruAPI,
+		logger:                logger,
+		discovered:            make(map[string]discoveredDoorLock),
+		disabledDoors:         make(map[string]discoveredDoorLock),
+		disabled:              make(map[string]bool),
+		done:                  make(chan struct{}),
+	}
+}
+
+// mqttAccount is an additional Dom.ru account whose doors are published next to the primary ones.
+type mqttAccount struct {
+	name string
+	api  *domru.APIWrapper
+}
+
+// AddOperatorAccount publishes the doors of another Dom.ru account, e.g. one billed by another operator.
+// Its entity IDs are prefixed with the operator, so only one account per operator can be added.
+// It must be called before Start.
+func (m *MqttIntegration) AddOperatorAccount(operatorID int, api *domru.APIWrapper) error {
+	if err := m.AddAccount(OperatorAccount(operatorID), api); err != nil {
+		return fmt.Errorf("account of operator %d is already added", operatorID)
+	}
+	return nil
+}
+
+// AddAccount publishes the doors of another Dom.ru account under the name, i.e. an account of the
+// credentials file. The name is a part of the entity IDs, it must not contain dashes or slashes.
+// It must be called before Start.
+func (m *MqttIntegration) AddAccount(name string, api *domru.APIWrapper) error {
+	if name == "" || strings.ContainsAny(name, "-/+#") {
+		return fmt.Errorf("invalid account name %q", name)
+	}
+	for _, account := range m.accounts {
+		if account.name == name {
+			return fmt.Errorf("account %s is already added", name)
+		}
+	}
+	m.accounts = append(m.accounts, mqttAccount{name: name, api: api})
+	return nil
+}
+
+// allAccounts returns the primary account followed by the additional ones.
+func (m *MqttIntegration) allAccounts() []mqttAccount {
+	return append([]mqttAccount{{api: m.domruAPI}}, m.accounts...)
+}
+
+// accountAPI returns the API of the named account, or nil if there is no such account.
+func (m *MqttIntegration) accountAPI(name string) *domru.APIWrapper {
+	for _, account := range m.allAccounts() {
+		if account.name == name {
+			return account.api
+		}
 	}
+	return nil
+}
+
+// brokerSchemes are the broker URL schemes the MQTT client connects to.
+var brokerSchemes = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}
+
+// ParseBrokerURL checks that raw is a broker URL the MQTT client can connect to.
+func ParseBrokerURL(raw string) (*url.URL, error) {
+	brokerURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(brokerSchemes, brokerURL.Scheme) {
+		return nil, fmt.Errorf("unsupported scheme %q, expected one of %s", brokerURL.Scheme, strings.Join(brokerSchemes, ", "))
+	}
+	if brokerURL.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+	return brokerURL, nil
+}
+
+// ErrMQTTUnavailable is returned by CheckConnection when no broker is configured outside of Home Assistant.
+var ErrMQTTUnavailable = errors.New("no MQTT broker configured outside of Home Assistant")
+
+// brokerOptions returns the client options of the broker, false when no broker is configured
+// and the addon doesn't run under the supervisor.
+func (m *MqttIntegration) brokerOptions(clientID string) (*mqtt.ClientOptions, bool) {
+	_, supervised := os.LookupEnv("SUPERVISOR_TOKEN")
+
+	mqttHost, mqttPort, mqttUsername, mqttPassword, mqttTLS := m.mqttHost, m.mqttPort, m.mqttUsername, m.mqttPassword, m.mqttTLS
+	if supervised && !m.brokerConfigured {
+		if service, err := m.mqttService(); err != nil {
+			m.logger.Error("MQTT service of the supervisor is unavailable, is the Mosquitto addon installed? "+
+				"Falling back to the configured broker", "error", err)
+		} else {
+			mqttHost, mqttPort, mqttUsername, mqttPassword = service.Host, service.Port, service.Username, service.Password
+			if service.SSL && mqttTLS == nil {
+				mqttTLS = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+		}
+	}
+	if mqttHost == "" && m.mqttURL == "" {
+		if !supervised {
+			return nil, false
+		}
+		mqttHost = supervisorBrokerHost
+	}
+
+	opts := mqtt.NewClientOptions()
+	if m.mqttURL != "" {
+		opts.AddBroker(m.mqttURL)
+		opts.SetTLSConfig(mqttTLS)
+	} else if mqttTLS != nil {
+		opts.AddBroker(fmt.Sprintf("ssl://%s:%d", mqttHost, mqttPort))
+		opts.SetTLSConfig(mqttTLS)
+	} else {
+		opts.AddBroker(fmt.Sprintf("tcp://%s:%d", mqttHost, mqttPort))
+	}
+	opts.SetClientID(clientID)
+	opts.SetUsername(mqttUsername)
+	opts.SetPassword(mqttPassword)
+	return opts, true
+}
+
+// resolveHAHost returns the base URL of the addon for entity pictures: the external URL, else the address
+// reported by the supervisor, else empty, which publishes the entities without pictures.
+func resolveHAHost(externalURL string, supervisorAddress func() (string, error), logger *slog.Logger) string {
+	if externalURL != "" {
+		return externalURL
+	}
+	address, err := supervisorAddress()
+	if err != nil {
+		logger.Warn("Unable to get the addon address from the supervisor, entities are published without pictures", "error", err)
+		return ""
+	}
+	if address == "" {
+		return ""
+	}
+	return "http://" + address
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// CheckConnection connects to the broker and disconnects right away, without publishing anything.
+// It uses its own client ID, so a running addon instance keeps its session.
+func (m *MqttIntegration) CheckConnection(timeout time.Duration) error {
+	opts, ok := m.brokerOptions(m.clientID() + "_check")
+	if !ok {
+		return ErrMQTTUnavailable
+	}
+	opts.SetConnectTimeout(timeout)
+
+	client := m.newClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(timeout) {
+		return errors.New("timed out connecting to the broker")
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+	client.Disconnect(250)
+	return nil
 }
 
 // Start connects to the MQTT broker and sets up device discovery.
 func (m *MqttIntegration) Start() {
-	var mqttHost string
-	if _, ok := os.LookupEnv("SUPERVISOR_TOKEN"); ok {
-		m.haHost = "https://home.pallam.dev/"
-		mqttHost = "addon_core_mosquitto"
-	} else {
+	if m.DryRun {
+		m.startDryRun()
 		return
 	}
 
-	mqttPort := 1883
-	mqttUser := "domru_proxy"
-	mqttPass := "domru_proxy"
+	opts, ok := m.brokerOptions(m.clientID())
+	if !ok {
+		m.logger.Warn("Not running under the Home Assistant supervisor and no MQTT broker configured, MQTT is disabled. " +
+			"Set mqtt-host or mqtt-url to use a broker")
+		return
+	}
 
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", mqttHost, mqttPort))
-	opts.SetClientID(fmt.Sprintf("domru_proxy_%d", time.Now().Unix()))
-	opts.SetUsername(mqttUser)
-	opts.SetPassword(mqttPass)
+	m.haHost = resolveHAHost(m.haHost, GetHomeAssistantNetworkAddressWithPort, m.logger)
 
-	opts.SetWill("domru_proxy/status", "offline", 1, true)
+	m.startedAt = time.Now()
+	opts.SetWill(m.Topics.Availability(), m.offlinePayload(), m.AvailabilityPublish.QoS, m.AvailabilityPublish.Retain)
 
 	opts.OnConnect = m.connectHandler
 	opts.OnConnectionLost = m.connectionLostHandler
+	// The client doubles the wait between reconnects up to the maximum
+	opts.SetAutoReconnect(true)
+	if m.MaxReconnectInterval > 0 {
+		opts.SetMaxReconnectInterval(m.MaxReconnectInterval)
+	}
+	opts.OnReconnecting = m.reconnectingHandler
+
+	// The client is guarded by discoveryMu until connected, CleanupDiscovery may run meanwhile
+	m.discoveryMu.Lock()
+	m.loadRegistry()
+	m.loadSettings()
+	m.loadOpenCounts()
+	m.client = m.newClient(opts)
+	m.discoveryMu.Unlock()
 
 	m.logger.Info("Connecting to MQTT broker...")
-	m.client = mqtt.NewClient(opts)
 	if token := m.client.Connect(); token.Wait() && token.Error() != nil {
 		m.logger.Error("Failed to connect to MQTT broker", "error", token.Error())
 		return
 	}
+
+	if m.BalanceInterval > 0 {
+		go m.runEvery(m.BalanceInterval, m.publishBalance)
+	}
+	if m.RediscoveryInterval > 0 {
+		go m.runRediscovery()
+	}
+	if m.CameraInterval > 0 {
+		go m.runEvery(m.CameraInterval, m.refreshCameras)
+	}
+	if m.TokenStatus != nil && m.DiagnosticsInterval > 0 {
+		go m.runEvery(m.DiagnosticsInterval, m.publishDiagnostics)
+	}
+	if m.Events != nil {
+		go m.runEvents("", m.Events)
+		if m.AccountEvents != nil {
+			for _, account := range m.accounts {
+				go m.runEvents(account.name, m.AccountEvents(account.name, account.api))
+			}
+		}
+	}
+}
+
+// hasEvents reports whether the events of the account are polled.
+func (m *MqttIntegration) hasEvents(account string) bool {
+	return m.Events != nil && (account == "" || m.AccountEvents != nil)
+}
+
+// clientID returns the MQTT client ID, MQTT_CLIENT_ID takes precedence over ClientID.
+func (m *MqttIntegration) clientID() string {
+	if clientID := os.Getenv(mqttClientIDEnv); clientID != "" {
+		return clientID
+	}
+	if m.ClientID != "" {
+		return m.ClientID
+	}
+	return DefaultClientID
+}
+
+// openDoor opens the door, checking it first if DoorPrecheck is set, and tracks the availability of the place.
+func (m *MqttIntegration) openDoor(account string, api *domru.APIWrapper, placeID, acID int) error {
+	open := api.OpenDoor
+	if m.DoorPrecheck {
+		open = api.OpenDoorChecked
+	}
+
+	err := open(placeID, acID)
+	if err != nil {
+		m.placeRequestFailed(account, placeID)
+	} else {
+		m.placeRequestSucceeded(account, placeID)
+	}
+	m.RecordDoorOpen(DoorOpen{Source: DoorOpenMQTT, Account: account, PlaceID: placeID, AccessControlID: acID, Err: err})
+	return err
 }
 
-func (m *MqttIntegration) connectHandler(client mqtt.Client) {
+// runEvery calls fn immediately and then every interval until the integration is stopped.
+func (m *MqttIntegration) runEvery(interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn()
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runRediscovery re-discovers devices every RediscoveryInterval plus jitter until the integration is stopped.
+// The first run is delayed, devices are already discovered on connect.
+func (m *MqttIntegration) runRediscovery() {
+	for {
+		wait := m.RediscoveryInterval + time.Duration(rand.Float64()*rediscoveryJitter*float64(m.RediscoveryInterval))
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !m.client.IsConnected() {
+			m.logger.Debug("Skipping re-discovery, not connected to MQTT broker")
+			continue
+		}
+		m.logger.Info("Re-discovering devices")
+		m.syncDevices(false)
+	}
+}
+
+// Rediscover publishes new and removes vanished devices right away, i.e. after the credentials
+// were changed to another account. It does nothing while not connected to the broker.
+func (m *MqttIntegration) Rediscover() {
+	if m.client == nil || !m.client.IsConnected() {
+		m.logger.Debug("Skipping re-discovery, not connected to MQTT broker")
+		return
+	}
+	m.logger.Info("Re-discovering devices")
+	m.syncDevices(false)
+}
+
+func (m *MqttIntegration) connectHandler(_ mqtt.Client) {
 	m.logger.Info("Connected to MQTT broker")
 
-	aToken := client.Publish("domru_proxy/status", 1, true, "online")
-	aToken.Wait()
-	if aToken.Error() != nil {
-		m.logger.Error("Failed to publish online status", "error", aToken.Error())
+	if err := m.publishOnline(); err != nil {
+		m.logger.Error("Failed to publish online status", "error", err)
 	} else {
 		m.logger.Info("Published online status to bridge availability topic")
 	}
 
 	// Subscribe to command topics
-	commandTopic := "domru/+/command"
-	commandToken := m.client.Subscribe(commandTopic, 1, m.commandHandler)
-	commandToken.Wait()
-	if commandToken.Error() != nil {
-		m.logger.Error("Failed to subscribe to command topic", "error", commandToken.Error())
-	} else {
-		m.logger.Info("Subscribed to command topic", "topic", commandTopic)
+	m.subscribe(m.Topics.Subscription("command"), m.commandHandler, "command")
+	m.subscribe(m.Topics.Subscription("state"), m.stateHandler, "state")
+	m.subscribe(m.Topics.Subscription("attributes"), m.doorAttributesHandler, "attributes")
+	if m.DoorCameras {
+		m.subscribe(m.Topics.Subscription("update"), m.cameraUpdateHandler, "camera update")
+	}
+	m.subscribe(m.Topics.Open(), m.openHandler, "open")
+	if m.CallButtons {
+		m.subscribe(m.Topics.Subscription("call"), m.callHandler, "call")
+	}
+	m.subscribe(m.Topics.Subscription("set"), m.doorEnabledHandler, "door enabled")
+	m.subscribe(m.Topics.SettingTopics(relockDelaySetting).Command, m.relockDelayHandler, "relock delay")
+	m.publishRelockDelay()
+	if m.BirthTopic != "" {
+		m.subscribe(m.BirthTopic, m.birthHandler, "birth")
 	}
 
-	stateTopic := "domru/+/state"
-	stateToken := m.client.Subscribe(stateTopic, 1, m.stateHandler)
-	stateToken.Wait()
-	if stateToken.Error() != nil {
-		m.logger.Error("Failed to subscribe to state topic", "error", stateToken.Error())
-	} else {
-		m.logger.Info("Subscribed to state topic", "topic", stateTopic)
+	// Discovery runs anyway, a birth message right after connecting must not run it twice
+	m.birth.allow(time.Now())
+	m.reconnectAttempts.Store(0)
+	if !m.discovering.CompareAndSwap(false, true) {
+		m.logger.Debug("Discovery of a previous connection is still running, not starting another one")
+		return
 	}
+	go func() {
+		defer m.discovering.Store(false)
+		m.discoverDevices()
+	}()
+}
 
-	go m.discoverDevices()
+// subscribe subscribes the handler to the topic and logs the outcome, what names the topic in the logs.
+// A failed subscription is only logged, the other topics of the connection still work.
+func (m *MqttIntegration) subscribe(topic string, handler mqtt.MessageHandler, what string) {
+	token := m.client.Subscribe(topic, 1, handler)
+	token.Wait()
+	if token.Error() != nil {
+		m.logger.Error(fmt.Sprintf("Failed to subscribe to %s topic", what), "topic", topic, "error", token.Error())
+		return
+	}
+	m.logger.Info(fmt.Sprintf("Subscribed to %s topic", what), "topic", topic)
 }
 
 func (m *MqttIntegration) connectionLostHandler(client mqtt.Client, err error) {
-	m.logger.Warn("MQTT connection lost", "error", err)
+	m.logger.Warn("MQTT connection lost, reconnecting", "error", err)
 }
 
-func (m *MqttIntegration) Stop() {
-	if m.client != nil && m.client.IsConnected() {
-		m.logger.Info("Disconnecting from MQTT broker")
-		m.client.Disconnect(250) // 250ms timeout
+// reconnectingHandler counts the reconnect attempts and stops reconnecting after ReconnectAttempts failed ones.
+func (m *MqttIntegration) reconnectingHandler(client mqtt.Client, _ *mqtt.ClientOptions) {
+	attempt := m.reconnectAttempts.Add(1)
+	if m.ReconnectAttempts <= 0 || int(attempt) <= m.ReconnectAttempts {
+		m.logger.Info("Reconnecting to MQTT broker", "attempt", attempt)
+		return
 	}
+
+	m.logger.Error("Giving up reconnecting to MQTT broker, restart the addon once the broker is back", "attempts", attempt-1)
+	// Disconnecting from within the handler would wait for the reconnect calling it
+	go client.Disconnect(0)
+}
+
+// DiscoverySummary describes the latest discovery run.
+type DiscoverySummary struct {
+	// Published is the number of door locks currently published, Failed and Removed count the latest run.
+	Published int
+	Failed    int
+	Removed   int
+	// At is the time of the latest run, zero if there was none yet.
+	At time.Time
+}
+
+// DiscoverySummary returns the summary of the latest discovery run.
+func (m *MqttIntegration) DiscoverySummary() DiscoverySummary {
+	m.discoveryMu.Lock()
+	defer m.discoveryMu.Unlock()
+
+	return m.summary
+}
+
+// discoveredDoorLock is an access control whose discovery config has been published.
+type discoveredDoorLock struct {
+	account       string
+	accessControl models.AccessControl
+	placeID       int
+}
+
+// hasDiscoveredDoors reports whether door locks of the account are published. The caller holds discoveryMu.
+func (m *MqttIntegration) hasDiscoveredDoors(account string) bool {
+	for _, door := range m.discovered {
+		if door.account == account {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *MqttIntegration) discoverDevices() {
 	// Allow some time for the connection to be fully established
-	time.Sleep(2 * time.Second)
+	time.Sleep(m.discoveryDelay)
 
-	placesResponse, err := m.domruAPI.RequestPlaces()
-	if err != nil {
-		m.logger.Error("Failed to get places for MQTT discovery", "error", err)
-		return
+	m.syncDevices(true)
+}
+
+// syncDevices publishes discovery for the access controls of all accounts and removes the ones
+// that disappeared since the previous run. Unless republish is set, already published
+// access controls are left untouched.
+func (m *MqttIntegration) syncDevices(republish bool) {
+	m.discoveryMu.Lock()
+	defer m.discoveryMu.Unlock()
+
+	m.checkTopicsChanged()
+
+	seen := make(map[string]bool)
+	unavailable := make(map[string]bool)
+	var discovered, failed int
+	for _, account := range m.allAccounts() {
+		placesResponse, err := account.api.RequestPlaces()
+		if err != nil {
+			// Without places nothing can be told about vanished devices, so keep the account doors as is
+			m.logger.Error("Failed to get places for MQTT discovery", "account", account.name, "error", err)
+			unavailable[account.name] = true
+			for _, placeID := range m.discoveredPlaces(account.name) {
+				m.placeRequestFailed(account.name, placeID)
+			}
+			continue
+		}
+		for _, data := range placesResponse.Data {
+			m.placeRequestSucceeded(account.name, data.Place.ID)
+		}
+		if len(placesResponse.Data) == 0 && m.hasDiscoveredDoors(account.name) {
+			// An empty answer is far more likely a glitch of the API than all doors gone at once
+			m.logger.Warn("No places returned for MQTT discovery, keeping the published access controls", "account", account.name)
+			unavailable[account.name] = true
+			continue
+		}
+		if account.name == "" {
+			m.setPlaces(placesResponse)
+			m.checkAccountChanged(placesResponse)
+			if m.CallButtons {
+				m.syncCallButtons(placesResponse, republish)
+			}
+		}
+
+		accountDiscovered, accountFailed := m.syncAccountDoorLocks(account, placesResponse, republish, seen)
+		discovered += accountDiscovered
+		failed += accountFailed
+	}
+
+	if m.Events != nil {
+		m.syncMotionSensors(republish)
+	}
+	if m.CameraInterval > 0 {
+		m.syncCameras(republish)
+	}
+
+	var removed int
+	for discoveryTopic, door := range m.discovered {
+		if seen[discoveryTopic] || unavailable[door.account] {
+			continue
+		}
+		m.logger.Info("Removing access control that is no longer in the account", "account", door.account, "placeID", door.placeID, "accessControlID", door.accessControl.ID, "name", door.accessControl.Name)
+		m.removeDoorLock(door.account, door.accessControl, door.placeID)
+		delete(m.discovered, discoveryTopic)
+		removed++
+	}
+	for discoveryTopic, door := range m.disabledDoors {
+		if seen[discoveryTopic] || unavailable[door.account] {
+			continue
+		}
+		m.logger.Info("Removing disabled access control that is no longer in the account", "account", door.account, "placeID", door.placeID, "accessControlID", door.accessControl.ID)
+		m.removeDoorLock(door.account, door.accessControl, door.placeID)
+		delete(m.disabledDoors, discoveryTopic)
+	}
+
+	m.saveRegistry()
+	m.indexDoors()
+	m.republishStates()
+
+	m.summary = DiscoverySummary{Published: len(m.discovered), Failed: failed, Removed: removed, At: time.Now()}
+	if m.AvailabilityJSON && int(m.discoveredCount.Swap(int32(len(m.discovered)))) != len(m.discovered) {
+		if err := m.publishOnline(); err != nil {
+			m.logger.Error("Failed to publish online status", "error", err)
+		}
 	}
+	m.logger.Info(fmt.Sprintf("%d of %d entities discovered, %d failed, %d removed", discovered, discovered+failed, failed, removed))
+}
 
+// syncAccountDoorLocks publishes the door locks of the account places and marks their discovery topics as seen.
+func (m *MqttIntegration) syncAccountDoorLocks(mqttAccount mqttAccount, placesResponse models.PlacesResponse, republish bool, seen map[string]bool) (discovered, failed int) {
+	account := mqttAccount.name
 	for _, data := range placesResponse.Data {
 		m.logger.Info("Discovering doorphone",
+			"account", account,
 			"placeID", data.Place.ID,
+			"address", data.Place.Address,
 			"accessControls (len)", len(data.Place.AccessControls),
-			"accessControls", data.Place.AccessControls,
+			"accessControls", logging.Values(data.Place.AccessControls),
 			"cameras", len(data.Place.Cameras),
 		)
+		if m.LogUnsafe {
+			m.logger.Debug("Discovering doorphone, unmasked place", "placeID", data.Place.ID, "place", fmt.Sprintf("%+v", data.Place))
+		}
 
+		place := placeKey{account: account, placeID: data.Place.ID}
+		addressChanged := m.placeAddresses[place] != data.Place.Address.VisibleAddress
+		m.placeAddresses[place] = data.Place.Address.VisibleAddress
+		if area := suggestedArea(data.Place.Address); area != "" {
+			m.placeAreas[placeKey{account: account, placeID: data.Place.ID}] = area
+		}
 		for _, ac := range data.Place.AccessControls {
-			m.publishDoorLock(ac, data.Place.ID)
+			discoveryTopic := m.Topics.AccountDoorLockTopics(account, ac.ID, data.Place.ID).Discovery
+			if !m.Filter.Allows(ac.ID, ac.Name) {
+				m.logger.Info("Skipping access control excluded by filter", "account", account, "placeID", data.Place.ID, "accessControlID", ac.ID, "name", ac.Name)
+				m.removeDoorLock(account, ac, data.Place.ID)
+				delete(m.discovered, discoveryTopic)
+				delete(m.disabledDoors, discoveryTopic)
+				continue
+			}
+
+			seen[discoveryTopic] = true
+			ac = m.namedDoor(ac)
+			if m.doorDisabled(account, ac.ID, data.Place.ID) {
+				m.syncDisabledDoor(discoveryTopic, discoveredDoorLock{account: account, accessControl: ac, placeID: data.Place.ID}, republish)
+				continue
+			}
+			delete(m.disabledDoors, discoveryTopic)
+			if _, ok := m.discovered[discoveryTopic]; ok && !republish {
+				if addressChanged {
+					m.publishAddressState(account, ac.ID, data.Place.ID)
+				}
+				continue
+			}
+			if err := m.publishDoor(account, ac, data.Place.ID); err != nil {
+				m.logger.Error("Failed to discover door lock", "account", account, "placeID", data.Place.ID, "accessControlID", ac.ID, "error", err)
+				failed++
+				continue
+			}
+			m.discovered[discoveryTopic] = discoveredDoorLock{account: account, accessControl: ac, placeID: data.Place.ID}
+			discovered++
+
+			if m.DoorCameras {
+				if err := m.publishDoorCamera(account, mqttAccount.api, ac, data.Place.ID); err != nil {
+					m.logger.Error("Failed to discover door camera", "account", account, "placeID", data.Place.ID, "accessControlID", ac.ID, "error", err)
+				}
+			}
 		}
 	}
+	return discovered, failed
 }
 
 // MqttDevice represents a Home Assistant device.
@@ -151,113 +930,274 @@ type MqttDevice struct {
 	Name         string   `json:"name"`
 	Model        string   `json:"model"`
 	Manufacturer string   `json:"manufacturer"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+	// ConfigurationURL links the device page to the addon UI.
+	ConfigurationURL string `json:"configuration_url,omitempty"`
+	SuggestedArea    string `json:"suggested_area,omitempty"`
 }
 
 // MqttLock represents the discovery payload for a lock entity.
 type MqttLock struct {
-	Name              string     `json:"name"`
-	UniqueID          string     `json:"unique_id"`
-	CommandTopic      string     `json:"command_topic"`
-	StateTopic        string     `json:"state_topic"`
-	PayloadUnlock     string     `json:"payload_unlock"`
-	PayloadLock       string     `json:"payload_lock"`
-	StateUnlocked     string     `json:"state_unlocked"`
-	StateLocked       string     `json:"state_locked"`
-	Optimistic        bool       `json:"optimistic"`
-	Device            MqttDevice `json:"device"`
-	Icon              string     `json:"icon,omitempty"`
-	EntityPicture     string     `json:"entity_picture,omitempty"`
-	AvailabilityTopic string     `json:"availability_topic"`
-}
-
-func (m *MqttIntegration) publishDoorLock(ac models.AccessControl, placeID int) {
-	deviceID := fmt.Sprintf("domru-door_%d_%d", ac.ID, placeID)
-	entityID := fmt.Sprintf("%s-open", deviceID)
-	discoveryTopic := fmt.Sprintf("homeassistant/lock/%s/config", entityID)
-	commandTopic := fmt.Sprintf("domru/%s/command", entityID)
-	stateTopic := fmt.Sprintf("domru/%s/state", entityID)
+	Name          string `json:"name"`
+	UniqueID      string `json:"unique_id"`
+	CommandTopic  string `json:"command_topic"`
+	StateTopic    string `json:"state_topic"`
+	PayloadUnlock string `json:"payload_unlock"`
+	PayloadLock   string `json:"payload_lock"`
+	// PayloadOpen makes Home Assistant offer lock.open, the door is reported UNLOCKED after it like after UNLOCK.
+	PayloadOpen          string     `json:"payload_open,omitempty"`
+	StateUnlocked        string     `json:"state_unlocked"`
+	StateLocked          string     `json:"state_locked"`
+	StateUnlocking       string     `json:"state_unlocking,omitempty"`
+	Optimistic           bool       `json:"optimistic"`
+	Device               MqttDevice `json:"device"`
+	Icon                 string     `json:"icon,omitempty"`
+	EntityPicture        string     `json:"entity_picture,omitempty"`
+	AvailabilityTopic    string     `json:"availability_topic,omitempty"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+	// Availability and AvailabilityMode replace AvailabilityTopic for entities with several availability topics.
+	Availability     []MqttAvailability `json:"availability,omitempty"`
+	AvailabilityMode string             `json:"availability_mode,omitempty"`
+	// JSONAttributesTopic carries the door identifiers and the outcome of the last open.
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"`
+}
+
+// publishDoorLock publishes the lock discovery config and, only if it was delivered, the initial state.
+func (m *MqttIntegration) publishDoorLock(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	discoveryTopic := topics.Discovery
+	stateTopic := topics.State
 
 	payload := MqttLock{
-		Name:          fmt.Sprintf("Open %s", ac.Name),
-		UniqueID:      entityID,
-		CommandTopic:  commandTopic,
-		StateTopic:    stateTopic,
-		PayloadUnlock: "UNLOCK",
-		PayloadLock:   "LOCK",
-		StateUnlocked: "UNLOCKED",
-		StateLocked:   "LOCKED",
-		Optimistic:    true,
-		Device: MqttDevice{
-			Identifiers:  []string{deviceID},
-			Name:         ac.Name,
-			Model:        "Doorphone",
-			Manufacturer: "Dom.ru",
-		},
-		Icon:              "mdi:door",
-		AvailabilityTopic: "domru_proxy/status",
+		Name:                m.text("Open %s", ac.Name),
+		UniqueID:            topics.EntityID,
+		CommandTopic:        topics.Command,
+		StateTopic:          stateTopic,
+		PayloadUnlock:       "UNLOCK",
+		PayloadLock:         "LOCK",
+		PayloadOpen:         "OPEN",
+		StateUnlocked:       "UNLOCKED",
+		StateLocked:         "LOCKED",
+		Optimistic:          m.Optimistic,
+		Device:              m.doorDevice(account, ac, placeID),
+		Icon:                "mdi:door",
+		Availability:        m.doorAvailability(topics),
+		AvailabilityMode:    "all",
+		JSONAttributesTopic: topics.Attributes,
+	}
+
+	if !m.Optimistic {
+		payload.StateUnlocking = "UNLOCKING"
 	}
 
 	if m.haHost != "" {
-		snapshotURL := constants.GetSnapshotUrl(m.haHost, placeID, ac.ID)
-		payload.EntityPicture = snapshotURL
+		payload.EntityPicture = m.doorSnapshotURL(ac.ID, placeID)
 	}
 
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		m.logger.Error("Failed to marshal lock discovery payload", "error", err)
-		return
+		return fmt.Errorf("marshal lock discovery payload: %w", err)
 	}
 
 	// Publish discovery message
-	token := m.client.Publish(discoveryTopic, 1, true, jsonPayload)
-	token.WaitTimeout(time.Second)
+	if err = m.publishWithRetry(discoveryTopic, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", discoveryTopic, err)
+	}
+	m.logger.Info("Published discovery topic for door lock", "topic", discoveryTopic)
 
-	if token.Error() != nil {
-		m.logger.Error("Failed to publish discovery topic", "error", token.Error())
-	} else {
-		m.logger.Info("Published discovery topic for door lock", "topic", discoveryTopic)
+	m.reconcileLockState(stateTopic)
+	m.updateDoorAttributes(topics.Attributes, func(attributes *doorAttributes) {
+		attributes.PlaceID = placeID
+		attributes.AccessControlID = ac.ID
+		attributes.OperatorID = ac.OperatorID
+		attributes.Address = m.placeAddresses[placeKey{account: account, placeID: placeID}]
+	})
+	return nil
+}
+
+func (m *MqttIntegration) location() *time.Location {
+	if m.Location == nil {
+		return time.Local
+	}
+	return m.Location
+}
+
+func (m *MqttIntegration) publish(topic string, options PublishOptions, payload interface{}) mqtt.Token {
+	return m.client.Publish(topic, options.QoS, options.Retain, payload)
+}
+
+// publishWithRetry publishes the payload and waits for the broker to acknowledge it,
+// retrying PublishAttempts times with a short delay on failure.
+func (m *MqttIntegration) publishWithRetry(topic string, options PublishOptions, payload interface{}) error {
+	attempts, timeout := m.PublishAttempts, m.PublishTimeout
+	if attempts < 1 {
+		attempts = defaultPublishAttempts
 	}
+	if timeout <= 0 {
+		timeout = defaultPublishTimeout
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			m.logger.Warn("Retrying publish", "topic", topic, "attempt", attempt, "error", err)
+			time.Sleep(publishRetryDelay)
+		}
+
+		token := m.publish(topic, options, payload)
+		if !token.WaitTimeout(timeout) {
+			err = errors.New("timed out waiting for the broker")
+			continue
+		}
+		if err = token.Error(); err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrNotAuthorized) {
+			// The ACL of the broker won't change between attempts
+			m.logger.Error("Broker denies publishing, check the ACL of the MQTT user", "topic", topic, "error", err)
+			return err
+		}
+	}
+	return err
+}
 
-	// Set initial state to LOCKED
-	m.client.Publish(stateTopic, 1, true, "LOCKED")
+// removeDoorLock publishes empty retained discovery configs, so Home Assistant removes the door lock, button, doorbell and camera.
+func (m *MqttIntegration) removeDoorLock(account string, ac models.AccessControl, placeID int) {
+	m.removeDoorEntities(m.Topics, account, ac, placeID)
+}
+
+// removeDoorEntities removes the entities of the door published under topics, its enabled switch included.
+func (m *MqttIntegration) removeDoorEntities(topics Topics, account string, ac models.AccessControl, placeID int) {
+	m.unpublishDoor(topics, account, ac, placeID)
+	doorTopics := topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	m.states.forget(doorTopics.EnabledEntityID)
+	m.removeDiscovery(doorTopics.EnabledDiscovery)
+}
+
+// unpublishDoor removes the entities of the door published under topics but its enabled switch.
+func (m *MqttIntegration) unpublishDoor(topics Topics, account string, ac models.AccessControl, placeID int) {
+	doorTopics := topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	cameraTopics := topics.DoorCameraTopics(account, ac.ID, placeID)
+	m.states.forget(doorTopics.EntityID, doorTopics.LastOpenEntityID, doorTopics.OpenedEntityID, doorTopics.AddressEntityID, doorTopics.OpenCountEntityID)
+	for _, discoveryTopic := range []string{
+		doorTopics.Discovery,
+		doorTopics.ButtonDiscovery,
+		doorTopics.EventDiscovery,
+		doorTopics.LastOpenDiscovery,
+		doorTopics.OpenedDiscovery,
+		doorTopics.AddressDiscovery,
+		doorTopics.TriggerDiscovery,
+		doorTopics.OpenCountDiscovery,
+		cameraTopics.Discovery,
+		cameraTopics.RefreshDiscovery,
+	} {
+		m.removeDiscovery(discoveryTopic)
+	}
+}
+
+// removeDiscovery publishes an empty retained discovery config, Home Assistant removes the entity.
+func (m *MqttIntegration) removeDiscovery(discoveryTopic string) {
+	token := m.publish(discoveryTopic, m.DiscoveryPublish, "")
+	token.WaitTimeout(time.Second)
+	if token.Error() != nil {
+		m.logger.Error("Failed to remove discovery topic", "topic", discoveryTopic, "error", token.Error())
+	}
 }
 
 func (m *MqttIntegration) commandHandler(_ mqtt.Client, msg mqtt.Message) {
+	if !m.commands.start() {
+		m.logger.Warn("Stopping, ignoring command", "topic", msg.Topic())
+		return
+	}
+	defer m.commands.done()
+
+	// Every command gets its own correlation ID, so a door open can be traced down to the upstream call
+	ctx := logging.ContextWithRequestID(context.Background(), logging.NewRequestID())
 	topic := msg.Topic()
 	command := string(msg.Payload())
-	m.logger.Info("Received command", "topic", topic, "command", command)
+	m.logger.InfoContext(ctx, "Received command", "topic", topic, "command", command)
 
-	var acID, placeID int
-	_, err := fmt.Sscanf(topic, "domru/domru-door_%d_%d-open/command", &acID, &placeID)
+	door, err := m.commandDoor(topic)
+	if errors.Is(err, errUnknownDoor) {
+		m.logger.WarnContext(ctx, "Received command for an entity that isn't a published door, ignoring it", "topic", topic)
+		return
+	}
 	if err != nil {
-		m.logger.Error("Failed to parse access control ID from topic", "topic", topic, "error", err)
+		m.logger.ErrorContext(ctx, "Failed to parse command topic", "topic", topic, "error", err)
+		return
+	}
+	account, acID, placeID := door.account, door.accessControl.ID, door.placeID
+	api := m.accountAPI(account)
+	if api == nil {
+		m.logger.WarnContext(ctx, "Received command for an unknown account", "topic", topic, "account", account)
 		return
 	}
 
-	stateTopic := fmt.Sprintf("domru/domru-door_%d_%d-open/state", acID, placeID)
+	stateTopic := m.Topics.AccountDoorLockTopics(account, acID, placeID).State
+
+	// Without a lock entity there is no state to report
+	hasLock := m.DoorEntity.lock()
 
 	switch command {
-	case "UNLOCK":
-		m.logger.Info("Opening door", "placeID", placeID, "accessControlID", acID)
-		if err := m.domruAPI.OpenDoor(placeID, acID); err != nil {
-			m.logger.Error("Failed to open door", "error", err)
-			// Optionally publish a failure state or log
+	// OPEN is the lock.open action, UNLOCK is kept for automations made before it
+	case "OPEN", "UNLOCK", "PRESS":
+		switch m.opens.begin(stateTopic, m.OpenDebounce) {
+		case openInFlight:
+			m.logger.InfoContext(ctx, "Door is being opened already, ignoring repeated command", "placeID", placeID, "accessControlID", acID)
+			return
+		case openRecent:
+			m.logger.InfoContext(ctx, "Door was opened just now, acknowledging repeated command", "placeID", placeID, "accessControlID", acID)
+			if !hasLock {
+				return
+			}
+			// The relock delay may be shorter than the debounce, a door already relocked is reported locked again
+			if m.relocks.isPending(stateTopic) {
+				m.publishLockState(stateTopic, "UNLOCKED")
+			} else {
+				m.publishLockState(stateTopic, "LOCKED")
+			}
 			return
 		}
 
-		// Optimistically set state to UNLOCKED, then back to LOCKED after a delay
-		m.client.Publish(stateTopic, 1, true, "UNLOCKED")
-		time.AfterFunc(5*time.Second, func() {
-			m.client.Publish(stateTopic, 1, true, "LOCKED")
+		// A relock of a previous open must not report the door locked while it is opened again
+		m.relocks.cancel(stateTopic)
+		if !m.Optimistic && hasLock {
+			// Home Assistant waits for a confirmed state, show the command is in progress meanwhile
+			m.publishLockState(stateTopic, "UNLOCKING")
+		}
+
+		m.logger.InfoContext(ctx, "Opening door", "placeID", placeID, "accessControlID", acID)
+		err := m.openDoor(account, api.WithContext(ctx), placeID, acID)
+		m.opens.done(stateTopic, err == nil)
+		topics := m.Topics.AccountDoorLockTopics(account, acID, placeID)
+		if err != nil {
+			m.logger.ErrorContext(ctx, "Failed to open door", "error", err)
+			m.publishCommandError(topics.EntityID, command, err)
+			m.updateDoorAttributes(topics.Attributes, func(attributes *doorAttributes) {
+				attributes.LastError = err.Error()
+			})
+			if hasLock {
+				// The door didn't open, confirm it is still locked instead of leaving it "unlocking" or "unlocked"
+				m.publishLockState(stateTopic, "LOCKED")
+			}
+			return
+		}
+
+		m.updateDoorAttributes(topics.Attributes, func(attributes *doorAttributes) {
+			attributes.LastOpened = time.Now().In(m.location()).Format(time.RFC3339)
+			attributes.LastError = ""
+		})
+		if !hasLock {
+			return
+		}
+		// Dom.ru accepted the command, report the door as unlocked, then back to LOCKED after a delay
+		m.publishLockState(stateTopic, "UNLOCKED")
+		m.relocks.schedule(stateTopic, m.relockDelay(), func() {
+			m.publishLockState(stateTopic, "LOCKED")
 		})
 	case "LOCK":
-		// The door locks automatically, so we just confirm the state.
-		m.client.Publish(stateTopic, 1, true, "LOCKED")
+		m.handleLockCommand(ctx, stateTopic, m.Topics.AccountDoorLockTopics(account, acID, placeID).Discovery)
 	default:
-		m.logger.Warn("Received unknown command", "command", command)
+		m.logger.WarnContext(ctx, "Received unknown command", "command", command)
 	}
 }
-
-func (m *MqttIntegration) stateHandler(_ mqtt.Client, msg mqtt.Message) {
-
-}
@@ -0,0 +1,46 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// publishAddressSensor publishes the diagnostic sensor with the address of the place of the door and its state,
+// so dashboards can tell the doors of different buildings apart.
+// It must be called with discoveryMu held.
+func (m *MqttIntegration) publishAddressSensor(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttSensor{
+		Name:                 m.text("Address"),
+		UniqueID:             topics.AddressEntityID,
+		StateTopic:           topics.AddressState,
+		EntityCategory:       "diagnostic",
+		Device:               m.doorDevice(account, ac, placeID),
+		Icon:                 "mdi:map-marker",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal address sensor discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.AddressDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.AddressDiscovery, err)
+	}
+	m.publishAddressState(account, ac.ID, placeID)
+	return nil
+}
+
+// publishAddressState publishes the address of the place as is, it's UTF-8 like the MQTT payloads Home Assistant reads.
+// It must be called with discoveryMu held.
+func (m *MqttIntegration) publishAddressState(account string, acID, placeID int) {
+	address := m.placeAddresses[placeKey{account: account, placeID: placeID}]
+	if address == "" {
+		address = unknownState
+	}
+	topics := m.Topics.AccountDoorLockTopics(account, acID, placeID)
+	m.publishState(topics.AddressEntityID, topics.AddressState, address)
+}
@@ -0,0 +1,34 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestPublishAddressSensor(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	address := "620000, Свердловская обл., г. Екатеринбург, ул. Академика Вонсовского, д. 1" + strings.Repeat(", корпус 2", 10)
+	m.placeAddresses[placeKey{placeID: 345}] = address
+
+	require.NoError(t, m.publishAddressSensor("", models.AccessControl{ID: 12, Name: "Подъезд 1"}, 345))
+
+	topics := Topics{}.DoorLockTopics(12, 345)
+	// The address is published whole and unescaped
+	assert.Equal(t, []string{address}, client.payloads(topics.AddressState))
+	discovery := client.payloads(topics.AddressDiscovery)
+	require.Len(t, discovery, 1)
+	var sensor MqttSensor
+	require.NoError(t, json.Unmarshal([]byte(discovery[0]), &sensor))
+	assert.Equal(t, "diagnostic", sensor.EntityCategory)
+	assert.Equal(t, []string{topics.DeviceID}, sensor.Device.Identifiers)
+}
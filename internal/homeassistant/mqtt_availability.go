@@ -0,0 +1,75 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// availabilityTemplate extracts the state of the structured bridge availability, see AvailabilityJSON.
+const availabilityTemplate = "{{ value_json.state }}"
+
+// bridgeAvailability is the structured payload of the bridge availability topic.
+type bridgeAvailability struct {
+	State      string `json:"state"`
+	Version    string `json:"version,omitempty"`
+	StartedAt  string `json:"started_at,omitempty"`
+	OperatorID int    `json:"operator_id,omitempty"`
+	Entities   int    `json:"entities"`
+}
+
+// availabilityTemplate returns the template entities apply to the bridge availability topic, empty for the plain one.
+func (m *MqttIntegration) availabilityTemplate() string {
+	if m.AvailabilityJSON {
+		return availabilityTemplate
+	}
+	return ""
+}
+
+// bridgeAvailability returns the bridge availability topic of an entity with several availability topics.
+func (m *MqttIntegration) bridgeAvailability() MqttAvailability {
+	return MqttAvailability{Topic: m.Topics.Availability(), ValueTemplate: m.availabilityTemplate()}
+}
+
+// offlinePayload is the last will, the broker publishes it on its own, so it only carries the state.
+func (m *MqttIntegration) offlinePayload() string {
+	if m.AvailabilityJSON {
+		return `{"state":"offline"}`
+	}
+	return "offline"
+}
+
+// onlinePayload describes the running bridge: its version, since when it runs, the operator of the
+// primary account and how many doors it published.
+func (m *MqttIntegration) onlinePayload() []byte {
+	if !m.AvailabilityJSON {
+		return []byte("online")
+	}
+
+	payload := bridgeAvailability{
+		State:    "online",
+		Version:  m.Version,
+		Entities: int(m.discoveredCount.Load()),
+	}
+	if !m.startedAt.IsZero() {
+		payload.StartedAt = m.startedAt.In(m.location()).Format(time.RFC3339)
+	}
+	if m.Credentials != nil {
+		if credentials, err := m.Credentials.LoadCredentials(); err == nil {
+			payload.OperatorID = credentials.OperatorID
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		m.logger.Error("Failed to marshal bridge availability", "error", err)
+		return []byte(`{"state":"online"}`)
+	}
+	return data
+}
+
+// publishOnline publishes the bridge as online.
+func (m *MqttIntegration) publishOnline() error {
+	token := m.publish(m.Topics.Availability(), m.AvailabilityPublish, m.onlinePayload())
+	token.Wait()
+	return token.Error()
+}
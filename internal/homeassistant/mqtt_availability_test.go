@@ -0,0 +1,31 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeAvailabilityPayloads(t *testing.T) {
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.Version = "1.4.0"
+	m.Location = time.UTC
+	m.startedAt = time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	m.discoveredCount.Store(3)
+
+	assert.Equal(t, "online", string(m.onlinePayload()))
+	assert.Equal(t, "offline", m.offlinePayload())
+	assert.Equal(t, MqttAvailability{Topic: m.Topics.Availability()}, m.bridgeAvailability())
+
+	m.AvailabilityJSON = true
+	var online bridgeAvailability
+	require.NoError(t, json.Unmarshal(m.onlinePayload(), &online))
+	assert.Equal(t, bridgeAvailability{State: "online", Version: "1.4.0", StartedAt: "2024-05-01T07:00:00Z", Entities: 3}, online)
+	assert.JSONEq(t, `{"state":"offline"}`, m.offlinePayload())
+	assert.Equal(t, availabilityTemplate, m.bridgeAvailability().ValueTemplate)
+}
@@ -0,0 +1,102 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+)
+
+const balanceCurrency = "RUB"
+
+// MqttSensor represents the discovery payload for a sensor entity.
+type MqttSensor struct {
+	Name                 string     `json:"name"`
+	UniqueID             string     `json:"unique_id"`
+	StateTopic           string     `json:"state_topic"`
+	JSONAttributesTopic  string     `json:"json_attributes_topic,omitempty"`
+	DeviceClass          string     `json:"device_class,omitempty"`
+	StateClass           string     `json:"state_class,omitempty"`
+	UnitOfMeasurement    string     `json:"unit_of_measurement,omitempty"`
+	EntityCategory       string     `json:"entity_category,omitempty"`
+	Device               MqttDevice `json:"device"`
+	Icon                 string     `json:"icon,omitempty"`
+	AvailabilityTopic    string     `json:"availability_topic"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+}
+
+// balanceAttributes are published alongside the balance sensor state.
+type balanceAttributes struct {
+	NextPaymentDate string  `json:"next_payment_date,omitempty"`
+	AmountToPay     float64 `json:"amount_to_pay"`
+	DaysToBlock     *int    `json:"days_to_block,omitempty"`
+	Blocked         bool    `json:"blocked"`
+	BlockType       string  `json:"block_type,omitempty"`
+	PaymentLink     string  `json:"payment_link,omitempty"`
+}
+
+// publishBalance publishes a balance sensor for every account, i.e. every agreement, that has a balance.
+func (m *MqttIntegration) publishBalance() {
+	for _, account := range m.allAccounts() {
+		m.publishAccountBalance(account)
+	}
+}
+
+func (m *MqttIntegration) publishAccountBalance(account mqttAccount) {
+	finances, err := account.api.RequestBalance()
+	if errors.Is(err, domru.ErrBalanceUnavailable) {
+		m.logger.Debug("Account has no balance, skipping balance sensor", "account", account.name)
+		return
+	}
+	if err != nil {
+		m.logger.Error("Failed to get balance", "account", account.name, "error", err)
+		return
+	}
+
+	deviceName := m.text("Dom.ru account")
+	if account.name != "" {
+		deviceName = m.text("Dom.ru account %s", account.name)
+	}
+	topics := m.Topics.AccountBalanceTopics(account.name)
+	payload := MqttSensor{
+		Name:                 m.text("Balance"),
+		UniqueID:             topics.EntityID,
+		StateTopic:           topics.State,
+		JSONAttributesTopic:  topics.Attributes,
+		DeviceClass:          "monetary",
+		StateClass:           "total",
+		UnitOfMeasurement:    balanceCurrency,
+		Device:               m.device([]string{topics.DeviceID}, deviceName, m.text("Account")),
+		Icon:                 "mdi:cash",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		m.logger.Error("Failed to marshal balance discovery payload", "error", err)
+		return
+	}
+
+	if err = m.publishWithRetry(topics.Discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		m.logger.Error("Failed to publish balance discovery topic", "account", account.name, "error", err)
+		return
+	}
+
+	attributes, err := json.Marshal(balanceAttributes{
+		NextPaymentDate: finances.TargetDate,
+		AmountToPay:     finances.AmountSum,
+		DaysToBlock:     finances.DaysToBlock,
+		Blocked:         finances.Blocked,
+		BlockType:       finances.BlockType,
+		PaymentLink:     finances.PaymentLink,
+	})
+	if err != nil {
+		m.logger.Error("Failed to marshal balance attributes", "error", err)
+		return
+	}
+
+	m.publish(topics.State, m.StatePublish, fmt.Sprintf("%.2f", *finances.Balance))
+	m.publish(topics.Attributes, m.StatePublish, attributes)
+}
@@ -0,0 +1,63 @@
+package homeassistant
+
+import (
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultBirthTopic is the topic Home Assistant announces its start on with the default discovery prefix.
+const DefaultBirthTopic = DefaultDiscoveryPrefix + "/status"
+
+// birthDebounce is the minimum time between re-discoveries triggered by birth messages,
+// so a flapping Home Assistant doesn't hammer the Dom.ru places API.
+const birthDebounce = time.Minute
+
+// birthGuard lets through one re-discovery per window.
+type birthGuard struct {
+	mu     sync.Mutex
+	last   time.Time
+	window time.Duration
+}
+
+// allow reports whether a re-discovery may run at now and, if so, starts a new window.
+func (g *birthGuard) allow(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.last.IsZero() && now.Sub(g.last) < g.window {
+		return false
+	}
+	g.last = now
+	return true
+}
+
+// birthHandler re-publishes everything when Home Assistant comes online, it forgets
+// the entities that were not retained, i.e. with discovery retain off, on restart.
+func (m *MqttIntegration) birthHandler(_ mqtt.Client, msg mqtt.Message) {
+	if string(msg.Payload()) != "online" {
+		return
+	}
+	if !m.birth.allow(time.Now()) {
+		m.logger.Debug("Ignoring repeated Home Assistant birth message", "topic", msg.Topic())
+		return
+	}
+
+	m.logger.Info("Home Assistant is online, re-publishing discovery", "topic", msg.Topic())
+	go m.republish()
+}
+
+// republish publishes the availability, the discovery configs and states of all entities again.
+func (m *MqttIntegration) republish() {
+	if err := m.publishOnline(); err != nil {
+		m.logger.Error("Failed to publish online status", "error", err)
+	}
+	m.syncDevices(true)
+	m.publishRelockDelay()
+	if m.BalanceInterval > 0 {
+		m.publishBalance()
+	}
+	if m.TokenStatus != nil && m.DiagnosticsInterval > 0 {
+		m.publishDiagnostics()
+	}
+}
@@ -0,0 +1,17 @@
+package homeassistant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBirthGuard(t *testing.T) {
+	guard := birthGuard{window: time.Minute}
+	now := time.Now()
+
+	assert.True(t, guard.allow(now))
+	assert.False(t, guard.allow(now.Add(10*time.Second)))
+	assert.True(t, guard.allow(now.Add(time.Minute)))
+}
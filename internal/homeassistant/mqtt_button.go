@@ -0,0 +1,136 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// DoorEntityType selects the entities a door is published as.
+type DoorEntityType string
+
+const (
+	// DoorEntityLock publishes an optimistic lock, unlocking it opens the door.
+	DoorEntityLock DoorEntityType = "lock"
+	// DoorEntityButton publishes a button, pressing it opens the door.
+	DoorEntityButton DoorEntityType = "button"
+	// DoorEntityBoth publishes both the lock and the button.
+	DoorEntityBoth DoorEntityType = "both"
+)
+
+// ParseDoorEntityType parses the door entity type, i.e. from a flag. Empty is DoorEntityLock.
+func ParseDoorEntityType(value string) (DoorEntityType, error) {
+	switch entityType := DoorEntityType(value); entityType {
+	case "":
+		return DoorEntityLock, nil
+	case DoorEntityLock, DoorEntityButton, DoorEntityBoth:
+		return entityType, nil
+	default:
+		return "", fmt.Errorf("invalid door entity type %q, expected lock, button or both", value)
+	}
+}
+
+func (t DoorEntityType) lock() bool {
+	return t != DoorEntityButton
+}
+
+func (t DoorEntityType) button() bool {
+	return t == DoorEntityButton || t == DoorEntityBoth
+}
+
+// MqttButton represents the discovery payload for a button entity.
+type MqttButton struct {
+	Name                 string     `json:"name"`
+	UniqueID             string     `json:"unique_id"`
+	CommandTopic         string     `json:"command_topic"`
+	PayloadPress         string     `json:"payload_press"`
+	Device               MqttDevice `json:"device"`
+	Icon                 string     `json:"icon,omitempty"`
+	AvailabilityTopic    string     `json:"availability_topic,omitempty"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+	// Availability and AvailabilityMode replace AvailabilityTopic for entities with several availability topics.
+	Availability     []MqttAvailability `json:"availability,omitempty"`
+	AvailabilityMode string             `json:"availability_mode,omitempty"`
+	// JSONAttributesTopic carries the door attributes, or the snapshot attributes of the refresh button.
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"`
+}
+
+// publishDoor publishes the door as the entities of DoorEntity and removes the discovery configs
+// of the other type, so switching the type doesn't leave stale entities behind.
+func (m *MqttIntegration) publishDoor(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	m.publishPlaceAvailability(account, placeID)
+
+	if m.DoorEntity.lock() {
+		if err := m.publishDoorLock(account, ac, placeID); err != nil {
+			return err
+		}
+	} else {
+		m.publish(topics.Discovery, m.DiscoveryPublish, "")
+	}
+
+	if m.DoorEntity.button() {
+		if err := m.publishDoorButton(account, ac, placeID); err != nil {
+			return err
+		}
+	} else {
+		m.publish(topics.ButtonDiscovery, m.DiscoveryPublish, "")
+	}
+
+	if err := m.publishLastOpenSensor(account, ac, placeID); err != nil {
+		m.logger.Error("Failed to discover last open sensor", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+	}
+	if err := m.publishAddressSensor(account, ac, placeID); err != nil {
+		m.logger.Error("Failed to discover address sensor", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+	}
+	if err := m.publishOpenedSensor(account, ac, placeID); err != nil {
+		m.logger.Error("Failed to discover door opened sensor", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+	}
+	if err := m.publishOpenCountSensor(account, ac, placeID); err != nil {
+		m.logger.Error("Failed to discover open count sensor", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+	}
+	if err := m.publishEnabledSwitch(account, ac, placeID, true); err != nil {
+		m.logger.Error("Failed to discover door enabled switch", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+	}
+
+	if m.hasEvents(account) {
+		if err := m.publishDoorbell(account, ac, placeID); err != nil {
+			m.logger.Error("Failed to discover doorbell", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+		}
+		if err := m.publishOpenedTrigger(account, ac, placeID); err != nil {
+			m.logger.Error("Failed to discover door opened trigger", "placeID", placeID, "accessControlID", ac.ID, "error", err)
+		}
+	} else {
+		m.publish(topics.EventDiscovery, m.DiscoveryPublish, "")
+		m.publish(topics.TriggerDiscovery, m.DiscoveryPublish, "")
+	}
+	return nil
+}
+
+// publishDoorButton publishes the button discovery config. The button shares the command topic of the lock.
+func (m *MqttIntegration) publishDoorButton(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttButton{
+		Name:             m.text("Open %s", ac.Name),
+		UniqueID:         topics.EntityID,
+		CommandTopic:     topics.Command,
+		PayloadPress:     "PRESS",
+		Device:           m.doorDevice(account, ac, placeID),
+		Icon:             "mdi:door-open",
+		Availability:     m.doorAvailability(topics),
+		AvailabilityMode: "all",
+
+		JSONAttributesTopic: topics.Attributes,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal button discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.ButtonDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.ButtonDiscovery, err)
+	}
+	m.logger.Info("Published discovery topic for door button", "topic", topics.ButtonDiscovery)
+	return nil
+}
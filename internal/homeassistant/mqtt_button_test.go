@@ -0,0 +1,34 @@
+package homeassistant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDoorEntityType(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantLock   bool
+		wantButton bool
+		wantErr    bool
+	}{
+		{value: "", wantLock: true},
+		{value: "lock", wantLock: true},
+		{value: "button", wantButton: true},
+		{value: "both", wantLock: true, wantButton: true},
+		{value: "switch", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			entityType, err := ParseDoorEntityType(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLock, entityType.lock())
+			assert.Equal(t, tt.wantButton, entityType.button())
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+const (
+	callAnswer = "ANSWER"
+	callReject = "REJECT"
+	// callTimeout is how long a call can be answered after the doorbell rang, the intercom hangs up by then.
+	callTimeout = time.Minute
+)
+
+// incomingCall is the last call of a place until it is answered, rejected or timed out.
+type incomingCall struct {
+	door discoveredDoorLock
+	at   time.Time
+}
+
+// callTracker holds the incoming calls of the places of the primary account.
+type callTracker struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	calls map[int]incomingCall
+}
+
+func newCallTracker(now func() time.Time) *callTracker {
+	return &callTracker{now: now, calls: make(map[int]incomingCall)}
+}
+
+// ring records a call of the door, replacing an earlier call of its place.
+func (c *callTracker) ring(door discoveredDoorLock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[door.placeID] = incomingCall{door: door, at: c.now()}
+}
+
+// take removes the call of the place and returns its door, unless there is none or it timed out.
+func (c *callTracker) take(placeID int) (discoveredDoorLock, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call, ok := c.calls[placeID]
+	delete(c.calls, placeID)
+	if !ok || c.now().Sub(call.at) > callTimeout {
+		return discoveredDoorLock{}, false
+	}
+	return call.door, true
+}
+
+// syncCallButtons publishes the call buttons of the places of the primary account that have a SIP account,
+// and removes the buttons of the places that are gone. It must be called with discoveryMu held.
+func (m *MqttIntegration) syncCallButtons(placesResponse models.PlacesResponse, republish bool) {
+	places := make(map[int]bool, len(placesResponse.Data))
+	for _, data := range placesResponse.Data {
+		placeID := data.Place.ID
+		places[placeID] = true
+		if m.callPlaces[placeID] && !republish {
+			continue
+		}
+		if !m.callPlaces[placeID] {
+			if len(data.Place.AccessControls) == 0 {
+				continue
+			}
+			// Places without a SIP account don't get calls the app could answer
+			sipDevice, err := m.domruAPI.RequestSipDevice(placeID, data.Place.AccessControls[0].ID)
+			if err != nil {
+				m.logger.Warn("Failed to get SIP account, skipping call buttons", "placeID", placeID, "error", err)
+				continue
+			}
+			m.logger.Debug("Found SIP account of the place", "placeID", placeID, "sip", sipDevice)
+		}
+		if err := m.publishCallButtons(placeID); err != nil {
+			m.logger.Error("Failed to discover call buttons", "placeID", placeID, "error", err)
+			continue
+		}
+		m.callPlaces[placeID] = true
+	}
+
+	for placeID := range m.callPlaces {
+		if !places[placeID] {
+			m.removeCallButtons(placeID)
+		}
+	}
+}
+
+// publishCallButtons publishes the buttons answering and rejecting the calls of the place.
+func (m *MqttIntegration) publishCallButtons(placeID int) error {
+	topics := m.Topics.PlaceCallTopics(placeID)
+	device := m.device([]string{topics.DeviceID}, m.text("Intercom"), m.text("Intercom calls"))
+	device.SuggestedArea = m.placeAreas[placeKey{placeID: placeID}]
+
+	for _, button := range []struct {
+		discovery string
+		payload   MqttButton
+	}{
+		{topics.AnswerDiscovery, MqttButton{Name: m.text("Answer & open"), UniqueID: topics.AnswerEntityID, PayloadPress: callAnswer, Icon: "mdi:phone-check"}},
+		{topics.RejectDiscovery, MqttButton{Name: m.text("Reject call"), UniqueID: topics.RejectEntityID, PayloadPress: callReject, Icon: "mdi:phone-hangup"}},
+	} {
+		button.payload.CommandTopic = topics.Command
+		button.payload.Device = device
+		button.payload.AvailabilityTopic = m.Topics.Availability()
+		button.payload.AvailabilityTemplate = m.availabilityTemplate()
+
+		jsonPayload, err := json.Marshal(button.payload)
+		if err != nil {
+			return fmt.Errorf("marshal call button discovery payload: %w", err)
+		}
+		if err = m.publishWithRetry(button.discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+			return fmt.Errorf("publish discovery topic %s: %w", button.discovery, err)
+		}
+	}
+	m.logger.Info("Published discovery topics for call buttons", "placeID", placeID)
+	return nil
+}
+
+// removeCallButtons removes the call buttons of the place. It must be called with discoveryMu held.
+func (m *MqttIntegration) removeCallButtons(placeID int) {
+	topics := m.Topics.PlaceCallTopics(placeID)
+	m.publish(topics.AnswerDiscovery, m.DiscoveryPublish, "")
+	m.publish(topics.RejectDiscovery, m.DiscoveryPublish, "")
+	delete(m.callPlaces, placeID)
+}
+
+// callHandler answers the last call of the place by opening the calling door, or rejects it.
+func (m *MqttIntegration) callHandler(_ mqtt.Client, msg mqtt.Message) {
+	placeID, err := m.Topics.parsePlaceCallTopic(msg.Topic())
+	if err != nil {
+		m.logger.Error("Failed to parse place ID from topic", "topic", msg.Topic(), "error", err)
+		return
+	}
+
+	switch command := string(msg.Payload()); command {
+	case callAnswer:
+		door, ok := m.calls.take(placeID)
+		if !ok {
+			m.logger.Warn("No incoming call to answer", "placeID", placeID)
+			return
+		}
+		m.logger.Info("Answering call, opening door", "placeID", placeID, "accessControlID", door.accessControl.ID)
+		// The door command opens it like the lock does, with its debounce, state and errors
+		commandTopic := m.Topics.AccountDoorLockTopics(door.account, door.accessControl.ID, door.placeID).Command
+		m.publish(commandTopic, PublishOptions{QoS: 1}, "PRESS")
+	case callReject:
+		if _, ok := m.calls.take(placeID); ok {
+			m.logger.Info("Rejected call", "placeID", placeID)
+		}
+	default:
+		m.logger.Warn("Received unknown call command", "placeID", placeID, "command", command)
+	}
+}
@@ -0,0 +1,54 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestCallHandler(t *testing.T) {
+	now := time.Now()
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	m.calls = newCallTracker(func() time.Time { return now })
+	door := discoveredDoorLock{accessControl: models.AccessControl{ID: 12}, placeID: 345}
+	callTopic := m.Topics.PlaceCallTopics(345).Command
+	doorCommand := m.Topics.DoorLockTopics(12, 345).Command
+
+	// Without a call there is nothing to answer
+	m.callHandler(nil, fakeMessage{topic: callTopic, payload: callAnswer})
+	assert.Empty(t, client.payloads(doorCommand))
+
+	m.calls.ring(door)
+	m.callHandler(nil, fakeMessage{topic: callTopic, payload: callAnswer})
+	assert.Equal(t, []string{"PRESS"}, client.payloads(doorCommand))
+
+	// A rejected call can't be answered anymore
+	m.calls.ring(door)
+	m.callHandler(nil, fakeMessage{topic: callTopic, payload: callReject})
+	m.callHandler(nil, fakeMessage{topic: callTopic, payload: callAnswer})
+	assert.Len(t, client.payloads(doorCommand), 1)
+
+	// Neither can a call the intercom hung up already
+	m.calls.ring(door)
+	now = now.Add(callTimeout + time.Second)
+	m.callHandler(nil, fakeMessage{topic: callTopic, payload: callAnswer})
+	assert.Len(t, client.payloads(doorCommand), 1)
+}
+
+func TestParsePlaceCallTopic(t *testing.T) {
+	topics := Topics{Prefix: "building2"}
+
+	placeID, err := topics.parsePlaceCallTopic(topics.PlaceCallTopics(345).Command)
+	assert.NoError(t, err)
+	assert.Equal(t, 345, placeID)
+
+	_, err = topics.parsePlaceCallTopic(topics.DoorLockTopics(12, 345).Command)
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// snapshotCacheTTL lets a burst of update commands reuse one snapshot.
+const snapshotCacheTTL = 3 * time.Second
+
+// MqttCamera represents the discovery payload for a camera entity fed with images over MQTT.
+type MqttCamera struct {
+	Name                 string     `json:"name"`
+	UniqueID             string     `json:"unique_id"`
+	Topic                string     `json:"topic"`
+	Device               MqttDevice `json:"device"`
+	AvailabilityTopic    string     `json:"availability_topic,omitempty"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+	// Availability and AvailabilityMode replace AvailabilityTopic for entities with several availability topics.
+	Availability     []MqttAvailability `json:"availability,omitempty"`
+	AvailabilityMode string             `json:"availability_mode,omitempty"`
+	// JSONAttributesTopic carries the time of the last snapshot and the last error of door cameras,
+	// the stream sources of camera entities.
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"`
+}
+
+// snapshotAttributes are the attributes of a door camera, the outcome of its last snapshot refresh.
+type snapshotAttributes struct {
+	LastSnapshot string `json:"last_snapshot,omitempty"`
+	LastError    string `json:"last_error"`
+}
+
+// snapshotCache keeps the latest snapshot of every door for a short time
+// and collapses concurrent fetches of the same door into one upstream request.
+type snapshotCache struct {
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]cachedSnapshot
+}
+
+type cachedSnapshot struct {
+	image     []byte
+	fetchedAt time.Time
+}
+
+func (c *snapshotCache) get(key string, fetch func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < snapshotCacheTTL {
+		return entry.image, nil
+	}
+
+	image, err, _ := c.group.Do(key, func() (interface{}, error) {
+		image, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.entries == nil {
+			c.entries = make(map[string]cachedSnapshot)
+		}
+		c.entries[key] = cachedSnapshot{image: image, fetchedAt: time.Now()}
+		return image, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return image.([]byte), nil
+}
+
+// fetchedAt returns when the cached snapshot was fetched, zero if there is none.
+func (c *snapshotCache) fetchedAt(key string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key].fetchedAt
+}
+
+// publishDoorCamera publishes the camera entity of the door and its current snapshot.
+func (m *MqttIntegration) publishDoorCamera(account string, api *domru.APIWrapper, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.DoorCameraTopics(account, ac.ID, placeID)
+	payload := MqttCamera{
+		Name:                 m.text("%s snapshot", ac.Name),
+		UniqueID:             topics.EntityID,
+		Topic:                topics.Image,
+		Device:               m.doorDevice(account, ac, placeID),
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+
+		JSONAttributesTopic: topics.Attributes,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal camera discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.Discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.Discovery, err)
+	}
+	if err = m.publishSnapshotRefreshButton(account, ac, placeID); err != nil {
+		return err
+	}
+
+	go m.updateSnapshot(account, api, ac.ID, placeID)
+	return nil
+}
+
+// publishSnapshotRefreshButton publishes the button discovery config refreshing the door snapshot.
+// Dashboards cache the entity pictures, pressing the button publishes a fresh snapshot to the camera.
+func (m *MqttIntegration) publishSnapshotRefreshButton(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.DoorCameraTopics(account, ac.ID, placeID)
+	payload := MqttButton{
+		Name:                 m.text("Refresh %s snapshot", ac.Name),
+		UniqueID:             topics.EntityID + "-refresh",
+		CommandTopic:         topics.Update,
+		PayloadPress:         "PRESS",
+		Device:               m.doorDevice(account, ac, placeID),
+		Icon:                 "mdi:camera-retake",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+
+		JSONAttributesTopic: topics.Attributes,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal refresh button discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.RefreshDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.RefreshDiscovery, err)
+	}
+	return nil
+}
+
+// publishSnapshotAttributes publishes the outcome of a snapshot refresh on the attributes topic of the door camera.
+func (m *MqttIntegration) publishSnapshotAttributes(topic string, attributes snapshotAttributes) {
+	payload, err := json.Marshal(attributes)
+	if err != nil {
+		m.logger.Error("Failed to marshal snapshot attributes", "error", err)
+		return
+	}
+	m.publish(topic, m.StatePublish, payload)
+}
+
+// updateSnapshot fetches the door snapshot, through the cache, and publishes it to the camera image topic.
+func (m *MqttIntegration) updateSnapshot(account string, api *domru.APIWrapper, acID, placeID int) {
+	topics := m.Topics.DoorCameraTopics(account, acID, placeID)
+	image, err := m.snapshots.get(topics.EntityID, func() ([]byte, error) {
+		if cameraID, ok := m.doorCamera(acID); ok {
+			return api.GetCameraSnapshot(cameraID)
+		}
+		return api.GetSnapshot(strconv.Itoa(placeID), strconv.Itoa(acID))
+	})
+	if err != nil {
+		m.logger.Error("Failed to get snapshot", "account", account, "placeID", placeID, "accessControlID", acID, "error", err)
+		m.publishSnapshotAttributes(topics.Attributes, snapshotAttributes{
+			LastSnapshot: m.snapshotTime(topics.EntityID),
+			LastError:    err.Error(),
+		})
+		return
+	}
+
+	token := m.publish(topics.Image, m.StatePublish, image)
+	token.Wait()
+	if token.Error() != nil {
+		m.logger.Error("Failed to publish snapshot", "topic", topics.Image, "error", token.Error())
+		return
+	}
+	m.publishSnapshotAttributes(topics.Attributes, snapshotAttributes{LastSnapshot: m.snapshotTime(topics.EntityID)})
+}
+
+// snapshotTime formats when the cached snapshot of the camera was fetched, empty if it never was.
+func (m *MqttIntegration) snapshotTime(entityID string) string {
+	fetchedAt := m.snapshots.fetchedAt(entityID)
+	if fetchedAt.IsZero() {
+		return ""
+	}
+	return fetchedAt.In(m.location()).Format(time.RFC3339)
+}
+
+// cameraUpdateHandler publishes a fresh snapshot of the door camera on any payload.
+func (m *MqttIntegration) cameraUpdateHandler(_ mqtt.Client, msg mqtt.Message) {
+	account, acID, placeID, err := m.Topics.parseCameraUpdateTopic(msg.Topic())
+	if err != nil {
+		m.logger.Debug("Ignoring update of an unknown entity", "topic", msg.Topic())
+		return
+	}
+	api := m.accountAPI(account)
+	if api == nil {
+		m.logger.Warn("Received update for an unknown account", "topic", msg.Topic(), "account", account)
+		return
+	}
+
+	m.logger.Debug("Updating snapshot", "account", account, "placeID", placeID, "accessControlID", acID)
+	go m.updateSnapshot(account, api, acID, placeID)
+}
@@ -0,0 +1,61 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestSnapshotCache_CollapsesBurst(t *testing.T) {
+	var cache snapshotCache
+	var fetches atomic.Int32
+	fetch := func() ([]byte, error) {
+		fetches.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return []byte("jpeg"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			image, err := cache.get("door", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("jpeg"), image)
+		}()
+	}
+	wg.Wait()
+
+	// A command right after the burst is served from the cache as well
+	_, err := cache.get("door", fetch)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestPublishSnapshotRefreshButton(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+
+	require.NoError(t, m.publishSnapshotRefreshButton("", models.AccessControl{ID: 12, Name: "Entrance"}, 345))
+
+	topics := Topics{}.DoorCameraTopics("", 12, 345)
+	payloads := client.payloads(topics.RefreshDiscovery)
+	require.Len(t, payloads, 1)
+	var button MqttButton
+	require.NoError(t, json.Unmarshal([]byte(payloads[0]), &button))
+	// The button feeds the update topic the camera entity already listens to
+	assert.Equal(t, topics.Update, button.CommandTopic)
+	assert.Equal(t, topics.Attributes, button.JSONAttributesTopic)
+	assert.Equal(t, "Refresh Entrance snapshot", button.Name)
+}
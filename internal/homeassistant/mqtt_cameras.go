@@ -0,0 +1,244 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// MqttAvailability is one of the availability topics of an entity.
+type MqttAvailability struct {
+	Topic         string `json:"topic"`
+	ValueTemplate string `json:"value_template,omitempty"`
+}
+
+// publishedCamera is a camera published as a camera entity. door is the access control
+// the camera belongs to, nil for cameras without one.
+type publishedCamera struct {
+	camera models.Camera
+	door   *discoveredDoorLock
+}
+
+// syncCameras publishes a camera entity for every camera of the primary account and removes the ones
+// of vanished cameras. It must be called with discoveryMu held, after the door locks are synced.
+func (m *MqttIntegration) syncCameras(republish bool) {
+	cameras, err := m.domruAPI.RequestCameras()
+	if err != nil {
+		m.logger.Error("Failed to get cameras for camera entities", "error", err)
+		return
+	}
+
+	doors := m.cameraDoors()
+	m.camerasMu.Lock()
+	previous := m.cameras
+	m.camerasMu.Unlock()
+
+	current := make(map[int]*publishedCamera, len(cameras.Data))
+	for _, camera := range cameras.Data {
+		published := &publishedCamera{camera: camera}
+		if door, ok := doors[camera.ID]; ok {
+			published.door = &door
+		}
+		if before, ok := previous[camera.ID]; ok && !republish && sameDoor(before.door, published.door) {
+			current[camera.ID] = before
+			continue
+		}
+		if err = m.publishCamera(published); err != nil {
+			m.logger.Error("Failed to discover camera", "cameraID", camera.ID, "error", err)
+			continue
+		}
+		current[camera.ID] = published
+		go m.refreshCamera(published)
+	}
+
+	for cameraID := range previous {
+		if _, ok := current[cameraID]; ok {
+			continue
+		}
+		m.logger.Info("Removing camera that is no longer in the account", "cameraID", cameraID)
+		m.publish(m.Topics.PlaceCameraTopics(cameraID).Discovery, m.DiscoveryPublish, "")
+	}
+
+	m.camerasMu.Lock()
+	defer m.camerasMu.Unlock()
+	m.cameras = current
+	for cameraID := range m.camerasDown {
+		if _, ok := current[cameraID]; !ok {
+			delete(m.camerasDown, cameraID)
+		}
+	}
+}
+
+// cameraDoors returns the published door locks of the primary account by the ID of their camera.
+// It must be called with discoveryMu held.
+func (m *MqttIntegration) cameraDoors() map[int]discoveredDoorLock {
+	doors := make(map[int]discoveredDoorLock)
+	for _, door := range m.discovered {
+		if door.account != "" {
+			continue
+		}
+		if cameraID := cast.ToInt(door.accessControl.ExternalCameraId); cameraID != 0 {
+			doors[cameraID] = door
+		}
+	}
+	return doors
+}
+
+func sameDoor(a, b *discoveredDoorLock) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.accessControl.ID == b.accessControl.ID && a.placeID == b.placeID
+}
+
+// publishCamera publishes the camera entity. A camera of an access control joins the device of its
+// door lock, so Home Assistant shows both on one device card.
+func (m *MqttIntegration) publishCamera(published *publishedCamera) error {
+	topics := m.Topics.PlaceCameraTopics(published.camera.ID)
+	device := m.device([]string{topics.DeviceID}, published.camera.Name, m.text("Camera"))
+	if door := published.door; door != nil {
+		device.Identifiers = append(device.Identifiers, m.Topics.AccountDoorLockTopics(door.account, door.accessControl.ID, door.placeID).DeviceID)
+		device.SuggestedArea = m.placeAreas[placeKey{account: door.account, placeID: door.placeID}]
+	}
+
+	payload := MqttCamera{
+		Name:     m.text("Snapshot"),
+		UniqueID: topics.EntityID,
+		Topic:    topics.Image,
+		Device:   device,
+		// The camera is only available while both the addon and its snapshots are
+		Availability:        []MqttAvailability{m.bridgeAvailability(), {Topic: topics.CameraAvailability}},
+		AvailabilityMode:    "all",
+		JSONAttributesTopic: topics.Attributes,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal camera discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.Discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.Discovery, err)
+	}
+	m.publishStreamAttributes(published.camera)
+	return nil
+}
+
+// streamAttributes are the attributes of a camera entity telling where to play its live video from.
+type streamAttributes struct {
+	// StreamURL is the stream endpoint of the addon, it redirects to or proxies a fresh Dom.ru stream.
+	StreamURL string `json:"stream_url,omitempty"`
+	// StreamSource is the RTSP or HLS source Dom.ru returned on discovery, it expires after a while.
+	StreamSource string `json:"stream_source,omitempty"`
+}
+
+// publishStreamAttributes publishes the stream sources of the camera, for go2rtc or the generic camera.
+// Without an external URL the addon can't tell its own address, and only the Dom.ru source is published.
+func (m *MqttIntegration) publishStreamAttributes(camera models.Camera) {
+	var attributes streamAttributes
+	if m.haHost != "" {
+		attributes.StreamURL = m.URLTemplates.StreamURL(camera.Model, m.haHost, camera.ID)
+	}
+	source, err := m.domruAPI.GetStreamURL(strconv.Itoa(camera.ID), nil)
+	if err != nil {
+		m.logger.Debug("No stream source of the camera", "cameraID", camera.ID, "error", err)
+	} else if isStreamSource(source) {
+		attributes.StreamSource = source
+	}
+
+	payload, err := json.Marshal(attributes)
+	if err != nil {
+		m.logger.Error("Failed to marshal camera stream attributes", "cameraID", camera.ID, "error", err)
+		return
+	}
+	m.publish(m.Topics.PlaceCameraTopics(camera.ID).Attributes, m.StatePublish, payload)
+}
+
+// isStreamSource reports whether the URL is an RTSP or HLS stream players can open directly.
+func isStreamSource(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "rtsp" || u.Scheme == "rtsps" || strings.HasSuffix(u.Path, ".m3u8")
+}
+
+// refreshCameras publishes a fresh snapshot of every camera entity.
+func (m *MqttIntegration) refreshCameras() {
+	m.camerasMu.Lock()
+	cameras := make([]*publishedCamera, 0, len(m.cameras))
+	for _, published := range m.cameras {
+		cameras = append(cameras, published)
+	}
+	m.camerasMu.Unlock()
+
+	for _, published := range cameras {
+		m.refreshCamera(published)
+	}
+}
+
+// refreshCamera publishes the current snapshot of the camera. A camera failing to return one is marked
+// unavailable once, and available again with its next snapshot, instead of logging every failure.
+func (m *MqttIntegration) refreshCamera(published *publishedCamera) {
+	cameraID := published.camera.ID
+	topics := m.Topics.PlaceCameraTopics(cameraID)
+	image, err := m.snapshots.get(topics.EntityID, func() ([]byte, error) {
+		if door := published.door; door != nil {
+			return m.domruAPI.GetSnapshot(strconv.Itoa(door.placeID), strconv.Itoa(door.accessControl.ID))
+		}
+		return m.domruAPI.GetCameraSnapshot(cameraID)
+	})
+	if err != nil {
+		if m.setCameraDown(cameraID, true) {
+			m.logger.Warn("Camera snapshot unavailable, marking the camera unavailable", "cameraID", cameraID, "error", err)
+			m.publish(topics.CameraAvailability, m.StatePublish, "offline")
+		} else {
+			m.logger.Debug("Camera snapshot still unavailable", "cameraID", cameraID, "error", err)
+		}
+		return
+	}
+
+	if m.setCameraDown(cameraID, false) {
+		m.logger.Info("Camera snapshot available again", "cameraID", cameraID)
+	}
+	m.publish(topics.CameraAvailability, m.StatePublish, "online")
+	token := m.publish(topics.Image, m.StatePublish, image)
+	token.Wait()
+	if token.Error() != nil {
+		m.logger.Error("Failed to publish snapshot", "topic", topics.Image, "error", token.Error())
+	}
+}
+
+// setCameraDown records whether the camera fails to return snapshots and reports whether that changed.
+func (m *MqttIntegration) setCameraDown(cameraID int, down bool) bool {
+	m.camerasMu.Lock()
+	defer m.camerasMu.Unlock()
+	if m.camerasDown[cameraID] == down {
+		return false
+	}
+	if m.camerasDown == nil {
+		m.camerasDown = make(map[int]bool)
+	}
+	if down {
+		m.camerasDown[cameraID] = true
+	} else {
+		delete(m.camerasDown, cameraID)
+	}
+	return true
+}
+
+// removeCameras publishes empty retained discovery configs of every camera entity.
+func (m *MqttIntegration) removeCameras() {
+	m.camerasMu.Lock()
+	defer m.camerasMu.Unlock()
+	for cameraID := range m.cameras {
+		m.publish(m.Topics.PlaceCameraTopics(cameraID).Discovery, m.DiscoveryPublish, "")
+	}
+	m.cameras = nil
+	m.camerasDown = nil
+}
@@ -0,0 +1,63 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestCameraDoors(t *testing.T) {
+	m := &MqttIntegration{discovered: map[string]discoveredDoorLock{
+		"entrance": {accessControl: models.AccessControl{ID: 1, ExternalCameraId: "77"}, placeID: 10},
+		"gate":     {accessControl: models.AccessControl{ID: 2}, placeID: 10},
+		"other":    {account: "operator_2", accessControl: models.AccessControl{ID: 3, ExternalCameraId: 78}, placeID: 20},
+	}}
+
+	doors := m.cameraDoors()
+	assert.Len(t, doors, 1, "only cameras of primary account doors are matched")
+	assert.Equal(t, 1, doors[77].accessControl.ID)
+}
+
+func TestSetCameraDown(t *testing.T) {
+	m := &MqttIntegration{}
+	assert.False(t, m.setCameraDown(1, false), "cameras start available")
+	assert.True(t, m.setCameraDown(1, true))
+	assert.False(t, m.setCameraDown(1, true), "repeated failures are no change")
+	assert.True(t, m.setCameraDown(1, false))
+}
+
+func TestPublishStreamAttributes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/v1/forpost/cameras/5/video":
+			_, _ = io.WriteString(w, `{"data": {"URL": "https://stream.example.com/hls/5/index.m3u8?token=abc"}}`)
+		case "/rest/v1/forpost/cameras/6/video":
+			_, _ = io.WriteString(w, `{"data": {"URL": "https://stream.example.com/player/6"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	api := domru.NewDomruAPI(server.Client(), server.URL)
+	api.Logger = logger
+	client := &fakeClient{}
+	m := NewMqttIntegration(api, logger, BrokerSettings{}, "https://ha.example.com/")
+	m.client = client
+
+	m.publishStreamAttributes(models.Camera{ID: 5})
+	assert.Equal(t, []string{`{"stream_url":"https://ha.example.com/stream/5","stream_source":"https://stream.example.com/hls/5/index.m3u8?token=abc"}`},
+		client.payloads(m.Topics.PlaceCameraTopics(5).Attributes))
+
+	// A web player isn't a source players can open
+	m.publishStreamAttributes(models.Camera{ID: 6})
+	assert.Equal(t, []string{`{"stream_url":"https://ha.example.com/stream/6"}`}, client.payloads(m.Topics.PlaceCameraTopics(6).Attributes))
+}
@@ -0,0 +1,68 @@
+package homeassistant
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultOpenDebounce is how long a door open absorbs repeated UNLOCK commands of the same door.
+const defaultOpenDebounce = 3 * time.Second
+
+// openDebouncer drops repeated opens of a door, i.e. Home Assistant retrying a command or a double tap
+// on the lock card, which Dom.ru sometimes answers with an error.
+type openDebouncer struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	doors map[string]*debouncedOpen
+}
+
+type debouncedOpen struct {
+	inFlight bool
+	openedAt time.Time
+}
+
+func newOpenDebouncer(now func() time.Time) *openDebouncer {
+	return &openDebouncer{now: now, doors: make(map[string]*debouncedOpen)}
+}
+
+// openResult tells how a repeated open is absorbed.
+type openResult int
+
+const (
+	// openAllowed means the door is opened, done must be called once the open finished.
+	openAllowed openResult = iota
+	// openInFlight means another open of the door is running and reports the outcome of both.
+	openInFlight
+	// openRecent means the door was opened within the window, the open is acknowledged as is.
+	openRecent
+)
+
+// begin checks whether the door may be opened now and marks it as being opened if so.
+func (d *openDebouncer) begin(door string, window time.Duration) openResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.doors[door]
+	switch {
+	case ok && state.inFlight:
+		return openInFlight
+	case ok && window > 0 && d.now().Sub(state.openedAt) < window:
+		return openRecent
+	}
+	d.doors[door] = &debouncedOpen{inFlight: true}
+	return openAllowed
+}
+
+// done finishes the open of the door begun by begin. Only successful opens absorb the following ones,
+// a failed open may be retried right away.
+func (d *openDebouncer) done(door string, success bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !success {
+		delete(d.doors, door)
+		return
+	}
+	d.doors[door] = &debouncedOpen{openedAt: d.now()}
+}
@@ -0,0 +1,52 @@
+package homeassistant
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenDebouncer(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	d := newOpenDebouncer(func() time.Time { return now })
+	const door = "domru/domru-door_12_345-open/state"
+
+	assert.Equal(t, openAllowed, d.begin(door, 3*time.Second))
+	assert.Equal(t, openInFlight, d.begin(door, 3*time.Second))
+	assert.Equal(t, openAllowed, d.begin("domru/domru-door_13_345-open/state", 3*time.Second))
+	d.done(door, true)
+
+	now = now.Add(time.Second)
+	assert.Equal(t, openRecent, d.begin(door, 3*time.Second))
+	now = now.Add(3 * time.Second)
+	assert.Equal(t, openAllowed, d.begin(door, 3*time.Second))
+
+	// A failed open may be retried right away
+	d.done(door, false)
+	assert.Equal(t, openAllowed, d.begin(door, 3*time.Second))
+}
+
+func TestOpenDebouncerConcurrentCommands(t *testing.T) {
+	d := newOpenDebouncer(time.Now)
+	const door = "domru/domru-door_12_345-open/state"
+
+	var opened atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d.begin(door, time.Minute) == openAllowed {
+				opened.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				d.done(door, true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), opened.Load())
+}
@@ -0,0 +1,117 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/090809/homeassistant-domru/pkg/tokenmanagement"
+)
+
+// unknownState makes Home Assistant show a sensor as unknown.
+const unknownState = "None"
+
+// lastErrorSensor is the key of the sensor that tells when the error happened in its attributes.
+const lastErrorSensor = "last_api_error"
+
+// TokenStatusSource reports the health of the Dom.ru session, i.e. tokenmanagement.ValidTokenProvider.
+type TokenStatusSource interface {
+	Status() tokenmanagement.Status
+}
+
+// diagnosticSensor is a diagnostic entity of the bridge device and how its state is derived from the session status.
+type diagnosticSensor struct {
+	name        string
+	key         string
+	deviceClass string
+	icon        string
+	state       func(status tokenmanagement.Status, location *time.Location) string
+}
+
+var diagnosticSensors = []diagnosticSensor{
+	{
+		name:        "Access token expiry",
+		key:         "token_expiry",
+		deviceClass: "timestamp",
+		icon:        "mdi:key-chain",
+		state: func(status tokenmanagement.Status, location *time.Location) string {
+			return timestampState(status.AccessTokenExpiry, location)
+		},
+	},
+	{
+		name:        "Last token refresh",
+		key:         "last_token_refresh",
+		deviceClass: "timestamp",
+		icon:        "mdi:refresh",
+		state: func(status tokenmanagement.Status, location *time.Location) string {
+			return timestampState(status.LastRefresh, location)
+		},
+	},
+	{
+		name: "Last API error",
+		key:  lastErrorSensor,
+		icon: "mdi:alert-circle-outline",
+		state: func(status tokenmanagement.Status, _ *time.Location) string {
+			if status.LastError == "" {
+				return unknownState
+			}
+			return status.LastError
+		},
+	},
+}
+
+// lastErrorAttributes tell when the last API error happened.
+type lastErrorAttributes struct {
+	At string `json:"at,omitempty"`
+}
+
+func timestampState(t time.Time, location *time.Location) string {
+	if t.IsZero() {
+		return unknownState
+	}
+	return t.In(location).Format(time.RFC3339)
+}
+
+// publishDiagnostics publishes the session health as diagnostic sensors of the bridge device.
+func (m *MqttIntegration) publishDiagnostics() {
+	status := m.TokenStatus.Status()
+
+	for _, sensor := range diagnosticSensors {
+		topics := m.Topics.DiagnosticTopics(sensor.key)
+		payload := MqttSensor{
+			Name:                 m.text(sensor.name),
+			UniqueID:             topics.EntityID,
+			StateTopic:           topics.State,
+			DeviceClass:          sensor.deviceClass,
+			EntityCategory:       "diagnostic",
+			Device:               m.device([]string{topics.DeviceID}, "Dom.ru proxy", m.text("Addon")),
+			Icon:                 sensor.icon,
+			AvailabilityTopic:    topics.Availability,
+			AvailabilityTemplate: m.availabilityTemplate(),
+		}
+		if sensor.key == lastErrorSensor {
+			payload.JSONAttributesTopic = topics.Attributes
+		}
+
+		jsonPayload, err := json.Marshal(payload)
+		if err != nil {
+			m.logger.Error("Failed to marshal diagnostic sensor discovery payload", "sensor", sensor.key, "error", err)
+			continue
+		}
+		if err = m.publishWithRetry(topics.Discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+			m.logger.Error("Failed to publish diagnostic sensor discovery topic", "sensor", sensor.key, "error", err)
+			continue
+		}
+		m.publish(topics.State, m.StatePublish, sensor.state(status, m.location()))
+	}
+
+	var lastError lastErrorAttributes
+	if !status.LastErrorAt.IsZero() {
+		lastError.At = status.LastErrorAt.In(m.location()).Format(time.RFC3339)
+	}
+	attributes, err := json.Marshal(lastError)
+	if err != nil {
+		m.logger.Error("Failed to marshal last API error attributes", "error", err)
+		return
+	}
+	m.publish(m.Topics.DiagnosticTopics(lastErrorSensor).Attributes, m.StatePublish, attributes)
+}
@@ -0,0 +1,60 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// doorAttributes are the attributes of a door lock: the door identifiers, for templates,
+// and the outcome of its last open.
+type doorAttributes struct {
+	PlaceID         int    `json:"place_id,omitempty"`
+	AccessControlID int    `json:"access_control_id,omitempty"`
+	Address         string `json:"address,omitempty"`
+	OperatorID      int    `json:"operator_id,omitempty"`
+	LastOpened      string `json:"last_opened,omitempty"`
+	LastError       string `json:"last_error"`
+}
+
+// updateDoorAttributes applies update to the attributes of the door lock and publishes the whole document,
+// so an open doesn't drop the identifiers published by discovery and vice versa.
+func (m *MqttIntegration) updateDoorAttributes(topic string, update func(attributes *doorAttributes)) {
+	m.doorAttributesMu.Lock()
+	attributes := m.doorAttributes[topic]
+	update(&attributes)
+	m.doorAttributes[topic] = attributes
+	m.doorAttributesMu.Unlock()
+
+	payload, err := json.Marshal(attributes)
+	if err != nil {
+		m.logger.Error("Failed to marshal door attributes", "error", err)
+		return
+	}
+	m.publish(topic, m.StatePublish, payload)
+}
+
+// doorAttributesHandler restores the outcome of the last open from the attributes retained before a restart.
+// Attributes already known, i.e. of an open since, take precedence.
+func (m *MqttIntegration) doorAttributesHandler(_ mqtt.Client, msg mqtt.Message) {
+	// The attributes subscription covers the sensors and cameras as well
+	if !msg.Retained() || !strings.HasSuffix(msg.Topic(), "-open/attributes") {
+		return
+	}
+	var retained doorAttributes
+	if err := json.Unmarshal(msg.Payload(), &retained); err != nil {
+		m.logger.Debug("Ignoring attributes that aren't door attributes", "topic", msg.Topic(), "error", err)
+		return
+	}
+
+	m.doorAttributesMu.Lock()
+	defer m.doorAttributesMu.Unlock()
+	attributes, ok := m.doorAttributes[msg.Topic()]
+	if ok && (attributes.LastOpened != "" || attributes.LastError != "") {
+		return
+	}
+	attributes.LastOpened = retained.LastOpened
+	attributes.LastError = retained.LastError
+	m.doorAttributes[msg.Topic()] = attributes
+}
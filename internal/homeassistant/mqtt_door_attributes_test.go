@@ -0,0 +1,45 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoorAttributesMarshal(t *testing.T) {
+	payload, err := json.Marshal(doorAttributes{PlaceID: 345, AccessControlID: 12, Address: "Lenina 1", OperatorID: 2})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"place_id":345,"access_control_id":12,"address":"Lenina 1","operator_id":2,"last_error":""}`, string(payload))
+}
+
+func TestUpdateDoorAttributes(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	topic := Topics{}.DoorLockTopics(12, 345).Attributes
+
+	// Retained before a restart
+	m.doorAttributesHandler(nil, fakeMessage{topic: topic, payload: `{"place_id":345,"last_opened":"2024-05-01T10:00:00+03:00","last_error":""}`, retained: true})
+	m.updateDoorAttributes(topic, func(attributes *doorAttributes) {
+		attributes.PlaceID = 345
+		attributes.AccessControlID = 12
+	})
+	m.updateDoorAttributes(topic, func(attributes *doorAttributes) {
+		attributes.LastError = "door is offline"
+	})
+
+	payloads := client.payloads(topic)
+	require.Len(t, payloads, 2)
+	var attributes doorAttributes
+	require.NoError(t, json.Unmarshal([]byte(payloads[1]), &attributes))
+	assert.Equal(t, doorAttributes{
+		PlaceID:         345,
+		AccessControlID: 12,
+		LastOpened:      "2024-05-01T10:00:00+03:00",
+		LastError:       "door is offline",
+	}, attributes)
+}
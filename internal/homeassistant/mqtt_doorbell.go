@@ -0,0 +1,108 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// doorbellEventType is the event type of an incoming intercom call.
+const doorbellEventType = "ring"
+
+// MqttEvent represents the discovery payload for an event entity.
+type MqttEvent struct {
+	Name             string             `json:"name"`
+	UniqueID         string             `json:"unique_id"`
+	StateTopic       string             `json:"state_topic"`
+	EventTypes       []string           `json:"event_types"`
+	DeviceClass      string             `json:"device_class,omitempty"`
+	Device           MqttDevice         `json:"device"`
+	Icon             string             `json:"icon,omitempty"`
+	Availability     []MqttAvailability `json:"availability"`
+	AvailabilityMode string             `json:"availability_mode,omitempty"`
+}
+
+// doorbellEvent is published on the doorbell topic for every call, the time is in the configured timezone.
+type doorbellEvent struct {
+	EventType string `json:"event_type"`
+	Time      string `json:"time,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// publishDoorbell publishes the event entity reporting the calls of the door.
+func (m *MqttIntegration) publishDoorbell(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttEvent{
+		Name:             m.text("Doorbell"),
+		UniqueID:         topics.EventEntityID,
+		StateTopic:       topics.Event,
+		EventTypes:       []string{doorbellEventType},
+		DeviceClass:      "doorbell",
+		Device:           m.doorDevice(account, ac, placeID),
+		Icon:             "mdi:doorbell",
+		Availability:     m.doorAvailability(topics),
+		AvailabilityMode: "all",
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal event discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.EventDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.EventDiscovery, err)
+	}
+	m.logger.Info("Published discovery topic for doorbell", "topic", topics.EventDiscovery)
+	return nil
+}
+
+// ring publishes a doorbell event for the door of the account the call event came from.
+func (m *MqttIntegration) ring(account string, event models.Event) {
+	door, ok := m.eventDoor(account, event)
+	if !ok {
+		m.logger.Debug("Call event of an unknown access control", "placeID", event.PlaceID, "source", event.Source, "eventID", event.ID)
+		return
+	}
+
+	payload := doorbellEvent{EventType: doorbellEventType, Message: event.Message}
+	if at, err := event.Time(); err == nil {
+		payload.Time = at.In(m.location()).Format(time.RFC3339)
+	}
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		m.logger.Error("Failed to marshal doorbell event", "error", err)
+		return
+	}
+
+	m.logger.Info("Doorbell rings", "placeID", door.placeID, "accessControlID", door.accessControl.ID, "eventID", event.ID)
+	// The call buttons answer calls of the primary account only
+	if m.CallButtons && door.account == "" {
+		m.calls.ring(door)
+	}
+	// Not retained, a replayed ring would fire automations after a Home Assistant restart
+	topics := m.Topics.AccountDoorLockTopics(door.account, door.accessControl.ID, door.placeID)
+	m.publish(topics.Event, PublishOptions{QoS: m.StatePublish.QoS}, jsonPayload)
+}
+
+// eventDoor finds the published door of the account a call or open event came from. Events without
+// an access control are assigned to the door of their place, if it is the only one.
+func (m *MqttIntegration) eventDoor(account string, event models.Event) (discoveredDoorLock, bool) {
+	m.discoveryMu.Lock()
+	defer m.discoveryMu.Unlock()
+
+	var placeDoors []discoveredDoorLock
+	for _, door := range m.discovered {
+		if door.account != account || door.placeID != event.PlaceID {
+			continue
+		}
+		if event.Source.ID != 0 && door.accessControl.ID == event.Source.ID {
+			return door, true
+		}
+		placeDoors = append(placeDoors, door)
+	}
+	if event.Source.ID == 0 && len(placeDoors) == 1 {
+		return placeDoors[0], true
+	}
+	return discoveredDoorLock{}, false
+}
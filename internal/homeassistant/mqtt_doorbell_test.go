@@ -0,0 +1,45 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestEventDoor(t *testing.T) {
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	entrance := discoveredDoorLock{accessControl: models.AccessControl{ID: 1}, placeID: 10}
+	gate := discoveredDoorLock{accessControl: models.AccessControl{ID: 2}, placeID: 10}
+	single := discoveredDoorLock{accessControl: models.AccessControl{ID: 3}, placeID: 20}
+	m.discovered["entrance"] = entrance
+	m.discovered["gate"] = gate
+	m.discovered["single"] = single
+	m.discovered["other account"] = discoveredDoorLock{account: "op2", accessControl: models.AccessControl{ID: 4}, placeID: 30}
+
+	tests := []struct {
+		name    string
+		account string
+		event   models.Event
+		want    discoveredDoorLock
+		wantOK  bool
+	}{
+		{name: "By access control", event: models.Event{PlaceID: 10, Source: models.Source{ID: 2}}, want: gate, wantOK: true},
+		{name: "Only door of the place", event: models.Event{PlaceID: 20}, want: single, wantOK: true},
+		{name: "Ambiguous place", event: models.Event{PlaceID: 10}},
+		{name: "Unknown access control", event: models.Event{PlaceID: 20, Source: models.Source{ID: 5}}},
+		{name: "Door of another account", event: models.Event{PlaceID: 30, Source: models.Source{ID: 4}}},
+		{name: "Additional account", account: "op2", event: models.Event{PlaceID: 30, Source: models.Source{ID: 4}}, want: m.discovered["other account"], wantOK: true},
+		{name: "Primary door for an additional account", account: "op2", event: models.Event{PlaceID: 10, Source: models.Source{ID: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			door, ok := m.eventDoor(tt.account, tt.event)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, door)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package homeassistant
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// dryRunToken is the completed token of an operation the dry run client only logged.
+type dryRunToken struct{}
+
+func (dryRunToken) Wait() bool                     { return true }
+func (dryRunToken) WaitTimeout(time.Duration) bool { return true }
+func (dryRunToken) Error() error                   { return nil }
+
+func (dryRunToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// dryRunClient logs the publishes and subscriptions of the integration instead of sending them to a broker,
+// or writes the publishes to out if set. It never receives messages, so no command reaches the handlers.
+type dryRunClient struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	out    io.Writer
+}
+
+func (c *dryRunClient) IsConnected() bool   { return true }
+func (c *dryRunClient) Connect() mqtt.Token { return dryRunToken{} }
+func (c *dryRunClient) Disconnect(uint)     {}
+
+func (c *dryRunClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	case []byte:
+		data = p
+	}
+
+	text := string(data)
+	if !utf8.Valid(data) {
+		// Snapshots are images
+		text = fmt.Sprintf("<%d bytes>", len(data))
+	}
+	if c.out == nil {
+		c.logger.Info("Dry run, not publishing", "topic", topic, "qos", qos, "retained", retained, "payload", text)
+		return dryRunToken{}
+	}
+
+	var indented bytes.Buffer
+	if json.Indent(&indented, data, "", "  ") == nil {
+		text = indented.String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, "%s (qos %d, retained %t)\n%s\n\n", topic, qos, retained, text)
+	return dryRunToken{}
+}
+
+func (c *dryRunClient) Subscribe(topic string, qos byte, _ mqtt.MessageHandler) mqtt.Token {
+	c.logger.Info("Dry run, not subscribing", "topic", topic, "qos", qos)
+	return dryRunToken{}
+}
+
+func (c *dryRunClient) Unsubscribe(...string) mqtt.Token { return dryRunToken{} }
+
+// startDryRun runs the discovery against a dry run client, so everything it would publish is logged.
+// The files of a real run are left alone.
+func (m *MqttIntegration) startDryRun() {
+	m.logger.Warn("MQTT dry run, discovery is logged instead of published and no commands are received")
+	m.RegistryFile, m.SettingsFile, m.OpenCountsFile = "", "", ""
+	m.haHost = resolveHAHost(m.haHost, GetHomeAssistantNetworkAddressWithPort, m.logger)
+	m.startedAt = time.Now()
+
+	m.discoveryMu.Lock()
+	m.client = &dryRunClient{logger: m.logger}
+	m.discoveryMu.Unlock()
+	m.connectHandler(nil)
+}
+
+// PrintDiscovery requests the places once and writes every message the discovery would publish to w,
+// without connecting to the broker. It fails if the places can't be requested.
+func (m *MqttIntegration) PrintDiscovery(w io.Writer) error {
+	if _, err := m.domruAPI.RequestPlaces(); err != nil {
+		return err
+	}
+
+	m.DryRun = true
+	m.RegistryFile, m.SettingsFile, m.OpenCountsFile = "", "", ""
+	m.discoveryMu.Lock()
+	m.client = &dryRunClient{logger: m.logger, out: w}
+	m.discoveryMu.Unlock()
+	m.syncDevices(true)
+	return nil
+}
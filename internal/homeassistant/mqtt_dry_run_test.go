@@ -0,0 +1,32 @@
+package homeassistant
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestDryRunClient(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = &dryRunClient{logger: m.logger, out: &out}
+
+	require.NoError(t, m.publishDoorLock("", models.AccessControl{ID: 12, Name: "Entrance"}, 345))
+	topics := m.Topics.DoorLockTopics(12, 345)
+	assert.Contains(t, out.String(), topics.Discovery+" (qos 1, retained true)\n{\n  ")
+	assert.Contains(t, out.String(), `"command_topic": "`+topics.Command+`"`)
+
+	// Subscribing registers no handler, commands never arrive
+	token := m.client.Subscribe(topics.Command, 1, func(mqtt.Client, mqtt.Message) {
+		t.Fatal("dry run delivered a command")
+	})
+	assert.True(t, token.Wait())
+	assert.NoError(t, token.Error())
+}
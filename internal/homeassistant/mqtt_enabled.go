@@ -0,0 +1,141 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// MqttSwitch represents the discovery payload for a switch entity.
+type MqttSwitch struct {
+	Name                 string     `json:"name"`
+	UniqueID             string     `json:"unique_id"`
+	CommandTopic         string     `json:"command_topic"`
+	StateTopic           string     `json:"state_topic"`
+	PayloadOn            string     `json:"payload_on"`
+	PayloadOff           string     `json:"payload_off"`
+	EntityCategory       string     `json:"entity_category,omitempty"`
+	Device               MqttDevice `json:"device"`
+	Icon                 string     `json:"icon,omitempty"`
+	AvailabilityTopic    string     `json:"availability_topic"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+}
+
+// doorSettingKey identifies the door in the settings, it doesn't depend on the topic prefix.
+func doorSettingKey(account string, acID, placeID int) string {
+	if account != "" {
+		return fmt.Sprintf("%s-door_%d_%d", account, acID, placeID)
+	}
+	return fmt.Sprintf("door_%d_%d", acID, placeID)
+}
+
+// doorDisabled reports whether the door was disabled with its enabled switch.
+func (m *MqttIntegration) doorDisabled(account string, acID, placeID int) bool {
+	m.settingsMu.Lock()
+	defer m.settingsMu.Unlock()
+	return m.disabled[doorSettingKey(account, acID, placeID)]
+}
+
+// publishEnabledSwitch publishes the config switch of the door and whether the door is enabled.
+func (m *MqttIntegration) publishEnabledSwitch(account string, ac models.AccessControl, placeID int, enabled bool) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttSwitch{
+		Name:                 m.text("Publish %s", ac.Name),
+		UniqueID:             topics.EnabledEntityID,
+		CommandTopic:         topics.EnabledCommand,
+		StateTopic:           topics.EnabledState,
+		PayloadOn:            "ON",
+		PayloadOff:           "OFF",
+		EntityCategory:       "config",
+		Device:               m.doorDevice(account, ac, placeID),
+		Icon:                 "mdi:eye",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal door enabled switch discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.EnabledDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.EnabledDiscovery, err)
+	}
+	state := "ON"
+	if !enabled {
+		state = "OFF"
+	}
+	m.publishState(topics.EnabledEntityID, topics.EnabledState, state)
+	return nil
+}
+
+// syncDisabledDoor removes the entities of the disabled door but its enabled switch, which is published
+// unless it already is. It must be called with discoveryMu held.
+func (m *MqttIntegration) syncDisabledDoor(discoveryTopic string, door discoveredDoorLock, republish bool) {
+	if _, ok := m.discovered[discoveryTopic]; ok {
+		m.logger.Info("Door disabled, removing its entities", "account", door.account, "placeID", door.placeID, "accessControlID", door.accessControl.ID)
+		m.unpublishDoor(m.Topics, door.account, door.accessControl, door.placeID)
+		delete(m.discovered, discoveryTopic)
+	}
+	if _, ok := m.disabledDoors[discoveryTopic]; ok && !republish {
+		return
+	}
+	if err := m.publishEnabledSwitch(door.account, door.accessControl, door.placeID, false); err != nil {
+		m.logger.Error("Failed to discover door enabled switch", "placeID", door.placeID, "accessControlID", door.accessControl.ID, "error", err)
+		return
+	}
+	m.disabledDoors[discoveryTopic] = door
+}
+
+// doorEnabledHandler enables or disables the door of the switch, persists the choice and runs the discovery,
+// which publishes or removes the entities of the door.
+func (m *MqttIntegration) doorEnabledHandler(_ mqtt.Client, msg mqtt.Message) {
+	// The subscription covers the settings of the bridge as well
+	account, acID, placeID, err := m.Topics.parseDoorTopic(msg.Topic(), "enabled", "set")
+	if err != nil {
+		return
+	}
+
+	var enabled bool
+	switch command := string(msg.Payload()); command {
+	case "ON":
+		enabled = true
+	case "OFF":
+	default:
+		m.logger.Warn("Received unknown door enabled command", "topic", msg.Topic(), "command", command)
+		return
+	}
+
+	key := doorSettingKey(account, acID, placeID)
+	m.settingsMu.Lock()
+	changed := m.disabled[key] == enabled
+	if enabled {
+		delete(m.disabled, key)
+	} else {
+		m.disabled[key] = true
+	}
+	m.settingsMu.Unlock()
+
+	topics := m.Topics.AccountDoorLockTopics(account, acID, placeID)
+	m.publishState(topics.EnabledEntityID, topics.EnabledState, string(msg.Payload()))
+	if !changed {
+		return
+	}
+	m.logger.Info("Door enabled changed", "account", account, "placeID", placeID, "accessControlID", acID, "enabled", enabled)
+	m.saveSettings()
+	go m.syncDevices(false)
+}
+
+// disabledDoorKeys returns the keys of the disabled doors in order, for the settings file.
+// It must be called with settingsMu held.
+func (m *MqttIntegration) disabledDoorKeys() []string {
+	keys := make([]string, 0, len(m.disabled))
+	for key := range m.disabled {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,47 @@
+package homeassistant
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoorEnabledSwitch(t *testing.T) {
+	var opens atomic.Int32
+	m, client, _ := newDoorIntegration(t, &opens)
+	m.SettingsFile = filepath.Join(t.TempDir(), "settings.json")
+	topics := m.Topics.DoorLockTopics(12, 345)
+	published := func() bool { return m.DiscoverySummary().Published == 1 }
+
+	m.connectHandler(nil)
+	require.Eventually(t, published, time.Second, 10*time.Millisecond)
+	assert.Contains(t, client.payloads(topics.EnabledState), "ON")
+
+	client.Publish(topics.EnabledCommand, 1, false, "OFF")
+	require.Eventually(t, func() bool { return !published() }, time.Second, 10*time.Millisecond)
+	configs := client.payloads(topics.Discovery)
+	assert.Empty(t, configs[len(configs)-1], "the lock is removed")
+	switches := client.payloads(topics.EnabledDiscovery)
+	assert.NotEmpty(t, switches[len(switches)-1], "the switch stays")
+
+	// Commands of the disabled door are ignored
+	client.Publish(topics.Command, 1, false, "OPEN")
+	assert.Zero(t, opens.Load())
+
+	// The door stays disabled after a restart
+	restarted, _, _ := newDoorIntegration(t, &opens)
+	restarted.SettingsFile = m.SettingsFile
+	restarted.loadSettings()
+	assert.True(t, restarted.doorDisabled("", 12, 345))
+
+	client.Publish(topics.EnabledCommand, 1, false, "ON")
+	require.Eventually(t, published, time.Second, 10*time.Millisecond)
+	configs = client.payloads(topics.Discovery)
+	assert.NotEmpty(t, configs[len(configs)-1])
+	client.Publish(topics.Command, 1, false, "OPEN")
+	assert.Equal(t, int32(1), opens.Load())
+}
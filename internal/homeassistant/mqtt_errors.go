@@ -0,0 +1,31 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CommandError is published to the errors topic when an entity command fails, i.e. to notify
+// about a door that didn't open.
+type CommandError struct {
+	EntityID  string `json:"entity_id"`
+	Command   string `json:"command"`
+	Error     string `json:"error"`
+	Timestamp string `json:"timestamp"`
+}
+
+// publishCommandError publishes the failure of the command of the entity to the errors topic. It's not retained,
+// an automation must not be triggered by an old failure after a restart.
+func (m *MqttIntegration) publishCommandError(entityID, command string, err error) {
+	payload, marshalErr := json.Marshal(CommandError{
+		EntityID:  entityID,
+		Command:   command,
+		Error:     err.Error(),
+		Timestamp: time.Now().In(m.location()).Format(time.RFC3339),
+	})
+	if marshalErr != nil {
+		m.logger.Error("Failed to marshal command error", "error", marshalErr)
+		return
+	}
+	m.publish(m.Topics.Errors(), PublishOptions{QoS: m.StatePublish.QoS}, payload)
+}
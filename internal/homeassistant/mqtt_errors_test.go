@@ -0,0 +1,30 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishCommandError(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+
+	m.publishCommandError("domru-door_12_345-open", "UNLOCK", errors.New("door is offline"))
+
+	require.Len(t, client.published, 1)
+	assert.Equal(t, "domru_proxy/errors", client.published[0].topic)
+	assert.False(t, client.published[0].retained)
+	var published CommandError
+	require.NoError(t, json.Unmarshal([]byte(client.published[0].payload), &published))
+	assert.Equal(t, "domru-door_12_345-open", published.EntityID)
+	assert.Equal(t, "UNLOCK", published.Command)
+	assert.Equal(t, "door is offline", published.Error)
+	assert.NotEmpty(t, published.Timestamp)
+}
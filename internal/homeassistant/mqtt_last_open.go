@@ -0,0 +1,144 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// DoorOpenSource tells where a door open came from.
+type DoorOpenSource string
+
+const (
+	// DoorOpenMQTT is an open by a lock or button command or the open topic.
+	DoorOpenMQTT DoorOpenSource = "mqtt"
+	// DoorOpenWeb is an open from the addon web UI.
+	DoorOpenWeb DoorOpenSource = "web"
+	// DoorOpenAPI is an open by a client of the Dom.ru compatible REST API.
+	DoorOpenAPI DoorOpenSource = "api"
+)
+
+// DoorOpen is an attempt to open a door, see RecordDoorOpen.
+type DoorOpen struct {
+	Source DoorOpenSource
+	// Account is the account the door belongs to, empty for the primary one.
+	Account         string
+	PlaceID         int
+	AccessControlID int
+	// Err is why the open failed, nil for a successful one.
+	Err error
+}
+
+// openedPulse is how long the door opened sensor stays on after an open, Home Assistant turns it off.
+const openedPulse = 2 * time.Second
+
+// lastOpenAttributes describe the latest open attempt of a door, the sensor state only changes on success.
+type lastOpenAttributes struct {
+	Source    DoorOpenSource `json:"source"`
+	Result    string         `json:"result"`
+	Error     string         `json:"error,omitempty"`
+	AttemptAt string         `json:"attempt_at"`
+}
+
+// publishLastOpenSensor publishes the sensor with the time of the last successful open of the door.
+func (m *MqttIntegration) publishLastOpenSensor(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttSensor{
+		Name:                 m.text("%s last opened", ac.Name),
+		UniqueID:             topics.LastOpenEntityID,
+		StateTopic:           topics.LastOpenState,
+		JSONAttributesTopic:  topics.LastOpenAttributes,
+		DeviceClass:          "timestamp",
+		Device:               m.doorDevice(account, ac, placeID),
+		Icon:                 "mdi:door-open",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal last open sensor discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.LastOpenDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.LastOpenDiscovery, err)
+	}
+	return nil
+}
+
+// publishOpenedSensor publishes the binary sensor turning on for a moment when the door is opened,
+// for automations reacting to opens whichever way the door was opened.
+func (m *MqttIntegration) publishOpenedSensor(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttBinarySensor{
+		Name:        m.text("%s opened", ac.Name),
+		UniqueID:    topics.OpenedEntityID,
+		StateTopic:  topics.OpenedState,
+		DeviceClass: "door",
+		PayloadOn:   "ON",
+		PayloadOff:  "OFF",
+		// Like motion, the open is a moment, Home Assistant turns the sensor off by itself
+		OffDelay:             int(openedPulse.Seconds()),
+		Device:               m.doorDevice(account, ac, placeID),
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal door opened sensor discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.OpenedDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.OpenedDiscovery, err)
+	}
+	m.states.set(topics.OpenedEntityID, entityState{topic: topics.OpenedState, payload: "OFF"})
+	if err = m.publishWithRetry(topics.OpenedState, m.StatePublish, "OFF"); err != nil {
+		m.logger.Error("Failed to publish initial door opened sensor state", "topic", topics.OpenedState, "error", err)
+	}
+	return nil
+}
+
+// RecordDoorOpen reports an open attempt, from MQTT or the HTTP handlers, on the last open sensor of the door,
+// and counts the open and pulses the opened sensor if the door was opened. It doesn't block, the sensors are updated in the background.
+func (m *MqttIntegration) RecordDoorOpen(open DoorOpen) {
+	now := time.Now()
+	if open.Err == nil {
+		deviceID := m.Topics.AccountDoorLockTopics(open.Account, open.AccessControlID, open.PlaceID).DeviceID
+		m.opened.own(deviceID, now)
+		m.countOpen(deviceID)
+	}
+	go m.publishLastOpen(open, now)
+}
+
+func (m *MqttIntegration) publishLastOpen(open DoorOpen, at time.Time) {
+	// The client is guarded by discoveryMu until connected
+	m.discoveryMu.Lock()
+	client := m.client
+	m.discoveryMu.Unlock()
+	if client == nil || !client.IsConnected() {
+		return
+	}
+
+	topics := m.Topics.AccountDoorLockTopics(open.Account, open.AccessControlID, open.PlaceID)
+	timestamp := at.In(m.location()).Format(time.RFC3339)
+	attributes := lastOpenAttributes{Source: open.Source, Result: "success", AttemptAt: timestamp}
+	if open.Err != nil {
+		attributes.Result = "failure"
+		attributes.Error = open.Err.Error()
+	}
+
+	payload, err := json.Marshal(attributes)
+	if err != nil {
+		m.logger.Error("Failed to marshal last open attributes", "error", err)
+		return
+	}
+	m.publish(topics.LastOpenAttributes, m.StatePublish, payload)
+	if open.Err == nil {
+		m.publishState(topics.LastOpenEntityID, topics.LastOpenState, timestamp)
+		m.publishState(topics.OpenCountEntityID, topics.OpenCountState, strconv.FormatInt(m.openCounts.count(topics.DeviceID), 10))
+		// Not retained, a restarting Home Assistant must not see the door opened again
+		m.publish(topics.OpenedState, PublishOptions{QoS: m.StatePublish.QoS}, "ON")
+	}
+}
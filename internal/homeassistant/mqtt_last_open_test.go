@@ -0,0 +1,31 @@
+package homeassistant
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishLastOpen(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	m.Location = time.UTC
+	topics := Topics{}.DoorLockTopics(12, 345)
+
+	m.publishLastOpen(DoorOpen{Source: DoorOpenWeb, PlaceID: 345, AccessControlID: 12}, time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC))
+	m.publishLastOpen(DoorOpen{Source: DoorOpenMQTT, PlaceID: 345, AccessControlID: 12, Err: errors.New("door is offline")},
+		time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+
+	// A failed open leaves the time of the last successful one
+	assert.Equal(t, []string{"2024-05-01T07:00:00Z"}, client.payloads(topics.LastOpenState))
+	assert.Equal(t, []string{"ON"}, client.payloads(topics.OpenedState))
+	assert.Equal(t, []string{
+		`{"source":"web","result":"success","attempt_at":"2024-05-01T07:00:00Z"}`,
+		`{"source":"mqtt","result":"failure","error":"door is offline","attempt_at":"2024-05-01T08:00:00Z"}`,
+	}, client.payloads(topics.LastOpenAttributes))
+}
@@ -0,0 +1,71 @@
+package homeassistant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// LockCommandMode is how a LOCK command is handled. Dom.ru has no API to close a door,
+// so no mode can actually lock it.
+type LockCommandMode string
+
+const (
+	// LockCommandConfirm reports the door as locked, it locks by itself after opening.
+	LockCommandConfirm LockCommandMode = "confirm"
+	// LockCommandIgnore leaves the state as is, i.e. for gates held open until they close by themselves.
+	LockCommandIgnore LockCommandMode = "ignore"
+	// LockCommandUnsupported reports the lock as jammed, so Home Assistant shows that the command failed.
+	LockCommandUnsupported LockCommandMode = "unsupported"
+)
+
+// DefaultLockCommandType is the LockCommand key applying to access control types without a mode of their own.
+const DefaultLockCommandType = "default"
+
+// ParseLockCommandModes parses "type=mode" entries, i.e. from a flag, into LockCommand modes.
+func ParseLockCommandModes(entries []string) (map[string]LockCommandMode, error) {
+	modes := make(map[string]LockCommandMode, len(entries))
+	for _, entry := range entries {
+		acType, mode, found := strings.Cut(entry, "=")
+		acType = strings.TrimSpace(acType)
+		switch LockCommandMode(strings.TrimSpace(mode)) {
+		case LockCommandConfirm, LockCommandIgnore, LockCommandUnsupported:
+		default:
+			found = false
+		}
+		if !found || acType == "" {
+			return nil, fmt.Errorf("invalid lock command mode %q, expected type=confirm|ignore|unsupported", entry)
+		}
+		modes[acType] = LockCommandMode(strings.TrimSpace(mode))
+	}
+	return modes, nil
+}
+
+// lockCommandMode returns the mode for the access control type.
+func (m *MqttIntegration) lockCommandMode(acType string) LockCommandMode {
+	if mode, ok := m.LockCommand[acType]; ok && acType != "" {
+		return mode
+	}
+	if mode, ok := m.LockCommand[DefaultLockCommandType]; ok {
+		return mode
+	}
+	return LockCommandConfirm
+}
+
+// handleLockCommand answers a LOCK command of the door lock published on discoveryTopic.
+func (m *MqttIntegration) handleLockCommand(ctx context.Context, stateTopic, discoveryTopic string) {
+	m.discoveryMu.Lock()
+	acType := m.discovered[discoveryTopic].accessControl.Type
+	m.discoveryMu.Unlock()
+
+	switch mode := m.lockCommandMode(acType); mode {
+	case LockCommandIgnore:
+		m.logger.InfoContext(ctx, "Ignoring lock command", "type", acType)
+	case LockCommandUnsupported:
+		m.logger.WarnContext(ctx, "Lock command is not supported by the access control", "type", acType)
+		m.publishLockState(stateTopic, "JAMMED")
+	default:
+		// The door locks automatically, so we just confirm the state.
+		m.publishLockState(stateTopic, "LOCKED")
+	}
+}
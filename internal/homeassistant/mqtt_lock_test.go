@@ -0,0 +1,20 @@
+package homeassistant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockCommandMode(t *testing.T) {
+	modes, err := ParseLockCommandModes([]string{"gate=ignore", "default = unsupported"})
+	assert.NoError(t, err)
+
+	m := &MqttIntegration{LockCommand: modes}
+	assert.Equal(t, LockCommandIgnore, m.lockCommandMode("gate"))
+	assert.Equal(t, LockCommandUnsupported, m.lockCommandMode("SIP"))
+	assert.Equal(t, LockCommandConfirm, (&MqttIntegration{}).lockCommandMode("SIP"))
+
+	_, err = ParseLockCommandModes([]string{"gate=close"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// EventSource broadcasts Dom.ru events, e.g. the events poller.
+type EventSource interface {
+	Subscribe() (<-chan models.Event, func())
+}
+
+// MqttBinarySensor represents the discovery payload for a binary sensor entity.
+type MqttBinarySensor struct {
+	Name                 string     `json:"name"`
+	UniqueID             string     `json:"unique_id"`
+	StateTopic           string     `json:"state_topic"`
+	DeviceClass          string     `json:"device_class,omitempty"`
+	PayloadOn            string     `json:"payload_on"`
+	PayloadOff           string     `json:"payload_off"`
+	OffDelay             int        `json:"off_delay,omitempty"`
+	JSONAttributesTopic  string     `json:"json_attributes_topic,omitempty"`
+	Device               MqttDevice `json:"device"`
+	AvailabilityTopic    string     `json:"availability_topic"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+}
+
+// motionAttributes are published with every motion, the time is in the configured timezone.
+type motionAttributes struct {
+	LastMotion string `json:"last_motion"`
+	Message    string `json:"message,omitempty"`
+}
+
+// syncMotionSensors publishes a motion sensor for every camera and removes the sensors of vanished cameras.
+// Unless republish is set, already published sensors are left untouched.
+// It must be called with discoveryMu held.
+func (m *MqttIntegration) syncMotionSensors(republish bool) {
+	cameras, err := m.domruAPI.RequestCameras()
+	if err != nil {
+		m.logger.Error("Failed to get cameras for motion sensors", "error", err)
+		return
+	}
+
+	current := make(map[int]bool, len(cameras.Data))
+	for _, camera := range cameras.Data {
+		current[camera.ID] = true
+		if m.isMotionCamera(camera.ID) && !republish {
+			continue
+		}
+		if err = m.publishMotionSensor(camera); err != nil {
+			m.logger.Error("Failed to discover motion sensor", "cameraID", camera.ID, "error", err)
+			delete(current, camera.ID)
+		}
+	}
+
+	m.motionMu.Lock()
+	defer m.motionMu.Unlock()
+	for cameraID := range m.motionCameras {
+		if current[cameraID] {
+			continue
+		}
+		m.logger.Info("Removing motion sensor of a camera that is no longer in the account", "cameraID", cameraID)
+		m.publish(m.Topics.CameraMotionTopics(cameraID).Discovery, m.DiscoveryPublish, "")
+	}
+	m.motionCameras = current
+}
+
+func (m *MqttIntegration) isMotionCamera(cameraID int) bool {
+	m.motionMu.RLock()
+	defer m.motionMu.RUnlock()
+	return m.motionCameras[cameraID]
+}
+
+func (m *MqttIntegration) publishMotionSensor(camera models.Camera) error {
+	topics := m.Topics.CameraMotionTopics(camera.ID)
+	payload := MqttBinarySensor{
+		Name:        m.text("Motion"),
+		UniqueID:    topics.EntityID,
+		StateTopic:  topics.State,
+		DeviceClass: "motion",
+		PayloadOn:   "ON",
+		PayloadOff:  "OFF",
+		// Dom.ru reports only the start of a motion, Home Assistant turns the sensor off by itself
+		OffDelay:             int(m.MotionOffDelay.Seconds()),
+		JSONAttributesTopic:  topics.Attributes,
+		Device:               m.device([]string{topics.DeviceID}, camera.Name, m.text("Camera")),
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal motion sensor discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.Discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.Discovery, err)
+	}
+	if err = m.publishWithRetry(topics.State, m.StatePublish, "OFF"); err != nil {
+		m.logger.Error("Failed to publish initial motion sensor state", "topic", topics.State, "error", err)
+	}
+	return nil
+}
+
+// runEvents turns on motion sensors, rings doorbells and fires door opened triggers for the Dom.ru events of the
+// account until the integration is stopped. Only the primary account has motion sensors.
+func (m *MqttIntegration) runEvents(account string, source EventSource) {
+	events, cancel := source.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			switch event.Kind() {
+			case models.EventKindMotion:
+				if account == "" {
+					m.motion(event)
+				}
+			case models.EventKindCall:
+				m.ring(account, event)
+			case models.EventKindOpen:
+				m.externalOpen(account, event)
+			}
+		}
+	}
+}
+
+// motion turns the motion sensor of the camera the event came from on.
+func (m *MqttIntegration) motion(event models.Event) {
+	if !m.isMotionCamera(event.Source.ID) {
+		m.logger.Debug("Motion event of an unknown camera", "source", event.Source, "eventID", event.ID)
+		return
+	}
+
+	m.logger.Debug("Motion detected", "cameraID", event.Source.ID, "eventID", event.ID)
+	// Not retained, a replayed motion would turn the sensor on after a Home Assistant restart
+	topics := m.Topics.CameraMotionTopics(event.Source.ID)
+	m.publishMotionAttributes(topics, event)
+	m.publish(topics.State, PublishOptions{QoS: m.StatePublish.QoS}, "ON")
+}
+
+// publishMotionAttributes publishes the time of the motion, so it matches the wall clock of the user.
+func (m *MqttIntegration) publishMotionAttributes(topics MotionTopics, event models.Event) {
+	at, err := event.Time()
+	if err != nil {
+		m.logger.Debug("Motion event without a valid timestamp", "eventID", event.ID, "error", err)
+		return
+	}
+	attributes, err := json.Marshal(motionAttributes{
+		LastMotion: at.In(m.location()).Format(time.RFC3339),
+		Message:    event.Message,
+	})
+	if err != nil {
+		m.logger.Error("Failed to marshal motion attributes", "error", err)
+		return
+	}
+	m.publish(topics.Attributes, m.StatePublish, attributes)
+}
@@ -0,0 +1,203 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/090809/homeassistant-domru/internal/domru"
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+	"github.com/090809/homeassistant-domru/pkg/logging"
+)
+
+// OpenRequest is the payload accepted on the open topic.
+// Place and door are matched by name (case-insensitive) or by ID.
+type OpenRequest struct {
+	Place   string `json:"place,omitempty"`
+	Door    string `json:"door,omitempty"`
+	PlaceID int    `json:"place_id,omitempty"`
+	DoorID  int    `json:"door_id,omitempty"`
+}
+
+// OpenResult is published to the open result topic after each open request.
+type OpenResult struct {
+	Success bool        `json:"success"`
+	Request OpenRequest `json:"request"`
+	PlaceID int         `json:"place_id,omitempty"`
+	DoorID  int         `json:"door_id,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	// RequestID correlates the result with the log lines of the request.
+	RequestID string `json:"request_id"`
+}
+
+func (m *MqttIntegration) setPlaces(places models.PlacesResponse) {
+	m.placesMu.Lock()
+	defer m.placesMu.Unlock()
+	m.places = &places
+}
+
+// errOpenDoorNotFound rejects open requests no door of the places matches.
+var errOpenDoorNotFound = errors.New("door not found")
+
+// latestPlaces returns the places seen during the last discovery, requesting them if there are none yet.
+// The places of the last discovery are stale until the next one, fresh tells whether they were just requested.
+func (m *MqttIntegration) latestPlaces() (places models.PlacesResponse, fresh bool, err error) {
+	m.placesMu.RLock()
+	cached := m.places
+	m.placesMu.RUnlock()
+	if cached != nil {
+		return *cached, false, nil
+	}
+
+	places, err = m.requestPlaces()
+	return places, true, err
+}
+
+// requestPlaces requests the current places and keeps them for the following open requests.
+func (m *MqttIntegration) requestPlaces() (models.PlacesResponse, error) {
+	placesResponse, err := m.domruAPI.RequestPlaces()
+	if err != nil {
+		return models.PlacesResponse{}, err
+	}
+	m.setPlaces(placesResponse)
+	return placesResponse, nil
+}
+
+func (m *MqttIntegration) openHandler(_ mqtt.Client, msg mqtt.Message) {
+	if !m.commands.start() {
+		m.logger.Warn("Stopping, ignoring open request", "topic", msg.Topic())
+		return
+	}
+	defer m.commands.done()
+
+	requestID := logging.NewRequestID()
+	ctx := logging.ContextWithRequestID(context.Background(), requestID)
+
+	var request OpenRequest
+	result := OpenResult{RequestID: requestID}
+
+	if err := json.Unmarshal(msg.Payload(), &request); err != nil {
+		result.Error = fmt.Sprintf("invalid payload: %v", err)
+		m.publishOpenResult(result)
+		return
+	}
+	result.Request = request
+	m.logger.InfoContext(ctx, "Received open request", "request", request)
+
+	places, fresh, err := m.latestPlaces()
+	if err != nil {
+		m.logger.ErrorContext(ctx, "Failed to get places for open request", "error", err)
+		result.Error = "failed to get places"
+		m.publishOpenResult(result)
+		return
+	}
+
+	placeID, ac, err := resolveOpenRequest(places, request)
+	if errors.Is(err, errOpenDoorNotFound) && !fresh {
+		// A door added or renamed since the last discovery is missing from its places, look it up in the current ones.
+		// A removed door is still found, opening it fails at Dom.ru
+		m.logger.DebugContext(ctx, "Door not found in the discovered places, requesting them again", "request", request)
+		if places, err = m.requestPlaces(); err != nil {
+			m.logger.ErrorContext(ctx, "Failed to get places for open request", "error", err)
+			result.Error = "failed to get places"
+			m.publishOpenResult(result)
+			return
+		}
+		placeID, ac, err = resolveOpenRequest(places, request)
+	}
+	if err != nil {
+		m.logger.WarnContext(ctx, "Failed to resolve open request", "request", request, "error", err)
+		result.Error = err.Error()
+		m.publishOpenResult(result)
+		return
+	}
+	result.PlaceID = placeID
+	result.DoorID = ac.ID
+
+	m.logger.InfoContext(ctx, "Opening door", "placeID", placeID, "accessControlID", ac.ID)
+	if err = m.openDoor("", m.domruAPI.WithContext(ctx), placeID, ac.ID); err != nil {
+		m.logger.ErrorContext(ctx, "Failed to open door", "error", err)
+		result.Error = "failed to open door"
+		if errors.Is(err, domru.ErrDoorUnavailable) {
+			result.Error = "door is offline or opening is not allowed"
+		}
+		m.publishOpenResult(result)
+		return
+	}
+
+	result.Success = true
+	m.publishOpenResult(result)
+}
+
+func (m *MqttIntegration) publishOpenResult(result OpenResult) {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		m.logger.Error("Failed to marshal open result", "error", err)
+		return
+	}
+	// Results are one-off events, retaining them would replay a stale result to new subscribers
+	m.publish(m.Topics.OpenResult(), PublishOptions{QoS: m.StatePublish.QoS}, payload)
+}
+
+// resolveOpenRequest finds the access control matching the request.
+// A door name must be unique across the matching places, otherwise the request is rejected as ambiguous.
+func resolveOpenRequest(places models.PlacesResponse, request OpenRequest) (int, models.AccessControl, error) {
+	if request.Door == "" && request.DoorID == 0 {
+		return 0, models.AccessControl{}, errors.New("door is required")
+	}
+
+	type match struct {
+		placeID int
+		ac      models.AccessControl
+	}
+	var matches []match
+
+	for _, data := range places.Data {
+		if !placeMatches(data.Place, request) {
+			continue
+		}
+		for _, ac := range data.Place.AccessControls {
+			if request.DoorID != 0 && ac.ID != request.DoorID {
+				continue
+			}
+			if request.Door != "" && !strings.EqualFold(strings.TrimSpace(ac.Name), strings.TrimSpace(request.Door)) {
+				continue
+			}
+			matches = append(matches, match{placeID: data.Place.ID, ac: ac})
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return 0, models.AccessControl{}, errOpenDoorNotFound
+	case 1:
+		return matches[0].placeID, matches[0].ac, nil
+	default:
+		return 0, models.AccessControl{}, fmt.Errorf("door is ambiguous, %d doors matched; specify the place", len(matches))
+	}
+}
+
+func placeMatches(place models.Place, request OpenRequest) bool {
+	if request.PlaceID != 0 && place.ID != request.PlaceID {
+		return false
+	}
+	if request.Place == "" {
+		return true
+	}
+
+	name := strings.TrimSpace(request.Place)
+	for _, candidate := range []string{
+		place.Address.VisibleAddress,
+		place.Address.GroupName,
+		place.Address.KladrAddressString,
+	} {
+		if candidate != "" && strings.EqualFold(strings.TrimSpace(candidate), name) {
+			return true
+		}
+	}
+	return false
+}
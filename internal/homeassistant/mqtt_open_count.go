@@ -0,0 +1,97 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// openCounter counts the successful opens of every door by device ID, whichever way the door was opened.
+type openCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (c *openCounter) count(door string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[door]
+}
+
+// publishOpenCountSensor publishes the sensor counting the successful opens of the door. Its state only grows,
+// so Home Assistant keeps long-term statistics of it, i.e. the opens per day.
+func (m *MqttIntegration) publishOpenCountSensor(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttSensor{
+		Name:                 m.text("%s opens", ac.Name),
+		UniqueID:             topics.OpenCountEntityID,
+		StateTopic:           topics.OpenCountState,
+		StateClass:           "total_increasing",
+		Device:               m.doorDevice(account, ac, placeID),
+		Icon:                 "mdi:counter",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal open count sensor discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.OpenCountDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.OpenCountDiscovery, err)
+	}
+	count := strconv.FormatInt(m.openCounts.count(topics.DeviceID), 10)
+	m.states.set(topics.OpenCountEntityID, entityState{topic: topics.OpenCountState, payload: count})
+	if err = m.publishWithRetry(topics.OpenCountState, m.StatePublish, count); err != nil {
+		m.logger.Error("Failed to publish initial open count", "topic", topics.OpenCountState, "error", err)
+	}
+	return nil
+}
+
+// countOpen counts a successful open of the door and persists the counts. Saving them under the lock
+// keeps a slower save from overwriting the counts of a later open.
+func (m *MqttIntegration) countOpen(deviceID string) {
+	m.openCounts.mu.Lock()
+	defer m.openCounts.mu.Unlock()
+
+	m.openCounts.counts[deviceID]++
+	if m.OpenCountsFile == "" {
+		return
+	}
+	data, err := json.Marshal(m.openCounts.counts)
+	if err == nil {
+		err = writeFileAtomic(m.OpenCountsFile, data)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to save open counts", "file", m.OpenCountsFile, "error", err)
+	}
+}
+
+// loadOpenCounts restores the open counts of a previous run.
+func (m *MqttIntegration) loadOpenCounts() {
+	if m.OpenCountsFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.OpenCountsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	counts := make(map[string]int64)
+	if err == nil {
+		err = json.Unmarshal(data, &counts)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to load open counts, counting from zero", "file", m.OpenCountsFile, "error", err)
+		return
+	}
+
+	m.openCounts.mu.Lock()
+	defer m.openCounts.mu.Unlock()
+	m.openCounts.counts = counts
+}
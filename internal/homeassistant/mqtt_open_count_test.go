@@ -0,0 +1,35 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountOpen(t *testing.T) {
+	countsFile := filepath.Join(t.TempDir(), "open_counts.json")
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.OpenCountsFile = countsFile
+	deviceID := m.Topics.DoorLockTopics(12, 345).DeviceID
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.countOpen(deviceID)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 10, m.openCounts.count(deviceID))
+
+	// The counts survive a restart
+	restarted := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	restarted.OpenCountsFile = countsFile
+	restarted.loadOpenCounts()
+	assert.EqualValues(t, 10, restarted.openCounts.count(deviceID))
+}
@@ -0,0 +1,155 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func openTestPlaces() models.PlacesResponse {
+	place := func(id int, address, group string, doors ...models.AccessControl) models.Data {
+		var data models.Data
+		data.Place.ID = id
+		data.Place.Address.VisibleAddress = address
+		data.Place.Address.GroupName = group
+		data.Place.AccessControls = doors
+		return data
+	}
+	return models.PlacesResponse{Data: []models.Data{
+		place(1, "ул. Ленина, 5", "Home", models.AccessControl{ID: 11, Name: "Entrance"}, models.AccessControl{ID: 12, Name: "Gate"}),
+		place(2, "ул. Мира, 7", "Dacha", models.AccessControl{ID: 21, Name: "Entrance"}),
+	}}
+}
+
+func TestResolveOpenRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     OpenRequest
+		wantPlaceID int
+		wantDoorID  int
+		wantErr     string
+	}{
+		{name: "Unique door name", request: OpenRequest{Door: "Gate"}, wantPlaceID: 1, wantDoorID: 12},
+		{name: "Door name case and whitespace", request: OpenRequest{Door: "  gATE "}, wantPlaceID: 1, wantDoorID: 12},
+		{name: "Door ID", request: OpenRequest{DoorID: 21}, wantPlaceID: 2, wantDoorID: 21},
+		{name: "Ambiguous door name", request: OpenRequest{Door: "Entrance"}, wantErr: "door is ambiguous, 2 doors matched; specify the place"},
+		{name: "Door name narrowed by the place", request: OpenRequest{Place: "dacha", Door: "entrance"}, wantPlaceID: 2, wantDoorID: 21},
+		{name: "Door of the place ID", request: OpenRequest{PlaceID: 1, Door: "Entrance"}, wantPlaceID: 1, wantDoorID: 11},
+		{name: "Door ID of another place", request: OpenRequest{PlaceID: 1, DoorID: 21}, wantErr: "door not found"},
+		{name: "Unknown door", request: OpenRequest{Door: "Garage"}, wantErr: "door not found"},
+		{name: "Unknown place", request: OpenRequest{Place: "Office", Door: "Gate"}, wantErr: "door not found"},
+		{name: "No door", request: OpenRequest{Place: "Home"}, wantErr: "door is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placeID, ac, err := resolveOpenRequest(openTestPlaces(), tt.request)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPlaceID, placeID)
+			assert.Equal(t, tt.wantDoorID, ac.ID)
+		})
+	}
+}
+
+func TestPlaceMatches(t *testing.T) {
+	var place models.Place
+	place.ID = 1
+	place.Address.VisibleAddress = "ул. Ленина, 5"
+	place.Address.GroupName = "Home"
+	place.Address.KladrAddressString = "г Москва, ул Ленина, д 5"
+
+	tests := []struct {
+		name    string
+		request OpenRequest
+		want    bool
+	}{
+		{name: "Any place", request: OpenRequest{}, want: true},
+		{name: "Visible address", request: OpenRequest{Place: "ул. Ленина, 5"}, want: true},
+		{name: "Group name case and whitespace", request: OpenRequest{Place: " HOME\t"}, want: true},
+		{name: "Kladr address", request: OpenRequest{Place: "г москва, ул ленина, д 5"}, want: true},
+		{name: "Place ID", request: OpenRequest{PlaceID: 1}, want: true},
+		{name: "Place ID and name", request: OpenRequest{PlaceID: 1, Place: "home"}, want: true},
+		{name: "Other place ID", request: OpenRequest{PlaceID: 2, Place: "home"}},
+		{name: "Partial name", request: OpenRequest{Place: "Ленина"}},
+		{name: "Unknown name", request: OpenRequest{Place: "Office"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, placeMatches(place, tt.request))
+		})
+	}
+}
+
+func TestOpenHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// cached are the places of the last discovery, nil if there was none
+		cached     *models.PlacesResponse
+		payload    string
+		wantResult OpenResult
+		wantOpens  int32
+	}{
+		{
+			name:       "By name",
+			payload:    `{"door": " entrance "}`,
+			wantResult: OpenResult{Success: true, PlaceID: 345, DoorID: 12},
+			wantOpens:  1,
+		},
+		{
+			name:       "By place and door ID",
+			payload:    `{"place": "ул. ленина, 5", "door_id": 12}`,
+			wantResult: OpenResult{Success: true, PlaceID: 345, DoorID: 12},
+			wantOpens:  1,
+		},
+		{
+			name:       "Door added since the last discovery",
+			cached:     &models.PlacesResponse{},
+			payload:    `{"door": "Entrance"}`,
+			wantResult: OpenResult{Success: true, PlaceID: 345, DoorID: 12},
+			wantOpens:  1,
+		},
+		{
+			name:       "Unknown door",
+			payload:    `{"door": "Garage"}`,
+			wantResult: OpenResult{Error: "door not found"},
+		},
+		{
+			name:       "Unknown place",
+			payload:    `{"place": "Office", "door": "Entrance"}`,
+			wantResult: OpenResult{Error: "door not found"},
+		},
+		{
+			name:       "Invalid payload",
+			payload:    `door`,
+			wantResult: OpenResult{Error: "invalid payload: invalid character 'd' looking for beginning of value"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opens atomic.Int32
+			m, client, _ := newDoorIntegration(t, &opens)
+			if tt.cached != nil {
+				m.setPlaces(*tt.cached)
+			}
+
+			m.openHandler(nil, fakeMessage{topic: m.Topics.Open(), payload: tt.payload})
+
+			results := client.payloads(m.Topics.OpenResult())
+			require.Len(t, results, 1)
+			var result OpenResult
+			require.NoError(t, json.Unmarshal([]byte(results[0]), &result))
+			assert.NotEmpty(t, result.RequestID)
+			result.RequestID, result.Request = "", OpenRequest{}
+			assert.Equal(t, tt.wantResult, result)
+			assert.Equal(t, tt.wantOpens, opens.Load())
+		})
+	}
+}
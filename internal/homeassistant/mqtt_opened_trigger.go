@@ -0,0 +1,125 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+const (
+	// doorOpenedTrigger is the type of the device trigger firing when a door is opened outside the addon.
+	doorOpenedTrigger = "door_opened"
+	// sameOpenWindow is how far apart two opens of a door may be and still be the same one. Open events carry
+	// the time Dom.ru logged the open, which differs a bit from the time the addon asked for it.
+	sameOpenWindow = time.Minute
+)
+
+// MqttDeviceTrigger represents the discovery payload for a device trigger.
+type MqttDeviceTrigger struct {
+	AutomationType string     `json:"automation_type"`
+	Type           string     `json:"type"`
+	Subtype        string     `json:"subtype"`
+	Topic          string     `json:"topic"`
+	Device         MqttDevice `json:"device"`
+}
+
+// doorOpenedPayload is published on the trigger topic for every open by others, the time is in the configured timezone.
+type doorOpenedPayload struct {
+	Time    string `json:"time,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// openHistory remembers the last open of every door by the addon and by others, by device ID.
+type openHistory struct {
+	mu       sync.Mutex
+	ours     map[string]time.Time
+	external map[string]time.Time
+}
+
+func newOpenHistory() *openHistory {
+	return &openHistory{ours: make(map[string]time.Time), external: make(map[string]time.Time)}
+}
+
+// own records an open of the door by the addon.
+func (h *openHistory) own(door string, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ours[door] = at
+}
+
+// isExternal reports whether an open event of the door is neither an open by the addon nor a duplicate of
+// an open reported already, and remembers it in the latter case.
+func (h *openHistory) isExternal(door string, at time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ours, ok := h.ours[door]; ok && absDuration(at.Sub(ours)) <= sameOpenWindow {
+		return false
+	}
+	if last, ok := h.external[door]; ok && absDuration(at.Sub(last)) <= sameOpenWindow {
+		return false
+	}
+	h.external[door] = at
+	return true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// publishOpenedTrigger publishes the device trigger firing when the door is opened outside the addon,
+// i.e. by the neighbours with their own app.
+func (m *MqttIntegration) publishOpenedTrigger(account string, ac models.AccessControl, placeID int) error {
+	topics := m.Topics.AccountDoorLockTopics(account, ac.ID, placeID)
+	payload := MqttDeviceTrigger{
+		AutomationType: "trigger",
+		Type:           doorOpenedTrigger,
+		Subtype:        "door",
+		Topic:          topics.Trigger,
+		Device:         m.doorDevice(account, ac, placeID),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal door opened trigger discovery payload: %w", err)
+	}
+	if err = m.publishWithRetry(topics.TriggerDiscovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		return fmt.Errorf("publish discovery topic %s: %w", topics.TriggerDiscovery, err)
+	}
+	return nil
+}
+
+// externalOpen fires the door opened trigger of the door of the account an open event came from, unless the addon opened it.
+func (m *MqttIntegration) externalOpen(account string, event models.Event) {
+	door, ok := m.eventDoor(account, event)
+	if !ok {
+		m.logger.Debug("Open event of an unknown access control", "placeID", event.PlaceID, "source", event.Source, "eventID", event.ID)
+		return
+	}
+
+	at, err := event.Time()
+	if err != nil {
+		// The poller delivers events within its interval, now is close enough
+		at = time.Now()
+	}
+	topics := m.Topics.AccountDoorLockTopics(door.account, door.accessControl.ID, door.placeID)
+	if !m.opened.isExternal(topics.DeviceID, at) {
+		m.logger.Debug("Open event of an open by the addon or reported already", "placeID", door.placeID, "accessControlID", door.accessControl.ID, "eventID", event.ID)
+		return
+	}
+
+	jsonPayload, err := json.Marshal(doorOpenedPayload{Time: at.In(m.location()).Format(time.RFC3339), Message: event.Message})
+	if err != nil {
+		m.logger.Error("Failed to marshal door opened trigger payload", "error", err)
+		return
+	}
+	m.logger.Info("Door opened outside the addon", "placeID", door.placeID, "accessControlID", door.accessControl.ID, "eventID", event.ID)
+	// Not retained, a replayed trigger would fire automations after a Home Assistant restart
+	m.publish(topics.Trigger, PublishOptions{QoS: m.StatePublish.QoS}, jsonPayload)
+}
@@ -0,0 +1,36 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestExternalOpen(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	m.discovered["entrance"] = discoveredDoorLock{accessControl: models.AccessControl{ID: 1}, placeID: 10}
+	topics := m.Topics.DoorLockTopics(1, 10)
+	openEvent := func(at time.Time) models.Event {
+		return models.Event{PlaceID: 10, Source: models.Source{ID: 1}, EventTypeName: "accessControlOpen", Timestamp: at.Format(time.RFC3339)}
+	}
+	start := time.Now().Truncate(time.Second)
+
+	// The addon opened the door itself
+	m.opened.own(topics.DeviceID, start)
+	m.externalOpen("", openEvent(start.Add(5*time.Second)))
+	assert.Empty(t, client.payloads(topics.Trigger))
+
+	m.externalOpen("", openEvent(start.Add(5*time.Minute)))
+	assert.Len(t, client.payloads(topics.Trigger), 1)
+
+	// A duplicate event of the same open fires no second trigger
+	m.externalOpen("", openEvent(start.Add(5*time.Minute+2*time.Second)))
+	assert.Len(t, client.payloads(topics.Trigger), 1)
+}
@@ -0,0 +1,101 @@
+package homeassistant
+
+import "sync"
+
+// defaultPlaceFailureThreshold is how many consecutive failed Dom.ru requests of a place mark its entities unavailable.
+const defaultPlaceFailureThreshold = 3
+
+// placeKey identifies a place of an account.
+type placeKey struct {
+	account string
+	placeID int
+}
+
+// placeHealth counts the consecutive failed Dom.ru requests by place and tells which places are offline.
+type placeHealth struct {
+	mu       sync.Mutex
+	failures map[placeKey]int
+	offline  map[placeKey]bool
+}
+
+func newPlaceHealth() *placeHealth {
+	return &placeHealth{failures: make(map[placeKey]int), offline: make(map[placeKey]bool)}
+}
+
+// failed counts a failed request and reports whether the place just went offline by reaching threshold failures.
+func (h *placeHealth) failed(key placeKey, threshold int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures[key]++
+	if h.failures[key] < threshold || h.offline[key] {
+		return false
+	}
+	h.offline[key] = true
+	return true
+}
+
+// succeeded resets the failures of the place and reports whether it was offline.
+func (h *placeHealth) succeeded(key placeKey) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	recovered := h.offline[key]
+	delete(h.failures, key)
+	delete(h.offline, key)
+	return recovered
+}
+
+func (h *placeHealth) isOffline(key placeKey) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.offline[key]
+}
+
+// doorAvailability lists the bridge and the place availability topics, an entity is available only while both are online.
+func (m *MqttIntegration) doorAvailability(topics DoorTopics) []MqttAvailability {
+	return []MqttAvailability{m.bridgeAvailability(), {Topic: topics.PlaceAvailability}}
+}
+
+// publishPlaceAvailability publishes the current availability of the place, i.e. after discovering its doors.
+func (m *MqttIntegration) publishPlaceAvailability(account string, placeID int) {
+	state := "online"
+	if m.placeHealth.isOffline(placeKey{account: account, placeID: placeID}) {
+		state = "offline"
+	}
+	m.publish(m.Topics.AccountPlaceAvailability(account, placeID), m.AvailabilityPublish, state)
+}
+
+// placeRequestFailed counts a failed Dom.ru request of the place and marks the place offline once
+// PlaceFailureThreshold requests failed in a row.
+func (m *MqttIntegration) placeRequestFailed(account string, placeID int) {
+	if m.PlaceFailureThreshold <= 0 {
+		return
+	}
+	if m.placeHealth.failed(placeKey{account: account, placeID: placeID}, m.PlaceFailureThreshold) {
+		m.logger.Warn("Dom.ru requests of the place keep failing, marking its entities unavailable", "account", account, "placeID", placeID)
+		m.publish(m.Topics.AccountPlaceAvailability(account, placeID), m.AvailabilityPublish, "offline")
+	}
+}
+
+// placeRequestSucceeded resets the failures of the place and marks it online again if it was offline.
+func (m *MqttIntegration) placeRequestSucceeded(account string, placeID int) {
+	if m.placeHealth.succeeded(placeKey{account: account, placeID: placeID}) {
+		m.logger.Info("Dom.ru requests of the place succeed again, marking its entities available", "account", account, "placeID", placeID)
+		m.publish(m.Topics.AccountPlaceAvailability(account, placeID), m.AvailabilityPublish, "online")
+	}
+}
+
+// discoveredPlaces returns the places of the account with published doors. It must be called with discoveryMu held.
+func (m *MqttIntegration) discoveredPlaces(account string) []int {
+	seen := make(map[int]bool)
+	var placeIDs []int
+	for _, door := range m.discovered {
+		if door.account != account || seen[door.placeID] {
+			continue
+		}
+		seen[door.placeID] = true
+		placeIDs = append(placeIDs, door.placeID)
+	}
+	return placeIDs
+}
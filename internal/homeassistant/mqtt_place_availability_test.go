@@ -0,0 +1,25 @@
+package homeassistant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceHealth(t *testing.T) {
+	health := newPlaceHealth()
+	place := placeKey{placeID: 345}
+
+	assert.False(t, health.failed(place, 3))
+	assert.False(t, health.failed(place, 3))
+	assert.True(t, health.failed(place, 3), "third failure marks the place offline")
+	assert.False(t, health.failed(place, 3), "offline places are marked once")
+	assert.True(t, health.isOffline(place))
+	assert.False(t, health.isOffline(placeKey{account: "op2", placeID: 345}))
+
+	assert.True(t, health.succeeded(place))
+	assert.False(t, health.isOffline(place))
+	assert.False(t, health.succeeded(place))
+
+	assert.False(t, health.failed(place, 3), "a success resets the failures")
+}
@@ -0,0 +1,77 @@
+package homeassistant
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultRelockDelay is how long a door lock is reported unlocked after it was opened.
+const defaultRelockDelay = 5 * time.Second
+
+// afterFunc schedules f after d and returns a function cancelling it, time.AfterFunc in production.
+type afterFunc func(d time.Duration, f func()) (stop func() bool)
+
+func timeAfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// relockScheduler reports opened doors as locked again after a delay. Every door has at most
+// one pending relock, opening it again restarts the delay instead of adding a second one.
+type relockScheduler struct {
+	afterFunc afterFunc
+
+	mu      sync.Mutex
+	pending map[string]*relock
+}
+
+type relock struct {
+	stop func() bool
+}
+
+func newRelockScheduler(afterFunc afterFunc) *relockScheduler {
+	return &relockScheduler{afterFunc: afterFunc, pending: make(map[string]*relock)}
+}
+
+// schedule calls lock for the state topic after delay, cancelling a relock pending for the topic.
+func (s *relockScheduler) schedule(stateTopic string, delay time.Duration, lock func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, ok := s.pending[stateTopic]; ok {
+		previous.stop()
+	}
+
+	current := &relock{}
+	s.pending[stateTopic] = current
+	current.stop = s.afterFunc(delay, func() {
+		s.mu.Lock()
+		// A relock stopped too late to be cancelled must not lock the door of a newer open
+		if s.pending[stateTopic] != current {
+			s.mu.Unlock()
+			return
+		}
+		delete(s.pending, stateTopic)
+		s.mu.Unlock()
+
+		lock()
+	})
+}
+
+// cancel drops the relock pending for the state topic, if any.
+func (s *relockScheduler) cancel(stateTopic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, ok := s.pending[stateTopic]; ok {
+		previous.stop()
+		delete(s.pending, stateTopic)
+	}
+}
+
+// isPending reports whether a relock is pending for the state topic.
+func (s *relockScheduler) isPending(stateTopic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.pending[stateTopic]
+	return ok
+}
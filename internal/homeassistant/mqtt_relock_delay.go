@@ -0,0 +1,174 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// relockDelaySetting is the key of the number entity setting the relock delay.
+const relockDelaySetting = "relock_delay"
+
+// The range of the relock delay number entity, commands outside of it are clamped.
+const (
+	minRelockDelay = time.Second
+	maxRelockDelay = time.Minute
+)
+
+// MqttNumber represents the discovery payload for a number entity.
+type MqttNumber struct {
+	Name                 string     `json:"name"`
+	UniqueID             string     `json:"unique_id"`
+	CommandTopic         string     `json:"command_topic"`
+	StateTopic           string     `json:"state_topic"`
+	Min                  float64    `json:"min"`
+	Max                  float64    `json:"max"`
+	Step                 float64    `json:"step"`
+	Mode                 string     `json:"mode,omitempty"`
+	UnitOfMeasurement    string     `json:"unit_of_measurement,omitempty"`
+	EntityCategory       string     `json:"entity_category,omitempty"`
+	Device               MqttDevice `json:"device"`
+	Icon                 string     `json:"icon,omitempty"`
+	AvailabilityTopic    string     `json:"availability_topic"`
+	AvailabilityTemplate string     `json:"availability_template,omitempty"`
+}
+
+// runtimeSettings are the settings changed over MQTT, persisted in SettingsFile.
+type runtimeSettings struct {
+	RelockDelaySeconds int `json:"relock_delay_seconds,omitempty"`
+	// DisabledDoors are the doors disabled with their enabled switch, by doorSettingKey.
+	DisabledDoors []string `json:"disabled_doors,omitempty"`
+}
+
+// relockDelay returns the relock delay set over MQTT, RelockDelay if there is none.
+// Changing it applies to the following opens only, pending relocks keep their delay.
+func (m *MqttIntegration) relockDelay() time.Duration {
+	if override := m.relockDelayOverride.Load(); override > 0 {
+		return time.Duration(override)
+	}
+	return m.RelockDelay
+}
+
+// clampRelockDelay parses a relock delay command in seconds and clamps it to the range of the number entity.
+func clampRelockDelay(payload string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(seconds) {
+		return 0, errors.New("not a number")
+	}
+	delay := time.Duration(math.Round(seconds)) * time.Second
+	return min(max(delay, minRelockDelay), maxRelockDelay), nil
+}
+
+// publishRelockDelay publishes the relock delay number entity of the bridge device and its current value.
+func (m *MqttIntegration) publishRelockDelay() {
+	topics := m.Topics.SettingTopics(relockDelaySetting)
+	payload := MqttNumber{
+		Name:                 m.text("Relock delay"),
+		UniqueID:             topics.EntityID,
+		CommandTopic:         topics.Command,
+		StateTopic:           topics.State,
+		Min:                  minRelockDelay.Seconds(),
+		Max:                  maxRelockDelay.Seconds(),
+		Step:                 1,
+		Mode:                 "box",
+		UnitOfMeasurement:    "s",
+		EntityCategory:       "config",
+		Device:               m.device([]string{topics.DeviceID}, "Dom.ru proxy", m.text("Addon")),
+		Icon:                 "mdi:lock-clock",
+		AvailabilityTopic:    topics.Availability,
+		AvailabilityTemplate: m.availabilityTemplate(),
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		m.logger.Error("Failed to marshal relock delay discovery payload", "error", err)
+		return
+	}
+	if err = m.publishWithRetry(topics.Discovery, m.DiscoveryPublish, jsonPayload); err != nil {
+		m.logger.Error("Failed to publish relock delay discovery topic", "topic", topics.Discovery, "error", err)
+		return
+	}
+	m.publishRelockDelayState()
+}
+
+func (m *MqttIntegration) publishRelockDelayState() {
+	seconds := int(m.relockDelay().Round(time.Second) / time.Second)
+	m.publish(m.Topics.SettingTopics(relockDelaySetting).State, m.StatePublish, strconv.Itoa(seconds))
+}
+
+// relockDelayHandler sets the relock delay to the commanded number of seconds and persists it.
+func (m *MqttIntegration) relockDelayHandler(_ mqtt.Client, msg mqtt.Message) {
+	delay, err := clampRelockDelay(string(msg.Payload()))
+	if err != nil {
+		m.logger.Warn("Ignoring invalid relock delay", "payload", string(msg.Payload()), "error", err)
+		// Home Assistant shows the rejected value until the state is published again
+		m.publishRelockDelayState()
+		return
+	}
+
+	m.relockDelayOverride.Store(int64(delay))
+	m.logger.Info("Relock delay changed", "delay", delay)
+	m.saveSettings()
+	m.publishRelockDelayState()
+}
+
+// loadSettings restores the settings changed over MQTT by a previous run.
+func (m *MqttIntegration) loadSettings() {
+	if m.SettingsFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.SettingsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var settings runtimeSettings
+	if err == nil {
+		err = json.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to load MQTT settings, using the configured ones", "file", m.SettingsFile, "error", err)
+		return
+	}
+
+	if settings.RelockDelaySeconds > 0 {
+		delay := min(max(time.Duration(settings.RelockDelaySeconds)*time.Second, minRelockDelay), maxRelockDelay)
+		m.relockDelayOverride.Store(int64(delay))
+	}
+	m.settingsMu.Lock()
+	defer m.settingsMu.Unlock()
+	for _, key := range settings.DisabledDoors {
+		m.disabled[key] = true
+	}
+}
+
+// saveSettings persists the settings changed over MQTT.
+func (m *MqttIntegration) saveSettings() {
+	if m.SettingsFile == "" {
+		return
+	}
+
+	// Concurrent saves must not replace the file with older settings
+	m.settingsMu.Lock()
+	defer m.settingsMu.Unlock()
+	settings := runtimeSettings{
+		RelockDelaySeconds: int(time.Duration(m.relockDelayOverride.Load()) / time.Second),
+		DisabledDoors:      m.disabledDoorKeys(),
+	}
+	data, err := json.Marshal(settings)
+	if err == nil {
+		err = writeFileAtomic(m.SettingsFile, data)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to save MQTT settings", "file", m.SettingsFile, "error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClampRelockDelay(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    time.Duration
+		wantErr bool
+	}{
+		{payload: "10", want: 10 * time.Second},
+		{payload: "2.6", want: 3 * time.Second},
+		{payload: "0", want: time.Second},
+		{payload: "600", want: time.Minute},
+		{payload: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			delay, err := clampRelockDelay(tt.payload)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, delay)
+		})
+	}
+}
+
+func TestRelockDelayHandler(t *testing.T) {
+	settingsFile := filepath.Join(t.TempDir(), "mqtt_settings.json")
+	newIntegration := func() (*MqttIntegration, *fakeClient) {
+		client := &fakeClient{}
+		m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+		m.client = client
+		m.SettingsFile = settingsFile
+		return m, client
+	}
+	stateTopic := Topics{}.SettingTopics(relockDelaySetting).State
+
+	m, client := newIntegration()
+	assert.Equal(t, defaultRelockDelay, m.relockDelay())
+	m.relockDelayHandler(nil, fakeMessage{payload: "90"})
+	assert.Equal(t, time.Minute, m.relockDelay())
+	assert.Equal(t, []string{"60"}, client.payloads(stateTopic))
+
+	// The delay survives a restart
+	restarted, _ := newIntegration()
+	restarted.loadSettings()
+	require.Equal(t, time.Minute, restarted.relockDelay())
+
+	restarted.relockDelayHandler(nil, fakeMessage{payload: "later"})
+	assert.Equal(t, time.Minute, restarted.relockDelay())
+}
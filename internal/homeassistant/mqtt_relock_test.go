@@ -0,0 +1,84 @@
+package homeassistant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTimers records scheduled functions, so tests fire them instead of waiting.
+type fakeTimers struct {
+	delays []time.Duration
+	funcs  []func()
+	active []bool
+}
+
+func (f *fakeTimers) afterFunc(d time.Duration, fn func()) func() bool {
+	i := len(f.funcs)
+	f.delays = append(f.delays, d)
+	f.funcs = append(f.funcs, fn)
+	f.active = append(f.active, true)
+	return func() bool {
+		wasActive := f.active[i]
+		f.active[i] = false
+		return wasActive
+	}
+}
+
+func TestRelockScheduler(t *testing.T) {
+	t.Run("Locks after the delay", func(t *testing.T) {
+		timers := &fakeTimers{}
+		scheduler := newRelockScheduler(timers.afterFunc)
+
+		var locked int
+		scheduler.schedule("door/state", 3*time.Second, func() { locked++ })
+		assert.Equal(t, []time.Duration{3 * time.Second}, timers.delays)
+
+		timers.funcs[0]()
+		assert.Equal(t, 1, locked)
+	})
+
+	t.Run("Second open restarts the delay", func(t *testing.T) {
+		timers := &fakeTimers{}
+		scheduler := newRelockScheduler(timers.afterFunc)
+
+		var locked int
+		scheduler.schedule("door/state", time.Second, func() { locked++ })
+		scheduler.schedule("door/state", time.Second, func() { locked++ })
+		assert.Equal(t, []bool{false, true}, timers.active)
+
+		// The first timer fired right before it was stopped
+		timers.funcs[0]()
+		assert.Equal(t, 0, locked)
+		timers.funcs[1]()
+		assert.Equal(t, 1, locked)
+	})
+
+	t.Run("Doors relock independently", func(t *testing.T) {
+		timers := &fakeTimers{}
+		scheduler := newRelockScheduler(timers.afterFunc)
+
+		var locked []string
+		scheduler.schedule("first/state", time.Second, func() { locked = append(locked, "first") })
+		scheduler.schedule("second/state", time.Second, func() { locked = append(locked, "second") })
+		assert.Equal(t, []bool{true, true}, timers.active)
+
+		timers.funcs[1]()
+		timers.funcs[0]()
+		assert.Equal(t, []string{"second", "first"}, locked)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		timers := &fakeTimers{}
+		scheduler := newRelockScheduler(timers.afterFunc)
+
+		var locked int
+		scheduler.schedule("door/state", time.Second, func() { locked++ })
+		scheduler.cancel("door/state")
+		assert.Equal(t, []bool{false}, timers.active)
+
+		timers.funcs[0]()
+		assert.Equal(t, 0, locked)
+	})
+}
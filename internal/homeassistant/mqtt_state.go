@@ -0,0 +1,71 @@
+package homeassistant
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// stateHandler remembers the states published on the state topics, including the retained ones
+// delivered on subscribing, so discovery doesn't overwrite them blindly.
+func (m *MqttIntegration) stateHandler(_ mqtt.Client, msg mqtt.Message) {
+	state := string(msg.Payload())
+	m.lockStatesMu.Lock()
+	m.lockStates[msg.Topic()] = state
+	m.lockStatesMu.Unlock()
+
+	if msg.Retained() {
+		m.relockLateRetainedState(msg.Topic(), state)
+	}
+}
+
+// relockLateRetainedState handles a retained unlocked state a slow broker delivers after the discovery locked
+// the door already: it's locked again after the relock delay, like reconcileLockState does for the states
+// delivered in time.
+func (m *MqttIntegration) relockLateRetainedState(stateTopic, state string) {
+	if state != "UNLOCKED" && state != "UNLOCKING" {
+		return
+	}
+	entityID, _, err := m.Topics.splitEntityTopic(stateTopic)
+	if err != nil {
+		return
+	}
+	// Only locks reconciled as LOCKED are relocked, the others are reconciled with this state still
+	if reconciled, ok := m.states.get(entityID); !ok || !reconciled.lock || reconciled.payload != "LOCKED" || m.relocks.isPending(stateTopic) {
+		return
+	}
+
+	m.logger.Info("Retained unlocked state arrived after the discovery, locking it after the relock delay", "topic", stateTopic, "state", state)
+	m.rememberLockState(stateTopic, state)
+	m.relocks.schedule(stateTopic, m.relockDelay(), func() {
+		m.publishLockState(stateTopic, "LOCKED")
+	})
+}
+
+func (m *MqttIntegration) lockState(stateTopic string) (string, bool) {
+	m.lockStatesMu.Lock()
+	defer m.lockStatesMu.Unlock()
+	state, ok := m.lockStates[stateTopic]
+	return state, ok
+}
+
+// reconcileLockState publishes the initial state of a discovered lock. A lock already LOCKED is left alone,
+// an unlocked one, i.e. retained before a restart, is locked after the relock delay. Without a known state the lock
+// is set LOCKED right away, otherwise Home Assistant shows it as unknown.
+func (m *MqttIntegration) reconcileLockState(stateTopic string) {
+	state, _ := m.lockState(stateTopic)
+	switch state {
+	case "LOCKED":
+		m.logger.Debug("Door lock is already locked", "topic", stateTopic)
+		m.rememberLockState(stateTopic, state)
+	case "UNLOCKED", "UNLOCKING":
+		m.rememberLockState(stateTopic, state)
+		m.logger.Info("Door lock was left unlocked, locking it after the relock delay", "topic", stateTopic, "state", state)
+		m.relocks.schedule(stateTopic, m.relockDelay(), func() {
+			m.publishLockState(stateTopic, "LOCKED")
+		})
+	default:
+		m.rememberLockState(stateTopic, "LOCKED")
+		if err := m.publishWithRetry(stateTopic, m.StatePublish, "LOCKED"); err != nil {
+			m.logger.Error("Failed to publish initial door lock state", "topic", stateTopic, "error", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestReconcileLockState(t *testing.T) {
+	newIntegration := func() (*MqttIntegration, *fakeClient, *fakeTimers) {
+		client := &fakeClient{}
+		timers := &fakeTimers{}
+		m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+		m.client = client
+		m.relocks = newRelockScheduler(timers.afterFunc)
+		m.RelockDelay = 3 * time.Second
+		return m, client, timers
+	}
+	stateTopic := Topics{}.DoorLockTopics(12, 345).State
+
+	t.Run("Unknown state is locked", func(t *testing.T) {
+		m, client, timers := newIntegration()
+		m.reconcileLockState(stateTopic)
+		assert.Equal(t, []string{"LOCKED"}, client.payloads(stateTopic))
+		assert.Empty(t, timers.funcs)
+	})
+
+	t.Run("Retained LOCKED is kept", func(t *testing.T) {
+		m, client, timers := newIntegration()
+		m.stateHandler(nil, fakeMessage{topic: stateTopic, payload: "LOCKED", retained: true})
+		m.reconcileLockState(stateTopic)
+		assert.Empty(t, client.payloads(stateTopic))
+		assert.Empty(t, timers.funcs)
+	})
+
+	t.Run("Retained UNLOCKED is relocked after the delay", func(t *testing.T) {
+		m, client, timers := newIntegration()
+		m.stateHandler(nil, fakeMessage{topic: stateTopic, payload: "UNLOCKED", retained: true})
+		m.reconcileLockState(stateTopic)
+		assert.Empty(t, client.payloads(stateTopic))
+		if assert.Len(t, timers.funcs, 1) {
+			assert.Equal(t, 3*time.Second, timers.delays[0])
+			timers.funcs[0]()
+			assert.Equal(t, []string{"LOCKED"}, client.payloads(stateTopic))
+		}
+	})
+
+	t.Run("Retained UNLOCKED after the discovery is relocked", func(t *testing.T) {
+		m, client, timers := newIntegration()
+		m.reconcileLockState(stateTopic)
+		// A slow broker delivers the retained state after the blind LOCKED
+		m.stateHandler(nil, fakeMessage{topic: stateTopic, payload: "UNLOCKED", retained: true})
+		if assert.Len(t, timers.funcs, 1) {
+			assert.Equal(t, 3*time.Second, timers.delays[0])
+			timers.funcs[0]()
+			assert.Equal(t, []string{"LOCKED", "LOCKED"}, client.payloads(stateTopic))
+		}
+
+		// Live states are the ones published by the integration, they are not relocked
+		m.stateHandler(nil, fakeMessage{topic: stateTopic, payload: "UNLOCKED"})
+		assert.Len(t, timers.funcs, 1)
+	})
+
+	t.Run("Latest state wins", func(t *testing.T) {
+		m, client, _ := newIntegration()
+		m.stateHandler(nil, fakeMessage{topic: stateTopic, payload: "UNLOCKED", retained: true})
+		m.stateHandler(nil, fakeMessage{topic: stateTopic, payload: "LOCKED"})
+		m.reconcileLockState(stateTopic)
+		assert.Empty(t, client.payloads(stateTopic))
+	})
+}
+
+func TestRepublishStates(t *testing.T) {
+	client := &fakeClient{}
+	timers := &fakeTimers{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	m.relocks = newRelockScheduler(timers.afterFunc)
+	opened := m.Topics.DoorLockTopics(12, 345)
+	stuck := m.Topics.DoorLockTopics(13, 345)
+	removed := m.Topics.DoorLockTopics(14, 345)
+
+	// The door was opened just now, another one missed its relock while disconnected
+	m.publishLockState(opened.State, "UNLOCKED")
+	m.relocks.schedule(opened.State, 3*time.Second, func() {})
+	m.publishLockState(stuck.State, "UNLOCKED")
+	m.publishState(opened.AddressEntityID, opened.AddressState, "ул. Ленина, 5")
+	m.publishLockState(removed.State, "LOCKED")
+	m.removeDoorLock("", models.AccessControl{ID: 14}, 345)
+
+	m.republishStates()
+	assert.Equal(t, []string{"UNLOCKED", "UNLOCKED"}, client.payloads(opened.State))
+	assert.Equal(t, []string{"UNLOCKED", "LOCKED"}, client.payloads(stuck.State))
+	assert.Equal(t, []string{"ул. Ленина, 5", "ул. Ленина, 5"}, client.payloads(opened.AddressState))
+	assert.Equal(t, []string{"LOCKED"}, client.payloads(removed.State))
+	assert.Equal(t, []string{"online"}, client.payloads(m.Topics.Availability()))
+}
@@ -0,0 +1,100 @@
+package homeassistant
+
+import (
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// entityState is the last state published for an entity.
+type entityState struct {
+	topic   string
+	payload string
+	// lock marks door lock states, they are published LOCKED again unless a relock is pending.
+	lock bool
+}
+
+// stateRegistry holds the last state published for every door entity by entity ID, so the states can be
+// published again when the broker lost them, i.e. after a restart.
+type stateRegistry struct {
+	mu     sync.Mutex
+	states map[string]entityState
+}
+
+func (r *stateRegistry) set(entityID string, state entityState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = make(map[string]entityState)
+	}
+	r.states[entityID] = state
+}
+
+func (r *stateRegistry) forget(entityIDs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entityID := range entityIDs {
+		delete(r.states, entityID)
+	}
+}
+
+func (r *stateRegistry) get(entityID string) (entityState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[entityID]
+	return state, ok
+}
+
+func (r *stateRegistry) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = nil
+}
+
+func (r *stateRegistry) all() map[string]entityState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := make(map[string]entityState, len(r.states))
+	for entityID, state := range r.states {
+		states[entityID] = state
+	}
+	return states
+}
+
+// publishState publishes the state of the entity and remembers it for republishStates.
+func (m *MqttIntegration) publishState(entityID, topic, payload string) mqtt.Token {
+	m.states.set(entityID, entityState{topic: topic, payload: payload})
+	return m.publish(topic, m.StatePublish, payload)
+}
+
+// publishLockState publishes the state of the door lock on stateTopic and remembers it for republishStates.
+func (m *MqttIntegration) publishLockState(stateTopic, payload string) mqtt.Token {
+	m.rememberLockState(stateTopic, payload)
+	return m.publish(stateTopic, m.StatePublish, payload)
+}
+
+func (m *MqttIntegration) rememberLockState(stateTopic, payload string) {
+	entityID, _, err := m.Topics.splitEntityTopic(stateTopic)
+	if err != nil {
+		entityID = stateTopic
+	}
+	m.states.set(entityID, entityState{topic: stateTopic, payload: payload, lock: true})
+}
+
+// republishStates publishes the bridge availability and the last state of every door entity again, the broker may
+// have lost the states not retained. Locks without a pending relock are LOCKED, Dom.ru has locked them meanwhile.
+func (m *MqttIntegration) republishStates() {
+	if err := m.publishOnline(); err != nil {
+		m.logger.Error("Failed to publish online status", "error", err)
+	}
+
+	states := m.states.all()
+	for _, state := range states {
+		if state.lock && state.payload != "LOCKED" && !m.relocks.isPending(state.topic) {
+			m.publishLockState(state.topic, "LOCKED")
+			continue
+		}
+		m.publish(state.topic, m.StatePublish, state.payload)
+	}
+	m.logger.Debug("Published entity states again", "entities", len(states))
+}
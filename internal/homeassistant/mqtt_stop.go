@@ -0,0 +1,89 @@
+package homeassistant
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultStopGracePeriod is how long Stop waits for the commands being handled.
+const defaultStopGracePeriod = 5 * time.Second
+
+// commandTracker counts the commands being handled, so Stop can wait for them.
+// Once waited for, it accepts no more commands.
+type commandTracker struct {
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+}
+
+// start registers a command, false means the integration is stopping and the command must be dropped.
+func (t *commandTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.pending.Add(1)
+	return true
+}
+
+func (t *commandTracker) done() {
+	t.pending.Done()
+}
+
+// wait stops accepting commands and waits up to timeout for the registered ones, false means some are still running.
+func (t *commandTracker) wait(timeout time.Duration) bool {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.pending.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Stop reports the bridge offline, stops taking commands, waits up to StopGracePeriod for the commands
+// being handled, i.e. a door being opened, and disconnects from the broker.
+func (m *MqttIntegration) Stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+	if m.client == nil || !m.client.IsConnected() {
+		m.commands.wait(0)
+		return
+	}
+
+	// The broker only publishes the last will on an unclean disconnect
+	if token := m.publish(m.Topics.Availability(), m.AvailabilityPublish, m.offlinePayload()); !token.WaitTimeout(time.Second) || token.Error() != nil {
+		m.logger.Warn("Failed to publish offline status", "error", token.Error())
+	}
+
+	topics := []string{
+		m.Topics.Subscription("command"),
+		m.Topics.Subscription("state"),
+		m.Topics.Subscription("attributes"),
+		m.Topics.Subscription("update"),
+		m.Topics.Subscription("call"),
+		m.Topics.Open(),
+		m.Topics.SettingTopics(relockDelaySetting).Command,
+	}
+	if m.BirthTopic != "" {
+		topics = append(topics, m.BirthTopic)
+	}
+	if token := m.client.Unsubscribe(topics...); !token.WaitTimeout(time.Second) || token.Error() != nil {
+		m.logger.Warn("Failed to unsubscribe from command topics", "error", token.Error())
+	}
+
+	if !m.commands.wait(m.StopGracePeriod) {
+		m.logger.Warn("Commands still running after the grace period, disconnecting anyway", "gracePeriod", m.StopGracePeriod)
+	}
+
+	m.logger.Info("Disconnecting from MQTT broker")
+	m.client.Disconnect(250) // 250ms timeout
+}
@@ -0,0 +1,40 @@
+package homeassistant
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopWaitsForCommands(t *testing.T) {
+	client := &fakeClient{}
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.client = client
+	m.StopGracePeriod = time.Second
+
+	// A door being opened while the addon stops
+	assert.True(t, m.commands.start())
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(finished)
+		m.commands.done()
+	}()
+
+	m.Stop()
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Stop returned before the command finished")
+	}
+	assert.Equal(t, []string{"offline"}, client.payloads(m.Topics.Availability()))
+	assert.True(t, client.published[0].retained)
+	assert.Contains(t, client.unsubscribed, m.Topics.Subscription("command"))
+	assert.True(t, client.disconnected)
+	// Commands arriving after Stop are dropped
+	assert.False(t, m.commands.start())
+}
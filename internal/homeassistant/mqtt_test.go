@@ -0,0 +1,165 @@
+package homeassistant
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerOptions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("Settings win over the environment", func(t *testing.T) {
+		t.Setenv(mqttHostEnv, "env-broker")
+		t.Setenv(mqttPortEnv, "1884")
+		m := NewMqttIntegration(nil, logger, BrokerSettings{Host: "broker.lan", Username: "ha", Password: "secret"}, "")
+
+		opts, ok := m.brokerOptions("test")
+		if assert.True(t, ok) {
+			assert.Equal(t, "tcp://broker.lan:1884", opts.Servers[0].String())
+			assert.Equal(t, "ha", opts.Username)
+			assert.Equal(t, "secret", opts.Password)
+		}
+	})
+
+	t.Run("Supervisor service unless a host is configured", func(t *testing.T) {
+		t.Setenv("SUPERVISOR_TOKEN", "token")
+		t.Setenv(mqttHostEnv, "env-broker")
+		m := NewMqttIntegration(nil, logger, BrokerSettings{}, "")
+		m.mqttService = func() (MQTTService, error) {
+			return MQTTService{Host: "core-mosquitto", Port: 1883, Username: "addons", Password: "generated"}, nil
+		}
+
+		opts, ok := m.brokerOptions("test")
+		if assert.True(t, ok) {
+			assert.Equal(t, "tcp://core-mosquitto:1883", opts.Servers[0].String())
+			assert.Equal(t, "addons", opts.Username)
+		}
+
+		m.mqttService = func() (MQTTService, error) { return MQTTService{}, errors.New("service unavailable") }
+		opts, ok = m.brokerOptions("test")
+		if assert.True(t, ok) {
+			assert.Equal(t, "tcp://env-broker:1883", opts.Servers[0].String())
+		}
+	})
+
+	t.Run("Configured broker outside of the supervisor", func(t *testing.T) {
+		t.Setenv(mqttHostEnv, "")
+		m := NewMqttIntegration(nil, logger, BrokerSettings{URL: "mqtt://broker.lan:1883"}, "")
+
+		opts, ok := m.brokerOptions("test")
+		if assert.True(t, ok) {
+			assert.Equal(t, "mqtt://broker.lan:1883", opts.Servers[0].String())
+		}
+	})
+
+	t.Run("No broker outside of the supervisor", func(t *testing.T) {
+		t.Setenv(mqttHostEnv, "")
+		_, ok := NewMqttIntegration(nil, logger, BrokerSettings{}, "").brokerOptions("test")
+		assert.False(t, ok)
+	})
+}
+
+func TestGetMQTTService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"result":"ok","data":{"host":"core-mosquitto","port":1883,"ssl":false,"protocol":"3.1.1","username":"addons","password":"generated","addon":"core_mosquitto"}}`))
+	}))
+	defer server.Close()
+
+	service, err := getMQTTService(context.Background(), server.Client(), server.URL, "token")
+	assert.NoError(t, err)
+	assert.Equal(t, MQTTService{Host: "core-mosquitto", Port: 1883, Username: "addons", Password: "generated"}, service)
+}
+
+func TestGetHomeAssistantNetworkAddressWithPortStandalone(t *testing.T) {
+	// Without the supervisor there is no address, so entities are published without pictures
+	host, err := GetHomeAssistantNetworkAddressWithPort()
+	assert.NoError(t, err)
+	assert.Empty(t, host)
+}
+
+func TestResolveHAHost(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	supervisor := func() (string, error) { return "172.30.32.1:8080", nil }
+	noSupervisor := func() (string, error) { return "", nil }
+	failingSupervisor := func() (string, error) { return "", errors.New("supervisor unavailable") }
+
+	tests := []struct {
+		name              string
+		externalURL       string
+		supervisorAddress func() (string, error)
+		want              string
+	}{
+		{name: "External URL wins", externalURL: "https://ha.example.com:8443", supervisorAddress: supervisor, want: "https://ha.example.com:8443"},
+		{name: "Supervisor address", supervisorAddress: supervisor, want: "http://172.30.32.1:8080"},
+		{name: "Without supervisor", supervisorAddress: noSupervisor, want: ""},
+		{name: "Supervisor error", supervisorAddress: failingSupervisor, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resolveHAHost(tt.externalURL, tt.supervisorAddress, logger))
+		})
+	}
+}
+
+func TestRunRediscoveryStops(t *testing.T) {
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{}, "")
+	m.RediscoveryInterval = time.Hour
+
+	stopped := make(chan struct{})
+	go func() {
+		m.runRediscovery()
+		close(stopped)
+	}()
+	m.Stop()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("re-discovery loop did not stop")
+	}
+}
+
+func TestBrokerOptionsTLS(t *testing.T) {
+	t.Setenv(mqttPortEnv, "")
+	tlsConfig, err := TLSSettings{}.Config()
+	assert.NoError(t, err)
+
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{Host: "broker.lan", TLS: tlsConfig}, "")
+	opts, ok := m.brokerOptions("test")
+	if assert.True(t, ok) {
+		assert.Equal(t, "ssl://broker.lan:8883", opts.Servers[0].String())
+		assert.Same(t, tlsConfig, opts.TLSConfig)
+	}
+
+	_, err = TLSSettings{CAFile: filepath.Join(t.TempDir(), "missing.pem")}.Config()
+	assert.Error(t, err)
+	_, err = TLSSettings{CertFile: "client.pem"}.Config()
+	assert.Error(t, err)
+}
+
+func TestParseBrokerURL(t *testing.T) {
+	for _, raw := range []string{"tcp://broker.lan:1883", "wss://mqtt.example.com/mqtt", "mqtts://broker.lan"} {
+		_, err := ParseBrokerURL(raw)
+		assert.NoError(t, err, raw)
+	}
+	for _, raw := range []string{"http://broker.lan", "broker.lan:1883", "ws:///mqtt"} {
+		_, err := ParseBrokerURL(raw)
+		assert.Error(t, err, raw)
+	}
+
+	m := NewMqttIntegration(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), BrokerSettings{URL: "wss://mqtt.example.com/mqtt"}, "")
+	opts, ok := m.brokerOptions("test")
+	if assert.True(t, ok) {
+		assert.Equal(t, "wss://mqtt.example.com/mqtt", opts.Servers[0].String())
+	}
+}
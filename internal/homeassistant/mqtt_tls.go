@@ -0,0 +1,48 @@
+package homeassistant
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSSettings are the files securing the connection to the broker.
+type TLSSettings struct {
+	// CAFile is the PEM CA bundle the broker certificate is verified with, empty uses the system roots.
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key, both empty for no client certificate.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify accepts any broker certificate.
+	InsecureSkipVerify bool
+}
+
+// Config loads the files into a TLS config for the broker connection.
+func (s TLSSettings) Config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: s.InsecureSkipVerify}
+
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", s.CAFile)
+		}
+	}
+
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,475 @@
+package homeassistant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ErrNotAuthorized is matched by the errors of publishes, subscriptions and connects the MQTT 5 broker
+// rejected for the missing permissions of the user, retrying them can't succeed.
+var ErrNotAuthorized = errors.New("not authorized by the broker")
+
+const (
+	reasonBadCredentials = 0x86
+	reasonNotAuthorized  = 0x87
+)
+
+// reasonNames are the MQTT 5 reason codes of failures brokers commonly report.
+var reasonNames = map[byte]string{
+	0x80:                 "unspecified error",
+	0x83:                 "implementation specific error",
+	reasonBadCredentials: "bad user name or password",
+	reasonNotAuthorized:  "not authorized",
+	0x8F:                 "topic filter invalid",
+	0x90:                 "topic name invalid",
+	0x97:                 "quota exceeded",
+	0x99:                 "payload format invalid",
+	0x9A:                 "retain not supported",
+	0x9B:                 "QoS not supported",
+}
+
+// ReasonCodeError is an operation the MQTT 5 broker rejected with a reason code.
+type ReasonCodeError struct {
+	// Op is the rejected operation, i.e. "publish" or "subscribe".
+	Op   string
+	Code byte
+	// Reason is the reason string the broker sent along, if any.
+	Reason string
+}
+
+func (e *ReasonCodeError) Error() string {
+	message := fmt.Sprintf("%s rejected by the broker with reason code 0x%02X", e.Op, e.Code)
+	if name, ok := reasonNames[e.Code]; ok {
+		message += " (" + name + ")"
+	}
+	if e.Reason != "" {
+		message += ": " + e.Reason
+	}
+	return message
+}
+
+func (e *ReasonCodeError) Is(target error) bool {
+	return target == ErrNotAuthorized && (e.Code == reasonNotAuthorized || e.Code == reasonBadCredentials)
+}
+
+// reasonCodeError returns the error of a failure reason code, nil for a success.
+func reasonCodeError(op string, code byte, reason string) error {
+	if code < 0x80 {
+		return nil
+	}
+	return &ReasonCodeError{Op: op, Code: code, Reason: reason}
+}
+
+// v5Token completes when its operation returns.
+type v5Token struct {
+	done chan struct{}
+	err  error
+}
+
+func newV5Token(operation func() error) *v5Token {
+	token := &v5Token{done: make(chan struct{})}
+	go func() {
+		token.err = operation()
+		close(token.done)
+	}()
+	return token
+}
+
+func (t *v5Token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *v5Token) WaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (t *v5Token) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *v5Token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+// v5Message is a message received over MQTT 5, acknowledged by the client already.
+type v5Message struct {
+	publish *paho.Publish
+}
+
+func (m v5Message) Duplicate() bool   { return false }
+func (m v5Message) Qos() byte         { return m.publish.QoS }
+func (m v5Message) Retained() bool    { return m.publish.Retain }
+func (m v5Message) Topic() string     { return m.publish.Topic }
+func (m v5Message) MessageID() uint16 { return m.publish.PacketID }
+func (m v5Message) Payload() []byte   { return m.publish.Payload }
+func (m v5Message) Ack()              {}
+
+// v5Client speaks MQTT 5 through paho.golang behind the client interface of paho.mqtt.golang, so the
+// integration works the same with either protocol. It takes the connection, will and handlers from the
+// client options, reconnects by itself and logs the reason codes the broker answers with.
+type v5Client struct {
+	opts   *mqtt.ClientOptions
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	cm       *autopaho.ConnectionManager
+	cancel   context.CancelFunc
+	handlers map[string]mqtt.MessageHandler
+	// connectErr is the latest failed connect attempt, reported when the first connect times out.
+	connectErr error
+
+	connected atomic.Bool
+	// wasConnected tells reconnects from the first connect.
+	wasConnected atomic.Bool
+	// queue hands the received messages to the handlers in order. It's unbounded, the client must never block
+	// on it: handlers wait for the acknowledgements of their publishes, which the blocked client would never read.
+	queueMu sync.Mutex
+	queue   []*paho.Publish
+	// queued is signalled when a message is added to the queue.
+	queued chan struct{}
+}
+
+func newV5Client(opts *mqtt.ClientOptions, logger *slog.Logger) *v5Client {
+	return &v5Client{
+		opts:     opts,
+		logger:   logger,
+		handlers: make(map[string]mqtt.MessageHandler),
+		queued:   make(chan struct{}, 1),
+	}
+}
+
+func (c *v5Client) IsConnected() bool      { return c.connected.Load() }
+func (c *v5Client) IsConnectionOpen() bool { return c.connected.Load() }
+
+func (c *v5Client) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewOptionsReader(c.opts)
+}
+
+// Connect connects to the first broker of the options, the token fails unless the connection is up within
+// the connect timeout. Once connected, lost connections are reestablished as long as AutoReconnect is set.
+func (c *v5Client) Connect() mqtt.Token {
+	return newV5Token(func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		cm, err := autopaho.NewConnection(ctx, c.config())
+		if err != nil {
+			cancel()
+			return fmt.Errorf("connect: %w", err)
+		}
+		c.mu.Lock()
+		c.cm, c.cancel = cm, cancel
+		c.mu.Unlock()
+		go c.dispatch(ctx)
+
+		timeout := c.opts.ConnectTimeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		awaitCtx, awaitCancel := context.WithTimeout(ctx, timeout)
+		defer awaitCancel()
+		if err = cm.AwaitConnection(awaitCtx); err != nil {
+			cancel()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.connectErr != nil {
+				return c.connectErr
+			}
+			return fmt.Errorf("connect: %w", err)
+		}
+		return nil
+	})
+}
+
+func (c *v5Client) config() autopaho.ClientConfig {
+	serverURLs := make([]*url.URL, 0, len(c.opts.Servers))
+	for _, server := range c.opts.Servers {
+		serverURL := *server
+		// paho.golang doesn't know the schemes of paho.mqtt.golang for plain connections
+		if serverURL.Scheme == "mqtt" {
+			serverURL.Scheme = "tcp"
+		}
+		serverURLs = append(serverURLs, &serverURL)
+	}
+
+	config := autopaho.ClientConfig{
+		ServerUrls:                    serverURLs,
+		TlsCfg:                        c.opts.TLSConfig,
+		KeepAlive:                     uint16(c.opts.KeepAlive),
+		CleanStartOnInitialConnection: c.opts.CleanSession,
+		ConnectTimeout:                c.opts.ConnectTimeout,
+		ConnectUsername:               c.opts.Username,
+		ConnectPassword:               []byte(c.opts.Password),
+		OnConnectionUp: func(_ *autopaho.ConnectionManager, connack *paho.Connack) {
+			c.connected.Store(true)
+			c.wasConnected.Store(true)
+			c.logger.Debug("Connected to MQTT 5 broker", "reasonCode", connack.ReasonCode)
+			if c.opts.OnConnect != nil {
+				// The handler subscribes and waits for the broker, the connection manager must not wait for it
+				go c.opts.OnConnect(c)
+			}
+		},
+		OnConnectionDown: func() bool {
+			c.connected.Store(false)
+			if c.opts.OnConnectionLost != nil {
+				go c.opts.OnConnectionLost(c, errors.New("connection lost"))
+			}
+			return c.opts.AutoReconnect
+		},
+		OnConnectError: func(err error) {
+			var connackErr *autopaho.ConnackError
+			if errors.As(err, &connackErr) {
+				err = reasonCodeError("connect", connackErr.ReasonCode, connackErr.Reason)
+			}
+			c.mu.Lock()
+			c.connectErr = err
+			c.mu.Unlock()
+			c.logger.Warn("Failed to connect to MQTT 5 broker", "error", err)
+			if c.wasConnected.Load() && c.opts.OnReconnecting != nil {
+				c.opts.OnReconnecting(c, c.opts)
+			}
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: c.opts.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(received paho.PublishReceived) (bool, error) {
+					c.enqueue(received.Packet)
+					return true, nil
+				},
+			},
+			OnServerDisconnect: func(disconnect *paho.Disconnect) {
+				reason := ""
+				if disconnect.Properties != nil {
+					reason = disconnect.Properties.ReasonString
+				}
+				c.logger.Warn("MQTT 5 broker disconnected", "reasonCode", disconnect.ReasonCode, "reason", reason)
+			},
+		},
+	}
+	if c.opts.MaxReconnectInterval > 2*time.Second {
+		// Like paho.mqtt.golang, the wait between reconnects grows up to the maximum
+		config.ReconnectBackoff = autopaho.NewExponentialBackoff(time.Second, c.opts.MaxReconnectInterval, 2*time.Second, 2)
+	}
+	if c.opts.WillEnabled {
+		config.WillMessage = &paho.WillMessage{
+			Topic:   c.opts.WillTopic,
+			Payload: c.opts.WillPayload,
+			QoS:     c.opts.WillQos,
+			Retain:  c.opts.WillRetained,
+		}
+	}
+	return config
+}
+
+// enqueue adds a received message to the queue of dispatch without waiting for the handlers.
+func (c *v5Client) enqueue(publish *paho.Publish) {
+	c.queueMu.Lock()
+	c.queue = append(c.queue, publish)
+	c.queueMu.Unlock()
+
+	select {
+	case c.queued <- struct{}{}:
+	default:
+		// dispatch is signalled already and drains the whole queue
+	}
+}
+
+// dequeue takes the oldest received message from the queue, nil when it's empty.
+func (c *v5Client) dequeue() *paho.Publish {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	if len(c.queue) == 0 {
+		return nil
+	}
+	publish := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	return publish
+}
+
+// dispatch calls the handlers of the received messages one after another, like the ordered paho.mqtt.golang client.
+func (c *v5Client) dispatch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.queued:
+		}
+		for publish := c.dequeue(); publish != nil; publish = c.dequeue() {
+			if ctx.Err() != nil {
+				return
+			}
+			c.handle(publish)
+		}
+	}
+}
+
+// handle calls the handlers of the subscriptions matching the topic of the message.
+func (c *v5Client) handle(publish *paho.Publish) {
+	c.mu.Lock()
+	var handlers []mqtt.MessageHandler
+	for filter, handler := range c.handlers {
+		if topicMatches(filter, publish.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+	for _, handler := range handlers {
+		handler(c, v5Message{publish: publish})
+	}
+}
+
+// connection returns the connection manager, nil before Connect.
+func (c *v5Client) connection() *autopaho.ConnectionManager {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cm
+}
+
+func (c *v5Client) Disconnect(quiesce uint) {
+	c.connected.Store(false)
+	cm := c.connection()
+	if cm == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond)
+	defer cancel()
+	if err := cm.Disconnect(ctx); err != nil {
+		c.logger.Debug("Failed to disconnect from MQTT 5 broker cleanly", "error", err)
+	}
+	c.mu.Lock()
+	c.cancel()
+	c.mu.Unlock()
+}
+
+func (c *v5Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	return newV5Token(func() error {
+		cm := c.connection()
+		if cm == nil {
+			return mqtt.ErrNotConnected
+		}
+		var data []byte
+		switch p := payload.(type) {
+		case string:
+			data = []byte(p)
+		case []byte:
+			data = p
+		default:
+			return fmt.Errorf("unknown payload type %T", payload)
+		}
+
+		response, err := cm.Publish(context.Background(), &paho.Publish{Topic: topic, QoS: qos, Retain: retained, Payload: data})
+		if response != nil {
+			reason := ""
+			if response.Properties != nil {
+				reason = response.Properties.ReasonString
+			}
+			if reasonErr := reasonCodeError("publish", response.ReasonCode, reason); reasonErr != nil {
+				c.logger.Warn("MQTT 5 broker rejected publish", "topic", topic, "reasonCode", response.ReasonCode, "reason", reason)
+				return reasonErr
+			}
+		}
+		return err
+	})
+}
+
+func (c *v5Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *v5Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	subscribe := &paho.Subscribe{}
+	for filter, qos := range filters {
+		c.AddRoute(filter, callback)
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: filter, QoS: qos})
+	}
+	return newV5Token(func() error {
+		cm := c.connection()
+		if cm == nil {
+			return mqtt.ErrNotConnected
+		}
+		suback, err := cm.Subscribe(context.Background(), subscribe)
+		if suback == nil {
+			return err
+		}
+		reason := ""
+		if suback.Properties != nil {
+			reason = suback.Properties.ReasonString
+		}
+		for i, code := range suback.Reasons {
+			if reasonErr := reasonCodeError("subscribe", code, reason); reasonErr != nil && i < len(subscribe.Subscriptions) {
+				c.logger.Warn("MQTT 5 broker rejected subscription", "topic", subscribe.Subscriptions[i].Topic, "reasonCode", code, "reason", reason)
+				return reasonErr
+			}
+		}
+		return err
+	})
+}
+
+func (c *v5Client) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.handlers, topic)
+	}
+	c.mu.Unlock()
+
+	return newV5Token(func() error {
+		cm := c.connection()
+		if cm == nil {
+			return mqtt.ErrNotConnected
+		}
+		unsuback, err := cm.Unsubscribe(context.Background(), &paho.Unsubscribe{Topics: topics})
+		if unsuback == nil {
+			return err
+		}
+		for _, code := range unsuback.Reasons {
+			if reasonErr := reasonCodeError("unsubscribe", code, ""); reasonErr != nil {
+				return reasonErr
+			}
+		}
+		return err
+	})
+}
+
+func (c *v5Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[topic] = callback
+}
+
+// topicMatches reports whether the topic matches the subscription filter with its + and # wildcards.
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
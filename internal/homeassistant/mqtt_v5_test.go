@@ -0,0 +1,188 @@
+package homeassistant
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReasonCodeError(t *testing.T) {
+	assert.NoError(t, reasonCodeError("publish", 0x10, ""))
+
+	err := reasonCodeError("publish", reasonNotAuthorized, "ACL denied")
+	assert.EqualError(t, err, "publish rejected by the broker with reason code 0x87 (not authorized): ACL denied")
+	assert.True(t, errors.Is(err, ErrNotAuthorized))
+
+	// Transient failures are not mistaken for missing permissions
+	assert.False(t, errors.Is(reasonCodeError("publish", 0x97, ""), ErrNotAuthorized))
+}
+
+func TestTopicMatches(t *testing.T) {
+	assert.True(t, topicMatches("domru/+/command", "domru/domru-door_1_2-open/command"))
+	assert.True(t, topicMatches("homeassistant/#", "homeassistant/status"))
+	assert.True(t, topicMatches("homeassistant/status", "homeassistant/status"))
+	assert.False(t, topicMatches("domru/+/command", "domru/domru-door_1_2-open/state"))
+	assert.False(t, topicMatches("domru/+", "domru/a/b"))
+}
+
+// fakeV5Broker is a minimal MQTT 5 broker answering publishes and subscriptions with the configured reason codes.
+type fakeV5Broker struct {
+	listener net.Listener
+	// publishReasons and subscribeReasons are the reason codes by topic, success when missing.
+	publishReasons   map[string]byte
+	subscribeReasons map[string]byte
+	// retained is how many retained messages every subscription receives, like the states of a busy broker.
+	retained int
+}
+
+func newFakeV5Broker(t *testing.T) *fakeV5Broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	return &fakeV5Broker{listener: listener, publishReasons: map[string]byte{}, subscribeReasons: map[string]byte{}}
+}
+
+func (b *fakeV5Broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(packets.NewThreadSafeConn(conn))
+	}
+}
+
+func (b *fakeV5Broker) handle(conn net.Conn) {
+	defer conn.Close()
+	var packetID uint16
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.Content.(type) {
+		case *packets.Connect:
+			_, _ = (&packets.Connack{Properties: &packets.Properties{}}).WriteTo(conn)
+		case *packets.Publish:
+			if p.QoS > 0 {
+				_, _ = (&packets.Puback{PacketID: p.PacketID, ReasonCode: b.publishReasons[p.Topic], Properties: &packets.Properties{}}).WriteTo(conn)
+			}
+		case *packets.Subscribe:
+			suback := &packets.Suback{PacketID: p.PacketID, Properties: &packets.Properties{}}
+			for _, subscription := range p.Subscriptions {
+				suback.Reasons = append(suback.Reasons, b.subscribeReasons[subscription.Topic])
+			}
+			_, _ = suback.WriteTo(conn)
+			for i := 0; i < b.retained; i++ {
+				packetID++
+				retained := &packets.Publish{PacketID: packetID, QoS: 1, Retain: true, Topic: fmt.Sprintf("state/%d", i), Payload: []byte("LOCKED"), Properties: &packets.Properties{}}
+				_, _ = retained.WriteTo(conn)
+			}
+		case *packets.Pingreq:
+			_, _ = (&packets.Pingresp{}).WriteTo(conn)
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+func connectFakeV5Broker(t *testing.T, broker *fakeV5Broker) *v5Client {
+	go broker.serve()
+	opts := mqtt.NewClientOptions().AddBroker("tcp://" + broker.listener.Addr().String()).SetClientID("domru_test").SetConnectTimeout(5 * time.Second)
+	client := newV5Client(opts, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	token := client.Connect()
+	require.True(t, token.WaitTimeout(5*time.Second))
+	require.NoError(t, token.Error())
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+func TestV5ClientReasonCodes(t *testing.T) {
+	broker := newFakeV5Broker(t)
+	broker.publishReasons["denied"] = reasonNotAuthorized
+	broker.publishReasons["full"] = 0x97
+	broker.subscribeReasons["denied/#"] = reasonNotAuthorized
+	client := connectFakeV5Broker(t, broker)
+
+	token := client.Publish("allowed", 1, false, "payload")
+	require.True(t, token.WaitTimeout(5*time.Second))
+	assert.NoError(t, token.Error())
+
+	token = client.Publish("denied", 1, false, "payload")
+	require.True(t, token.WaitTimeout(5*time.Second))
+	var reasonErr *ReasonCodeError
+	if assert.True(t, errors.As(token.Error(), &reasonErr)) {
+		assert.Equal(t, "publish", reasonErr.Op)
+		assert.Equal(t, byte(reasonNotAuthorized), reasonErr.Code)
+	}
+	assert.True(t, errors.Is(token.Error(), ErrNotAuthorized))
+
+	token = client.Publish("full", 1, false, []byte("payload"))
+	require.True(t, token.WaitTimeout(5*time.Second))
+	assert.Error(t, token.Error())
+	assert.False(t, errors.Is(token.Error(), ErrNotAuthorized))
+
+	token = client.Subscribe("allowed/#", 1, func(mqtt.Client, mqtt.Message) {})
+	require.True(t, token.WaitTimeout(5*time.Second))
+	assert.NoError(t, token.Error())
+
+	token = client.Subscribe("denied/#", 1, func(mqtt.Client, mqtt.Message) {})
+	require.True(t, token.WaitTimeout(5*time.Second))
+	if assert.True(t, errors.As(token.Error(), &reasonErr)) {
+		assert.Equal(t, "subscribe", reasonErr.Op)
+	}
+	assert.True(t, errors.Is(token.Error(), ErrNotAuthorized))
+}
+
+func TestV5ClientHandlersPublishing(t *testing.T) {
+	broker := newFakeV5Broker(t)
+	broker.retained = 300
+	client := connectFakeV5Broker(t, broker)
+
+	// Handlers publish and wait for the acknowledgement, like the state handlers do
+	var handled atomic.Int32
+	token := client.Subscribe("state/#", 1, func(c mqtt.Client, msg mqtt.Message) {
+		if token := c.Publish(msg.Topic()+"/ack", 1, false, msg.Payload()); token.WaitTimeout(time.Second) && token.Error() == nil {
+			handled.Add(1)
+		}
+	})
+	require.True(t, token.WaitTimeout(5*time.Second))
+	require.NoError(t, token.Error())
+
+	assert.Eventually(t, func() bool { return handled.Load() == 300 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestV5ClientQueueDoesNotBlock(t *testing.T) {
+	client := newV5Client(mqtt.NewClientOptions(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Nothing dispatches, receiving must not wait for the handlers anyway
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			client.enqueue(&paho.Publish{Topic: fmt.Sprintf("state/%d", i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueue blocked")
+	}
+
+	// The messages are dispatched in order
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, fmt.Sprintf("state/%d", i), client.dequeue().Topic)
+	}
+	assert.Nil(t, client.dequeue())
+}
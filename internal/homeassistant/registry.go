@@ -0,0 +1,247 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+// loggedOutAccount marks a registry whose entities must be removed by the next discovery,
+// whichever account it finds.
+const loggedOutAccount = "logged-out"
+
+// entityRegistry is the persisted set of published door locks, so entities published
+// before a restart can still be removed once they vanish from the account.
+type entityRegistry struct {
+	// Account identifies the primary account the entities were published for.
+	Account string `json:"account"`
+	// TopicPrefix, DiscoveryPrefix, Instance and OperatorID name the topics and entity IDs the doors were published under.
+	TopicPrefix     string `json:"topic_prefix,omitempty"`
+	DiscoveryPrefix string `json:"discovery_prefix,omitempty"`
+	Instance        string `json:"instance,omitempty"`
+	OperatorID      int    `json:"operator_id,omitempty"`
+	// Doors are the published door locks by discovery topic.
+	Doors map[string]registeredDoor `json:"doors"`
+	// DisabledDoors are the doors disabled over MQTT, only their enabled switch is published.
+	DisabledDoors map[string]registeredDoor `json:"disabled_doors,omitempty"`
+}
+
+type registeredDoor struct {
+	Account         string `json:"account,omitempty"`
+	PlaceID         int    `json:"place_id"`
+	AccessControlID int    `json:"access_control_id"`
+	Type            string `json:"type,omitempty"`
+}
+
+func newRegisteredDoor(door discoveredDoorLock) registeredDoor {
+	return registeredDoor{
+		Account:         door.account,
+		PlaceID:         door.placeID,
+		AccessControlID: door.accessControl.ID,
+		Type:            door.accessControl.Type,
+	}
+}
+
+func (door registeredDoor) discovered() discoveredDoorLock {
+	return discoveredDoorLock{
+		account:       door.Account,
+		accessControl: models.AccessControl{ID: door.AccessControlID, Type: door.Type},
+		placeID:       door.PlaceID,
+	}
+}
+
+// loadRegistry restores the door locks published by a previous run into discovered.
+func (m *MqttIntegration) loadRegistry() {
+	if m.RegistryFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.RegistryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var registry entityRegistry
+	if err == nil {
+		err = json.Unmarshal(data, &registry)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to load MQTT entity registry, stale entities of the previous run are kept", "file", m.RegistryFile, "error", err)
+		return
+	}
+
+	m.registeredAccount = registry.Account
+	// Registries of older versions don't know the prefixes, their doors are taken as published under the current ones
+	m.registeredTopics = m.Topics
+	if registry.TopicPrefix != "" {
+		m.registeredTopics = Topics{Prefix: registry.TopicPrefix, DiscoveryPrefix: registry.DiscoveryPrefix, Instance: registry.Instance, OperatorID: registry.OperatorID}
+	}
+	for discoveryTopic, door := range registry.Doors {
+		m.discovered[discoveryTopic] = door.discovered()
+	}
+	for discoveryTopic, door := range registry.DisabledDoors {
+		m.disabledDoors[discoveryTopic] = door.discovered()
+	}
+	m.indexDoors()
+}
+
+// saveRegistry persists the published door locks. It must be called with discoveryMu held.
+func (m *MqttIntegration) saveRegistry() {
+	if m.RegistryFile == "" {
+		return
+	}
+
+	registry := entityRegistry{
+		Account:         m.registeredAccount,
+		TopicPrefix:     m.Topics.prefix(),
+		DiscoveryPrefix: m.Topics.discoveryPrefix(),
+		Instance:        m.Topics.Instance,
+		OperatorID:      m.Topics.OperatorID,
+		Doors:           make(map[string]registeredDoor, len(m.discovered)),
+	}
+	for discoveryTopic, door := range m.discovered {
+		registry.Doors[discoveryTopic] = newRegisteredDoor(door)
+	}
+	if len(m.disabledDoors) > 0 {
+		registry.DisabledDoors = make(map[string]registeredDoor, len(m.disabledDoors))
+		for discoveryTopic, door := range m.disabledDoors {
+			registry.DisabledDoors[discoveryTopic] = newRegisteredDoor(door)
+		}
+	}
+
+	data, err := json.Marshal(registry)
+	if err == nil {
+		err = writeFileAtomic(m.RegistryFile, data)
+	}
+	if err != nil {
+		m.logger.Warn("Failed to save MQTT entity registry", "file", m.RegistryFile, "error", err)
+	}
+}
+
+// writeFileAtomic replaces the file with data, so a crash can't leave it half-written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// accountKey identifies the primary account by its places, another account has other places.
+func accountKey(places models.PlacesResponse) string {
+	ids := make([]string, 0, len(places.Data))
+	for _, data := range places.Data {
+		ids = append(ids, strconv.Itoa(data.Place.ID))
+	}
+	slices.Sort(ids)
+	return "places:" + strings.Join(ids, ",")
+}
+
+// checkAccountChanged removes all published entities when the primary account differs from
+// the one they were published for, i.e. after logging in as another account.
+// It must be called with discoveryMu held.
+func (m *MqttIntegration) checkAccountChanged(places models.PlacesResponse) {
+	key := accountKey(places)
+	if m.registeredAccount != "" && m.registeredAccount != key {
+		m.logger.Info("Account changed since the entities were published, removing them", "previous", m.registeredAccount, "current", key)
+		m.cleanupDiscovery(true)
+	}
+	m.registeredAccount = key
+}
+
+// checkTopicsChanged removes the door locks published under other prefixes than the current ones, so
+// changing mqtt-topic-prefix, mqtt-discovery-prefix or mqtt-instance-suffix doesn't leave duplicated entities behind.
+// The doors are published again under the new prefixes by the discovery. It must be called with discoveryMu held.
+func (m *MqttIntegration) checkTopicsChanged() {
+	previous := m.registeredTopics
+	m.registeredTopics = m.Topics
+	if len(m.discovered) == 0 && len(m.disabledDoors) == 0 || previous.prefix() == m.Topics.prefix() && previous.discoveryPrefix() == m.Topics.discoveryPrefix() && previous.idPrefix() == m.Topics.idPrefix() {
+		return
+	}
+
+	m.logger.Info("Topic prefix changed since the entities were published, removing them",
+		"previous", previous.prefix(), "current", m.Topics.prefix(),
+		"previousIDs", previous.idPrefix(), "currentIDs", m.Topics.idPrefix(),
+		"previousDiscovery", previous.discoveryPrefix(), "currentDiscovery", m.Topics.discoveryPrefix())
+	for discoveryTopic, door := range m.discovered {
+		m.removeDoorEntities(previous, door.account, door.accessControl, door.placeID)
+		delete(m.discovered, discoveryTopic)
+	}
+	for discoveryTopic, door := range m.disabledDoors {
+		m.removeDoorEntities(previous, door.account, door.accessControl, door.placeID)
+		delete(m.disabledDoors, discoveryTopic)
+	}
+}
+
+// CleanupDiscovery removes all entities published for the current account from Home Assistant, i.e. on logout,
+// and reports the bridge offline until the next discovery. The doors of additional accounts stay logged in,
+// so they are kept and the bridge stays online with them. Without a broker connection the removal is left
+// to the next discovery.
+func (m *MqttIntegration) CleanupDiscovery() {
+	m.discoveryMu.Lock()
+	defer m.discoveryMu.Unlock()
+
+	if m.client == nil || !m.client.IsConnected() {
+		m.registeredAccount = loggedOutAccount
+		m.saveRegistry()
+		return
+	}
+	otherAccounts := len(m.accounts) > 0
+	m.cleanupDiscovery(!otherAccounts)
+	m.registeredAccount = ""
+	m.saveRegistry()
+	if otherAccounts {
+		return
+	}
+	if token := m.publish(m.Topics.Availability(), m.AvailabilityPublish, m.offlinePayload()); token.Wait() && token.Error() != nil {
+		m.logger.Error("Failed to publish offline status", "error", token.Error())
+	}
+}
+
+// cleanupDiscovery publishes empty retained discovery configs of every published door lock, door camera,
+// call button, motion sensor and camera entity. Unless allAccounts is set, the door locks of additional
+// accounts are kept. It must be called with discoveryMu held.
+func (m *MqttIntegration) cleanupDiscovery(allAccounts bool) {
+	for discoveryTopic, door := range m.discovered {
+		if allAccounts || door.account == "" {
+			m.removeDoorLock(door.account, door.accessControl, door.placeID)
+			delete(m.discovered, discoveryTopic)
+		}
+	}
+	for discoveryTopic, door := range m.disabledDoors {
+		if allAccounts || door.account == "" {
+			m.removeDoorLock(door.account, door.accessControl, door.placeID)
+			delete(m.disabledDoors, discoveryTopic)
+		}
+	}
+	m.indexDoors()
+	if allAccounts {
+		m.states.clear()
+	}
+	for placeID := range m.callPlaces {
+		m.removeCallButtons(placeID)
+	}
+
+	m.motionMu.Lock()
+	defer m.motionMu.Unlock()
+	for cameraID := range m.motionCameras {
+		m.publish(m.Topics.CameraMotionTopics(cameraID).Discovery, m.DiscoveryPublish, "")
+	}
+	m.motionCameras = nil
+	m.removeCameras()
+}
@@ -0,0 +1,93 @@
+package homeassistant
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+)
+
+func TestRegistryRoundTrip(t *testing.T) {
+	registryFile := filepath.Join(t.TempDir(), "entities.json")
+	door := discoveredDoorLock{account: "op2", accessControl: models.AccessControl{ID: 12, Type: "SIP"}, placeID: 345}
+
+	saved := NewMqttIntegration(nil, slog.Default(), BrokerSettings{}, "")
+	saved.RegistryFile = registryFile
+	saved.registeredAccount = "places:345"
+	saved.discovered["homeassistant/lock/door/config"] = door
+	saved.saveRegistry()
+
+	loaded := NewMqttIntegration(nil, slog.Default(), BrokerSettings{}, "")
+	loaded.RegistryFile = registryFile
+	loaded.loadRegistry()
+
+	assert.Equal(t, "places:345", loaded.registeredAccount)
+	assert.Equal(t, map[string]discoveredDoorLock{"homeassistant/lock/door/config": door}, loaded.discovered)
+}
+
+func TestAccountKey(t *testing.T) {
+	place := func(id int) models.Data {
+		var data models.Data
+		data.Place.ID = id
+		return data
+	}
+
+	assert.Equal(t,
+		accountKey(models.PlacesResponse{Data: []models.Data{place(2), place(10)}}),
+		accountKey(models.PlacesResponse{Data: []models.Data{place(10), place(2)}}),
+	)
+	assert.NotEqual(t,
+		accountKey(models.PlacesResponse{Data: []models.Data{place(2)}}),
+		accountKey(models.PlacesResponse{Data: []models.Data{place(3)}}),
+	)
+}
+
+func TestHasDiscoveredDoors(t *testing.T) {
+	m := NewMqttIntegration(nil, slog.Default(), BrokerSettings{}, "")
+	assert.False(t, m.hasDiscoveredDoors(""))
+
+	m.discovered["homeassistant/lock/door/config"] = discoveredDoorLock{account: "op2", placeID: 345}
+	assert.True(t, m.hasDiscoveredDoors("op2"))
+	assert.False(t, m.hasDiscoveredDoors(""))
+}
+
+func TestCheckTopicsChanged(t *testing.T) {
+	registryFile := filepath.Join(t.TempDir(), "entities.json")
+	door := discoveredDoorLock{accessControl: models.AccessControl{ID: 12}, placeID: 345}
+
+	saved := NewMqttIntegration(nil, slog.Default(), BrokerSettings{}, "")
+	saved.RegistryFile = registryFile
+	saved.discovered[saved.Topics.AccountDoorLockTopics("", 12, 345).Discovery] = door
+	saved.saveRegistry()
+
+	client := &fakeClient{}
+	loaded := NewMqttIntegration(nil, slog.Default(), BrokerSettings{}, "")
+	loaded.client = client
+	loaded.RegistryFile = registryFile
+	loaded.Topics = Topics{Prefix: "building2"}
+	loaded.loadRegistry()
+	loaded.checkTopicsChanged()
+
+	assert.Empty(t, loaded.discovered)
+	assert.Equal(t, []string{""}, client.payloads("homeassistant/lock/domru-door_12_345-open/config"))
+	assert.Empty(t, client.payloads("homeassistant/lock/building2-door_12_345-open/config"))
+
+	// Unchanged prefixes keep the doors
+	loaded.discovered["homeassistant/lock/building2-door_12_345-open/config"] = door
+	loaded.checkTopicsChanged()
+	assert.Len(t, loaded.discovered, 1)
+
+	// Enabling the instance suffix removes the doors published without it
+	loaded.saveRegistry()
+	restarted := NewMqttIntegration(nil, slog.Default(), BrokerSettings{}, "")
+	restarted.client = client
+	restarted.RegistryFile = registryFile
+	restarted.Topics = Topics{Prefix: "building2", Instance: "home", OperatorID: 2}
+	restarted.loadRegistry()
+	restarted.checkTopicsChanged()
+	assert.Empty(t, restarted.discovered)
+	assert.Equal(t, []string{""}, client.payloads("homeassistant/lock/building2-door_12_345-open/config"))
+}
@@ -0,0 +1,473 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultTopicPrefix is the namespace of the MQTT topics and entity IDs unless another one is configured.
+const DefaultTopicPrefix = "domru"
+
+// DefaultDiscoveryPrefix is the topic prefix Home Assistant reads discovery configs from by default.
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// Topics builds the MQTT topics and entity IDs under a prefix, so several addon instances
+// can share a broker. The zero value uses DefaultTopicPrefix and DefaultDiscoveryPrefix.
+type Topics struct {
+	Prefix string
+	// DiscoveryPrefix is the discovery prefix configured in Home Assistant.
+	DiscoveryPrefix string
+	// Instance is a suffix telling addon instances apart in the device and entity IDs and the bridge topics.
+	// With it, the IDs carry OperatorID as well, so accounts sharing a door don't merge it. Empty keeps the IDs
+	// of the prefix alone.
+	Instance string
+	// OperatorID is the operator of the primary account, it's a part of the IDs only along with Instance.
+	OperatorID int
+}
+
+func (t Topics) prefix() string {
+	if t.Prefix == "" {
+		return DefaultTopicPrefix
+	}
+	return t.Prefix
+}
+
+// idPrefix starts the device and entity IDs: the topic prefix, followed by the instance and the operator
+// when an instance is set.
+func (t Topics) idPrefix() string {
+	if t.Instance == "" {
+		return t.prefix()
+	}
+	if t.OperatorID > 0 {
+		return fmt.Sprintf("%s-%s-%s", t.prefix(), t.Instance, OperatorAccount(t.OperatorID))
+	}
+	return fmt.Sprintf("%s-%s", t.prefix(), t.Instance)
+}
+
+// bridge is the topic level of the topics shared by all entities of the instance.
+func (t Topics) bridge() string {
+	if t.Instance == "" {
+		return t.prefix() + "_proxy"
+	}
+	return fmt.Sprintf("%s_proxy_%s", t.prefix(), t.Instance)
+}
+
+func (t Topics) discoveryPrefix() string {
+	if t.DiscoveryPrefix == "" {
+		return DefaultDiscoveryPrefix
+	}
+	return t.DiscoveryPrefix
+}
+
+// discovery returns the discovery config topic of the entity of the component, i.e. "lock".
+func (t Topics) discovery(component, entityID string) string {
+	return fmt.Sprintf("%s/%s/%s/config", t.discoveryPrefix(), component, entityID)
+}
+
+// Birth is the topic Home Assistant announces its start on, it lives under the discovery prefix.
+func (t Topics) Birth() string {
+	return t.discoveryPrefix() + "/status"
+}
+
+// Availability is the bridge availability topic shared by all entities.
+func (t Topics) Availability() string {
+	return t.bridge() + "/status"
+}
+
+// Open is the topic accepting door open requests by name or ID.
+func (t Topics) Open() string {
+	return t.bridge() + "/open"
+}
+
+// OpenResult is the topic the results of the open requests are published to.
+func (t Topics) OpenResult() string {
+	return t.bridge() + "/open/result"
+}
+
+// Errors is the topic the failures of entity commands are published to.
+func (t Topics) Errors() string {
+	return t.bridge() + "/errors"
+}
+
+// Subscription returns the wildcard topic matching the topic with the suffix of every entity, i.e. "command".
+func (t Topics) Subscription(suffix string) string {
+	return fmt.Sprintf("%s/+/%s", t.prefix(), suffix)
+}
+
+// DoorTopics are the identifiers and MQTT topics of a door lock entity.
+type DoorTopics struct {
+	DeviceID     string `json:"device_id"`
+	EntityID     string `json:"entity_id"`
+	Discovery    string `json:"discovery"`
+	Command      string `json:"command"`
+	State        string `json:"state"`
+	Availability string `json:"availability"`
+	// PlaceAvailability goes offline while the Dom.ru requests of the place keep failing.
+	PlaceAvailability string `json:"place_availability"`
+	// Attributes carries the outcome of the last open of the lock.
+	Attributes string `json:"attributes"`
+	// ButtonDiscovery is the discovery topic of the button opening the door, it shares Command with the lock.
+	ButtonDiscovery string `json:"button_discovery"`
+	// EventEntityID, EventDiscovery and Event belong to the doorbell event entity of the door.
+	EventEntityID  string `json:"event_entity_id"`
+	EventDiscovery string `json:"event_discovery"`
+	Event          string `json:"event"`
+	// LastOpenEntityID, LastOpenDiscovery, LastOpenState and LastOpenAttributes belong to the sensor
+	// telling when and how the door was last opened.
+	LastOpenEntityID   string `json:"last_open_entity_id"`
+	LastOpenDiscovery  string `json:"last_open_discovery"`
+	LastOpenState      string `json:"last_open_state"`
+	LastOpenAttributes string `json:"last_open_attributes"`
+	// OpenedEntityID, OpenedDiscovery and OpenedState belong to the binary sensor turning on for a moment
+	// when the door is opened.
+	OpenedEntityID  string `json:"opened_entity_id"`
+	OpenedDiscovery string `json:"opened_discovery"`
+	OpenedState     string `json:"opened_state"`
+	// AddressEntityID, AddressDiscovery and AddressState belong to the diagnostic sensor with the place address.
+	AddressEntityID  string `json:"address_entity_id"`
+	AddressDiscovery string `json:"address_discovery"`
+	AddressState     string `json:"address_state"`
+	// OpenCountEntityID, OpenCountDiscovery and OpenCountState belong to the sensor counting the successful opens.
+	OpenCountEntityID  string `json:"open_count_entity_id"`
+	OpenCountDiscovery string `json:"open_count_discovery"`
+	OpenCountState     string `json:"open_count_state"`
+	// TriggerDiscovery and Trigger belong to the device trigger firing when the door is opened outside the addon.
+	TriggerDiscovery string `json:"trigger_discovery"`
+	Trigger          string `json:"trigger"`
+	// EnabledEntityID, EnabledDiscovery, EnabledCommand and EnabledState belong to the switch publishing or
+	// removing the other entities of the door. It stays published while the door is disabled.
+	EnabledEntityID  string `json:"enabled_entity_id"`
+	EnabledDiscovery string `json:"enabled_discovery"`
+	EnabledCommand   string `json:"enabled_command"`
+	EnabledState     string `json:"enabled_state"`
+}
+
+// DoorLockTopics returns the topics the door lock of the access control is published on.
+func (t Topics) DoorLockTopics(acID, placeID int) DoorTopics {
+	return t.AccountDoorLockTopics("", acID, placeID)
+}
+
+// OperatorAccount names the additional account of an operator in entity IDs.
+func OperatorAccount(operatorID int) string {
+	return fmt.Sprintf("op%d", operatorID)
+}
+
+// AccountDoorLockTopics returns the door lock topics of an access control of the account.
+// Additional accounts have their name in the IDs, so doors under different operators can't collide.
+// The primary account (empty name) keeps the IDs of DoorLockTopics.
+func (t Topics) AccountDoorLockTopics(account string, acID, placeID int) DoorTopics {
+	deviceID := fmt.Sprintf("%s-door_%d_%d", t.idPrefix(), acID, placeID)
+	if account != "" {
+		deviceID = fmt.Sprintf("%s-%s-door_%d_%d", t.idPrefix(), account, acID, placeID)
+	}
+	entityID := fmt.Sprintf("%s-open", deviceID)
+	eventEntityID := fmt.Sprintf("%s-ring", deviceID)
+	lastOpenEntityID := fmt.Sprintf("%s-last_open", deviceID)
+	openedEntityID := fmt.Sprintf("%s-opened", deviceID)
+	addressEntityID := fmt.Sprintf("%s-address", deviceID)
+	triggerID := fmt.Sprintf("%s-door_opened", deviceID)
+	openCountEntityID := fmt.Sprintf("%s-open_count", deviceID)
+	enabledEntityID := fmt.Sprintf("%s-enabled", deviceID)
+
+	return DoorTopics{
+		DeviceID:     deviceID,
+		EntityID:     entityID,
+		Discovery:    t.discovery("lock", entityID),
+		Command:      fmt.Sprintf("%s/%s/command", t.prefix(), entityID),
+		State:        fmt.Sprintf("%s/%s/state", t.prefix(), entityID),
+		Availability: t.Availability(),
+		Attributes:   fmt.Sprintf("%s/%s/attributes", t.prefix(), entityID),
+
+		PlaceAvailability: t.AccountPlaceAvailability(account, placeID),
+
+		ButtonDiscovery: t.discovery("button", entityID),
+
+		EventEntityID:  eventEntityID,
+		EventDiscovery: t.discovery("event", eventEntityID),
+		Event:          fmt.Sprintf("%s/%s/event", t.prefix(), eventEntityID),
+
+		LastOpenEntityID:   lastOpenEntityID,
+		LastOpenDiscovery:  t.discovery("sensor", lastOpenEntityID),
+		LastOpenState:      fmt.Sprintf("%s/%s/state", t.prefix(), lastOpenEntityID),
+		LastOpenAttributes: fmt.Sprintf("%s/%s/attributes", t.prefix(), lastOpenEntityID),
+
+		OpenedEntityID:  openedEntityID,
+		OpenedDiscovery: t.discovery("binary_sensor", openedEntityID),
+		OpenedState:     fmt.Sprintf("%s/%s/state", t.prefix(), openedEntityID),
+
+		AddressEntityID:  addressEntityID,
+		AddressDiscovery: t.discovery("sensor", addressEntityID),
+		AddressState:     fmt.Sprintf("%s/%s/state", t.prefix(), addressEntityID),
+
+		OpenCountEntityID:  openCountEntityID,
+		OpenCountDiscovery: t.discovery("sensor", openCountEntityID),
+		OpenCountState:     fmt.Sprintf("%s/%s/state", t.prefix(), openCountEntityID),
+
+		TriggerDiscovery: t.discovery("device_automation", triggerID),
+		Trigger:          fmt.Sprintf("%s/%s/trigger", t.prefix(), triggerID),
+
+		EnabledEntityID:  enabledEntityID,
+		EnabledDiscovery: t.discovery("switch", enabledEntityID),
+		EnabledCommand:   fmt.Sprintf("%s/%s/set", t.prefix(), enabledEntityID),
+		EnabledState:     fmt.Sprintf("%s/%s/state", t.prefix(), enabledEntityID),
+	}
+}
+
+// AccountPlaceAvailability returns the availability topic of the place of the named account,
+// the primary account has no name.
+func (t Topics) AccountPlaceAvailability(account string, placeID int) string {
+	place := fmt.Sprintf("place_%d", placeID)
+	if account != "" {
+		place = account + "-" + place
+	}
+	if t.Instance != "" {
+		place = t.idPrefix() + "-" + place
+	}
+	return fmt.Sprintf("%s/%s/availability", t.prefix(), place)
+}
+
+// CallTopics are the identifiers and MQTT topics of the buttons answering and rejecting the calls of a place.
+type CallTopics struct {
+	DeviceID        string `json:"device_id"`
+	AnswerEntityID  string `json:"answer_entity_id"`
+	AnswerDiscovery string `json:"answer_discovery"`
+	RejectEntityID  string `json:"reject_entity_id"`
+	RejectDiscovery string `json:"reject_discovery"`
+	// Command is shared by both buttons, they press it with callAnswer and callReject. It doesn't end with
+	// "command", the door command subscription would receive it otherwise.
+	Command string `json:"command"`
+}
+
+// PlaceCallTopics returns the topics the call buttons of the place of the primary account are published on.
+func (t Topics) PlaceCallTopics(placeID int) CallTopics {
+	deviceID := fmt.Sprintf("%s-place_%d", t.idPrefix(), placeID)
+	answerEntityID := deviceID + "-call_answer"
+	rejectEntityID := deviceID + "-call_reject"
+	return CallTopics{
+		DeviceID:        deviceID,
+		AnswerEntityID:  answerEntityID,
+		AnswerDiscovery: t.discovery("button", answerEntityID),
+		RejectEntityID:  rejectEntityID,
+		RejectDiscovery: t.discovery("button", rejectEntityID),
+		Command:         fmt.Sprintf("%s/%s/call", t.prefix(), deviceID),
+	}
+}
+
+// splitEntityTopic splits a "<prefix>/<entity ID>/<suffix>" topic of an entity under the prefix.
+func (t Topics) splitEntityTopic(topic string) (entityID, suffix string, err error) {
+	segments := strings.Split(topic, "/")
+	if len(segments) != 3 || segments[0] != t.prefix() || segments[1] == "" || segments[2] == "" {
+		return "", "", fmt.Errorf("not an entity topic under %s: %s", t.prefix(), topic)
+	}
+	return segments[1], segments[2], nil
+}
+
+// parsePlaceCallTopic extracts the place ID from a call command topic.
+func (t Topics) parsePlaceCallTopic(topic string) (placeID int, err error) {
+	deviceID, suffix, err := t.splitEntityTopic(topic)
+	if err != nil {
+		return 0, err
+	}
+	place, found := strings.CutPrefix(deviceID, t.idPrefix()+"-place_")
+	if !found || suffix != "call" {
+		return 0, fmt.Errorf("unexpected call topic: %s", topic)
+	}
+	if placeID, err = parseID(place); err != nil {
+		return 0, fmt.Errorf("unexpected call topic %s: %w", topic, err)
+	}
+	return placeID, nil
+}
+
+// parseCameraUpdateTopic extracts the account and IDs from a door camera update topic.
+func (t Topics) parseCameraUpdateTopic(topic string) (account string, acID, placeID int, err error) {
+	return t.parseDoorTopic(topic, "camera", "update")
+}
+
+// parseDoorTopic extracts the account and IDs from a "<prefix>/<device ID>-<entity>/<suffix>" topic of a door entity,
+// i.e. the "update" topic of the "camera".
+func (t Topics) parseDoorTopic(topic, entity, suffix string) (account string, acID, placeID int, err error) {
+	entityID, topicSuffix, err := t.splitEntityTopic(topic)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	door, found := strings.CutSuffix(entityID, "-"+entity)
+	if !found || topicSuffix != suffix {
+		return "", 0, 0, fmt.Errorf("not a door topic ending with %s/%s: %s", entity, suffix, topic)
+	}
+	door, found = strings.CutPrefix(door, t.idPrefix()+"-")
+	if before, after, ok := strings.Cut(door, "-"); ok {
+		account, door = before, after
+	}
+	ids, isDoor := strings.CutPrefix(door, "door_")
+	acText, placeText, hasPlace := strings.Cut(ids, "_")
+	if !found || !isDoor || !hasPlace {
+		return "", 0, 0, fmt.Errorf("unexpected door topic: %s", topic)
+	}
+	if acID, err = parseID(acText); err != nil {
+		return "", 0, 0, fmt.Errorf("unexpected door topic %s: %w", topic, err)
+	}
+	if placeID, err = parseID(placeText); err != nil {
+		return "", 0, 0, fmt.Errorf("unexpected door topic %s: %w", topic, err)
+	}
+	return account, acID, placeID, nil
+}
+
+// parseID parses a positive decimal ID of a topic.
+func parseID(text string) (int, error) {
+	id, err := strconv.Atoi(text)
+	if err != nil || id <= 0 || strconv.Itoa(id) != text {
+		return 0, fmt.Errorf("invalid ID %q", text)
+	}
+	return id, nil
+}
+
+// CameraTopics are the identifiers and MQTT topics of a door camera entity showing the door snapshot.
+type CameraTopics struct {
+	DeviceID     string `json:"device_id"`
+	EntityID     string `json:"entity_id"`
+	Discovery    string `json:"discovery"`
+	Image        string `json:"image"`
+	Update       string `json:"update,omitempty"`
+	Availability string `json:"availability"`
+	// Attributes carries the time of the last snapshot and the last error of door cameras, and the stream
+	// sources of camera entities.
+	Attributes string `json:"attributes,omitempty"`
+	// RefreshDiscovery is the discovery topic of the button refreshing the snapshot, it publishes to Update.
+	RefreshDiscovery string `json:"refresh_discovery,omitempty"`
+	// CameraAvailability tells whether the camera returns snapshots, only camera entities of PlaceCameraTopics have it.
+	CameraAvailability string `json:"camera_availability,omitempty"`
+}
+
+// DoorCameraTopics returns the topics the camera of the door is published on.
+// The camera belongs to the same device as the door lock.
+func (t Topics) DoorCameraTopics(account string, acID, placeID int) CameraTopics {
+	deviceID := t.AccountDoorLockTopics(account, acID, placeID).DeviceID
+	entityID := fmt.Sprintf("%s-camera", deviceID)
+
+	return CameraTopics{
+		DeviceID:     deviceID,
+		EntityID:     entityID,
+		Discovery:    t.discovery("camera", entityID),
+		Image:        fmt.Sprintf("%s/%s/image", t.prefix(), entityID),
+		Update:       fmt.Sprintf("%s/%s/update", t.prefix(), entityID),
+		Availability: t.Availability(),
+		Attributes:   fmt.Sprintf("%s/%s/attributes", t.prefix(), entityID),
+
+		RefreshDiscovery: t.discovery("button", entityID+"-refresh"),
+	}
+}
+
+// PlaceCameraTopics returns the topics the camera entity of an account camera is published on.
+// It shares the device with the motion sensor of the camera.
+func (t Topics) PlaceCameraTopics(cameraID int) CameraTopics {
+	deviceID := t.CameraMotionTopics(cameraID).DeviceID
+	entityID := fmt.Sprintf("%s-snapshot", deviceID)
+
+	return CameraTopics{
+		DeviceID:           deviceID,
+		EntityID:           entityID,
+		Discovery:          t.discovery("camera", entityID),
+		Image:              fmt.Sprintf("%s/%s/image", t.prefix(), entityID),
+		Availability:       t.Availability(),
+		Attributes:         fmt.Sprintf("%s/%s/attributes", t.prefix(), entityID),
+		CameraAvailability: fmt.Sprintf("%s/%s/availability", t.prefix(), entityID),
+	}
+}
+
+// MotionTopics are the identifiers and MQTT topics of a camera motion sensor.
+type MotionTopics struct {
+	DeviceID     string `json:"device_id"`
+	EntityID     string `json:"entity_id"`
+	Discovery    string `json:"discovery"`
+	State        string `json:"state"`
+	Attributes   string `json:"attributes"`
+	Availability string `json:"availability"`
+}
+
+// CameraMotionTopics returns the topics the motion sensor of the camera is published on.
+func (t Topics) CameraMotionTopics(cameraID int) MotionTopics {
+	deviceID := fmt.Sprintf("%s-camera_%d", t.idPrefix(), cameraID)
+	entityID := fmt.Sprintf("%s-motion", deviceID)
+
+	return MotionTopics{
+		DeviceID:     deviceID,
+		EntityID:     entityID,
+		Discovery:    t.discovery("binary_sensor", entityID),
+		State:        fmt.Sprintf("%s/%s/state", t.prefix(), entityID),
+		Attributes:   fmt.Sprintf("%s/%s/attributes", t.prefix(), entityID),
+		Availability: t.Availability(),
+	}
+}
+
+// SensorTopics are the identifiers and MQTT topics of a sensor, i.e. the account balance.
+type SensorTopics struct {
+	DeviceID     string
+	EntityID     string
+	Discovery    string
+	State        string
+	Attributes   string
+	Availability string
+}
+
+// BalanceTopics returns the topics the balance sensor of the primary account is published on.
+func (t Topics) BalanceTopics() SensorTopics {
+	return t.AccountBalanceTopics("")
+}
+
+// AccountBalanceTopics returns the topics the balance sensor of the named account is published on,
+// the primary account has no name.
+func (t Topics) AccountBalanceTopics(account string) SensorTopics {
+	deviceID := t.idPrefix() + "-account"
+	if account != "" {
+		deviceID = fmt.Sprintf("%s-%s-account", t.idPrefix(), account)
+	}
+	entityID := strings.TrimSuffix(deviceID, "-account") + "-balance"
+
+	return SensorTopics{
+		DeviceID:     deviceID,
+		EntityID:     entityID,
+		Discovery:    t.discovery("sensor", entityID),
+		State:        fmt.Sprintf("%s/%s/state", t.prefix(), entityID),
+		Attributes:   fmt.Sprintf("%s/%s/attributes", t.prefix(), entityID),
+		Availability: t.Availability(),
+	}
+}
+
+// SettingTopics are the identifiers and MQTT topics of a config entity of the bridge device.
+type SettingTopics struct {
+	DeviceID     string
+	EntityID     string
+	Discovery    string
+	Command      string
+	State        string
+	Availability string
+}
+
+// SettingTopics returns the topics the number entity of a runtime setting of the bridge device is published on.
+func (t Topics) SettingTopics(key string) SettingTopics {
+	entityID := fmt.Sprintf("%s-%s", t.idPrefix(), key)
+
+	return SettingTopics{
+		DeviceID:     t.idPrefix() + "-bridge",
+		EntityID:     entityID,
+		Discovery:    t.discovery("number", entityID),
+		Command:      fmt.Sprintf("%s/%s/set", t.prefix(), entityID),
+		State:        fmt.Sprintf("%s/%s/state", t.prefix(), entityID),
+		Availability: t.Availability(),
+	}
+}
+
+// DiagnosticTopics returns the topics the diagnostic sensor of the bridge device is published on.
+func (t Topics) DiagnosticTopics(key string) SensorTopics {
+	entityID := fmt.Sprintf("%s-%s", t.idPrefix(), key)
+
+	return SensorTopics{
+		DeviceID:     t.idPrefix() + "-bridge",
+		EntityID:     entityID,
+		Discovery:    t.discovery("sensor", entityID),
+		State:        fmt.Sprintf("%s/%s/state", t.prefix(), entityID),
+		Attributes:   fmt.Sprintf("%s/%s/attributes", t.prefix(), entityID),
+		Availability: t.Availability(),
+	}
+}
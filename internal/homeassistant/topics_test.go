@@ -0,0 +1,98 @@
+package homeassistant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDoorTopic(t *testing.T) {
+	instance := Topics{Instance: "home", OperatorID: 2}
+	tests := []struct {
+		name        string
+		topics      Topics
+		topic       string
+		wantAccount string
+		wantACID    int
+		wantPlaceID int
+		wantErr     bool
+	}{
+		{"Primary account", Topics{}, Topics{}.DoorLockTopics(12, 345).Command, "", 12, 345, false},
+		{"Operator account", Topics{}, Topics{}.AccountDoorLockTopics(OperatorAccount(2), 12, 345).Command, "op2", 12, 345, false},
+		{"Custom prefix", Topics{Prefix: "home2"}, Topics{Prefix: "home2"}.AccountDoorLockTopics("op2", 12, 345).Command, "op2", 12, 345, false},
+		{"Other prefix", Topics{Prefix: "home2"}, Topics{}.DoorLockTopics(12, 345).Command, "", 0, 0, true},
+		{"Instance", instance, instance.DoorLockTopics(12, 345).Command, "", 12, 345, false},
+		{"Instance operator account", instance, instance.AccountDoorLockTopics("op3", 12, 345).Command, "op3", 12, 345, false},
+		{"Other instance", instance, Topics{Instance: "flat", OperatorID: 2}.DoorLockTopics(12, 345).Command, "", 0, 0, true},
+		{"Other operator", instance, Topics{Instance: "home", OperatorID: 3}.DoorLockTopics(12, 345).Command, "", 0, 0, true},
+		{"Without instance", instance, Topics{}.DoorLockTopics(12, 345).Command, "", 0, 0, true},
+		{"State topic", Topics{}, Topics{}.DoorLockTopics(12, 345).State, "", 0, 0, true},
+		{"Other entity", Topics{}, "domru/domru-door_12_345-camera/command", "", 0, 0, true},
+		{"Trailing garbage", Topics{}, "domru/domru-door_12_345x-open/command", "", 0, 0, true},
+		{"Missing place", Topics{}, "domru/domru-door_12-open/command", "", 0, 0, true},
+		{"Extra ID", Topics{}, "domru/domru-door_12_345_6-open/command", "", 0, 0, true},
+		{"Negative ID", Topics{}, "domru/domru-door_-12_345-open/command", "", 0, 0, true},
+		{"Extra segment", Topics{}, "domru/domru-door_12_345-open/command/set", "", 0, 0, true},
+		{"Nested entity", Topics{}, "domru/domru/domru-door_12_345-open/command", "", 0, 0, true},
+		{"Empty entity", Topics{}, "domru//command", "", 0, 0, true},
+		{"Unprefixed entity", Topics{}, "domru/door_12_345-open/command", "", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, acID, placeID, err := tt.topics.parseDoorTopic(tt.topic, "open", "command")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAccount, account)
+			assert.Equal(t, tt.wantACID, acID)
+			assert.Equal(t, tt.wantPlaceID, placeID)
+		})
+	}
+}
+
+func TestAccountBalanceTopics(t *testing.T) {
+	primary := Topics{}.BalanceTopics()
+	assert.Equal(t, "domru-balance", primary.EntityID)
+	assert.Equal(t, "domru-account", primary.DeviceID)
+
+	operator := Topics{}.AccountBalanceTopics(OperatorAccount(2))
+	assert.Equal(t, "domru-op2-balance", operator.EntityID)
+	assert.Equal(t, "domru-op2-account", operator.DeviceID)
+	assert.Equal(t, "homeassistant/sensor/domru-op2-balance/config", operator.Discovery)
+}
+
+func TestDiscoveryPrefix(t *testing.T) {
+	assert.Equal(t, "homeassistant/lock/domru-door_12_345-open/config", Topics{}.DoorLockTopics(12, 345).Discovery)
+	assert.Equal(t, DefaultBirthTopic, Topics{}.Birth())
+
+	topics := Topics{DiscoveryPrefix: "ha-discovery"}
+	assert.Equal(t, "ha-discovery/lock/domru-door_12_345-open/config", topics.DoorLockTopics(12, 345).Discovery)
+	assert.Equal(t, "ha-discovery/camera/domru-door_12_345-camera/config", topics.DoorCameraTopics("", 12, 345).Discovery)
+	assert.Equal(t, "ha-discovery/sensor/domru-balance/config", topics.BalanceTopics().Discovery)
+	assert.Equal(t, "ha-discovery/status", topics.Birth())
+}
+
+func TestInstanceTopics(t *testing.T) {
+	topics := Topics{Instance: "home", OperatorID: 2}
+	door := topics.DoorLockTopics(12, 345)
+	assert.Equal(t, "domru-home-op2-door_12_345", door.DeviceID)
+	assert.Equal(t, "domru-home-op2-door_12_345-open", door.EntityID)
+	assert.Equal(t, "homeassistant/lock/domru-home-op2-door_12_345-open/config", door.Discovery)
+	assert.Equal(t, "domru/domru-home-op2-door_12_345-open/command", door.Command)
+	assert.Equal(t, "domru/domru-home-op2-place_345/availability", door.PlaceAvailability)
+	assert.Equal(t, "domru_proxy_home/status", door.Availability)
+	assert.Equal(t, "domru-home-op2-balance", topics.BalanceTopics().EntityID)
+
+	placeID, err := topics.parsePlaceCallTopic(topics.PlaceCallTopics(345).Command)
+	assert.NoError(t, err)
+	assert.Equal(t, 345, placeID)
+
+	// Without an instance the operator is left out, the IDs stay as they were
+	legacy := Topics{OperatorID: 2}.DoorLockTopics(12, 345)
+	assert.Equal(t, "domru-door_12_345", legacy.DeviceID)
+	assert.Equal(t, "domru/place_345/availability", legacy.PlaceAvailability)
+	assert.Equal(t, "domru_proxy/status", legacy.Availability)
+}
@@ -10,4 +10,11 @@ type HomePageData struct {
 	Phone      string
 	Cameras    models.CamerasResponse
 	Places     models.PlacesResponse
+	// Accounts are the places of the additional accounts of the credentials file.
+	Accounts []AccountPlaces
+}
+
+type AccountPlaces struct {
+	Name   string
+	Places models.PlacesResponse
 }
@@ -9,16 +9,29 @@ type AccountsPageData struct {
 	Phone      string
 	BaseURL    string
 	LoginError string
+	// Account is the name of the account to log in to, empty for the primary account.
+	Account string
 }
 
 type LoginPageData struct {
 	LoginError string
 	Phone      string
 	BaseURL    string
+	// Account is the name of the account to log in to, empty for the primary account.
+	Account string
+	// InMemory warns that the credentials are kept in memory only and are lost on restart.
+	InMemory bool
 }
 
 type SMSPageData struct {
 	Phone      string
 	BaseURL    string
 	LoginError string
+	// Account is the name of the account to log in to, empty for the primary account.
+	Account string
+}
+
+type ErrorPageData struct {
+	Status  int
+	Message string
 }
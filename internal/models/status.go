@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type StatusPageData struct {
+	BaseURL    string
+	Phone      string
+	OperatorID int
+
+	// TokenExpiresAt is zero when the expiry can't be read from the token.
+	TokenExpiresAt time.Time
+	TokenExpired   bool
+
+	// MqttEnabled is false when the addon runs without MQTT, the discovery fields are empty then.
+	MqttEnabled     bool
+	DoorsDiscovered int
+	DiscoveryFailed int
+	LastDiscovery   time.Time
+
+	Errors []string
+}
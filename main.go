@@ -10,11 +10,16 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
+	// Embedded, so --timezone works on images without a timezone database
+	_ "time/tzdata"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
@@ -22,10 +27,12 @@ import (
 	"github.com/090809/homeassistant-domru/internal/domru"
 	"github.com/090809/homeassistant-domru/internal/domru/constants"
 	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
+	"github.com/090809/homeassistant-domru/internal/events"
 	"github.com/090809/homeassistant-domru/internal/homeassistant"
 	"github.com/090809/homeassistant-domru/pkg/auth"
 	"github.com/090809/homeassistant-domru/pkg/authorizedhttp"
 	"github.com/090809/homeassistant-domru/pkg/logging"
+	"github.com/090809/homeassistant-domru/pkg/requestlog"
 	"github.com/090809/homeassistant-domru/pkg/reverseproxy"
 	"github.com/090809/homeassistant-domru/pkg/tokenmanagement"
 )
@@ -33,22 +40,213 @@ import (
 //go:embed templates/*
 var templateFs embed.FS
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+const (
+	flagPort                 = "port"
+	flagRefreshToken         = "refresh-token"
+	flagOperatorID           = "operator-id"
+	flagCredentialsFile      = "credentials"
+	flagLogLevel             = "log-level"
+	flagHaConfigFile         = "ha-config"
+	flagWatchCredentials     = "watch-credentials"
+	flagTokenRefreshSkew     = "token-refresh-skew"
+	flagBalanceInterval      = "mqtt-balance-interval"
+	flagRediscovery          = "mqtt-rediscovery-interval"
+	flagMqttOptimistic       = "mqtt-optimistic"
+	flagMqttRelockDelay      = "mqtt-relock-delay"
+	flagMqttOpenDebounce     = "mqtt-open-debounce"
+	flagMqttStopGrace        = "mqtt-stop-grace-period"
+	flagMqttDiagnostics      = "mqtt-diagnostics-interval"
+	flagMqttPlaceFailures    = "mqtt-place-failure-threshold"
+	flagMqttReconnectMax     = "mqtt-max-reconnect-interval"
+	flagMqttReconnects       = "mqtt-reconnect-attempts"
+	flagShutdownDrain        = "shutdown-drain-timeout"
+	flagExtraCredentials     = "extra-credentials"
+	flagMqttClientID         = "mqtt-client-id"
+	flagMqttV5               = "mqtt-v5"
+	flagMqttDryRun           = "mqtt-dry-run"
+	flagMotionOffDelay       = "mqtt-motion-off-delay"
+	flagLogProxySample       = "log-proxy-sample"
+	flagDoorPrecheck         = "door-precheck"
+	flagMqttDoorCameras      = "mqtt-door-cameras"
+	flagMqttCallButtons      = "mqtt-call-buttons"
+	flagSnapshotPlaceholder  = "snapshot-placeholder"
+	flagMqttTopicPrefix      = "mqtt-topic-prefix"
+	flagMqttDiscoveryPrefix  = "mqtt-discovery-prefix"
+	flagMqttInstanceSuffix   = "mqtt-instance-suffix"
+	flagAccessLog            = "access-log"
+	flagSnapshotTemplates    = "snapshot-url-templates"
+	flagStreamTemplates      = "stream-url-templates"
+	flagOpenDoor             = "open-door"
+	flagPlaceID              = "place-id"
+	flagAccessControlID      = "access-control-id"
+	flagMqttRegistryFile     = "mqtt-registry-file"
+	flagMqttSettingsFile     = "mqtt-settings-file"
+	flagMqttOpenCountsFile   = "mqtt-open-counts-file"
+	flagMqttAvailabilityJSON = "mqtt-availability-json"
+	flagHTTPMaxIdle          = "http-max-idle-conns"
+	flagHTTPMaxIdlePerHost   = "http-max-idle-conns-per-host"
+	flagHTTPIdleTimeout      = "http-idle-timeout"
+	flagHTTP2                = "http2"
+	flagStreamFlush          = "stream-flush-interval"
+	flagLogUnsafe            = "log-unsafe"
+	flagMqttLockCommand      = "mqtt-lock-command"
+	flagMqttPublishAttempts  = "mqtt-publish-attempts"
+	flagMqttPublishTimeout   = "mqtt-publish-timeout"
+	flagMqttDoorCameraIDs    = "mqtt-door-camera-ids"
+	flagMqttLocale           = "mqtt-locale"
+	flagMqttDoorNames        = "mqtt-door-names"
+	flagTimezone             = "timezone"
+	flagSelftest             = "selftest"
+	flagPrintDiscovery       = "print-discovery"
+	flagRequestLogSize       = "request-log-size"
+	flagStreamProxy          = "stream-proxy"
+	flagStreamMaxPerCamera   = "stream-max-per-camera"
+	flagMqttURL              = "mqtt-url"
+	flagMqttHost             = "mqtt-host"
+	flagMqttPort             = "mqtt-port"
+	flagMqttUser             = "mqtt-user"
+	flagMqttPassword         = "mqtt-password"
+	flagMqttTLS              = "mqtt-tls"
+	flagMqttCAFile           = "mqtt-ca-file"
+	flagMqttCertFile         = "mqtt-cert-file"
+	flagMqttKeyFile          = "mqtt-key-file"
+	flagMqttTLSInsecure      = "mqtt-tls-insecure"
+	flagExternalURL          = "external-url"
+	flagMqttCameraInterval   = "mqtt-camera-interval"
+	flagMqttEntityType       = "mqtt-entity-type"
+	flagMqttBirthTopic       = "mqtt-birth-topic"
+	flagBaseURL              = "base-url"
+	flagMqttInclude          = "mqtt-include"
+	flagMqttExclude          = "mqtt-exclude"
+	flagEventsInterval       = "events-interval"
+	flagEventsMaxClients     = "events-max-clients"
+	flagEventsCursorFile     = "events-cursor-file"
+	flagCredentialsStore     = "credentials-backend"
+	flagRedisAddr            = "redis-addr"
+	flagRedisPassword        = "redis-password"
+	flagRedisDB              = "redis-db"
+	flagRedisKey             = "redis-key"
+	flagCredentialsKey       = "credentials-key"
+	flagCredentialsKeyFile   = "credentials-key-file"
+
+	flagMqttQoS                = "mqtt-qos"
+	flagMqttRetain             = "mqtt-retain"
+	flagMqttDiscoveryQoS       = "mqtt-discovery-qos"
+	flagMqttDiscoveryRetain    = "mqtt-discovery-retain"
+	flagMqttStateQoS           = "mqtt-state-qos"
+	flagMqttStateRetain        = "mqtt-state-retain"
+	flagMqttAvailabilityQoS    = "mqtt-availability-qos"
+	flagMqttAvailabilityRetain = "mqtt-availability-retain"
+)
+
 const (
-	flagPort            = "port"
-	flagRefreshToken    = "refresh-token"
-	flagOperatorID      = "operator-id"
-	flagCredentialsFile = "credentials"
-	flagLogLevel        = "log-level"
-	flagHaConfigFile    = "ha-config"
+	credentialsBackendFile  = "file"
+	credentialsBackendEnv   = "env"
+	credentialsBackendRedis = "redis"
+
+	// memoryCredentialsFile as the credentials file keeps the credentials in memory only.
+	memoryCredentialsFile = "memory://"
 )
 
 func initFlags() {
 	pflag.Int(flagPort, 8080, "listen port")
+	pflag.String(flagBaseURL, constants.BaseUrl, "Dom.ru API base URL")
 	pflag.String(flagHaConfigFile, "/data/options.json", "home assistant config file")
 	pflag.String(flagCredentialsFile, "/data/accounts.json", "credentials file path (i.e: /data/accounts.json")
 	pflag.String(flagLogLevel, "info", "log level")
 	pflag.String(flagRefreshToken, "", "refresh token")
 	pflag.Int(flagOperatorID, 0, "operator id")
+	pflag.Bool(flagWatchCredentials, false, "reload credentials when the credentials file is changed externally")
+	pflag.Duration(flagTokenRefreshSkew, time.Minute, "how long before its expiry the access token is refreshed, 0 refreshes it only after Dom.ru rejects it")
+	pflag.Duration(flagBalanceInterval, time.Hour, "balance sensor refresh interval, 0 disables the sensor")
+	pflag.Bool(flagDoorPrecheck, false, "check that a door is online and may be opened before opening it")
+	pflag.Int(flagLogProxySample, 1, "log only one of every N proxied requests at debug level")
+	pflag.Duration(flagMotionOffDelay, 30*time.Second, "how long camera motion sensors stay on after a motion event")
+	pflag.String(flagMqttClientID, homeassistant.DefaultClientID, "MQTT client ID, must be unique per addon instance (MQTT_CLIENT_ID overrides it)")
+	pflag.Bool(flagMqttV5, false, "connect to the MQTT broker with MQTT 5 instead of 3.1.1")
+	pflag.StringSlice(flagExtraCredentials, []string{}, "credentials files of accounts under other operators, their doors are published via MQTT too")
+	pflag.Duration(flagShutdownDrain, 10*time.Second, "how long proxied streams may keep running on shutdown before they are closed")
+	pflag.Bool(flagMqttOptimistic, false, "let Home Assistant assume lock states instead of waiting for a confirmed state")
+	pflag.Duration(flagMqttReconnectMax, 2*time.Minute, "maximum wait between reconnects to the MQTT broker")
+	pflag.Int(flagMqttReconnects, 0, "reconnects to the MQTT broker before giving up, 0 never gives up")
+	pflag.Int(flagMqttPlaceFailures, 3, "consecutive failed Dom.ru requests of a place until its entities are unavailable, 0 disables it")
+	pflag.Duration(flagMqttDiagnostics, time.Minute, "refresh interval of the session health diagnostic sensors, 0 disables them")
+	pflag.Duration(flagMqttRelockDelay, 5*time.Second, "how long an opened door is reported unlocked")
+	pflag.Duration(flagMqttOpenDebounce, 3*time.Second, "how long after opening a door repeated open commands are acknowledged without opening it again")
+	pflag.Duration(flagMqttStopGrace, 5*time.Second, "how long the shutdown waits for MQTT commands being handled, i.e. a door being opened")
+	pflag.Duration(flagRediscovery, 6*time.Hour, "interval of MQTT re-discovery of added and removed devices, 0 disables it")
+	pflag.StringSlice(flagMqttInclude, nil, "access controls to expose via MQTT, by ID or name glob (default all)")
+	pflag.StringSlice(flagMqttExclude, nil, "access controls to hide from MQTT, by ID or name glob")
+	pflag.Duration(flagEventsInterval, 15*time.Second, "live events polling interval, 0 disables events")
+	pflag.Int(flagEventsMaxClients, 10, "maximum number of concurrent live event streams")
+	pflag.String(flagEventsCursorFile, "/data/events_cursor.json", "file keeping the events seen last per place, so a restart neither replays nor misses doorbell rings, empty disables it")
+	pflag.String(flagCredentialsStore, credentialsBackendFile, "credentials storage backend: file, env or redis")
+	pflag.String(flagRedisAddr, "localhost:6379", "redis address for the redis credentials backend")
+	pflag.String(flagRedisPassword, "", "redis password for the redis credentials backend")
+	pflag.Int(flagRedisDB, 0, "redis database for the redis credentials backend")
+	pflag.String(flagRedisKey, "domru:credentials", "redis key for the redis credentials backend")
+	pflag.String(flagCredentialsKey, "", "secret encrypting the credentials file, empty keeps it plaintext")
+	pflag.String(flagCredentialsKeyFile, "", "file holding the secret encrypting the credentials file")
+	pflag.Int(flagMqttQoS, 1, "MQTT QoS of all publishes, unless set for the category")
+	pflag.Bool(flagMqttRetain, true, "retain all MQTT publishes, unless set for the category")
+	pflag.Int(flagMqttDiscoveryQoS, 1, "MQTT QoS for discovery configs")
+	pflag.Bool(flagMqttDiscoveryRetain, true, "retain MQTT discovery configs")
+	pflag.Int(flagMqttStateQoS, 1, "MQTT QoS for entity states")
+	pflag.Bool(flagMqttStateRetain, true, "retain MQTT entity states")
+	pflag.Int(flagMqttAvailabilityQoS, 1, "MQTT QoS for the availability topic")
+	pflag.Bool(flagMqttAvailabilityRetain, true, "retain the MQTT availability topic")
+	pflag.Bool(flagMqttDoorCameras, true, "publish a camera entity with the snapshot of every door")
+	pflag.Bool(flagMqttCallButtons, false, "publish buttons answering and rejecting the intercom calls of every place")
+	pflag.Bool(flagSnapshotPlaceholder, true, "serve a placeholder image when a snapshot can't be retrieved instead of an error")
+	pflag.String(flagMqttTopicPrefix, homeassistant.DefaultTopicPrefix, "namespace of the MQTT topics and entity IDs, must be unique per addon instance on a broker")
+	pflag.String(flagMqttDiscoveryPrefix, homeassistant.DefaultDiscoveryPrefix, "discovery prefix configured in Home Assistant")
+	pflag.String(flagMqttInstanceSuffix, "", "suffix of the entity IDs telling addon instances on a broker apart, adds the operator of the account to the IDs as well")
+	pflag.Bool(flagAccessLog, false, "log every request with its status and duration at info level")
+	pflag.StringSlice(flagSnapshotTemplates, nil, "snapshot URL templates by camera model as model=template, \"default\" applies to other models")
+	pflag.StringSlice(flagStreamTemplates, nil, "stream URL templates by camera model as model=template, \"default\" applies to other models")
+	pflag.Bool(flagOpenDoor, false, "open the door given by --place-id and --access-control-id and exit")
+	pflag.Int(flagPlaceID, 0, "place of the door opened with --open-door")
+	pflag.Int(flagAccessControlID, 0, "access control of the door opened with --open-door")
+	pflag.String(flagMqttRegistryFile, "/data/mqtt_entities.json", "file remembering the published MQTT entities, so stale ones are removed after a restart or an account change")
+	pflag.String(flagMqttSettingsFile, "/data/mqtt_settings.json", "file remembering the settings changed over MQTT, i.e. the relock delay")
+	pflag.String(flagMqttOpenCountsFile, "/data/mqtt_open_counts.json", "file keeping the open counts of the doors across restarts, empty counts from zero on every start")
+	pflag.Bool(flagMqttAvailabilityJSON, false, "publish the bridge availability as JSON with the version, start time, operator and entity count")
+	pflag.Int(flagHTTPMaxIdle, 100, "maximum idle connections to Dom.ru kept open")
+	pflag.Int(flagHTTPMaxIdlePerHost, 10, "maximum idle connections kept open per Dom.ru host")
+	pflag.Duration(flagHTTPIdleTimeout, 90*time.Second, "how long idle connections to Dom.ru are kept open")
+	pflag.Bool(flagHTTP2, true, "use HTTP/2 to Dom.ru where supported")
+	pflag.Duration(flagStreamFlush, 100*time.Millisecond, "how often proxied responses are flushed while streaming, negative flushes after every write")
+	pflag.Bool(flagLogUnsafe, false, "log unmasked addresses and account IDs at debug level, don't share such logs")
+	pflag.StringSlice(flagMqttLockCommand, nil, "LOCK command handling by access control type as type=confirm|ignore|unsupported, \"default\" applies to other types")
+	pflag.Int(flagMqttPublishAttempts, 3, "how often MQTT discovery configs and initial states are published until the broker acknowledges them")
+	pflag.Duration(flagMqttPublishTimeout, time.Second, "how long each MQTT discovery publish waits for the broker to acknowledge it")
+	pflag.StringSlice(flagMqttDoorCameraIDs, nil, "camera showing a door, when it's a separate device, as accessControlID=cameraID")
+	pflag.String(flagMqttLocale, string(homeassistant.LocaleEN), "language of the entity names: en or ru")
+	pflag.StringSlice(flagMqttDoorNames, nil, "names of doors in Home Assistant instead of the Dom.ru ones, as accessControlID=name")
+	pflag.String(flagTimezone, "", "timezone of event and status times, i.e. Europe/Moscow (default the system timezone)")
+	pflag.Bool(flagSelftest, false, "check the credentials, Dom.ru API, MQTT broker and Home Assistant host, print the results and exit")
+	pflag.Bool(flagMqttDryRun, false, "log the MQTT discovery instead of publishing it and don't receive commands")
+	pflag.Bool(flagPrintDiscovery, false, "print the MQTT discovery of the places without publishing it and exit")
+	pflag.Int(flagRequestLogSize, 0, "how many recent proxied requests GET /admin/requests lists, 0 disables the list")
+	pflag.Bool(flagStreamProxy, false, "proxy camera streams instead of redirecting clients to Dom.ru")
+	pflag.Int(flagStreamMaxPerCamera, 4, "maximum concurrent proxied streams of a camera, 0 is unlimited")
+	pflag.String(flagMqttURL, "", "MQTT broker URL, i.e. wss://mqtt.example.com/mqtt, takes precedence over the host and port")
+	pflag.String(flagMqttHost, "", "MQTT broker host (default MQTT_HOST or the Mosquitto addon)")
+	pflag.Int(flagMqttPort, 0, "MQTT broker port (default MQTT_PORT or 1883)")
+	pflag.String(flagMqttUser, "", "MQTT broker username (default MQTT_USER)")
+	pflag.String(flagMqttPassword, "", "MQTT broker password (default MQTT_PASSWORD)")
+	pflag.Bool(flagMqttTLS, false, "connect to the MQTT broker over TLS (default port 8883)")
+	pflag.String(flagMqttCAFile, "", "PEM CA certificates the MQTT broker is verified with (default the system roots)")
+	pflag.String(flagMqttCertFile, "", "PEM client certificate for the MQTT broker")
+	pflag.String(flagMqttKeyFile, "", "PEM key of the MQTT client certificate")
+	pflag.Bool(flagMqttTLSInsecure, false, "skip verification of the MQTT broker certificate")
+	pflag.String(flagExternalURL, "", "URL Home Assistant reaches the addon at, for entity pictures (default the supervisor address)")
+	pflag.Duration(flagMqttCameraInterval, time.Minute, "snapshot refresh interval of the camera entities, 0 disables them")
+	pflag.String(flagMqttEntityType, string(homeassistant.DoorEntityLock), "entities doors are published as: lock, button or both")
+	pflag.String(flagMqttBirthTopic, homeassistant.DefaultBirthTopic, "topic Home Assistant announces its start on, empty disables re-publishing discovery")
 	pflag.Parse()
 
 	err := viper.BindPFlags(pflag.CommandLine)
@@ -80,19 +278,44 @@ func main() {
 	initFlags()
 
 	logger := initLogger()
+	cfg, err := loadConfig(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	listenAddr := fmt.Sprintf(":%d", viper.GetInt(flagPort))
-	credentialsFile := viper.GetString(flagCredentialsFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listenAddr := fmt.Sprintf(":%d", cfg.Port)
+	credentialsFile := cfg.Credentials.File
+
+	upstream, err := parseBaseURL(cfg.BaseURL)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", flagBaseURL, err)
+	}
+	baseURL := upstream.String()
 
 	retryableClient := retryablehttp.NewClient()
 	retryableClient.RetryMax = 5
+	retryableClient.HTTPClient.Transport = authorizedhttp.TransportOptions{
+		MaxIdleConns:        cfg.HTTP.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.HTTP.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.HTTP.IdleTimeout,
+		DisableHTTP2:        !cfg.HTTP.HTTP2,
+	}.NewBaseTransport()
+
+	credentialsStore, err := newCredentialsStore(cfg.Credentials, logger)
+	if err != nil {
+		log.Fatalf("Unable to create credentials store: %v", err)
+	}
 
-	credentialsStore := auth.NewFileCredentialsStore(credentialsFile)
-
-	overrideCredentialsWithFlags(credentialsStore, logger)
+	overrideCredentialsWithFlags(credentialsStore, cfg.Credentials, logger)
 
 	authProvider := tokenmanagement.NewValidTokenProvider(credentialsStore)
 	authProvider.Logger = logger
+	authProvider.BaseURL = baseURL
+	authProvider.RefreshSkew = cfg.Credentials.RefreshSkew
 	authClient := authorizedhttp.NewClient(
 		authProvider,
 		authProvider,
@@ -100,48 +323,208 @@ func main() {
 	)
 	authClient.DefaultClient = retryableClient.StandardClient()
 	authClient.Logger = logger
+	authClient.OnError = authProvider.RecordError
 
-	domruAPI := domru.NewDomruAPI(authClient)
+	urlTemplates := cfg.URLs.URLTemplates()
+
+	domruAPI := domru.NewDomruAPI(authClient, baseURL)
 	domruAPI.Logger = logger
 
-	mqttIntegration := homeassistant.NewMqttIntegration(domruAPI, logger)
+	if cfg.OpenDoor.Enabled {
+		os.Exit(runOpenDoor(domruAPI, cfg.OpenDoor.PlaceID, cfg.OpenDoor.AccessControlID, cfg.DoorPrecheck))
+	}
+
+	mqttTLS, err := cfg.MQTT.tlsConfig()
+	if err != nil {
+		logger.With("err", err.Error()).Error("Unable to set up MQTT TLS")
+		os.Exit(1)
+	}
+	mqttIntegration := homeassistant.NewMqttIntegration(domruAPI, logger, homeassistant.BrokerSettings{
+		URL:      cfg.MQTT.URL,
+		Host:     cfg.MQTT.Host,
+		Port:     cfg.MQTT.Port,
+		Username: cfg.MQTT.Username,
+		Password: cfg.MQTT.Password,
+		TLS:      mqttTLS,
+	}, cfg.ExternalURL)
+	mqttIntegration.BalanceInterval = cfg.MQTT.BalanceInterval
+	mqttIntegration.RediscoveryInterval = cfg.MQTT.RediscoveryInterval
+	mqttIntegration.Optimistic = cfg.MQTT.Optimistic
+	mqttIntegration.RelockDelay = cfg.MQTT.RelockDelay
+	mqttIntegration.OpenDebounce = cfg.MQTT.OpenDebounce
+	mqttIntegration.StopGracePeriod = cfg.MQTT.StopGracePeriod
+	mqttIntegration.TokenStatus = authProvider
+	mqttIntegration.Version = version
+	mqttIntegration.DiagnosticsInterval = cfg.MQTT.DiagnosticsInterval
+	mqttIntegration.PlaceFailureThreshold = cfg.MQTT.PlaceFailureThreshold
+	mqttIntegration.MaxReconnectInterval = cfg.MQTT.MaxReconnectInterval
+	mqttIntegration.ReconnectAttempts = cfg.MQTT.ReconnectAttempts
+	mqttIntegration.ClientID = cfg.MQTT.ClientID
+	mqttIntegration.MQTTv5 = cfg.MQTT.V5
+	mqttIntegration.DryRun = cfg.MQTT.DryRun
+	mqttIntegration.DoorPrecheck = cfg.DoorPrecheck
+	mqttIntegration.DoorCameras = cfg.MQTT.DoorCameras
+	mqttIntegration.CallButtons = cfg.MQTT.CallButtons
+	mqttIntegration.CameraInterval = cfg.MQTT.CameraInterval
+	mqttIntegration.DoorEntity, _ = homeassistant.ParseDoorEntityType(cfg.MQTT.EntityType)
+	mqttIntegration.BirthTopic = cfg.MQTT.BirthTopic
+	mqttIntegration.Topics = homeassistant.Topics{Prefix: cfg.MQTT.TopicPrefix, DiscoveryPrefix: cfg.MQTT.DiscoveryPrefix, Instance: cfg.MQTT.InstanceSuffix}
+	if cfg.MQTT.InstanceSuffix != "" {
+		// The IDs keep the operator of the start, an account of another operator gets new IDs on restart
+		if credentials, loadErr := credentialsStore.LoadCredentials(); loadErr == nil {
+			mqttIntegration.Topics.OperatorID = credentials.OperatorID
+		}
+	}
+	mqttIntegration.URLTemplates = urlTemplates
+	mqttIntegration.DoorCameraIDs = cfg.MQTT.doorCameraIDs()
+	mqttIntegration.Locale, _ = homeassistant.ParseLocale(cfg.MQTT.Locale)
+	mqttIntegration.DoorNames, _ = homeassistant.ParseDoorNames(cfg.MQTT.DoorNames)
+	mqttIntegration.RegistryFile = cfg.MQTT.RegistryFile
+	mqttIntegration.SettingsFile = cfg.MQTT.SettingsFile
+	mqttIntegration.OpenCountsFile = cfg.MQTT.OpenCountsFile
+	mqttIntegration.AvailabilityJSON = cfg.MQTT.AvailabilityJSON
+	mqttIntegration.Credentials = credentialsStore
+	mqttIntegration.LogUnsafe = cfg.LogUnsafe
+	mqttIntegration.PublishAttempts = cfg.MQTT.PublishAttempts
+	mqttIntegration.PublishTimeout = cfg.MQTT.PublishTimeout
+	mqttIntegration.LockCommand = cfg.MQTT.lockCommandModes()
+	mqttIntegration.DiscoveryPublish = publishOptions(cfg.MQTT.DiscoveryQoS, cfg.MQTT.DiscoveryRetain)
+	mqttIntegration.StatePublish = publishOptions(cfg.MQTT.StateQoS, cfg.MQTT.StateRetain)
+	mqttIntegration.AvailabilityPublish = publishOptions(cfg.MQTT.AvailabilityQoS, cfg.MQTT.AvailabilityRetain)
+	mqttIntegration.Filter = homeassistant.EntityFilter{
+		Include: cfg.MQTT.Include,
+		Exclude: cfg.MQTT.Exclude,
+	}
+
+	if cfg.Selftest {
+		os.Exit(runSelftest(os.Stdout, newSelftestChecks(credentialsStore, authProvider, domruAPI, mqttIntegration)))
+	}
+	if cfg.PrintDiscovery {
+		os.Exit(runPrintDiscovery(os.Stdout, mqttIntegration))
+	}
+
+	addOperatorAccounts(mqttIntegration, cfg.Credentials, retryableClient.StandardClient(), baseURL, logger)
+	fileAccounts, fileProviders := newFileAccounts(credentialsStore, cfg.Credentials, retryableClient.StandardClient(), baseURL, logger)
+	for _, account := range fileAccounts {
+		if err = mqttIntegration.AddAccount(account.Name, account.API); err != nil {
+			logger.With("account", account.Name).With("err", err.Error()).Error("Unable to add account to MQTT")
+		}
+	}
+
+	var eventsCursors *events.Cursors
+	if cfg.EventsCursorFile != "" {
+		eventsCursors = events.NewCursors(cfg.EventsCursorFile)
+		eventsCursors.Logger = logger
+	}
+	eventsPoller := events.NewPoller(domruAPI)
+	eventsPoller.Logger = logger
+	eventsPoller.Interval = cfg.EventsInterval
+	eventsPoller.Cursors = eventsCursors
+	go eventsPoller.Run(ctx)
+
+	if eventsPoller.Interval > 0 {
+		mqttIntegration.Events = eventsPoller
+		mqttIntegration.AccountEvents = func(name string, api *domru.APIWrapper) homeassistant.EventSource {
+			accountPoller := events.NewPoller(api)
+			accountPoller.Logger = logger.With("account", name)
+			accountPoller.Interval = cfg.EventsInterval
+			accountPoller.Cursors = eventsCursors
+			accountPoller.Account = name
+			go accountPoller.Run(ctx)
+			return accountPoller
+		}
+		mqttIntegration.MotionOffDelay = cfg.MQTT.MotionOffDelay
+		mqttIntegration.Location = cfg.location()
+	}
 	go mqttIntegration.Start()
 
 	handlers := controllers.NewHandlers(templateFs, credentialsStore, domruAPI)
 	handlers.Logger = logger
+	handlers.Discovery = mqttIntegration
+	handlers.DiscoveryCleanup = mqttIntegration
+	handlers.Rediscovery = mqttIntegration
+	handlers.Session = authProvider
+	handlers.DoorOpens = mqttIntegration
+	handlers.MQTTTopics = mqttIntegration.Topics
+	handlers.Accounts = fileAccounts
+	handlers.URLTemplates = urlTemplates
+	handlers.SnapshotPlaceholder = cfg.URLs.SnapshotPlaceholder
+	handlers.Location = cfg.location()
+	handlers.StreamProxy = cfg.HTTP.StreamProxy
+	handlers.StreamClient = &http.Client{Transport: retryableClient.HTTPClient.Transport}
+	handlers.MaxStreamsPerCamera = cfg.HTTP.StreamMaxPerCamera
+	if eventsPoller.Interval > 0 {
+		handlers.Events = eventsPoller
+		handlers.MaxEventStreams = cfg.EventsMaxClients
+	}
 
-	upstream, err := url.Parse(constants.BaseUrl)
-	if err != nil {
-		log.Fatal(err)
+	if fileStore, isFile := credentialsStore.(*auth.FileCredentialsStore); cfg.Credentials.Watch && isFile {
+		providers := append([]*tokenmanagement.ValidTokenProvider{authProvider}, fileProviders...)
+		onChange := credentialsChangeHandler(credentialsStore, providers, mqttIntegration.Rediscover, logger)
+		watchCredentials(fileStore, credentialsFile, func() {
+			onChange()
+			handlers.CredentialsChanged()
+		}, logger)
 	}
 
 	proxy := reverseproxy.NewReverseProxy(upstream)
 	proxy.Client = authClient
-	proxyHandler := proxy.ProxyRequestHandler()
+	proxy.ObserveResponse = func(r *http.Request, resp *http.Response) {
+		recordUpstreamStatus(r, resp)
+		handlers.ObserveDoorOpen(r, resp)
+	}
+	proxy.FlushInterval = cfg.HTTP.StreamFlushInterval
+	var proxyHandler http.Handler = http.HandlerFunc(proxy.ProxyRequestHandler())
+	if cfg.RequestLogSize > 0 {
+		requestLog := requestlog.NewLog(cfg.RequestLogSize)
+		proxyHandler = requestLogMiddleware(requestLog, proxyHandler)
+		handlers.RequestLog = requestLog
+	}
+	proxyLogSampler := logging.NewSampler(cfg.LogProxySample)
 
 	http.HandleFunc("GET /login", handlers.LoginPageHandler)
 	http.HandleFunc("POST /login", handlers.LoginPhoneInputHandler)
 	http.HandleFunc("GET /login/address", handlers.SelectAccountHandler)
 	http.HandleFunc("POST /loginWithPassword", handlers.LoginWithPasswordHandler)
 	http.HandleFunc("POST /sms", handlers.SubmitSmsCodeHandler)
+	http.HandleFunc("POST /logout", handlers.LogoutHandler)
 	http.HandleFunc("GET /stream/{cameraId}", handlers.StreamController)
+	http.HandleFunc("GET /archive/{cameraId}", checkCredentialsMiddleware(credentialsStore, handlers.ArchiveHandler))
 	http.HandleFunc("GET /pages/home.html", checkCredentialsMiddleware(credentialsStore, handlers.HomeHandler))
+	http.HandleFunc("GET /pages/status.html", checkCredentialsMiddleware(credentialsStore, handlers.StatusHandler))
+	http.HandleFunc("GET /events", checkCredentialsMiddleware(credentialsStore, handlers.EventsHandler))
+	http.HandleFunc("GET /api/devices", checkCredentialsMiddleware(credentialsStore, handlers.DevicesHandler))
+	http.HandleFunc("GET /api/auth/status", handlers.AuthStatusHandler)
+	http.HandleFunc("GET /admin/requests", checkCredentialsMiddleware(credentialsStore, handlers.RequestsHandler))
+	http.HandleFunc("GET /rest/v1/places/{placeId}/accesscontrols/{accessControlId}/videosnapshots", handlers.SnapshotHandler)
+	if cfg.DoorPrecheck {
+		// Without the pre-check door opens are proxied to Dom.ru as is
+		http.HandleFunc("POST /rest/v1/places/{placeId}/accesscontrols/{accessControlId}/actions", handlers.OpenDoorHandler)
+	}
+	http.HandleFunc("POST /accounts/{account}/places/{placeId}/accesscontrols/{accessControlId}/actions", handlers.OpenDoorHandler)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
-			logger.With("url", r.URL.String()).Debug("proxying request")
-			proxyHandler(w, r)
+			if logger.Enabled(r.Context(), slog.LevelDebug) && proxyLogSampler.Allow() {
+				logger.With("url", r.URL.String()).DebugContext(r.Context(), "proxying request")
+			}
+			proxyHandler.ServeHTTP(w, r)
 		} else {
-			logger.Debug("Redirecting to /pages/home.html")
+			logger.DebugContext(r.Context(), "Redirecting to /pages/home.html")
 			http.Redirect(w, r, "/pages/home.html", http.StatusMovedPermanently)
 		}
 	})
 
 	log.Printf("Listening on %s\n", listenAddr)
 
+	handler := recoveryMiddleware(logger, handlers.RenderInternalError, http.DefaultServeMux)
+	if cfg.AccessLog {
+		handler = accessLogMiddleware(logger, handler)
+	}
+
 	server := &http.Server{
 		Addr:         listenAddr,
-		Handler:      nil,
+		Handler:      requestIDMiddleware(handler),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  50 * time.Second,
@@ -159,29 +542,114 @@ func main() {
 	<-stop
 
 	logger.Info("Shutting down server...")
+	cancel()
 
 	// Shutdown MQTT client
 	mqttIntegration.Stop()
 
+	// Let proxied streams drain, then close them cleanly before the server shutdown deadline
+	drainTimeout := cfg.ShutdownDrain
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer drainCancel()
+	go func() {
+		logger.Info("Draining proxied requests", "active", proxy.Active(), "timeout", drainTimeout)
+		if err := proxy.Shutdown(drainCtx); err != nil {
+			logger.Warn("Closed proxied requests that did not finish in time", "active", proxy.Active())
+		}
+	}()
+
+	// Event streams never end by themselves, the server shutdown would wait for them until its deadline
+	handlers.CloseEventStreams()
+
 	// Shutdown HTTP server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), drainTimeout+5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.Error("Server shutdown failed", "error", err)
 	}
 
 	logger.Info("Server gracefully stopped")
 }
 
-func overrideCredentialsWithFlags(credentialsStore *auth.FileCredentialsStore, logger *slog.Logger) {
-	sanitizedToken := sanitizing_utils.KeepFirstNCharacters(viper.GetString(flagRefreshToken), 7)
-	logger.With("refreshToken", sanitizedToken).With("operator-id", viper.GetInt(flagOperatorID)).Debug("Checking flags")
-	if viper.GetString(flagRefreshToken) != "" && viper.GetInt(flagOperatorID) != 0 {
+// parseBaseURL validates the Dom.ru base URL and strips the trailing slash.
+func parseBaseURL(raw string) (*url.URL, error) {
+	parsed, err := url.Parse(strings.TrimRight(raw, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q, expected http or https", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("host is empty")
+	}
+	return parsed, nil
+}
+
+func newCredentialsStore(cfg CredentialsConfig, logger *slog.Logger) (auth.CredentialsStore, error) {
+	switch cfg.Backend {
+	case credentialsBackendFile:
+		if cfg.File == memoryCredentialsFile {
+			logger.Warn("Keeping credentials in memory, they are lost on restart")
+			return auth.NewMemoryCredentialsStore(), nil
+		}
+		key, err := cfg.key()
+		if err != nil {
+			return nil, err
+		}
+		store := auth.NewFileCredentialsStore(cfg.File)
+		store.Key = key
+		if !writableDir(filepath.Dir(cfg.File)) {
+			logger.With("file", cfg.File).Warn("Credentials directory is not writable, keeping credentials in memory, they are lost on restart")
+			return seededMemoryStore(store), nil
+		}
+		return store, nil
+	case credentialsBackendEnv:
+		return auth.NewEnvCredentialsStore("DOMRU_"), nil
+	case credentialsBackendRedis:
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+		return auth.NewRedisCredentialsStore(client, cfg.RedisKey), nil
+	default:
+		return nil, fmt.Errorf("unknown credentials backend %q", cfg.Backend)
+	}
+}
+
+// seededMemoryStore returns a memory store holding the credentials of the file, if it can be read.
+func seededMemoryStore(file *auth.FileCredentialsStore) *auth.MemoryCredentialsStore {
+	memory := auth.NewMemoryCredentialsStore()
+	if credentials, err := file.LoadCredentials(); err == nil {
+		_ = memory.SaveCredentials(credentials)
+	}
+	return memory
+}
+
+// writableDir reports whether files can be created in the directory, creating it when it's missing.
+func writableDir(dir string) bool {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return false
+	}
+	file, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return false
+	}
+	file.Close()
+	_ = os.Remove(file.Name())
+	return true
+}
+
+func overrideCredentialsWithFlags(credentialsStore auth.CredentialsStore, cfg CredentialsConfig, logger *slog.Logger) {
+	sanitizedToken := sanitizing_utils.KeepFirstNCharacters(cfg.RefreshToken, 7)
+	logger.With("refreshToken", sanitizedToken).With("operator-id", cfg.OperatorID).Debug("Checking flags")
+	if cfg.RefreshToken != "" && cfg.OperatorID != 0 {
 		logger.Info("Overriding credentials with flags")
 		credentials := auth.Credentials{
 			AccessToken:  "",
-			RefreshToken: viper.GetString(flagRefreshToken),
-			OperatorID:   viper.GetInt(flagOperatorID),
+			RefreshToken: cfg.RefreshToken,
+			OperatorID:   cfg.OperatorID,
 		}
 		err := credentialsStore.SaveCredentials(credentials)
 		if err != nil {
@@ -190,6 +658,142 @@ func overrideCredentialsWithFlags(credentialsStore *auth.FileCredentialsStore, l
 	}
 }
 
+// watchCredentials calls onChange on external changes of the credentials file, the writes of the store itself are ignored.
+func watchCredentials(store *auth.FileCredentialsStore, credentialsFile string, onChange func(), logger *slog.Logger) {
+	watcher, err := auth.NewCredentialsWatcher(credentialsFile)
+	if err != nil {
+		logger.With("err", err.Error()).Warn("Unable to watch credentials file, changes will be picked up on the next request only")
+		return
+	}
+	watcher.Logger = logger
+	watcher.Ignore = store.WrittenByStore
+
+	go watcher.Watch(onChange)
+}
+
+// credentialsChangeHandler returns the reaction to an external change of the credentials file: the providers
+// drop the state of the old credentials, and the devices are re-discovered when the accounts of the file or their
+// operators changed. Another account of the same operator is noticed by the periodic re-discovery.
+func credentialsChangeHandler(credentialsStore auth.CredentialsStore, providers []*tokenmanagement.ValidTokenProvider, rediscover func(), logger *slog.Logger) func() {
+	previous, _ := loadCredentialsAccounts(credentialsStore)
+	return func() {
+		for _, provider := range providers {
+			provider.InvalidateCredentials()
+		}
+
+		accounts, err := loadCredentialsAccounts(credentialsStore)
+		if err != nil {
+			logger.With("err", err.Error()).Warn("Unable to load the changed credentials")
+			return
+		}
+		if slices.Equal(accounts, previous) {
+			logger.Info("Credentials changed, the accounts are the same")
+			return
+		}
+		previous = accounts
+		logger.With("accounts", accounts).Info("Credentials changed, re-discovering devices")
+		go rediscover()
+	}
+}
+
+// credentialsAccount identifies an account of the credentials, a change of it asks for a re-discovery.
+type credentialsAccount struct {
+	Name       string
+	OperatorID int
+}
+
+// loadCredentialsAccounts returns the accounts of the store, stores without accounts have only the primary one.
+func loadCredentialsAccounts(credentialsStore auth.CredentialsStore) ([]credentialsAccount, error) {
+	accountStore, ok := credentialsStore.(auth.AccountStore)
+	if !ok {
+		credentials, err := credentialsStore.LoadCredentials()
+		if err != nil {
+			return nil, err
+		}
+		return []credentialsAccount{{OperatorID: credentials.OperatorID}}, nil
+	}
+
+	named, err := accountStore.Accounts()
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]credentialsAccount, 0, len(named))
+	for _, account := range named {
+		accounts = append(accounts, credentialsAccount{Name: account.Name, OperatorID: account.OperatorID})
+	}
+	return accounts, nil
+}
+
+// newFileAccounts gives every account of the credentials file but the primary one its own token provider and API.
+// Stores without accounts have none.
+func newFileAccounts(credentialsStore auth.CredentialsStore, credentialsConfig CredentialsConfig, httpClient *http.Client, baseURL string, logger *slog.Logger) ([]controllers.Account, []*tokenmanagement.ValidTokenProvider) {
+	accountStore, ok := credentialsStore.(auth.AccountStore)
+	if !ok {
+		return nil, nil
+	}
+	accounts, err := accountStore.Accounts()
+	if err != nil || len(accounts) < 2 {
+		return nil, nil
+	}
+
+	var fileAccounts []controllers.Account
+	var providers []*tokenmanagement.ValidTokenProvider
+	for _, account := range accounts[1:] {
+		provider := tokenmanagement.NewValidTokenProvider(accountStore.Account(account.Name))
+		provider.Logger = logger.With("account", account.Name)
+		provider.BaseURL = baseURL
+		provider.RefreshSkew = credentialsConfig.RefreshSkew
+
+		client := authorizedhttp.NewClient(provider, provider, provider)
+		client.DefaultClient = httpClient
+		client.Logger = logger
+
+		api := domru.NewDomruAPI(client, baseURL)
+		api.Logger = logger
+
+		fileAccounts = append(fileAccounts, controllers.Account{Name: account.Name, API: api})
+		providers = append(providers, provider)
+	}
+	return fileAccounts, providers
+}
+
+// addOperatorAccounts gives every extra credentials file its own token provider and API,
+// so accounts of other operators refresh their tokens independently, and adds them to MQTT.
+func addOperatorAccounts(mqttIntegration *homeassistant.MqttIntegration, credentialsConfig CredentialsConfig, httpClient *http.Client, baseURL string, logger *slog.Logger) {
+	key, err := credentialsConfig.key()
+	if err != nil {
+		logger.With("err", err.Error()).Error("Unable to read the credentials key, skipping the extra accounts")
+		return
+	}
+	for _, credentialsFile := range credentialsConfig.ExtraFiles {
+		store := auth.NewFileCredentialsStore(credentialsFile)
+		store.Key = key
+		credentials, err := store.LoadCredentials()
+		if err != nil {
+			logger.With("file", credentialsFile).With("err", err.Error()).Error("Unable to load extra credentials, skipping the account")
+			continue
+		}
+
+		provider := tokenmanagement.NewValidTokenProvider(store)
+		provider.Logger = logger
+		provider.BaseURL = baseURL
+		provider.RefreshSkew = credentialsConfig.RefreshSkew
+
+		client := authorizedhttp.NewClient(provider, provider, provider)
+		client.DefaultClient = httpClient
+		client.Logger = logger
+
+		api := domru.NewDomruAPI(client, baseURL)
+		api.Logger = logger
+
+		if err = mqttIntegration.AddOperatorAccount(credentials.OperatorID, api); err != nil {
+			logger.With("file", credentialsFile).With("err", err.Error()).Error("Unable to add extra account")
+			continue
+		}
+		logger.With("file", credentialsFile).With("operator-id", credentials.OperatorID).Info("Added extra account")
+	}
+}
+
 func checkCredentialsMiddleware(credentialsStore auth.CredentialsStore, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		credentials, err := credentialsStore.LoadCredentials()
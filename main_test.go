@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/pkg/auth"
+)
+
+func TestNewCredentialsStore(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	store, err := newCredentialsStore(CredentialsConfig{Backend: credentialsBackendFile, File: memoryCredentialsFile}, logger)
+	require.NoError(t, err)
+	assert.IsType(t, &auth.MemoryCredentialsStore{}, store)
+
+	store, err = newCredentialsStore(CredentialsConfig{Backend: credentialsBackendFile, File: filepath.Join(t.TempDir(), "accounts.json")}, logger)
+	require.NoError(t, err)
+	assert.IsType(t, &auth.FileCredentialsStore{}, store)
+
+	// A file that can't be written is read into memory
+	file := filepath.Join(t.TempDir(), "accounts.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"refreshToken":"refresh","operatorId":2}`), 0o600))
+	credentials, err := seededMemoryStore(auth.NewFileCredentialsStore(file)).LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", credentials.RefreshToken)
+}
+
+func TestCredentialsChangeHandler(t *testing.T) {
+	store := auth.NewMemoryCredentialsStore()
+	require.NoError(t, store.SaveCredentials(auth.Credentials{RefreshToken: "refresh", OperatorID: 2}))
+
+	rediscovered := make(chan struct{}, 1)
+	onChange := credentialsChangeHandler(store, nil, func() { rediscovered <- struct{}{} }, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// New tokens of the same account and operator don't need a re-discovery
+	require.NoError(t, store.SaveCredentials(auth.Credentials{RefreshToken: "other", OperatorID: 2}))
+	onChange()
+	select {
+	case <-rediscovered:
+		t.Fatal("devices were re-discovered for the same operator")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, store.SaveCredentials(auth.Credentials{RefreshToken: "another", OperatorID: 3}))
+	onChange()
+	select {
+	case <-rediscovered:
+	case <-time.After(time.Second):
+		t.Fatal("devices were not re-discovered")
+	}
+}
+
+func TestWatchCredentialsIgnoresOwnWrites(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "accounts.json")
+	store := auth.NewFileCredentialsStore(file)
+	require.NoError(t, store.SaveCredentials(auth.Credentials{RefreshToken: "refresh", OperatorID: 2}))
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var changes, rediscoveries atomic.Int32
+	handler := credentialsChangeHandler(store, nil, func() { rediscoveries.Add(1) }, logger)
+	// The handler invalidates the providers, not calling it means no invalidation
+	watchCredentials(store, file, func() {
+		changes.Add(1)
+		handler()
+	}, logger)
+
+	// A token refresh saved by the store itself
+	require.NoError(t, store.SaveCredentials(auth.Credentials{RefreshToken: "refreshed", OperatorID: 2}))
+	time.Sleep(time.Second)
+	assert.Zero(t, changes.Load())
+	assert.Zero(t, rediscoveries.Load())
+
+	// An edit by hand moving the account to another operator
+	require.NoError(t, os.WriteFile(file, []byte(`{"accounts":[{"name":"default","refreshToken":"edited","operatorId":3}]}`), 0o600))
+	require.Eventually(t, func() bool { return rediscoveries.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), changes.Load())
+}
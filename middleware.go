@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/090809/homeassistant-domru/pkg/logging"
+	"github.com/090809/homeassistant-domru/pkg/requestlog"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+)
+
+// requestIDMiddleware stores the incoming X-Request-ID, or a new one, in the request context
+// and echoes it in the response, so every log line of the request can be correlated.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = logging.NewRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(logging.ContextWithRequestID(r.Context(), requestID)))
+	})
+}
+
+// isValidRequestID accepts short IDs of printable ASCII, anything else would pollute the logs.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// recoveryMiddleware turns a panic in a handler into a logged error and a 500 error page,
+// so a single broken request can't take the addon down. A response the handler started already
+// can't be replaced by the page anymore, its connection is aborted instead of ending it as if it were complete.
+func recoveryMiddleware(logger *slog.Logger, renderError func(http.ResponseWriter, *http.Request, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Aborting a handler on purpose must still abort the response
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+
+			// The panic value goes into the message, so it's sanitized like any other log message
+			logger.With("method", r.Method).With("path", r.URL.Path).With("stack", string(debug.Stack())).
+				ErrorContext(r.Context(), fmt.Sprintf("recovered from panic: %v", recovered))
+			if recorder.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			renderError(w, r, fmt.Errorf("panic: %v", recovered))
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// accessLogKey is the context key of the accessLogEntry of a request.
+type accessLogKey struct{}
+
+// accessLogEntry collects what handlers deeper in the chain know about a request, i.e. the upstream status.
+type accessLogEntry struct {
+	upstreamStatus int
+}
+
+// accessLogMiddleware logs every request with its status, response size and duration at info level.
+// Only the path is logged, the query may carry tokens.
+func accessLogMiddleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessLogEntry{}
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entryLogger := logger.With("method", r.Method).With("path", r.URL.Path).With("status", status).
+			With("bytes", recorder.bytes).With("duration", time.Since(start))
+		if entry.upstreamStatus != 0 {
+			entryLogger = entryLogger.With("upstream_status", entry.upstreamStatus)
+		}
+		entryLogger.InfoContext(r.Context(), "request handled")
+	})
+}
+
+// recordUpstreamStatus adds the status of a proxied response to the access log entry of the request.
+func recordUpstreamStatus(r *http.Request, resp *http.Response) {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.upstreamStatus = resp.StatusCode
+	}
+}
+
+// responseRecorder captures the status and size of a response. Unwrap keeps
+// http.ResponseController working, so streamed responses are still flushed.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// requestLogMiddleware records every request in the request log. Only the sanitized path is kept,
+// the query and the path itself may carry tokens.
+func requestLogMiddleware(log *requestlog.Log, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.Record(requestlog.Entry{Time: start, Method: r.Method, Path: logging.Sanitize(r.URL.Path), Status: status})
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	renderError := func(w http.ResponseWriter, _ *http.Request, err error) {
+		http.Error(w, "error page: "+err.Error(), http.StatusInternalServerError)
+	}
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		wantStatus  int
+		wantBody    string
+		wantAborted bool
+	}{
+		{
+			name:       "Panic before writing",
+			handler:    func(http.ResponseWriter, *http.Request) { panic("boom") },
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "error page: panic: boom\n",
+		},
+		{
+			name: "Panic after the header",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("boom")
+			},
+			wantStatus:  http.StatusAccepted,
+			wantAborted: true,
+		},
+		{
+			name: "Panic after the body",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = io.WriteString(w, "partial")
+				panic("boom")
+			},
+			wantStatus:  http.StatusOK,
+			wantBody:    "partial",
+			wantAborted: true,
+		},
+		{
+			name:       "No panic",
+			handler:    func(w http.ResponseWriter, _ *http.Request) { _, _ = io.WriteString(w, "ok") },
+			wantStatus: http.StatusOK,
+			wantBody:   "ok",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serve := func() {
+				recoveryMiddleware(logger, renderError, tt.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+			if tt.wantAborted {
+				assert.PanicsWithValue(t, http.ErrAbortHandler, serve)
+			} else {
+				assert.NotPanics(t, serve)
+			}
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// DefaultAccount names the account of a credentials file written before accounts were supported.
+const DefaultAccount = "default"
+
+// ErrAccountNotFound is returned for an account missing from the credentials file.
+var ErrAccountNotFound = errors.New("account not found")
+
+// accountName restricts account names to what may be a part of MQTT entity IDs.
+var accountName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// NamedCredentials are the credentials of one account of the credentials file.
+type NamedCredentials struct {
+	Name string `json:"name"`
+	Credentials
+}
+
+// AccountStore is a credentials store holding several named accounts,
+// i.e. the contracts of an apartment and a summer house.
+type AccountStore interface {
+	CredentialsStore
+	Accounts() ([]NamedCredentials, error)
+	AddAccount(name string, credentials Credentials) error
+	RemoveAccount(name string) error
+	Account(name string) CredentialsStore
+}
+
+// ValidateAccountName checks that name may be used as an account name.
+func ValidateAccountName(name string) error {
+	if !accountName.MatchString(name) {
+		return fmt.Errorf("account name %q must be 1 to 32 lowercase latin letters, digits or underscores", name)
+	}
+	return nil
+}
+
+// Accounts returns the accounts of the file, the primary one first.
+func (f *FileCredentialsStore) Accounts() ([]NamedCredentials, error) {
+	unlock, err := f.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return f.readAccounts()
+}
+
+// AddAccount adds the named account to the file, or replaces the credentials of the account
+// when it is already there, so logging in again to an account doesn't duplicate it.
+func (f *FileCredentialsStore) AddAccount(name string, credentials Credentials) error {
+	if err := ValidateAccountName(name); err != nil {
+		return err
+	}
+
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	accounts, err := f.readAccountsForUpdate()
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(accounts, func(account NamedCredentials) bool { return account.Name == name }); i >= 0 {
+		accounts[i].Credentials = credentials
+	} else {
+		accounts = append(accounts, NamedCredentials{Name: name, Credentials: credentials})
+	}
+	return f.writeAccounts(accounts)
+}
+
+// RemoveAccount removes the named account from the file. Removing the primary account
+// makes the next one primary.
+func (f *FileCredentialsStore) RemoveAccount(name string) error {
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	accounts, err := f.readAccounts()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(accounts, func(account NamedCredentials) bool { return account.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrAccountNotFound, name)
+	}
+	return f.writeAccounts(slices.Delete(accounts, i, i+1))
+}
+
+// Account returns the store of a single account of the file, i.e. for its token provider.
+func (f *FileCredentialsStore) Account(name string) CredentialsStore {
+	return &accountCredentialsStore{file: f, name: name}
+}
+
+// accountCredentialsStore loads and saves the credentials of one account of a FileCredentialsStore.
+type accountCredentialsStore struct {
+	file *FileCredentialsStore
+	name string
+}
+
+func (a *accountCredentialsStore) LoadCredentials() (Credentials, error) {
+	unlock, err := a.file.lock()
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer unlock()
+
+	accounts, err := a.file.readAccounts()
+	if err != nil {
+		return Credentials{}, err
+	}
+	for _, account := range accounts {
+		if account.Name == a.name {
+			return account.Credentials, nil
+		}
+	}
+	return Credentials{}, fmt.Errorf("%w: %s", ErrAccountNotFound, a.name)
+}
+
+// ClearCredentials removes the account from the file.
+func (a *accountCredentialsStore) ClearCredentials() error {
+	return a.file.RemoveAccount(a.name)
+}
+
+// SaveCredentials updates the credentials of the account, it doesn't bring back a removed account.
+func (a *accountCredentialsStore) SaveCredentials(credentials Credentials) error {
+	unlock, err := a.file.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	accounts, err := a.file.readAccounts()
+	if err != nil {
+		return err
+	}
+	for i := range accounts {
+		if accounts[i].Name == a.name {
+			accounts[i].Credentials = credentials
+			return a.file.writeAccounts(accounts)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAccountNotFound, a.name)
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// encryptedHeaderV1 starts credentials files encrypted with AES-256-GCM, followed by the base64
+// encoded nonce and ciphertext. Files without a header are plaintext JSON.
+const encryptedHeaderV1 = "domru-credentials-encrypted-v1\n"
+
+// ErrCredentialsKey is returned for an encrypted credentials file that can't be decrypted.
+var ErrCredentialsKey = errors.New("the credentials file can't be decrypted with the credentials key, set the key it was encrypted with or log in again")
+
+// NewCredentialsKey derives the key encrypting the credentials file from a secret, i.e. a long random string.
+func NewCredentialsKey(secret string) []byte {
+	key := sha256.Sum256([]byte(secret))
+	return key[:]
+}
+
+func newCredentialsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCredentials encrypts the content of the credentials file with the key.
+func encryptCredentials(key, plaintext []byte) ([]byte, error) {
+	aead, err := newCredentialsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	// The header is authenticated, so the version can't be swapped
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(encryptedHeaderV1))
+	return append([]byte(encryptedHeaderV1), base64.StdEncoding.EncodeToString(sealed)+"\n"...), nil
+}
+
+// isEncryptedCredentials reports whether the content of the credentials file is encrypted.
+func isEncryptedCredentials(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeaderV1))
+}
+
+// decryptCredentials decrypts the content of an encrypted credentials file,
+// it returns ErrCredentialsKey when there is no key or it's the wrong one.
+func decryptCredentials(key, data []byte) ([]byte, error) {
+	if key == nil {
+		return nil, ErrCredentialsKey
+	}
+	aead, err := newCredentialsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(encryptedHeaderV1):])))
+	if err != nil {
+		return nil, fmt.Errorf("decode encrypted credentials: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted credentials are too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(encryptedHeaderV1))
+	if err != nil {
+		return nil, ErrCredentialsKey
+	}
+	return plaintext, nil
+}
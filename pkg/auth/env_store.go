@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// EnvCredentialsStore reads credentials from environment variables.
+// The environment can't be persisted, so saved credentials (i.e. after a token refresh) are kept in memory.
+type EnvCredentialsStore struct {
+	prefix string
+
+	mu    sync.RWMutex
+	saved *Credentials
+}
+
+// NewEnvCredentialsStore reads <prefix>ACCESS_TOKEN, <prefix>REFRESH_TOKEN and <prefix>OPERATOR_ID.
+func NewEnvCredentialsStore(prefix string) *EnvCredentialsStore {
+	return &EnvCredentialsStore{prefix: prefix}
+}
+
+func (e *EnvCredentialsStore) SaveCredentials(credentials Credentials) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.saved = &credentials
+	return nil
+}
+
+func (e *EnvCredentialsStore) LoadCredentials() (Credentials, error) {
+	e.mu.RLock()
+	saved := e.saved
+	e.mu.RUnlock()
+	if saved != nil {
+		return *saved, nil
+	}
+
+	refreshToken := os.Getenv(e.prefix + "REFRESH_TOKEN")
+	if refreshToken == "" {
+		return Credentials{}, fmt.Errorf("%sREFRESH_TOKEN is not set", e.prefix)
+	}
+
+	operatorID, err := strconv.Atoi(os.Getenv(e.prefix + "OPERATOR_ID"))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("parse %sOPERATOR_ID: %w", e.prefix, err)
+	}
+
+	return Credentials{
+		AccessToken:  os.Getenv(e.prefix + "ACCESS_TOKEN"),
+		RefreshToken: refreshToken,
+		OperatorID:   operatorID,
+	}, nil
+}
+
+// ClearCredentials can't unset the environment, the cleared credentials are kept in memory instead.
+func (e *EnvCredentialsStore) ClearCredentials() error {
+	return e.SaveCredentials(Credentials{})
+}
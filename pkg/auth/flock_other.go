@@ -0,0 +1,10 @@
+//go:build !unix
+
+package auth
+
+import "os"
+
+// Advisory locks are not supported, only the goroutines of this process are serialized.
+func lockFileExclusive(*os.File) error { return nil }
+
+func unlockFile(*os.File) error { return nil }
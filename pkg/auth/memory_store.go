@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+)
+
+// MemoryCredentialsStore keeps the credentials in memory only, i.e. on a read-only filesystem.
+// They are lost on restart, so they have to be seeded, i.e. with a refresh token from the flags.
+type MemoryCredentialsStore struct {
+	mu          sync.RWMutex
+	credentials *Credentials
+}
+
+func NewMemoryCredentialsStore() *MemoryCredentialsStore {
+	return &MemoryCredentialsStore{}
+}
+
+func (m *MemoryCredentialsStore) SaveCredentials(credentials Credentials) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.credentials = &credentials
+	return nil
+}
+
+func (m *MemoryCredentialsStore) LoadCredentials() (Credentials, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.credentials == nil {
+		return Credentials{}, errors.New("no credentials in memory, log in or set the refresh token")
+	}
+	return *m.credentials, nil
+}
+
+func (m *MemoryCredentialsStore) ClearCredentials() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.credentials = nil
+	return nil
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCredentialsStore(t *testing.T) {
+	store := NewMemoryCredentialsStore()
+	_, err := store.LoadCredentials()
+	assert.Error(t, err)
+
+	require.NoError(t, store.SaveCredentials(Credentials{RefreshToken: "refresh", OperatorID: 2}))
+	credentials, err := store.LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{RefreshToken: "refresh", OperatorID: 2}, credentials)
+}
@@ -10,23 +10,25 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/090809/homeassistant-domru/internal/domru/constants"
 	"github.com/090809/homeassistant-domru/internal/domru/helpers"
 	"github.com/090809/homeassistant-domru/internal/domru/models"
 	"github.com/090809/homeassistant-domru/pkg/antiblock_client"
 )
 
 const (
-	AuthPasswordUrl      = "https://myhome.proptech.ru/auth/v2/auth/%s/password"
+	AuthPasswordUrl      = "%s/auth/v2/auth/%s/password"
 	TimestampLayout      = "2006-01-02T15:04:05Z"
 	EarthTimestampLayout = "20060102150405"
 )
 
-func getAuthPasswordUrl(login string) string {
-	return fmt.Sprintf(AuthPasswordUrl, login)
+func getAuthPasswordUrl(baseURL, login string) string {
+	return fmt.Sprintf(AuthPasswordUrl, baseURL, login)
 }
 
 type PasswordAuthenticator struct {
 	Logger   *slog.Logger
+	BaseURL  string
 	login    string
 	password string
 }
@@ -34,6 +36,7 @@ type PasswordAuthenticator struct {
 func NewPasswordAuthenticator(login, password string) *PasswordAuthenticator {
 	return &PasswordAuthenticator{
 		Logger:   slog.Default(),
+		BaseURL:  constants.BaseUrl,
 		login:    login,
 		password: password,
 	}
@@ -44,7 +47,7 @@ func (a *PasswordAuthenticator) Authenticate() (models.AuthenticationResponse, e
 	body := generatePasswordAuthRequest(now, a.login, a.password)
 
 	var authResp models.AuthenticationResponse
-	url := getAuthPasswordUrl(a.login)
+	url := getAuthPasswordUrl(a.BaseURL, a.login)
 	antiblockClient := antiblock_client.NewAntiblockClient()
 	err := helpers.NewUpstreamRequest(url,
 		helpers.WithBody(body),
@@ -18,11 +18,13 @@ const (
 type SmsCodeGetter func() (string, error)
 
 type PhoneNumberAuthenticator struct {
+	BaseURL     string
 	phoneNumber string
 }
 
 func NewPhoneNumberAuthenticator(phoneNumber string) *PhoneNumberAuthenticator {
 	return &PhoneNumberAuthenticator{
+		BaseURL:     constants.BaseUrl,
 		phoneNumber: phoneNumber,
 	}
 }
@@ -57,7 +59,7 @@ func (a *PhoneNumberAuthenticator) isPhoneNumberValid() bool {
 }
 
 func (a *PhoneNumberAuthenticator) requestConfirmationCode(account models.Account) error {
-	confirmURL := fmt.Sprintf("%s/auth/v2/confirmation/%s", constants.BaseUrl, a.phoneNumber)
+	confirmURL := constants.GetAuthConfirmationUrl(a.BaseURL, a.phoneNumber)
 	if account.AccountID == nil {
 		return fmt.Errorf("account id is nil. Account: %v", account)
 	}
@@ -74,7 +76,7 @@ func (a *PhoneNumberAuthenticator) requestConfirmationCode(account models.Accoun
 }
 
 func (a *PhoneNumberAuthenticator) sendConfirmationCode(smsCode string, account models.Account) (models.AuthenticationResponse, error) {
-	confirmURL := fmt.Sprintf("%s/auth/v3/auth/%s/confirmation", constants.BaseUrl, a.phoneNumber)
+	confirmURL := fmt.Sprintf("%s/auth/v3/auth/%s/confirmation", a.BaseURL, a.phoneNumber)
 	//if account.ProfileID == nil {
 	//	return models.AuthenticationResponse{}, fmt.Errorf("profile id is nil. Account: %v", account)
 	//}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisTimeout = 5 * time.Second
+
+// RedisCredentialsStore keeps credentials as a JSON document under a single Redis key.
+type RedisCredentialsStore struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRedisCredentialsStore(client *redis.Client, key string) *RedisCredentialsStore {
+	return &RedisCredentialsStore{client: client, key: key}
+}
+
+func (r *RedisCredentialsStore) SaveCredentials(credentials Credentials) error {
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return fmt.Errorf("marshal credentials: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err = r.client.Set(ctx, r.key, data, 0).Err(); err != nil {
+		return fmt.Errorf("redis set %s: %w", r.key, err)
+	}
+	return nil
+}
+
+func (r *RedisCredentialsStore) LoadCredentials() (Credentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := r.client.Get(ctx, r.key).Bytes()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("redis get %s: %w", r.key, err)
+	}
+
+	var credentials Credentials
+	if err = json.Unmarshal(data, &credentials); err != nil {
+		return Credentials{}, fmt.Errorf("unmarshal credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+func (r *RedisCredentialsStore) ClearCredentials() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := r.client.Del(ctx, r.key).Err(); err != nil {
+		return fmt.Errorf("redis del %s: %w", r.key, err)
+	}
+	return nil
+}
@@ -1,10 +1,15 @@
 package auth
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path"
+	"sync"
 
 	"github.com/090809/homeassistant-domru/internal/domru/models"
 	"github.com/090809/homeassistant-domru/internal/domru/sanitizing_utils"
@@ -35,10 +40,35 @@ func NewCredentialsFromAuthResponse(authResponse models.AuthenticationResponse)
 type CredentialsStore interface {
 	SaveCredentials(credentials Credentials) error
 	LoadCredentials() (Credentials, error)
+	// ClearCredentials forgets the credentials on logout.
+	ClearCredentials() error
 }
 
+// CorruptCredentialsError is returned when the credentials file is empty, isn't valid JSON or can't be
+// decrypted, the credentials are lost and the user has to log in again.
+type CorruptCredentialsError struct {
+	Err error
+}
+
+func (e CorruptCredentialsError) Error() string {
+	return "unreadable credentials file: " + e.Err.Error()
+}
+
+func (e CorruptCredentialsError) Unwrap() error {
+	return e.Err
+}
+
+// FileCredentialsStore keeps the credentials of one or more named accounts in a JSON file.
+// LoadCredentials and SaveCredentials work with the first, primary, account.
 type FileCredentialsStore struct {
+	// Key encrypts the file, see NewCredentialsKey. Without it the file is plaintext JSON.
+	// Plaintext files are read with a key as well, they are encrypted on the next write.
+	Key []byte
+
 	filePath string
+	mu       sync.Mutex
+	// written is the hash of the content the store wrote last, it tells its own writes from external ones.
+	written [sha256.Size]byte
 }
 
 func NewFileCredentialsStore(filePath string) *FileCredentialsStore {
@@ -46,37 +76,195 @@ func NewFileCredentialsStore(filePath string) *FileCredentialsStore {
 }
 
 func (f *FileCredentialsStore) SaveCredentials(credentials Credentials) error {
-	directory := path.Dir(f.filePath)
-
-	if _, err := os.Stat(directory); os.IsNotExist(err) {
-		if err := os.MkdirAll(directory, 0o700); err != nil {
-			return err
-		}
-	}
-
-	file, err := os.OpenFile(f.filePath, os.O_RDWR|os.O_CREATE, 0o666)
+	unlock, err := f.lock()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer unlock()
 
-	encoder := json.NewEncoder(file)
-	return encoder.Encode(credentials)
+	// A fresh login of the primary account replaces a corrupt file, nothing can be read from it anyway
+	accounts, err := f.readAccountsForUpdate()
+	if err != nil && !errors.As(err, &CorruptCredentialsError{}) {
+		return err
+	}
+	if len(accounts) == 0 {
+		accounts = []NamedCredentials{{Name: DefaultAccount}}
+	}
+	accounts[0].Credentials = credentials
+	return f.writeAccounts(accounts)
 }
 
 func (f *FileCredentialsStore) LoadCredentials() (Credentials, error) {
-	file, err := os.Open(f.filePath)
+	unlock, err := f.lock()
 	if err != nil {
 		return Credentials{}, err
 	}
-	defer file.Close()
+	defer unlock()
 
-	var credentials Credentials
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&credentials)
+	accounts, err := f.readAccounts()
 	if err != nil {
 		return Credentials{}, err
 	}
+	if len(accounts) == 0 {
+		return Credentials{}, errors.New("no accounts in the credentials file")
+	}
+	return accounts[0].Credentials, nil
+}
 
-	return credentials, nil
+// ClearCredentials forgets the credentials of the primary account. The other accounts of the file
+// are kept, they are removed with RemoveAccount. A file without other accounts is removed.
+func (f *FileCredentialsStore) ClearCredentials() error {
+	unlock, err := f.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	accounts, err := f.readAccounts()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case errors.As(err, &CorruptCredentialsError{}):
+		// Nothing can be read from the file, it may still hold accounts readable with another key
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if len(accounts) > 1 {
+		accounts[0].Credentials = Credentials{}
+		return f.writeAccounts(accounts)
+	}
+	if err = os.Remove(f.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// WrittenByStore reports whether the file holds the content the store wrote last, i.e. a change the
+// CredentialsWatcher reports is the store's own write, such as a token refresh, rather than an external edit.
+func (f *FileCredentialsStore) WrittenByStore() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.filePath)
+	if err != nil {
+		return false
+	}
+	return sha256.Sum256(data) == f.written
+}
+
+// lock serializes the access to the file, the mutex between goroutines and an advisory lock of
+// the lock file next to it between processes sharing the file. The returned function releases both.
+// In a read-only directory nobody can write the file, so it's only read under the mutex.
+func (f *FileCredentialsStore) lock() (func(), error) {
+	f.mu.Lock()
+
+	// The lock file is never replaced, unlike the credentials file which is renamed over on every write
+	directory := path.Dir(f.filePath)
+	err := os.MkdirAll(directory, 0o700)
+	var lockFile *os.File
+	if err == nil {
+		lockFile, err = os.OpenFile(f.filePath+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	}
+	if err != nil {
+		return f.mu.Unlock, nil
+	}
+	if err = lockFileExclusive(lockFile); err != nil {
+		lockFile.Close()
+		f.mu.Unlock()
+		return nil, fmt.Errorf("lock credentials file: %w", err)
+	}
+
+	return func() {
+		_ = unlockFile(lockFile)
+		lockFile.Close()
+		f.mu.Unlock()
+	}, nil
+}
+
+// credentialsFile is the content of the credentials file. Files written before accounts were
+// supported hold the credentials of a single account at the top level instead.
+type credentialsFile struct {
+	Accounts []NamedCredentials `json:"accounts"`
+	Credentials
+}
+
+// readAccounts reads the accounts, a single account file is read as a list of its account. The file is left
+// as it is, the next write migrates it to the accounts list. It must be called with the file locked.
+func (f *FileCredentialsStore) readAccounts() ([]NamedCredentials, error) {
+	data, err := os.ReadFile(f.filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, CorruptCredentialsError{Err: errors.New("file is empty")}
+	}
+	if isEncryptedCredentials(data) {
+		if data, err = decryptCredentials(f.Key, data); err != nil {
+			return nil, CorruptCredentialsError{Err: err}
+		}
+	}
+
+	// Only the first JSON value is read, files used to be rewritten without truncating them
+	var content credentialsFile
+	if err = json.NewDecoder(bytes.NewReader(data)).Decode(&content); err != nil {
+		return nil, CorruptCredentialsError{Err: err}
+	}
+	if content.Accounts != nil || content.Credentials == (Credentials{}) {
+		return content.Accounts, nil
+	}
+
+	return []NamedCredentials{{Name: DefaultAccount, Credentials: content.Credentials}}, nil
+}
+
+// readAccountsForUpdate reads the accounts to be changed and written back. A missing file has no accounts.
+// A corrupt file is an error, it may still hold the primary account, i.e. encrypted with another key.
+// It must be called with the file locked.
+func (f *FileCredentialsStore) readAccountsForUpdate() ([]NamedCredentials, error) {
+	accounts, err := f.readAccounts()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return accounts, err
+}
+
+// writeAccounts replaces the credentials file, so a crash can't leave it half-written.
+// It must be called with the file locked.
+func (f *FileCredentialsStore) writeAccounts(accounts []NamedCredentials) error {
+	data, err := json.Marshal(struct {
+		Accounts []NamedCredentials `json:"accounts"`
+	}{Accounts: accounts})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if f.Key != nil {
+		if data, err = encryptCredentials(f.Key, data); err != nil {
+			return fmt.Errorf("encrypt credentials: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(f.filePath), path.Base(f.filePath)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), f.filePath); err != nil {
+		return err
+	}
+	f.written = sha256.Sum256(data)
+	return nil
 }
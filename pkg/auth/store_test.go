@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCredentialsStoreMigratesSingleAccount(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"accessToken":"access","refreshToken":"refresh","operatorId":2}`), 0o600))
+	store := NewFileCredentialsStore(file)
+
+	credentials, err := store.LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, Credentials{AccessToken: "access", RefreshToken: "refresh", OperatorID: 2}, credentials)
+
+	// Reading leaves the file alone, the next write migrates it
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"accessToken":"access","refreshToken":"refresh","operatorId":2}`, string(data))
+
+	require.NoError(t, store.AddAccount("dacha", Credentials{AccessToken: "dacha", OperatorID: 3}))
+	data, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"accounts":[{"name":"default","accessToken":"access","refreshToken":"refresh","operatorId":2},
+		{"name":"dacha","accessToken":"dacha","refreshToken":"","operatorId":3}]}`, string(data))
+}
+
+func TestFileCredentialsStoreAccounts(t *testing.T) {
+	store := NewFileCredentialsStore(filepath.Join(t.TempDir(), "domru", "credentials.json"))
+
+	require.NoError(t, store.SaveCredentials(Credentials{AccessToken: "apartment", OperatorID: 2}))
+	require.NoError(t, store.AddAccount("dacha", Credentials{AccessToken: "dacha", OperatorID: 3}))
+	assert.Error(t, store.AddAccount("Дача", Credentials{}))
+
+	// The accounts are refreshed independently
+	require.NoError(t, store.Account("dacha").SaveCredentials(Credentials{AccessToken: "refreshed", OperatorID: 3}))
+	require.NoError(t, store.SaveCredentials(Credentials{AccessToken: "apartment2", OperatorID: 2}))
+
+	accounts, err := store.Accounts()
+	require.NoError(t, err)
+	assert.Equal(t, []NamedCredentials{
+		{Name: DefaultAccount, Credentials: Credentials{AccessToken: "apartment2", OperatorID: 2}},
+		{Name: "dacha", Credentials: Credentials{AccessToken: "refreshed", OperatorID: 3}},
+	}, accounts)
+
+	require.NoError(t, store.RemoveAccount("dacha"))
+	assert.True(t, errors.Is(store.RemoveAccount("dacha"), ErrAccountNotFound))
+	_, err = store.Account("dacha").LoadCredentials()
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	assert.True(t, errors.Is(store.Account("dacha").SaveCredentials(Credentials{}), ErrAccountNotFound))
+}
+
+func TestFileCredentialsStoreCorruptFile(t *testing.T) {
+	for name, content := range map[string]string{"Empty": "", "Truncated": `{"accounts":[{"name":"def`} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "credentials.json")
+			require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+			store := NewFileCredentialsStore(file)
+
+			_, err := store.LoadCredentials()
+			assert.True(t, errors.As(err, &CorruptCredentialsError{}))
+
+			// Adding an account doesn't overwrite the file, it may hold the primary account
+			err = store.AddAccount("dacha", Credentials{AccessToken: "dacha"})
+			assert.True(t, errors.As(err, &CorruptCredentialsError{}))
+			data, err := os.ReadFile(file)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+
+			// Logging in again replaces the corrupt file
+			require.NoError(t, store.SaveCredentials(Credentials{AccessToken: "access"}))
+			credentials, err := store.LoadCredentials()
+			require.NoError(t, err)
+			assert.Equal(t, "access", credentials.AccessToken)
+		})
+	}
+}
+
+func TestFileCredentialsStoreConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "credentials.json")
+
+	// Every store opens the lock file on its own, like another process sharing the file would
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, NewFileCredentialsStore(file).AddAccount(fmt.Sprintf("account_%d", i), Credentials{OperatorID: i}))
+		}()
+	}
+	wg.Wait()
+
+	accounts, err := NewFileCredentialsStore(file).Accounts()
+	require.NoError(t, err)
+	assert.Len(t, accounts, 10)
+
+	// No temp files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"credentials.json", "credentials.json.lock"}, names)
+}
+
+func TestFileCredentialsStoreEncryption(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"accessToken":"access","refreshToken":"refresh","operatorId":2}`), 0o600))
+	store := NewFileCredentialsStore(file)
+	store.Key = NewCredentialsKey("secret")
+
+	// The plaintext file is read and only encrypted when it's written
+	credentials, err := store.LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", credentials.RefreshToken)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.False(t, isEncryptedCredentials(data))
+
+	require.NoError(t, store.SaveCredentials(credentials))
+	data, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.True(t, isEncryptedCredentials(data))
+	assert.NotContains(t, string(data), "refresh")
+
+	credentials, err = store.LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", credentials.RefreshToken)
+
+	for name, key := range map[string][]byte{"Wrong key": NewCredentialsKey("other"), "No key": nil} {
+		t.Run(name, func(t *testing.T) {
+			other := NewFileCredentialsStore(file)
+			other.Key = key
+			_, err := other.LoadCredentials()
+			assert.True(t, errors.As(err, &CorruptCredentialsError{}))
+			assert.True(t, errors.Is(err, ErrCredentialsKey))
+
+			// The primary account of the file survives a secondary login with the wrong key
+			err = other.AddAccount("dacha", Credentials{RefreshToken: "dacha"})
+			assert.True(t, errors.Is(err, ErrCredentialsKey))
+			credentials, err := store.LoadCredentials()
+			require.NoError(t, err)
+			assert.Equal(t, "refresh", credentials.RefreshToken)
+		})
+	}
+}
+
+func TestFileCredentialsStoreClear(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	store := NewFileCredentialsStore(file)
+	require.NoError(t, store.SaveCredentials(Credentials{RefreshToken: "refresh"}))
+	require.NoError(t, store.AddAccount("dacha", Credentials{RefreshToken: "dacha"}))
+
+	// Logging out of the primary account keeps the other accounts
+	require.NoError(t, store.ClearCredentials())
+	credentials, err := store.LoadCredentials()
+	require.NoError(t, err)
+	assert.Empty(t, credentials.RefreshToken)
+	dacha, err := store.Account("dacha").LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "dacha", dacha.RefreshToken)
+
+	// Without other accounts the file is removed
+	require.NoError(t, store.RemoveAccount("dacha"))
+	require.NoError(t, store.ClearCredentials())
+	_, err = store.LoadCredentials()
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	// Clearing twice is fine
+	assert.NoError(t, store.ClearCredentials())
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CredentialsWatcher reports modifications of the credentials file. Without Ignore that includes
+// the writes of the process itself, i.e. the token refreshes saved by its store.
+type CredentialsWatcher struct {
+	Logger *slog.Logger
+	// Debounce is how long the file has to stay unchanged before onChange is called,
+	// so a file written in several steps is reported once. Zero reports every change.
+	Debounce time.Duration
+	// Ignore, if set, is asked before onChange is called. Changes it reports as the process's own
+	// writes are skipped, i.e. with FileCredentialsStore.WrittenByStore.
+	Ignore func() bool
+	filePath string
+	watcher  *fsnotify.Watcher
+}
+
+// NewCredentialsWatcher starts watching the directory containing filePath.
+// The directory is watched instead of the file itself, so replacing the file
+// (i.e. editors writing a new file and renaming it) is noticed as well.
+func NewCredentialsWatcher(filePath string) (*CredentialsWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("create watcher: %w", err)
+	}
+
+	if err = watcher.Add(filepath.Dir(filePath)); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("watch %s: %w", filepath.Dir(filePath), err)
+	}
+
+	return &CredentialsWatcher{
+		Logger:   slog.Default(),
+		Debounce: 500 * time.Millisecond,
+		filePath: filepath.Clean(filePath),
+		watcher:  watcher,
+	}, nil
+}
+
+// Watch calls onChange every time the credentials file is written, created or replaced, unless Ignore skips the change.
+// It blocks until Close is called.
+func (w *CredentialsWatcher) Watch(onChange func()) {
+	// settled fires once the file stopped changing for Debounce, it's nil while no change is pending
+	var settled <-chan time.Time
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != w.filePath {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			w.Logger.With("file", w.filePath).With("op", event.Op.String()).Debug("credentials file changed")
+			if w.Debounce <= 0 {
+				w.changed(onChange)
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(w.Debounce)
+			settled = timer.C
+		case <-settled:
+			settled, timer = nil, nil
+			w.changed(onChange)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.Logger.With("err", err).Warn("credentials watcher error")
+		}
+	}
+}
+
+// changed calls onChange for a change Ignore doesn't skip.
+func (w *CredentialsWatcher) changed(onChange func()) {
+	if w.Ignore != nil && w.Ignore() {
+		w.Logger.With("file", w.filePath).Debug("credentials file written by the addon itself, ignoring the change")
+		return
+	}
+	w.Logger.With("file", w.filePath).Info("credentials file changed")
+	onChange()
+}
+
+func (w *CredentialsWatcher) Close() error {
+	return w.watcher.Close()
+}
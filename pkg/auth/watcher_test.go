@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsWatcherDebounce(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "accounts.json")
+	watcher, err := NewCredentialsWatcher(file)
+	require.NoError(t, err)
+	defer watcher.Close()
+	watcher.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	watcher.Debounce = 100 * time.Millisecond
+
+	var changes atomic.Int32
+	go watcher.Watch(func() { changes.Add(1) })
+
+	// Other files of the directory are ignored
+	require.NoError(t, os.WriteFile(file+".lock", nil, 0o600))
+	for range 5 {
+		require.NoError(t, os.WriteFile(file, []byte(`{"accounts":[]}`), 0o600))
+	}
+
+	require.Eventually(t, func() bool { return changes.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(1), changes.Load())
+}
+
+func TestCredentialsWatcherIgnoresOwnWrites(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "accounts.json")
+	store := NewFileCredentialsStore(file)
+	watcher, err := NewCredentialsWatcher(file)
+	require.NoError(t, err)
+	defer watcher.Close()
+	watcher.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	watcher.Debounce = 50 * time.Millisecond
+	watcher.Ignore = store.WrittenByStore
+
+	var changes atomic.Int32
+	go watcher.Watch(func() { changes.Add(1) })
+
+	// Token refreshes of the store itself are not reported
+	require.NoError(t, store.SaveCredentials(Credentials{RefreshToken: "refresh", OperatorID: 2}))
+	require.NoError(t, store.AddAccount("dacha", Credentials{RefreshToken: "dacha", OperatorID: 3}))
+	time.Sleep(200 * time.Millisecond)
+	assert.Zero(t, changes.Load())
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"accounts":[{"name":"default","refreshToken":"edited"}]}`), 0o600))
+	require.Eventually(t, func() bool { return changes.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+}
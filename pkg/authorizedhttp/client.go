@@ -1,9 +1,9 @@
 package authorizedhttp
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
-	"strconv"
 
 	"github.com/090809/homeassistant-domru/internal/domru/http"
 )
@@ -37,6 +37,8 @@ type Client struct {
 	tokenProvider  TokenProvider
 	tokenRefresher TokenRefresher
 	Logger         *slog.Logger
+	// OnError is called with every request that failed or was answered with an error status, nil ignores them.
+	OnError func(error)
 
 	operatorProvider OperatorProvider
 
@@ -54,47 +56,18 @@ func NewClient(tokenProvider TokenProvider, tokenRefresher TokenRefresher, opera
 	}
 }
 
+// Do sends the request through a Transport using DefaultClient.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	resp, err := c.tryRequest(req)
-	if err != nil {
-		c.Logger.With("error", err).With("url", req.URL).With("method", req.Method).With("headers", req.Header).Warn("Failed to send request")
-		return nil, err
-	}
-
-	if resp.StatusCode == http.StatusUnauthorized {
-		// Refresh the token
-		c.Logger.Debug("Token expired. Refreshing token...")
-		err = c.tokenRefresher.RefreshToken()
+	transport := newTransport(c.tokenProvider, c.tokenRefresher, c.operatorProvider)
+	transport.Base = clientRoundTripper{client: c.DefaultClient}
+	transport.Logger = c.Logger
+	resp, err := transport.RoundTrip(req)
+	if c.OnError != nil {
 		if err != nil {
-			c.Logger.With("err", err).Warn("Failed to refresh token. Redirecting to login page")
-			return nil, NewTokenRefreshError(err)
+			c.OnError(fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err))
+		} else if resp.StatusCode >= http.StatusBadRequest {
+			c.OnError(fmt.Errorf("%s %s: status %d", req.Method, req.URL.Path, resp.StatusCode))
 		}
-
-		return c.tryRequest(req)
 	}
-
 	return resp, err
 }
-
-func (c *Client) tryRequest(req *http.Request) (*http.Response, error) {
-	newToken, err := c.tokenProvider.GetToken()
-	if err != nil {
-		c.Logger.With("error", err).Warn("Failed to get new token")
-		return nil, err
-	}
-
-	operatorID, err := c.operatorProvider.GetOperatorID()
-	if err != nil {
-		c.Logger.With("error", err).Warn("Failed to get operator id")
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+newToken)
-	req.Header.Set("Operator", strconv.Itoa(operatorID))
-	resp, err := c.DefaultClient.Do(req)
-	if err != nil {
-		c.Logger.With("error", err).With("url", req.URL).With("method", req.Method).With("headers", req.Header).Warn("Failed to send request")
-		return nil, err
-	}
-	return resp, nil
-}
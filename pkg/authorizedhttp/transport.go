@@ -0,0 +1,152 @@
+package authorizedhttp
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/090809/homeassistant-domru/internal/domru/http"
+)
+
+// maxReplayBodySize limits how much of a request body without GetBody is buffered
+// to be sent again after a token refresh. Larger requests are not retried.
+const maxReplayBodySize = 1 << 20
+
+// Provider supplies the token and operator ID and refreshes the token,
+// e.g. tokenmanagement.ValidTokenProvider.
+type Provider interface {
+	TokenProvider
+	TokenRefresher
+	OperatorProvider
+}
+
+// Transport is an http.RoundTripper adding the Dom.ru authorization headers to every request.
+// When Dom.ru answers 401, the token is refreshed and the request is sent once more.
+type Transport struct {
+	// Base sends the requests, http.DefaultTransport is used when nil.
+	Base   http.RoundTripper
+	Logger *slog.Logger
+
+	tokenProvider    TokenProvider
+	tokenRefresher   TokenRefresher
+	operatorProvider OperatorProvider
+}
+
+// NewTransport returns a transport authorizing requests with the provider, i.e.
+// &http.Client{Transport: authorizedhttp.NewTransport(provider)}.
+func NewTransport(provider Provider) *Transport {
+	return newTransport(provider, provider, provider)
+}
+
+func newTransport(tokenProvider TokenProvider, tokenRefresher TokenRefresher, operatorProvider OperatorProvider) *Transport {
+	return &Transport{
+		Logger:           slog.Default(),
+		tokenProvider:    tokenProvider,
+		tokenRefresher:   tokenRefresher,
+		operatorProvider: operatorProvider,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	getBody, err := replayableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.tryRequest(req)
+	if err != nil {
+		t.Logger.With("error", err).With("url", req.URL).With("method", req.Method).With("headers", req.Header).WarnContext(req.Context(), "Failed to send request")
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	t.Logger.DebugContext(req.Context(), "Token expired. Refreshing token...")
+	if err = t.tokenRefresher.RefreshToken(); err != nil {
+		resp.Body.Close()
+		t.Logger.With("err", err).WarnContext(req.Context(), "Failed to refresh token. Redirecting to login page")
+		return nil, NewTokenRefreshError(err)
+	}
+
+	if getBody == nil {
+		// The body is already consumed and can't be sent again, the caller gets the 401 response
+		t.Logger.DebugContext(req.Context(), "Request body can't be replayed, not retrying after token refresh")
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if retry.Body, err = getBody(); err != nil {
+		return nil, err
+	}
+	return t.tryRequest(retry)
+}
+
+// tryRequest sends a copy of req with the current token, a RoundTripper must not modify the request.
+func (t *Transport) tryRequest(req *http.Request) (*http.Response, error) {
+	newToken, err := t.tokenProvider.GetToken()
+	if err != nil {
+		t.Logger.With("error", err).WarnContext(req.Context(), "Failed to get new token")
+		return nil, err
+	}
+
+	operatorID, err := t.operatorProvider.GetOperatorID()
+	if err != nil {
+		t.Logger.With("error", err).WarnContext(req.Context(), "Failed to get operator id")
+		return nil, err
+	}
+
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer "+newToken)
+	authorized.Header.Set("Operator", strconv.Itoa(operatorID))
+	return t.base().RoundTrip(authorized)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// replayableBody returns a function producing a fresh copy of the request body, nil if it can't be replayed.
+// Small bodies of requests without GetBody, such as proxied ones, are buffered for that.
+func replayableBody(req *http.Request) (func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return func() (io.ReadCloser, error) { return http.NoBody, nil }, nil
+	}
+	if req.GetBody != nil {
+		return req.GetBody, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxReplayBodySize {
+		req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), req.Body), Closer: req.Body}
+		return nil, nil
+	}
+	req.Body.Close()
+
+	getBody := func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	req.Body, _ = getBody()
+	return getBody, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// clientRoundTripper adapts an HTTP client to a RoundTripper, so Client can keep its DefaultClient.
+type clientRoundTripper struct {
+	client myhttp.HTTPClient
+}
+
+func (c clientRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req)
+}
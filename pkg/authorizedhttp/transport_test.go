@@ -0,0 +1,77 @@
+package authorizedhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubProvider struct {
+	token      string
+	refreshErr error
+	refreshes  int
+}
+
+func (p *stubProvider) GetToken() (string, error)   { return p.token, nil }
+func (p *stubProvider) GetOperatorID() (int, error) { return 42, nil }
+func (p *stubProvider) RefreshToken() error {
+	p.refreshes++
+	if p.refreshErr != nil {
+		return p.refreshErr
+	}
+	p.token = "fresh"
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		refreshErr error
+		wantStatus int
+		wantErr    bool
+	}{
+		{name: "retries with refreshed token", wantStatus: http.StatusOK},
+		{name: "refresh failure", refreshErr: errors.New("boom"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				assert.Equal(t, "42", r.Header.Get("Operator"))
+				if r.Header.Get("Authorization") != "Bearer fresh" {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer server.Close()
+
+			provider := &stubProvider{token: "stale", refreshErr: tt.refreshErr}
+			client := &http.Client{Transport: NewTransport(provider)}
+
+			// A plain reader has no GetBody, the transport has to buffer it for the retry
+			req, err := http.NewRequest(http.MethodPost, server.URL, io.NopCloser(strings.NewReader("payload")))
+			require.NoError(t, err)
+
+			resp, err := client.Do(req)
+			assert.Equal(t, 1, provider.refreshes)
+			if tt.wantErr {
+				var refreshErr TokenRefreshError
+				assert.ErrorAs(t, err, &refreshErr)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, []string{"payload", "payload"}, bodies)
+			assert.Empty(t, req.Header.Get("Authorization"), "the caller's request must not be modified")
+		})
+	}
+}
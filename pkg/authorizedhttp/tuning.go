@@ -0,0 +1,41 @@
+package authorizedhttp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// TransportOptions tune the connection pool of the transport sending the requests to Dom.ru.
+// Zero values keep the http.DefaultTransport settings.
+type TransportOptions struct {
+	// MaxIdleConns limits the idle connections kept open across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the idle connections kept open to a host, so parallel
+	// camera streams and API calls reuse connections instead of opening new ones.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration
+	// DisableHTTP2 keeps upstream connections on HTTP/1.1, HTTP/2 is used where the server supports it otherwise.
+	DisableHTTP2 bool
+}
+
+// NewBaseTransport returns a transport with the options applied, to be used as Transport.Base
+// or the transport of the client behind Client.DefaultClient.
+func (o TransportOptions) NewBaseTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.MaxIdleConns > 0 {
+		transport.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = o.IdleConnTimeout
+	}
+	if o.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
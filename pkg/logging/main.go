@@ -19,8 +19,14 @@ func (h *SanitizingHandler) Enabled(ctx context.Context, l slog.Level) bool {
 	return h.Handler.Enabled(ctx, l)
 }
 
+// Handle sanitizes the message and adds the request ID from ctx, so use the *Context logging
+// methods to correlate log lines of a request.
 func (h *SanitizingHandler) Handle(ctx context.Context, rec slog.Record) error {
 	rec.Message = sanitize(rec.Message)
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		rec = rec.Clone()
+		rec.AddAttrs(slog.String(RequestIDKey, requestID))
+	}
 	return h.Handler.Handle(ctx, rec)
 }
 
@@ -32,6 +38,11 @@ func (h *SanitizingHandler) WithGroup(name string) slog.Handler {
 	return &SanitizingHandler{h.Handler.WithGroup(name)}
 }
 
+// Sanitize masks tokens, UUIDs, logins and account IDs in s, the same way log messages are masked.
+func Sanitize(s string) string {
+	return sanitize(s)
+}
+
 func sanitize(msg string) string {
 	tokenRegex := regexp.MustCompile(`[a-z0-9]{30}`)
 	uuidRegex := regexp.MustCompile(`\b[0-9a-f]{8}\b-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-\b[0-9a-f]{12}\b`)
@@ -48,7 +59,7 @@ func sanitize(msg string) string {
 
 func ParseLogLevel(level string) slog.Level {
 	switch strings.ToLower(level) {
-	case "debug":
+	case "trace", "debug":
 		return slog.LevelDebug
 	case "info":
 		return slog.LevelInfo
@@ -87,6 +87,7 @@ func TestParseLogLevel(t *testing.T) {
 		{"Info level", "info", slog.LevelInfo},
 		{"Warn level", "warn", slog.LevelWarn},
 		{"Error level", "error", slog.LevelError},
+		{"Trace level", "trace", slog.LevelDebug},
 		{"Default level", "unknown", slog.LevelInfo},
 	}
 
@@ -98,3 +99,35 @@ func TestParseLogLevel(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizingHandler_RequestID(t *testing.T) {
+	var outputBuffer bytes.Buffer
+	logger := slog.New(NewSanitizingLoggerHandler(slog.NewJSONHandler(&outputBuffer, nil)))
+
+	ctx := ContextWithRequestID(context.Background(), "abc123")
+	logger.InfoContext(ctx, "door opened")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(outputBuffer.Bytes(), &record))
+	assert.Equal(t, "abc123", record[RequestIDKey])
+
+	outputBuffer.Reset()
+	logger.Info("no request")
+	record = nil
+	assert.NoError(t, json.Unmarshal(outputBuffer.Bytes(), &record))
+	assert.NotContains(t, record, RequestIDKey)
+}
+
+func TestSampler_Allow(t *testing.T) {
+	sampler := NewSampler(3)
+	var allowed []bool
+	for i := 0; i < 7; i++ {
+		allowed = append(allowed, sampler.Allow())
+	}
+	assert.Equal(t, []bool{true, false, false, true, false, false, true}, allowed)
+
+	for _, sampler := range []*Sampler{NewSampler(0), NewSampler(1), nil} {
+		assert.True(t, sampler.Allow())
+		assert.True(t, sampler.Allow())
+	}
+}
@@ -0,0 +1,26 @@
+package logging
+
+import "sync/atomic"
+
+// Sampler lets through one of every N calls. It is meant for high-volume debug lines,
+// such as logging every proxied request, and must not be used for warnings or errors.
+type Sampler struct {
+	every uint64
+	calls atomic.Uint64
+}
+
+// NewSampler returns a sampler allowing one of every n calls, n <= 1 allows all of them.
+func NewSampler(n int) *Sampler {
+	if n < 1 {
+		n = 1
+	}
+	return &Sampler{every: uint64(n)}
+}
+
+// Allow reports whether the current call should be logged. The first call is always allowed.
+func (s *Sampler) Allow() bool {
+	if s == nil || s.every <= 1 {
+		return true
+	}
+	return (s.calls.Add(1)-1)%s.every == 0
+}
@@ -0,0 +1,16 @@
+package logging
+
+import (
+	"log/slog"
+	"strconv"
+)
+
+// Values logs a slice through the LogValue of its elements, slog resolves only the logged value itself,
+// so sanitizing LogValue methods of elements would be skipped otherwise.
+func Values[T slog.LogValuer](items []T) slog.Value {
+	attrs := make([]slog.Attr, 0, len(items))
+	for i, item := range items {
+		attrs = append(attrs, slog.Any(strconv.Itoa(i), item))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,79 @@
+package requestlog
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a recorded request. Path is stored sanitized and without the query.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// Filter selects entries, zero fields match every entry.
+type Filter struct {
+	Method     string
+	Status     int
+	PathPrefix string
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.Method == "" || strings.EqualFold(f.Method, e.Method)) &&
+		(f.Status == 0 || f.Status == e.Status) &&
+		strings.HasPrefix(e.Path, f.PathPrefix)
+}
+
+// Log keeps the last requests in a fixed-size ring buffer, older ones are overwritten.
+type Log struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewLog returns a log keeping the last size requests, size must be positive.
+func NewLog(size int) *Log {
+	return &Log{entries: make([]Entry, size)}
+}
+
+// Record adds an entry, the caller must have sanitized the path.
+func (l *Log) Record(entry Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Page returns up to limit entries matching the filter, newest first, after skipping offset of them,
+// and the number of matching entries.
+func (l *Log) Page(filter Filter, offset, limit int) ([]Entry, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+
+	page := []Entry{}
+	total := 0
+	for i := 1; i <= count; i++ {
+		entry := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if !filter.matches(entry) {
+			continue
+		}
+		if total >= offset && len(page) < limit {
+			page = append(page, entry)
+		}
+		total++
+	}
+	return page, total
+}
@@ -0,0 +1,31 @@
+package requestlog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogPage(t *testing.T) {
+	log := NewLog(3)
+	for _, entry := range []Entry{
+		{Method: "GET", Path: "/rest/v1/places", Status: 200},
+		{Method: "GET", Path: "/rest/v1/forpost/cameras", Status: 200},
+		{Method: "POST", Path: "/rest/v1/places/1/accesscontrols/2/actions", Status: 403},
+		{Method: "GET", Path: "/rest/v1/places/1/events", Status: 200},
+	} {
+		log.Record(entry)
+	}
+
+	entries, total := log.Page(Filter{}, 0, 10)
+	assert.Equal(t, 3, total, "the oldest entry is overwritten")
+	assert.Equal(t, "/rest/v1/places/1/events", entries[0].Path)
+
+	entries, total = log.Page(Filter{Method: "get"}, 1, 1)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []Entry{{Method: "GET", Path: "/rest/v1/forpost/cameras", Status: 200}}, entries)
+
+	entries, total = log.Page(Filter{Status: 403, PathPrefix: "/rest/v1/places/"}, 0, 10)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "POST", entries[0].Method)
+}
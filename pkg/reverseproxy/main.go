@@ -1,24 +1,100 @@
 package reverseproxy
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/090809/homeassistant-domru/internal/domru/http"
 )
 
+// closeGrace is how long Shutdown waits for requests to return after they were told to close.
+const closeGrace = 3 * time.Second
+
 type ReverseProxy struct {
 	Client myhttp.HTTPClient
-	target *url.URL
+	// FlushInterval is how often a response is flushed to the client while being copied, so stream
+	// frames aren't held in buffers. Negative flushes after every write, zero only at the end.
+	// Event streams are always flushed after every write.
+	FlushInterval time.Duration
+	// ObserveResponse, if set, is called with every upstream response before it is copied, i.e. to log its status.
+	ObserveResponse func(req *http.Request, resp *http.Response)
+	target          *url.URL
+
+	// mu orders inFlight.Add before the Wait of Shutdown, requests arriving after shuttingDown is set are rejected
+	mu           sync.Mutex
+	shuttingDown bool
+	inFlight     sync.WaitGroup
+	active       atomic.Int32
+	closing      chan struct{}
+	closeOnce    sync.Once
 }
 
 func NewReverseProxy(target *url.URL) *ReverseProxy {
-	return &ReverseProxy{target: target, Client: http.DefaultClient}
+	return &ReverseProxy{target: target, Client: http.DefaultClient, closing: make(chan struct{})}
+}
+
+// Active returns the number of requests currently being proxied.
+func (p *ReverseProxy) Active() int {
+	return int(p.active.Load())
+}
+
+// Shutdown waits for in-flight requests, such as camera streams, to finish until ctx is done.
+// Requests still running then have their upstream request cancelled, so their responses end
+// cleanly instead of being cut when the process exits. New requests are rejected from the start.
+func (p *ReverseProxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.shuttingDown = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.closeOnce.Do(func() { close(p.closing) })
+	select {
+	case <-done:
+	case <-time.After(closeGrace):
+	}
+	return ctx.Err()
 }
 
 func (p *ReverseProxy) ProxyRequestHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if !p.start() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		p.active.Add(1)
+		defer func() {
+			p.active.Add(-1)
+			p.inFlight.Done()
+		}()
+
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-p.closing:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		req = req.WithContext(ctx)
+
 		// Step 1: rewrite URL
 		req.URL.Scheme = p.target.Scheme
 		req.URL.Host = p.target.Host
@@ -30,16 +106,64 @@ func (p *ReverseProxy) ProxyRequestHandler() func(http.ResponseWriter, *http.Req
 			return
 		}
 		defer resp.Body.Close()
+		if p.ObserveResponse != nil {
+			p.ObserveResponse(req, resp)
+		}
 
 		// Step 4: copy payload to response writer
 		copyHeader(w.Header(), resp.Header)
 		w.WriteHeader(resp.StatusCode)
-		_, err = io.Copy(w, resp.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-		}
-		resp.Body.Close()
+		// The status is already sent, an interrupted copy (e.g. on shutdown) can only end the body early
+		_, _ = io.Copy(newFlushWriter(w, p.flushInterval(resp)), resp.Body)
+	}
+}
+
+// start counts a new in-flight request, unless the proxy is shutting down.
+func (p *ReverseProxy) start() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shuttingDown {
+		return false
+	}
+	p.inFlight.Add(1)
+	return true
+}
+
+// flushInterval returns the flush interval of the response.
+func (p *ReverseProxy) flushInterval(resp *http.Response) time.Duration {
+	if mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); mediaType == "text/event-stream" {
+		return -1
+	}
+	return p.FlushInterval
+}
+
+// flushWriter flushes the response after every write, or at most every interval.
+type flushWriter struct {
+	w         io.Writer
+	flush     func() error
+	interval  time.Duration
+	lastFlush time.Time
+}
+
+// newFlushWriter returns w as is when the response is flushed only at the end.
+func newFlushWriter(w http.ResponseWriter, interval time.Duration) io.Writer {
+	if interval == 0 {
+		return w
+	}
+	return &flushWriter{w: w, flush: http.NewResponseController(w).Flush, interval: interval}
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if f.interval < 0 || time.Since(f.lastFlush) >= f.interval {
+		f.lastFlush = time.Now()
+		// Writers that can't flush just keep buffering
+		_ = f.flush()
 	}
+	return n, nil
 }
 
 func copyHeader(dst, src http.Header) {
@@ -0,0 +1,135 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseProxy_Shutdown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// An endless stream, like a camera
+		for {
+			if _, err := io.WriteString(w, "frame\n"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	proxy := NewReverseProxy(target)
+	server := httptest.NewServer(http.HandlerFunc(proxy.ProxyRequestHandler()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "frame\n", line)
+	assert.Equal(t, 1, proxy.Active())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = proxy.Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.Equal(t, 0, proxy.Active())
+
+	// The stream ends with a proper end of body instead of a broken connection
+	_, err = io.Copy(io.Discard, reader)
+	assert.NoError(t, err)
+}
+
+func TestReverseProxy_Shutdown_Idle(t *testing.T) {
+	target, _ := url.Parse("http://example.com")
+	proxy := NewReverseProxy(target)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, proxy.Shutdown(ctx))
+}
+
+func TestReverseProxy_ShutdownRejectsNewRequests(t *testing.T) {
+	var upstreamRequests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { upstreamRequests.Add(1) }))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	proxy := NewReverseProxy(target)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, proxy.Shutdown(ctx))
+
+	w := httptest.NewRecorder()
+	proxy.ProxyRequestHandler()(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Zero(t, upstreamRequests.Load())
+	assert.Equal(t, 0, proxy.Active())
+}
+
+// Requests racing with Shutdown either run before its Wait or are rejected, the race detector catches an Add after Wait.
+func TestReverseProxy_ShutdownRace(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	proxy := NewReverseProxy(target)
+	handler := proxy.ProxyRequestHandler()
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, proxy.Shutdown(ctx))
+	wg.Wait()
+	assert.Equal(t, 0, proxy.Active())
+}
+
+func TestFlushWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		wantFlushed bool
+	}{
+		{"Flush after every write", -1, true},
+		{"Periodic flush", time.Hour, true},
+		{"Flush at the end only", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			_, err := newFlushWriter(recorder, tt.interval).Write([]byte("frame"))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFlushed, recorder.Flushed)
+			assert.Equal(t, "frame", recorder.Body.String())
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package tokenmanagement
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenExpiry returns the expiry time from the exp claim of a JWT access token.
+// The signature isn't verified, the result is only good for display and scheduling.
+func TokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decode token payload: %w", err)
+	}
+
+	var claims struct {
+		ExpiresAt *json.Number `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("unmarshal token claims: %w", err)
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, errors.New("token has no exp claim")
+	}
+
+	expiresAt, err := claims.ExpiresAt.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse exp claim: %w", err)
+	}
+	return time.Unix(int64(expiresAt), 0), nil
+}
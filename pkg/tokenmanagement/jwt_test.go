@@ -0,0 +1,24 @@
+package tokenmanagement
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","exp":1700000000}`))
+
+	expiresAt, err := TokenExpiry("header." + payload + ".signature")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 0), expiresAt)
+
+	_, err = TokenExpiry("opaque-token")
+	assert.Error(t, err)
+
+	noExp := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`))
+	_, err = TokenExpiry("header." + noExp + ".signature")
+	assert.Error(t, err)
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/090809/homeassistant-domru/internal/domru/constants"
 	"github.com/090809/homeassistant-domru/internal/domru/helpers"
@@ -14,14 +17,35 @@ import (
 )
 
 type ValidTokenProvider struct {
-	Logger           *slog.Logger
+	Logger  *slog.Logger
+	BaseURL string
+	// RefreshSkew is how long before the expiry of the access token GetToken refreshes it,
+	// 0 refreshes only after Dom.ru rejected the token.
+	RefreshSkew      time.Duration
 	credentialsStore auth.CredentialsStore
+	now              func() time.Time
+
+	// generation is bumped every time the credentials are replaced externally,
+	// so refreshes started with the old credentials are not saved over the new ones.
+	generation atomic.Uint64
+
+	// refreshGroup makes concurrent RefreshToken calls share one refresh request,
+	// so requests failing with 401 at once don't rotate the refresh token several times.
+	refreshGroup singleflight.Group
+	// refreshedAt is when the credentials were last refreshed, requests rejected with the previous
+	// token just after a shared refresh finished reuse it instead of rotating the refresh token again.
+	refreshedAt atomic.Int64
+
+	status statusTracker
 }
 
 func NewValidTokenProvider(credentialsStore auth.CredentialsStore) *ValidTokenProvider {
 	v := &ValidTokenProvider{
 		credentialsStore: credentialsStore,
 		Logger:           slog.Default(),
+		BaseURL:          constants.BaseUrl,
+		RefreshSkew:      time.Minute,
+		now:              time.Now,
 	}
 	return v
 }
@@ -41,19 +65,81 @@ func (v *ValidTokenProvider) GetToken() (string, error) {
 		v.Logger.With("err", err.Error()).Warn("load credentials")
 		return "", fmt.Errorf("load credentials: %w", err)
 	}
+	if !v.expiresSoon(credentials.AccessToken) {
+		return credentials.AccessToken, nil
+	}
 
-	return credentials.AccessToken, nil
+	// The stale token is still returned when the refresh fails, Dom.ru decides whether it's accepted
+	if err = v.RefreshToken(); err != nil {
+		v.Logger.With("err", err.Error()).Warn("refresh expiring token")
+		return credentials.AccessToken, nil
+	}
+	refreshed, err := v.credentialsStore.LoadCredentials()
+	if err != nil {
+		v.Logger.With("err", err.Error()).Warn("load credentials")
+		return "", fmt.Errorf("load credentials: %w", err)
+	}
+	return refreshed.AccessToken, nil
 }
 
+// expiresSoon reports whether the token is a JWT expiring within RefreshSkew.
+// Tokens without a readable exp claim are never refreshed ahead of time.
+func (v *ValidTokenProvider) expiresSoon(token string) bool {
+	if v.RefreshSkew <= 0 {
+		return false
+	}
+	expiresAt, err := TokenExpiry(token)
+	if err != nil {
+		return false
+	}
+	return !v.now().Add(v.RefreshSkew).Before(expiresAt)
+}
+
+// InvalidateCredentials drops any state derived from previously loaded credentials.
+// It should be called when the credentials were changed outside the provider.
+func (v *ValidTokenProvider) InvalidateCredentials() {
+	v.generation.Add(1)
+	v.refreshedAt.Store(0)
+	v.Logger.Debug("credentials invalidated")
+}
+
+// refreshReuseWindow is how long a successful refresh is reused by the callers arriving after it.
+const refreshReuseWindow = 2 * time.Second
+
+// RefreshToken refreshes the access token. Callers arriving while a refresh is
+// in progress, or right after it succeeded, get its result instead of starting another one.
 func (v *ValidTokenProvider) RefreshToken() error {
+	_, err, shared := v.refreshGroup.Do("refresh", func() (any, error) {
+		if refreshedAt := v.refreshedAt.Load(); refreshedAt != 0 && v.now().Sub(time.Unix(0, refreshedAt)) < refreshReuseWindow {
+			v.Logger.Debug("token was just refreshed, reusing it")
+			return nil, nil
+		}
+
+		err := v.refreshToken()
+		if err != nil {
+			v.status.failed(err, time.Now())
+		} else {
+			v.refreshedAt.Store(v.now().UnixNano())
+			v.status.refreshed(time.Now())
+		}
+		return nil, err
+	})
+	if shared {
+		v.Logger.Debug("reused concurrent token refresh")
+	}
+	return err
+}
+
+func (v *ValidTokenProvider) refreshToken() error {
 	v.Logger.Debug("refreshing token...")
+	generation := v.generation.Load()
 	credentials, err := v.credentialsStore.LoadCredentials()
 	if err != nil {
 		return fmt.Errorf("load credentials: %w", err)
 	}
 
 	var refreshTokenResponse models.AuthenticationResponse
-	refreshURL := fmt.Sprintf(constants.API_REFRESH_SESSION, constants.BaseUrl)
+	refreshURL := constants.GetRefreshSessionUrl(v.BaseURL)
 	err = helpers.NewUpstreamRequest(refreshURL,
 		helpers.WithHeader("Bearer", credentials.RefreshToken),
 		helpers.WithHeader("Operator", fmt.Sprint(credentials.OperatorID)),
@@ -63,6 +149,11 @@ func (v *ValidTokenProvider) RefreshToken() error {
 		return fmt.Errorf("send request to refresh token: %w", err)
 	}
 
+	if generation != v.generation.Load() {
+		v.Logger.Info("credentials changed while refreshing token, discarding refreshed token")
+		return nil
+	}
+
 	err = v.credentialsStore.SaveCredentials(auth.NewCredentialsFromAuthResponse(refreshTokenResponse))
 	if err != nil {
 		return fmt.Errorf("save credentials: %w", err)
@@ -0,0 +1,190 @@
+package tokenmanagement
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/090809/homeassistant-domru/internal/domru/models"
+	"github.com/090809/homeassistant-domru/pkg/auth"
+)
+
+type memoryStore struct {
+	mu          sync.Mutex
+	credentials auth.Credentials
+}
+
+func (m *memoryStore) SaveCredentials(credentials auth.Credentials) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.credentials = credentials
+	return nil
+}
+
+func (m *memoryStore) ClearCredentials() error {
+	return m.SaveCredentials(auth.Credentials{})
+}
+
+func (m *memoryStore) LoadCredentials() (auth.Credentials, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.credentials, nil
+}
+
+func TestRefreshTokenSingleFlight(t *testing.T) {
+	const callers = 20
+
+	var refreshes atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes.Add(1)
+		<-release
+		_ = json.NewEncoder(w).Encode(models.AuthenticationResponse{AccessToken: "fresh", RefreshToken: "rotated", OperatorID: 2})
+	}))
+	defer server.Close()
+
+	store := &memoryStore{credentials: auth.Credentials{AccessToken: "stale", RefreshToken: "refresh", OperatorID: 2}}
+	provider := NewValidTokenProvider(store)
+	provider.BaseURL = server.URL
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = provider.GetToken()
+			errs <- provider.RefreshToken()
+		}()
+	}
+
+	// Hold the upstream response until every caller had the chance to join the refresh in flight
+	require.Eventually(t, func() bool { return refreshes.Load() > 0 }, time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), refreshes.Load())
+
+	token, err := provider.GetToken()
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", token)
+	credentials, err := store.LoadCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", credentials.RefreshToken)
+
+	// A request rejected with the stale token just after the refresh reuses it
+	require.NoError(t, provider.RefreshToken())
+	assert.Equal(t, int32(1), refreshes.Load())
+
+	provider.now = func() time.Time { return time.Now().Add(time.Minute) }
+	require.NoError(t, provider.RefreshToken())
+	assert.Equal(t, int32(2), refreshes.Load())
+}
+
+func TestStatus(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.AuthenticationResponse{AccessToken: "fresh", RefreshToken: "rotated", OperatorID: 2})
+	}))
+	defer server.Close()
+
+	provider := NewValidTokenProvider(&memoryStore{credentials: auth.Credentials{RefreshToken: "refresh", OperatorID: 2}})
+	provider.BaseURL = server.URL
+	assert.Equal(t, Status{}, provider.Status())
+
+	require.NoError(t, provider.RefreshToken())
+	status := provider.Status()
+	assert.WithinDuration(t, time.Now(), status.LastRefresh, time.Second)
+	assert.Empty(t, status.LastError)
+
+	// A refresh right after the previous one would reuse its result
+	provider.now = func() time.Time { return time.Now().Add(time.Minute) }
+	fail = true
+	require.Error(t, provider.RefreshToken())
+	status = provider.Status()
+	assert.NotEmpty(t, status.LastError)
+	assert.WithinDuration(t, time.Now(), status.LastErrorAt, time.Second)
+}
+
+func jwtExpiringAt(expiresAt time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"1","exp":%d}`, expiresAt.Unix())))
+	return "header." + payload + ".signature"
+}
+
+func TestGetTokenRefreshesExpiringToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name        string
+		token       string
+		skew        time.Duration
+		wantRefresh bool
+	}{
+		{name: "Valid token", token: jwtExpiringAt(now.Add(time.Hour)), skew: time.Minute},
+		{name: "Within skew", token: jwtExpiringAt(now.Add(30 * time.Second)), skew: time.Minute, wantRefresh: true},
+		{name: "Expired", token: jwtExpiringAt(now.Add(-time.Hour)), skew: time.Minute, wantRefresh: true},
+		{name: "Skew disabled", token: jwtExpiringAt(now.Add(-time.Hour))},
+		{name: "Opaque token", token: "opaque-token", skew: time.Minute},
+		{name: "Malformed payload", token: "header.!!!.signature", skew: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var refreshes atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				refreshes.Add(1)
+				_ = json.NewEncoder(w).Encode(models.AuthenticationResponse{AccessToken: "fresh", RefreshToken: "rotated", OperatorID: 2})
+			}))
+			defer server.Close()
+
+			provider := NewValidTokenProvider(&memoryStore{credentials: auth.Credentials{AccessToken: tt.token, RefreshToken: "refresh", OperatorID: 2}})
+			provider.BaseURL = server.URL
+			provider.RefreshSkew = tt.skew
+			provider.now = func() time.Time { return now }
+
+			token, err := provider.GetToken()
+			require.NoError(t, err)
+			if tt.wantRefresh {
+				assert.Equal(t, "fresh", token)
+				assert.Equal(t, int32(1), refreshes.Load())
+			} else {
+				assert.Equal(t, tt.token, token)
+				assert.Zero(t, refreshes.Load())
+			}
+		})
+	}
+}
+
+func TestGetTokenRefreshFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	stale := jwtExpiringAt(time.Now().Add(10 * time.Second))
+	provider := NewValidTokenProvider(&memoryStore{credentials: auth.Credentials{AccessToken: stale, RefreshToken: "refresh", OperatorID: 2}})
+	provider.BaseURL = server.URL
+
+	token, err := provider.GetToken()
+	require.NoError(t, err)
+	assert.Equal(t, stale, token)
+	assert.NotEmpty(t, provider.Status().LastError)
+}
@@ -0,0 +1,59 @@
+package tokenmanagement
+
+import (
+	"sync"
+	"time"
+)
+
+// Status describes the health of the Dom.ru session.
+type Status struct {
+	// AccessTokenExpiry is zero when the access token isn't a JWT with an exp claim.
+	AccessTokenExpiry time.Time
+	// LastRefresh is the time of the last successful token refresh since the start.
+	LastRefresh time.Time
+	// LastError is the last failed token refresh or Dom.ru API request, empty when there was none.
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// statusTracker records the outcome of refreshes and API requests.
+type statusTracker struct {
+	mu          sync.Mutex
+	lastRefresh time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func (s *statusTracker) refreshed(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRefresh = at
+}
+
+func (s *statusTracker) failed(err error, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorAt = at
+}
+
+// Status returns the health of the session, the token expiry is read from the stored credentials.
+func (v *ValidTokenProvider) Status() Status {
+	v.status.mu.Lock()
+	status := Status{
+		LastRefresh: v.status.lastRefresh,
+		LastError:   v.status.lastError,
+		LastErrorAt: v.status.lastErrorAt,
+	}
+	v.status.mu.Unlock()
+
+	if credentials, err := v.credentialsStore.LoadCredentials(); err == nil {
+		status.AccessTokenExpiry, _ = TokenExpiry(credentials.AccessToken)
+	}
+	return status
+}
+
+// RecordError records a failed Dom.ru API request as the last error of the session.
+func (v *ValidTokenProvider) RecordError(err error) {
+	v.status.failed(err, time.Now())
+}